@@ -37,7 +37,7 @@ ListCatalog available tools.
 
 		dbtObj.SetVerbose(verbose)
 
-		err = dbtObj.FetchCatalog(versions)
+		err = dbtObj.FetchCatalog(versions, "")
 		if err != nil {
 			fmt.Printf("Error running list: %s\n", err)
 			os.Exit(1)
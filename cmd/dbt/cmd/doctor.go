@@ -0,0 +1,67 @@
+// Copyright © 2019 Nik Ogura <nik.ogura@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"fmt"
+	"github.com/nikogura/dbt/pkg/dbt"
+	"github.com/spf13/cobra"
+	"os"
+)
+
+var doctorCmd = &cobra.Command{
+	Use:   "doctor",
+	Short: "Check your dbt install",
+	Long: `
+Check your dbt install end to end.
+
+Verifies the config file, the local dbt directories, the repository, the truststore, and that at least one tool is available.  Each failed check comes with a hint on how to fix it.
+`,
+	Example: "dbt doctor",
+	Run:     Doctor,
+}
+
+func init() {
+	rootCmd.AddCommand(doctorCmd)
+}
+
+// Doctor run diagnostics on the dbt install and print the results.
+func Doctor(cmd *cobra.Command, args []string) {
+	// A broken config is exactly what we're here to diagnose, so carry on regardless.
+	dbtObj, err := dbt.NewDbtForServer("", server)
+	if err != nil {
+		fmt.Printf("Error creating DBT object: %s\n\n", err)
+	}
+
+	dbtObj.SetVerbose(verbose)
+
+	cancel := withTimeout(dbtObj)
+	defer cancel()
+
+	failed := false
+
+	for _, result := range dbtObj.Doctor("") {
+		fmt.Println(result)
+
+		if !result.Passed {
+			failed = true
+			fmt.Printf("       %s\n", result.Remediation)
+		}
+	}
+
+	if failed {
+		os.Exit(1)
+	}
+}
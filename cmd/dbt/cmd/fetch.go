@@ -0,0 +1,90 @@
+// Copyright © 2019 Nik Ogura <nik.ogura@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"fmt"
+	"github.com/nikogura/dbt/pkg/dbt"
+	"github.com/spf13/cobra"
+	"log"
+)
+
+var fetchCmd = &cobra.Command{
+	Use:   "fetch <tool>[@<version>][@sha256:<digest>] [<tool>[@<version>][@sha256:<digest>] ...]",
+	Short: "Download and verify tools without running them",
+	Long: `
+Download and verify tools without running them.
+
+Handy for warming the tool cache ahead of going offline.  Fetches the latest version unless -v is given, or the tool is given as <tool>@<version>.  A tool given with @sha256:<digest>, or pinned in tools.digests, has to be exactly that binary.
+`,
+	Example: "dbt fetch catalog boilerplate@3.6.1",
+	Args:    cobra.MinimumNArgs(1),
+	Run:     Fetch,
+}
+
+func init() {
+	fetchCmd.Flags().StringVarP(&toolVersion, "toolversion", "v", "", "Version of tool to fetch.")
+	rootCmd.AddCommand(fetchCmd)
+}
+
+// Fetch download and verify the named tools, printing where each ended up.
+func Fetch(cmd *cobra.Command, args []string) {
+	dbtObj, err := dbt.NewDbtForServer("", server)
+	if err != nil {
+		log.Fatalf("Error creating DBT object: %s", err)
+	}
+
+	dbtObj.SetVerbose(verbose)
+
+	cancel := withTimeout(dbtObj)
+	defer cancel()
+
+	homedir, err := dbt.GetHomeDir()
+	if err != nil {
+		log.Fatalf("Failed to discover user homedir: %s\n", err)
+	}
+
+	err = dbtObj.FetchTrustStore(homedir)
+	if err != nil {
+		exitIfTimedOut(dbtObj)
+		log.Fatalf("Failed to fetch remote truststore: %s", err)
+	}
+
+	for _, arg := range args {
+		spec, specDigest := dbt.ParseToolDigest(arg)
+		toolName, version := dbt.ParseToolSpec(spec)
+		if version == "" {
+			version = toolVersion
+		}
+
+		digest, err := dbtObj.ToolDigest(toolName, specDigest)
+		if err != nil {
+			log.Fatalf("Failed to fetch %s: %s", arg, err)
+		}
+
+		localPath, err := dbtObj.FetchTool(toolName, version, homedir)
+		if err != nil {
+			exitIfTimedOut(dbtObj)
+			log.Fatalf("Failed to fetch %s: %s", arg, err)
+		}
+
+		err = dbt.VerifyToolDigest(localPath, toolName, digest)
+		if err != nil {
+			log.Fatalf("Failed to fetch %s: %s", arg, err)
+		}
+
+		fmt.Println(localPath)
+	}
+}
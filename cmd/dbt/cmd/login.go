@@ -0,0 +1,65 @@
+// Copyright © 2019 Nik Ogura <nik.ogura@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"github.com/nikogura/dbt/pkg/dbt"
+	"github.com/spf13/cobra"
+	"log"
+)
+
+var loginCmd = &cobra.Command{
+	Use:   "login",
+	Short: "Log in to an OIDC protected repo",
+	Long: `
+Log in to an OIDC protected repo.
+
+Uses the OAuth2 device flow against the issuer in the config's 'oidc' section.  You're shown a url and a code to enter there, from any browser.  Once you have, the token is cached in ~/.dbt/token.json and used for every request until it expires, refreshing it when it can.
+`,
+	Example: "dbt login",
+	Args:    cobra.NoArgs,
+	Run:     Login,
+}
+
+func init() {
+	rootCmd.AddCommand(loginCmd)
+}
+
+// Login log in with the OIDC device flow, and cache the token.
+func Login(cmd *cobra.Command, args []string) {
+	dbtObj, err := dbt.NewDbtForServer("", server)
+	if err != nil {
+		log.Fatalf("Error creating DBT object: %s", err)
+	}
+
+	dbtObj.SetVerbose(verbose)
+
+	// the device code's own lifetime bounds the wait, unless --timeout is shorter
+	ctx := context.Background()
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+
+	_, err = dbtObj.LoginOIDC(ctx)
+	if err != nil {
+		log.Fatalf("Login failed: %s", err)
+	}
+
+	fmt.Printf("Logged in to %s.\n", dbtObj.Config.OIDC.Issuer)
+}
@@ -0,0 +1,66 @@
+// Copyright © 2019 Nik Ogura <nik.ogura@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"fmt"
+	"github.com/nikogura/dbt/pkg/dbt"
+	"github.com/spf13/cobra"
+	"log"
+)
+
+var logoutCmd = &cobra.Command{
+	Use:   "logout",
+	Short: "Forget cached logins",
+	Long: `
+Forget cached logins.
+
+Removes the tokens 'dbt login' cached in ~/.dbt/token.json.  With --server, only that server's login is forgotten, otherwise all of them are.  Handy on shared machines, or before logging in as someone else.
+`,
+	Example: "dbt logout\ndbt logout --server prod",
+	Args:    cobra.NoArgs,
+	Run:     Logout,
+}
+
+func init() {
+	rootCmd.AddCommand(logoutCmd)
+}
+
+// Logout forget cached logins, for the named server or all of them.
+func Logout(cmd *cobra.Command, args []string) {
+	dbtObj, err := dbt.NewDbtForServer("", server)
+	if err != nil {
+		log.Fatalf("Error creating DBT object: %s", err)
+	}
+
+	dbtObj.SetVerbose(verbose)
+
+	serverName := ""
+	if server != "" {
+		serverName = dbtObj.OIDCTokenKey()
+	}
+
+	err = dbtObj.Logout(serverName)
+	if err != nil {
+		log.Fatalf("Logout failed: %s", err)
+	}
+
+	if serverName != "" {
+		fmt.Printf("Logged out of %s.\n", serverName)
+		return
+	}
+
+	fmt.Println("Logged out.")
+}
@@ -15,6 +15,7 @@
 package cmd
 
 import (
+	"context"
 	"fmt"
 	"github.com/nikogura/dbt/pkg/dbt"
 	"github.com/spf13/cobra"
@@ -22,11 +23,16 @@ import (
 	"os"
 	"os/exec"
 	"syscall"
+	"time"
 )
 
 var toolVersion string
 var offline bool
+var noUpgrade bool
+var forceUpgradeCheck bool
 var verbose bool
+var server string
+var timeout time.Duration
 
 var rootCmd = &cobra.Command{
 	Use:   "dbt",
@@ -39,15 +45,23 @@ A framework for running self-updating signed binaries from a trusted repository.
 Run 'dbt -- catalog list' to see a list of what tools are available in your repository.
 
 `,
-	Example: "dbt -- catalog list",
-	Version: "3.6.1",
-	Run:     Run,
+	Example: "dbt -- catalog list\ndbt -- catalog@3.6.1 list",
+	Version: VERSION,
+	// Anything that isn't a subcommand is a tool name.
+	Args: cobra.ArbitraryArgs,
+	// Don't shadow a tool called 'completion'.
+	CompletionOptions: cobra.CompletionOptions{DisableDefaultCmd: true},
+	Run:               Run,
 }
 
 func init() {
 	rootCmd.Flags().StringVarP(&toolVersion, "toolversion", "v", "", "Version of tool to run.")
 	rootCmd.Flags().BoolVarP(&offline, "offline", "o", false, "Offline mode.")
-	rootCmd.Flags().BoolVarP(&verbose, "verbose", "V", false, "Verbose output")
+	rootCmd.Flags().BoolVarP(&noUpgrade, "no-upgrade", "", false, "Don't upgrade dbt itself.  Tools are still fetched online.")
+	rootCmd.Flags().BoolVarP(&forceUpgradeCheck, "force-upgrade-check", "", false, "Check for a new version of dbt, even if it's been checked for recently.")
+	rootCmd.PersistentFlags().BoolVarP(&verbose, "verbose", "V", false, "Verbose output")
+	rootCmd.PersistentFlags().StringVarP(&server, "server", "", "", "Name of the server to use from a multi-server config.  Overrides the config's default server.")
+	rootCmd.PersistentFlags().DurationVarP(&timeout, "timeout", "", 0, "Give up on the repository after this long, e.g. 30s or 2m.  Covers the truststore, upgrade check, and tool download, not the tool's own run.")
 }
 
 // Execute - execute the command
@@ -68,13 +82,16 @@ func Run(cmd *cobra.Command, args []string) {
 		os.Exit(0)
 	}
 
-	dbtObj, err := dbt.NewDbt("")
+	dbtObj, err := dbt.NewDbtForServer("", server)
 	if err != nil {
 		log.Fatalf("Error creating DBT object: %s", err)
 	}
 
 	dbtObj.SetVerbose(verbose)
 
+	cancel := withTimeout(dbtObj)
+	defer cancel()
+
 	homedir, err := dbt.GetHomeDir()
 	if err != nil {
 		log.Fatalf("Failed to discover user homedir: %s\n", err)
@@ -85,34 +102,99 @@ func Run(cmd *cobra.Command, args []string) {
 		log.Fatalf("Couldn't find `dbt` in $PATH: %s", err)
 	}
 
-	// if we're not explicitly offline, try to upgrade in place
+	// if we're not explicitly offline, try to upgrade in place.  Outside the check interval, or with --no-upgrade, that's no trip to the repo at all.
 	if !offline {
-		// first fetch the current truststore
-		err = dbtObj.FetchTrustStore(homedir)
-		if err != nil {
-			log.Fatalf("Failed to fetch remote truststore: %s.\n\nIf you want to try in 'offline' mode, retry your command again with: dbt -o ...", err)
-		}
+		switch {
+		case noUpgrade:
+			dbtObj.VerboseOutput("Skipping dbt upgrade check.")
+		case !forceUpgradeCheck && !dbtObj.UpgradeCheckDue(homedir):
+			dbtObj.VerboseOutput("Checked for a new version of dbt within the last %s.  Skipping dbt upgrade check.", dbtObj.UpgradeCheckInterval())
+		default:
+			// first fetch the current truststore
+			fetchTrustStore(dbtObj, homedir)
+
+			ok, err := dbtObj.IsCurrent(dbtBinary)
+			if err != nil {
+				// not knowing is no reason to upgrade, nor to call the check done
+				log.Printf("Failed to confirm whether we're up to date: %s", err)
+				break
+			}
 
-		ok, err := dbtObj.IsCurrent(dbtBinary)
-		if err != nil {
-			log.Printf("Failed to confirm whether we're up to date: %s", err)
-		}
+			if ok {
+				markUpgradeChecked(dbtObj, homedir)
+				break
+			}
 
-		if !ok {
 			log.Printf("Downloading and verifying new version of dbt.")
 			err = dbtObj.UpgradeInPlace(dbtBinary)
 			if err != nil {
+				exitIfTimedOut(dbtObj)
 				err = fmt.Errorf("upgrade in place failed: %s", err)
 				log.Fatalf("Error: %s", err)
 			}
 
+			// the new version needn't check again as soon as it starts
+			markUpgradeChecked(dbtObj, homedir)
+
 			// Single white female ourself
 			_ = syscall.Exec(dbtBinary, os.Args, os.Environ())
 		}
+
+		// tools can't be verified without a truststore, so if there's none yet, it's fetched regardless
+		if !truststoreCached(homedir) {
+			fetchTrustStore(dbtObj, homedir)
+		}
 	}
 
 	err = dbtObj.RunTool(toolVersion, args, homedir, offline)
 	if err != nil {
+		// the tool ran and failed, and has said its piece.  Pass its exit code on untouched.
+		if code, ok := dbt.ToolExitCode(err); ok {
+			os.Exit(code)
+		}
+
+		exitIfTimedOut(dbtObj)
 		log.Fatal(err)
 	}
 }
+
+// fetchTrustStore fetches the truststore, or exits if it can't.
+func fetchTrustStore(dbtObj *dbt.DBT, homedir string) {
+	err := dbtObj.FetchTrustStore(homedir)
+	if err != nil {
+		exitIfTimedOut(dbtObj)
+		log.Fatalf("Failed to fetch remote truststore: %s.\n\nIf you want to try in 'offline' mode, retry your command again with: dbt -o ...", err)
+	}
+}
+
+// truststoreCached reports whether there's a truststore from an earlier run to verify tools with.
+func truststoreCached(homedir string) (cached bool) {
+	_, err := os.Stat(dbt.TruststorePath(homedir))
+	return err == nil
+}
+
+// markUpgradeChecked records the upgrade check, so it isn't repeated until the interval is up.  Failing to is no reason to stop, it just means checking again next time.
+func markUpgradeChecked(dbtObj *dbt.DBT, homedir string) {
+	err := dbt.MarkUpgradeChecked(homedir)
+	if err != nil {
+		dbtObj.VerboseOutput("Failed to record upgrade check: %s", err)
+	}
+}
+
+// withTimeout bounds everything dbtObj does over the network by --timeout, if it was given.  Call the returned function when done.
+func withTimeout(dbtObj *dbt.DBT) (cancel context.CancelFunc) {
+	if timeout <= 0 {
+		return func() {}
+	}
+
+	dbtObj.Context, cancel = context.WithTimeout(context.Background(), timeout)
+
+	return cancel
+}
+
+// exitIfTimedOut exits with a timeout error if --timeout ran out.  Whatever else went wrong, that's the reason.
+func exitIfTimedOut(dbtObj *dbt.DBT) {
+	if dbtObj.RequestContext().Err() == context.DeadlineExceeded {
+		log.Fatalf("Timed out after %s.", timeout)
+	}
+}
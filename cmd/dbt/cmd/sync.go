@@ -0,0 +1,81 @@
+// Copyright © 2019 Nik Ogura <nik.ogura@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"context"
+	"github.com/nikogura/dbt/pkg/dbt"
+	"github.com/spf13/cobra"
+	"log"
+)
+
+var syncFrom string
+var syncTo string
+var syncDryRun bool
+
+var syncCmd = &cobra.Command{
+	Use:   "sync --from <server> --to <server> [<tool> ...]",
+	Short: "Copy tools from one repository to another",
+	Long: `
+Copy tools from one repository to another.
+
+Both repositories are named servers from a multi-server config.  Every tool version in the source that's missing or different in the destination is downloaded, verified against the source's truststore, and uploaded to the destination, which has to be a reposerver.  Give tool names to sync just those tools.
+`,
+	Example: "dbt sync --from prod --to dr --dry-run",
+	Run:     Sync,
+}
+
+func init() {
+	syncCmd.Flags().StringVarP(&syncFrom, "from", "", "", "Server to copy tools from.")
+	syncCmd.Flags().StringVarP(&syncTo, "to", "", "", "Server to copy tools to.")
+	syncCmd.Flags().BoolVarP(&syncDryRun, "dry-run", "", false, "Show what would be copied without copying it.")
+	_ = syncCmd.MarkFlagRequired("from")
+	_ = syncCmd.MarkFlagRequired("to")
+	rootCmd.AddCommand(syncCmd)
+}
+
+// Sync copy tools from the --from server's repo to the --to server's.
+func Sync(cmd *cobra.Command, args []string) {
+	srcConfig, err := dbt.LoadDbtConfigForServer("", syncFrom, verbose)
+	if err != nil {
+		log.Fatalf("Failed to load config for %s: %s", syncFrom, err)
+	}
+
+	dstConfig, err := dbt.LoadDbtConfigForServer("", syncTo, verbose)
+	if err != nil {
+		log.Fatalf("Failed to load config for %s: %s", syncTo, err)
+	}
+
+	opts := dbt.SyncOptions{
+		DryRun:  syncDryRun,
+		Tools:   args,
+		Verbose: verbose,
+	}
+
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		opts.Context, cancel = context.WithTimeout(context.Background(), timeout)
+		defer cancel()
+	}
+
+	err = dbt.SyncRepos(dbt.RepoTarget{Config: srcConfig}, dbt.RepoTarget{Config: dstConfig}, opts)
+	if err != nil {
+		if opts.Context != nil && opts.Context.Err() == context.DeadlineExceeded {
+			log.Fatalf("Timed out after %s.", timeout)
+		}
+
+		log.Fatalf("Failed to sync %s to %s: %s", syncFrom, syncTo, err)
+	}
+}
@@ -0,0 +1,133 @@
+// Copyright © 2019 Nik Ogura <nik.ogura@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/nikogura/dbt/pkg/dbt"
+	"github.com/spf13/cobra"
+	"log"
+	"runtime"
+	"runtime/debug"
+)
+
+// VERSION the version of dbt
+var VERSION = "3.6.1"
+
+// commit and buildDate are set at build time with -ldflags "-X github.com/nikogura/dbt/cmd/dbt/cmd.commit=<sha> -X github.com/nikogura/dbt/cmd/dbt/cmd.buildDate=<date>".  Without them, they come from the VCS info Go stamps into builds made in a git checkout, if there is any.
+var commit string
+var buildDate string
+
+var versionJson bool
+
+// VersionInfo what 'dbt version --json' reports, for inventory and auditing.
+type VersionInfo struct {
+	Version         string `json:"version"`
+	Commit          string `json:"commit"`
+	BuildDate       string `json:"builddate"`
+	GoVersion       string `json:"goversion"`
+	Platform        string `json:"platform"`
+	Repository      string `json:"repository"`
+	TrustStore      string `json:"truststore"`
+	ToolsRepository string `json:"toolsrepository"`
+}
+
+var versionCmd = &cobra.Command{
+	Use:   "version",
+	Short: "Show dbt's version and build information",
+	Long: `
+Show dbt's version, the commit and date it was built from, the Go version it was built with, and the repositories it's configured to use.
+
+Nothing is fetched, and dbt doesn't upgrade itself first, so what's shown is what's installed.  --json prints the same as JSON, for inventory and auditing.
+`,
+	Example: "dbt version\ndbt version --json",
+	Args:    cobra.NoArgs,
+	Run:     Version,
+}
+
+func init() {
+	versionCmd.Flags().BoolVarP(&versionJson, "json", "", false, "Print version information as JSON.")
+	rootCmd.AddCommand(versionCmd)
+}
+
+// Version print dbt's version and build information.
+func Version(cmd *cobra.Command, args []string) {
+	info := versionInfo()
+
+	if versionJson {
+		out, err := json.MarshalIndent(info, "", "  ")
+		if err != nil {
+			log.Fatalf("Failed to encode version information: %s", err)
+		}
+
+		fmt.Println(string(out))
+		return
+	}
+
+	fmt.Printf("dbt version %s\n", info.Version)
+	fmt.Printf("Commit:           %s\n", info.Commit)
+	fmt.Printf("Built:            %s\n", info.BuildDate)
+	fmt.Printf("Go version:       %s\n", info.GoVersion)
+	fmt.Printf("Platform:         %s\n", info.Platform)
+	fmt.Printf("Repository:       %s\n", info.Repository)
+	fmt.Printf("Truststore:       %s\n", info.TrustStore)
+	fmt.Printf("Tools repository: %s\n", info.ToolsRepository)
+}
+
+// versionInfo gathers the version information.  A missing or broken config just leaves the repositories blank.  The version's still worth knowing.
+func versionInfo() (info VersionInfo) {
+	info = VersionInfo{
+		Version:   VERSION,
+		Commit:    commit,
+		BuildDate: buildDate,
+		GoVersion: runtime.Version(),
+		Platform:  fmt.Sprintf("%s/%s", runtime.GOOS, runtime.GOARCH),
+	}
+
+	if buildInfo, ok := debug.ReadBuildInfo(); ok {
+		for _, setting := range buildInfo.Settings {
+			switch {
+			case setting.Key == "vcs.revision" && info.Commit == "":
+				info.Commit = setting.Value
+			case setting.Key == "vcs.time" && info.BuildDate == "":
+				info.BuildDate = setting.Value
+			}
+		}
+	}
+
+	if info.Commit == "" {
+		info.Commit = "unknown"
+	}
+
+	if info.BuildDate == "" {
+		info.BuildDate = "unknown"
+	}
+
+	config, err := dbt.LoadDbtConfigForServer("", server, verbose)
+	if err != nil {
+		if verbose {
+			log.Printf("Failed to load config: %s", err)
+		}
+
+		return info
+	}
+
+	info.Repository = config.Dbt.Repo
+	info.TrustStore = config.Dbt.TrustStore
+	info.ToolsRepository = config.Tools.Repo
+
+	return info
+}
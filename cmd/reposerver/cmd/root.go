@@ -26,6 +26,7 @@ var address string
 var port int
 var serverRoot string
 var configFile string
+var checkConfig bool
 
 var rootCmd = &cobra.Command{
 	Use:   "reposerver",
@@ -46,6 +47,7 @@ func init() {
 	rootCmd.Flags().IntVarP(&port, "port", "p", 9999, "Port on which to run server.")
 	rootCmd.Flags().StringVarP(&serverRoot, "root", "r", "", "Server Root (Local path from which to serve components.")
 	rootCmd.Flags().StringVarP(&configFile, "file", "f", "", "Config file for reposerver.")
+	rootCmd.Flags().BoolVarP(&checkConfig, "check-config", "", false, "Check the config and exit, rather than starting the server.")
 }
 
 // Execute  execute the command
@@ -80,6 +82,16 @@ func Run(cmd *cobra.Command, args []string) {
 		log.Fatalf("Failed to initialize reposerver object.  Cannot continue.")
 	}
 
+	if checkConfig {
+		err := repo.Validate()
+		if err != nil {
+			log.Fatalf("%s", err)
+		}
+
+		fmt.Println("Config OK")
+		os.Exit(0)
+	}
+
 	err := repo.RunRepoServer()
 	if err != nil {
 		log.Fatalf("Error running server: %s", err)
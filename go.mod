@@ -1,8 +1,15 @@
 module github.com/nikogura/dbt
 
 require (
+	github.com/Masterminds/semver/v3 v3.1.1
 	github.com/abbot/go-http-auth v0.4.0
-	github.com/aws/aws-sdk-go v1.44.186
+	github.com/aws/aws-sdk-go v1.44.186 // indirect
+	github.com/aws/aws-sdk-go-v2 v1.17.3
+	github.com/aws/aws-sdk-go-v2/config v1.18.8
+	github.com/aws/aws-sdk-go-v2/credentials v1.13.8
+	github.com/aws/aws-sdk-go-v2/feature/s3/manager v1.11.47
+	github.com/aws/aws-sdk-go-v2/service/s3 v1.30.0
+	github.com/aws/smithy-go v1.13.5
 	github.com/fatih/color v1.14.1
 	github.com/gorilla/mux v1.8.0
 	github.com/johannesboyne/gofakes3 v0.0.0-20200218152459-de0855a40bc1
@@ -14,17 +21,19 @@ require (
 	github.com/orion-labs/jwt-ssh-agent-go v0.0.0-20200108200620-50a51684897c
 	github.com/phayes/freeport v0.0.0-20180830031419-95f893ade6f2
 	github.com/pkg/errors v0.9.1
+	github.com/prometheus/client_golang v1.11.1
 	github.com/rivo/uniseg v0.4.3 // indirect
 	github.com/sirupsen/logrus v1.9.0
 	github.com/spf13/afero v1.2.1
 	github.com/spf13/cobra v1.6.1
 	github.com/stretchr/testify v1.8.1
-	golang.org/x/crypto v0.4.0 // indirect
+	golang.org/x/crypto v0.4.0
 	golang.org/x/mod v0.6.0-dev.0.20220419223038-86c51ed26bb4
 	golang.org/x/net v0.4.0
-	golang.org/x/sys v0.4.0 // indirect
+	golang.org/x/sys v0.4.0
 	gopkg.in/check.v1 v1.0.0-20201130134442-10cb98267c6c // indirect
 	gopkg.in/cheggaaa/pb.v1 v1.0.25
+	gopkg.in/yaml.v3 v3.0.1
 )
 
 go 1.16
@@ -0,0 +1,164 @@
+// Copyright © 2019 Nik Ogura <nik.ogura@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package dbt
+
+import (
+	"bytes"
+	"compress/bzip2"
+	"encoding/binary"
+	"fmt"
+	"github.com/pkg/errors"
+	"io"
+	"io/ioutil"
+)
+
+// BSDIFF_MAGIC The magic string at the start of every bsdiff patch.
+const BSDIFF_MAGIC = "BSDIFF40"
+
+// BSDIFF_SUFFIX Suffix of a patch from an older version of a file.  The old version follows it, e.g. 'dbt.bsdiff.3.6.1'.
+const BSDIFF_SUFFIX = ".bsdiff"
+
+// BSDIFF_HEADER_LEN Length of a bsdiff patch header.  The magic string, followed by the lengths of the control block, the diff block, and the new file.
+const BSDIFF_HEADER_LEN = 32
+
+// BSDIFF_MAX_SIZE Largest file a patch may claim to produce.  The header isn't signed, so the size in it is checked against this before anything is allocated.
+const BSDIFF_MAX_SIZE = 1 << 30
+
+// PatchFile applies the bsdiff patch at patchPath to the file at oldPath, and writes the result to newPath.
+func PatchFile(oldPath string, patchPath string, newPath string) (err error) {
+	old, err := ioutil.ReadFile(oldPath)
+	if err != nil {
+		err = errors.Wrapf(err, "failed to read %s", oldPath)
+		return err
+	}
+
+	patch, err := ioutil.ReadFile(patchPath)
+	if err != nil {
+		err = errors.Wrapf(err, "failed to read %s", patchPath)
+		return err
+	}
+
+	patched, err := bspatch(old, patch)
+	if err != nil {
+		err = errors.Wrapf(err, "failed to apply %s to %s", patchPath, oldPath)
+		return err
+	}
+
+	err = ioutil.WriteFile(newPath, patched, 0755)
+	if err != nil {
+		err = errors.Wrapf(err, "failed to write %s", newPath)
+		return err
+	}
+
+	return err
+}
+
+// bspatch applies a patch in the format produced by Colin Percival's bsdiff.  After the header come three bzip2 compressed blocks.  The control block is a series of triples: how many bytes to add from the diff block to the old file, how many bytes to copy from the extra block, and how far to seek in the old file.
+func bspatch(old []byte, patch []byte) (patched []byte, err error) {
+	if len(patch) < BSDIFF_HEADER_LEN || string(patch[:len(BSDIFF_MAGIC)]) != BSDIFF_MAGIC {
+		err = errors.New("not a bsdiff patch")
+		return patched, err
+	}
+
+	ctrlLen := offtin(patch[8:16])
+	diffLen := offtin(patch[16:24])
+	newSize := offtin(patch[24:32])
+
+	if ctrlLen < 0 || diffLen < 0 || ctrlLen > int64(len(patch)-BSDIFF_HEADER_LEN) || diffLen > int64(len(patch)-BSDIFF_HEADER_LEN)-ctrlLen {
+		err = errors.New("corrupt bsdiff header")
+		return patched, err
+	}
+
+	if newSize < 0 || newSize > BSDIFF_MAX_SIZE {
+		err = errors.New(fmt.Sprintf("bsdiff header claims a new file of %d bytes", newSize))
+		return patched, err
+	}
+
+	ctrlStart := int64(BSDIFF_HEADER_LEN)
+	diffStart := ctrlStart + ctrlLen
+	extraStart := diffStart + diffLen
+
+	ctrlReader := bzip2.NewReader(bytes.NewReader(patch[ctrlStart:diffStart]))
+	diffReader := bzip2.NewReader(bytes.NewReader(patch[diffStart:extraStart]))
+	extraReader := bzip2.NewReader(bytes.NewReader(patch[extraStart:]))
+
+	patched = make([]byte, newSize)
+	oldSize := int64(len(old))
+	ctrlBuf := make([]byte, 8)
+
+	var oldPos, newPos int64
+
+	for newPos < newSize {
+		var ctrl [3]int64
+
+		for i := range ctrl {
+			_, err = io.ReadFull(ctrlReader, ctrlBuf)
+			if err != nil {
+				err = errors.Wrapf(err, "failed to read control block")
+				return nil, err
+			}
+
+			ctrl[i] = offtin(ctrlBuf)
+		}
+
+		// Compared against what's left, rather than added to newPos, so huge values can't overflow past the check.
+		if ctrl[0] < 0 || ctrl[0] > newSize-newPos || ctrl[2] > oldSize+newSize || ctrl[2] < -(oldSize+newSize) {
+			err = errors.New(fmt.Sprintf("corrupt control block at offset %d", newPos))
+			return nil, err
+		}
+
+		_, err = io.ReadFull(diffReader, patched[newPos:newPos+ctrl[0]])
+		if err != nil {
+			err = errors.Wrapf(err, "failed to read diff block")
+			return nil, err
+		}
+
+		for i := int64(0); i < ctrl[0]; i++ {
+			if oldPos+i >= 0 && oldPos+i < oldSize {
+				patched[newPos+i] += old[oldPos+i]
+			}
+		}
+
+		newPos += ctrl[0]
+		oldPos += ctrl[0]
+
+		if ctrl[1] < 0 || ctrl[1] > newSize-newPos {
+			err = errors.New(fmt.Sprintf("corrupt control block at offset %d", newPos))
+			return nil, err
+		}
+
+		_, err = io.ReadFull(extraReader, patched[newPos:newPos+ctrl[1]])
+		if err != nil {
+			err = errors.Wrapf(err, "failed to read extra block")
+			return nil, err
+		}
+
+		newPos += ctrl[1]
+		oldPos += ctrl[2]
+	}
+
+	return patched, err
+}
+
+// offtin decodes bsdiff's 8 byte sign and magnitude integers.
+func offtin(buf []byte) (offset int64) {
+	offset = int64(binary.LittleEndian.Uint64(buf) &^ (1 << 63))
+
+	if buf[7]&0x80 != 0 {
+		offset = -offset
+	}
+
+	return offset
+}
@@ -0,0 +1,116 @@
+// Copyright © 2019 Nik Ogura <nik.ogura@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package dbt
+
+import (
+	"encoding/binary"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+// testfixtures/dbt.bsdiff patches TEST_PATCH_OLD into TEST_PATCH_NEW
+const TEST_PATCH_OLD = "#!/bin/sh\necho dbt old\n"
+const TEST_PATCH_NEW = "#!/bin/sh\necho dbt new version\n"
+
+func TestPatchFile(t *testing.T) {
+	patch, err := ioutil.ReadFile("testfixtures/dbt.bsdiff")
+	if err != nil {
+		t.Fatalf("Failed reading patch fixture: %s", err)
+	}
+
+	// same patch, but claiming to produce an enormous file
+	hugePatch := make([]byte, len(patch))
+	copy(hugePatch, patch)
+	binary.LittleEndian.PutUint64(hugePatch[24:32], 1<<62)
+
+	// says to copy 1 byte from the diff block, then math.MaxInt64 bytes from the extra block
+	overflow, err := ioutil.ReadFile("testfixtures/overflow.bsdiff")
+	if err != nil {
+		t.Fatalf("Failed reading overflow fixture: %s", err)
+	}
+
+	inputs := []struct {
+		name   string
+		patch  []byte
+		output string
+		errors bool
+	}{
+		{
+			"good patch",
+			patch,
+			TEST_PATCH_NEW,
+			false,
+		},
+		{
+			"not a patch",
+			[]byte(TEST_PATCH_NEW),
+			"",
+			true,
+		},
+		{
+			"truncated patch",
+			patch[:len(patch)-20],
+			"",
+			true,
+		},
+		{
+			"huge new size",
+			hugePatch,
+			"",
+			true,
+		},
+		{
+			"overflowing control block",
+			overflow,
+			"",
+			true,
+		},
+	}
+
+	for _, tc := range inputs {
+		t.Run(tc.name, func(t *testing.T) {
+			dir, err := ioutil.TempDir("", "dbt-patch")
+			if err != nil {
+				t.Fatalf("Failed creating temp dir: %s", err)
+			}
+
+			defer os.RemoveAll(dir)
+
+			oldFile := fmt.Sprintf("%s/old", dir)
+			patchFile := fmt.Sprintf("%s/patch", dir)
+			newFile := fmt.Sprintf("%s/new", dir)
+
+			_ = ioutil.WriteFile(oldFile, []byte(TEST_PATCH_OLD), 0755)
+			_ = ioutil.WriteFile(patchFile, tc.patch, 0644)
+
+			err = PatchFile(oldFile, patchFile, newFile)
+			if tc.errors {
+				assert.Error(t, err, "Patch fails.")
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("Failed patching: %s", err)
+			}
+
+			patched, _ := ioutil.ReadFile(newFile)
+
+			assert.Equal(t, tc.output, string(patched), "Patched file matches.")
+		})
+	}
+}
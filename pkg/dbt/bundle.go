@@ -0,0 +1,458 @@
+// Copyright © 2019 Nik Ogura <nik.ogura@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package dbt
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"fmt"
+	"github.com/pkg/errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// BUNDLE_TRUSTSTORE the name of the truststore inside a bundle
+const BUNDLE_TRUSTSTORE = "truststore"
+
+// BUNDLE_TOOLS_TRUSTSTORE the name of the tools truststore inside a bundle, if the tools were verified against one
+const BUNDLE_TOOLS_TRUSTSTORE = "tools-truststore"
+
+// BUNDLE_TOOLS_DIR the directory holding tools inside a bundle
+const BUNDLE_TOOLS_DIR = "tools"
+
+// ToolSpec names a tool, and optionally a version of it.  An empty version means the latest.
+type ToolSpec struct {
+	Name    string `json:"name" yaml:"name"`
+	Version string `json:"version,omitempty" yaml:"version,omitempty"`
+}
+
+// ExportBundle downloads and verifies the given tools, their checksums and signatures, and the truststore, and packs them into a gzipped tarball for carrying into an air-gapped network.  Tools are fetched for the platform chosen by TargetPlatform, so DBT_TARGET_OS and DBT_TARGET_ARCH can be used to build bundles for other machines.
+func (dbt *DBT) ExportBundle(tools []ToolSpec, destTar string) (err error) {
+	staging, err := ioutil.TempDir("", "dbt-bundle")
+	if err != nil {
+		err = errors.Wrapf(err, "failed to create staging dir")
+		return err
+	}
+
+	defer os.RemoveAll(staging)
+
+	err = makeStagingDbtDir(staging, dbt.Verbose)
+	if err != nil {
+		return err
+	}
+
+	err = dbt.FetchTrustStore(staging)
+	if err != nil {
+		err = errors.Wrapf(err, "failed to fetch truststore")
+		return err
+	}
+
+	goos, goarch := TargetPlatform("", "")
+
+	for _, tool := range tools {
+		version := tool.Version
+
+		// the version is part of the path in the bundle, so it has to be known up front
+		if version == "" {
+			version, err = dbt.FindLatestVersion(tool.Name)
+			if err != nil {
+				err = errors.Wrapf(err, "failed to find latest version of %s", tool.Name)
+				return err
+			}
+
+			if version == "" {
+				err = fmt.Errorf("tool %s is not in repo", tool.Name)
+				return err
+			}
+		}
+
+		destPath := fmt.Sprintf("%s/%s/%s", CachedToolDir(staging, tool.Name), version, ToolFileName(tool.Name, goos))
+
+		err = dbt.FetchToolForPlatform(tool.Name, version, goos, goarch, destPath, staging)
+		if err != nil {
+			err = errors.Wrapf(err, "failed to fetch %s", tool.Name)
+			return err
+		}
+	}
+
+	out, err := os.Create(destTar)
+	if err != nil {
+		err = errors.Wrapf(err, "failed to create %s", destTar)
+		return err
+	}
+
+	defer out.Close()
+
+	gz := gzip.NewWriter(out)
+	tw := tar.NewWriter(gz)
+
+	err = addToBundle(tw, TruststorePath(staging), BUNDLE_TRUSTSTORE)
+	if err != nil {
+		return err
+	}
+
+	if _, err := os.Stat(ToolsTruststorePath(staging)); err == nil {
+		err = addToBundle(tw, ToolsTruststorePath(staging), BUNDLE_TOOLS_TRUSTSTORE)
+		if err != nil {
+			return err
+		}
+	}
+
+	err = filepath.Walk(ToolDir(staging), func(filePath string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() {
+			return err
+		}
+
+		// ETags only mean anything to the machine that fetched the files
+		if strings.HasSuffix(filePath, ETAG_SUFFIX) {
+			return err
+		}
+
+		rel, err := filepath.Rel(ToolDir(staging), filePath)
+		if err != nil {
+			return err
+		}
+
+		return addToBundle(tw, filePath, path.Join(BUNDLE_TOOLS_DIR, filepath.ToSlash(rel)))
+	})
+	if err != nil {
+		err = errors.Wrapf(err, "failed to add tools to %s", destTar)
+		return err
+	}
+
+	err = tw.Close()
+	if err != nil {
+		err = errors.Wrapf(err, "failed to finish %s", destTar)
+		return err
+	}
+
+	err = gz.Close()
+	if err != nil {
+		err = errors.Wrapf(err, "failed to finish %s", destTar)
+		return err
+	}
+
+	dbt.VerboseOutput("Wrote bundle of %d tools to %s", len(tools), destTar)
+
+	return err
+}
+
+// ImportBundle unpacks a bundle made by ExportBundle into the tool cache under homedir, after which 'dbt -o' can run the tools.  Every tool must verify before anything is installed.  If homedir already has a truststore, the tools are verified against it, and the bundle's truststores are ignored, so a bundle can't vouch for itself.  Only on a first import, when there's no truststore yet, or when trustBundle is set to confirm the bundle's truststores are wanted, are the tools verified against the bundle's truststores, which are then installed.  An existing tools truststore is never removed.
+func (dbt *DBT) ImportBundle(srcTar string, homedir string, trustBundle bool) (err error) {
+	staging, err := ioutil.TempDir("", "dbt-bundle")
+	if err != nil {
+		err = errors.Wrapf(err, "failed to create staging dir")
+		return err
+	}
+
+	defer os.RemoveAll(staging)
+
+	err = makeStagingDbtDir(staging, dbt.Verbose)
+	if err != nil {
+		return err
+	}
+
+	toolFiles, err := extractBundle(srcTar, staging)
+	if err != nil {
+		return err
+	}
+
+	_, statErr := os.Stat(TruststorePath(homedir))
+	installTrust := trustBundle || os.IsNotExist(statErr)
+
+	if installTrust {
+		if _, err := os.Stat(TruststorePath(staging)); os.IsNotExist(err) {
+			err = fmt.Errorf("bundle %s contains no truststore", srcTar)
+			return err
+		}
+	} else {
+		dbt.VerboseOutput("Verifying bundle %s against the truststore in %s", srcTar, TrustDir(homedir))
+
+		err = useLocalTrust(homedir, staging)
+		if err != nil {
+			return err
+		}
+	}
+
+	// verify everything before installing anything
+	for _, name := range toolFiles {
+		if strings.HasSuffix(name, ".sha256") || strings.HasSuffix(name, ".asc") || strings.HasSuffix(name, ETAG_SUFFIX) {
+			continue
+		}
+
+		err = dbt.verifyToolFile(staging, fmt.Sprintf("%s/%s", ToolDir(staging), name))
+		if err != nil {
+			err = errors.Wrapf(err, "%s in bundle %s failed to verify", name, srcTar)
+			return err
+		}
+	}
+
+	latest := make(map[string]string)
+	for _, name := range toolFiles {
+		parts := strings.Split(name, "/")
+		if VersionAIsNewerThanB(parts[1], latest[parts[0]]) {
+			latest[parts[0]] = parts[1]
+		}
+	}
+
+	err = GenerateDbtDir(homedir, dbt.Verbose)
+	if err != nil {
+		err = errors.Wrapf(err, "failed to create dbt dirs")
+		return err
+	}
+
+	if installTrust {
+		err = FileCopy(TruststorePath(staging), TruststorePath(homedir))
+		if err != nil {
+			err = errors.Wrapf(err, "failed to install truststore")
+			return err
+		}
+
+		if _, err := os.Stat(ToolsTruststorePath(staging)); err == nil {
+			err = FileCopy(ToolsTruststorePath(staging), ToolsTruststorePath(homedir))
+			if err != nil {
+				err = errors.Wrapf(err, "failed to install tools truststore")
+				return err
+			}
+		}
+	}
+
+	for _, name := range toolFiles {
+		// bundles exported before ETags were left out may still have them, but they don't belong to this machine
+		if strings.HasSuffix(name, ETAG_SUFFIX) {
+			continue
+		}
+
+		dbt.VerboseOutput("Installing %s", name)
+
+		destPath := fmt.Sprintf("%s/%s", ToolDir(homedir), name)
+
+		err = removeLegacyCachedTool(homedir, strings.Split(name, "/")[0])
+		if err != nil {
+			return err
+		}
+
+		err = os.MkdirAll(filepath.Dir(destPath), 0755)
+		if err != nil {
+			err = errors.Wrapf(err, "failed to create dir for %s", destPath)
+			return err
+		}
+
+		err = FileCopy(fmt.Sprintf("%s/%s", ToolDir(staging), name), destPath)
+		if err != nil {
+			err = errors.Wrapf(err, "failed to install %s", name)
+			return err
+		}
+	}
+
+	// the bundle is all there is to go on offline, so the newest version in it is the latest
+	for toolName, version := range latest {
+		if cached, _ := LatestCachedVersion(homedir, toolName); VersionAIsNewerThanB(cached, version) {
+			continue
+		}
+
+		linkErr := linkLatestCachedVersion(homedir, toolName, version)
+		if linkErr != nil {
+			dbt.VerboseOutput("Failed to link latest version of %s: %s", toolName, linkErr)
+		}
+	}
+
+	return err
+}
+
+// makeStagingDbtDir creates a throwaway dbt dir.  Creating .dbt first keeps it there even if XDG vars are set.
+func makeStagingDbtDir(staging string, verbose bool) (err error) {
+	err = os.MkdirAll(fmt.Sprintf("%s/%s", staging, DbtDir), 0755)
+	if err != nil {
+		err = errors.Wrapf(err, "failed to create staging dir")
+		return err
+	}
+
+	err = GenerateDbtDir(staging, verbose)
+	if err != nil {
+		err = errors.Wrapf(err, "failed to create staging dir")
+		return err
+	}
+
+	return err
+}
+
+// addToBundle writes a single file into the bundle under the given name
+func addToBundle(tw *tar.Writer, filePath string, name string) (err error) {
+	info, err := os.Stat(filePath)
+	if err != nil {
+		err = errors.Wrapf(err, "failed to stat %s", filePath)
+		return err
+	}
+
+	header, err := tar.FileInfoHeader(info, "")
+	if err != nil {
+		err = errors.Wrapf(err, "failed to create tar header for %s", filePath)
+		return err
+	}
+
+	header.Name = name
+
+	err = tw.WriteHeader(header)
+	if err != nil {
+		err = errors.Wrapf(err, "failed to write tar header for %s", name)
+		return err
+	}
+
+	f, err := os.Open(filePath)
+	if err != nil {
+		err = errors.Wrapf(err, "failed to open %s", filePath)
+		return err
+	}
+
+	defer f.Close()
+
+	_, err = io.Copy(tw, f)
+	if err != nil {
+		err = errors.Wrapf(err, "failed to add %s to bundle", name)
+		return err
+	}
+
+	return err
+}
+
+// bundleToolPath returns true if name is a tool file in a bundle, i.e. tools/<tool>/<version>/<file>, with nothing in it that could climb out of the tool dir.
+func bundleToolPath(name string) bool {
+	parts := strings.Split(name, "/")
+	if len(parts) != 4 || parts[0] != BUNDLE_TOOLS_DIR {
+		return false
+	}
+
+	for _, part := range parts[1:] {
+		if part == "" || part == "." || part == ".." || strings.Contains(part, "\\") {
+			return false
+		}
+	}
+
+	semverMatch := regexp.MustCompile(`^\d+\.\d+\.\d+$`)
+
+	return semverMatch.MatchString(parts[2])
+}
+
+// useLocalTrust replaces the truststores unpacked into staging with those under homedir, so the bundle is verified against what's already trusted.
+func useLocalTrust(homedir string, staging string) (err error) {
+	err = FileCopy(TruststorePath(homedir), TruststorePath(staging))
+	if err != nil {
+		err = errors.Wrapf(err, "failed to copy truststore")
+		return err
+	}
+
+	if _, err := os.Stat(ToolsTruststorePath(homedir)); err == nil {
+		err = FileCopy(ToolsTruststorePath(homedir), ToolsTruststorePath(staging))
+		if err != nil {
+			err = errors.Wrapf(err, "failed to copy tools truststore")
+			return err
+		}
+
+		return err
+	}
+
+	err = os.Remove(ToolsTruststorePath(staging))
+	if err != nil && !os.IsNotExist(err) {
+		err = errors.Wrapf(err, "failed to remove bundled tools truststore")
+		return err
+	}
+
+	return nil
+}
+
+// extractBundle unpacks a bundle into a staging dbt dir, returning the paths of the tool files in it, relative to the tool dir.  Anything other than the truststores and tools/<tool>/<version>/<file> is refused, so a bundle can't write outside the dbt dir.
+func extractBundle(srcTar string, staging string) (toolFiles []string, err error) {
+	toolFiles = make([]string, 0)
+
+	in, err := os.Open(srcTar)
+	if err != nil {
+		err = errors.Wrapf(err, "failed to open bundle %s", srcTar)
+		return toolFiles, err
+	}
+
+	defer in.Close()
+
+	gz, err := gzip.NewReader(in)
+	if err != nil {
+		err = errors.Wrapf(err, "failed to read bundle %s", srcTar)
+		return toolFiles, err
+	}
+
+	defer gz.Close()
+
+	tr := tar.NewReader(gz)
+
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+
+		if err != nil {
+			err = errors.Wrapf(err, "failed to read bundle %s", srcTar)
+			return toolFiles, err
+		}
+
+		var destPath string
+		var mode os.FileMode = 0644
+
+		switch {
+		case header.Name == BUNDLE_TRUSTSTORE:
+			destPath = TruststorePath(staging)
+		case header.Name == BUNDLE_TOOLS_TRUSTSTORE:
+			destPath = ToolsTruststorePath(staging)
+		case bundleToolPath(header.Name):
+			rel := strings.TrimPrefix(header.Name, BUNDLE_TOOLS_DIR+"/")
+			destPath = filepath.Join(ToolDir(staging), filepath.FromSlash(rel))
+			mode = 0755
+			toolFiles = append(toolFiles, rel)
+
+			err = os.MkdirAll(filepath.Dir(destPath), 0755)
+			if err != nil {
+				err = errors.Wrapf(err, "failed to create dir for %s", destPath)
+				return toolFiles, err
+			}
+		default:
+			err = fmt.Errorf("unexpected file %q in bundle %s", header.Name, srcTar)
+			return toolFiles, err
+		}
+
+		if header.Typeflag != tar.TypeReg {
+			err = fmt.Errorf("%q in bundle %s is not a regular file", header.Name, srcTar)
+			return toolFiles, err
+		}
+
+		out, err := os.OpenFile(destPath, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, mode)
+		if err != nil {
+			err = errors.Wrapf(err, "failed to create %s", destPath)
+			return toolFiles, err
+		}
+
+		_, err = io.Copy(out, tr)
+		_ = out.Close()
+		if err != nil {
+			err = errors.Wrapf(err, "failed to extract %s", header.Name)
+			return toolFiles, err
+		}
+	}
+
+	return toolFiles, err
+}
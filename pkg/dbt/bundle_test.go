@@ -0,0 +1,432 @@
+// Copyright © 2019 Nik Ogura <nik.ogura@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package dbt
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"github.com/keybase/go-crypto/openpgp"
+	"github.com/keybase/go-crypto/openpgp/armor"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+// newTestSignedRepo serves a repository holding one signed tool, and a truststore holding the key that signed it.  Unlike the repo built in setUp, this needs neither gpg nor gomason.  The repo's root dir is returned so tests can add to it.
+func newTestSignedRepo(t *testing.T, toolName string, versions ...string) (repoRoot string, config Config, signer *openpgp.Entity) {
+	repoRoot, err := ioutil.TempDir("", "dbt-signed-repo")
+	if err != nil {
+		t.Fatalf("Failed creating repo root: %s", err)
+	}
+
+	t.Cleanup(func() { os.RemoveAll(repoRoot) })
+
+	signer = newTestSigner(t, "tester")
+
+	writeTestTrustStore(t, fmt.Sprintf("%s/truststore", repoRoot), signer)
+
+	for _, version := range versions {
+		writeTestSignedTool(t, signer, fmt.Sprintf("%s/dbt-tools/%s/%s/%s/%s/%s", repoRoot, toolName, version, runtime.GOOS, runtime.GOARCH, ToolFileName(toolName, runtime.GOOS)), fmt.Sprintf("#!/bin/sh\necho %s %s\n", toolName, version))
+	}
+
+	server := httptest.NewServer(http.FileServer(http.Dir(repoRoot)))
+	t.Cleanup(server.Close)
+
+	config = Config{
+		Dbt: DbtConfig{
+			Repo:       fmt.Sprintf("%s/dbt", server.URL),
+			TrustStore: fmt.Sprintf("%s/truststore", server.URL),
+		},
+		Tools: ToolsConfig{
+			Repo: fmt.Sprintf("%s/dbt-tools", server.URL),
+		},
+	}
+
+	return repoRoot, config, signer
+}
+
+// withTestETags serves the repo at repoRoot again, the way the reposerver does, with ETags, and returns config pointed at it.
+func withTestETags(t *testing.T, repoRoot string, config Config) Config {
+	server := httptest.NewServer(ETagHandler(http.Dir(repoRoot), http.FileServer(http.Dir(repoRoot))))
+	t.Cleanup(server.Close)
+
+	config.Dbt.Repo = fmt.Sprintf("%s/dbt", server.URL)
+	config.Dbt.TrustStore = fmt.Sprintf("%s/truststore", server.URL)
+	config.Tools.Repo = fmt.Sprintf("%s/dbt-tools", server.URL)
+
+	return config
+}
+
+// newTestSigner creates a signing key
+func newTestSigner(t *testing.T, name string) (signer *openpgp.Entity) {
+	signer, err := openpgp.NewEntity(name, "", fmt.Sprintf("%s@nikogura.com", name), nil)
+	if err != nil {
+		t.Fatalf("Failed creating signing key: %s", err)
+	}
+
+	// NewEntity doesn't self-sign the identities until the private key is serialized, and the public key can't be serialized without them.
+	err = signer.SerializePrivate(ioutil.Discard, nil)
+	if err != nil {
+		t.Fatalf("Failed self-signing key: %s", err)
+	}
+
+	return signer
+}
+
+// writeTestTrustStore writes a truststore holding the public key of the signer
+func writeTestTrustStore(t *testing.T, filePath string, signer *openpgp.Entity) {
+	truststore, err := os.Create(filePath)
+	if err != nil {
+		t.Fatalf("Failed creating truststore: %s", err)
+	}
+
+	w, err := armor.Encode(truststore, openpgp.PublicKeyType, nil)
+	if err != nil {
+		t.Fatalf("Failed armoring truststore: %s", err)
+	}
+
+	err = signer.Serialize(w)
+	if err != nil {
+		t.Fatalf("Failed writing truststore: %s", err)
+	}
+
+	_ = w.Close()
+	_, _ = truststore.WriteString("\n")
+	_ = truststore.Close()
+}
+
+// writeTestSignedTool writes a tool, its checksum, and its signature
+func writeTestSignedTool(t *testing.T, signer *openpgp.Entity, filePath string, content string) {
+	err := os.MkdirAll(filepath.Dir(filePath), 0755)
+	if err != nil {
+		t.Fatalf("Failed creating tool dir: %s", err)
+	}
+
+	err = ioutil.WriteFile(filePath, []byte(content), 0755)
+	if err != nil {
+		t.Fatalf("Failed writing tool: %s", err)
+	}
+
+	checksum, err := FileSha256(filePath)
+	if err != nil {
+		t.Fatalf("Failed checksumming tool: %s", err)
+	}
+
+	err = ioutil.WriteFile(fmt.Sprintf("%s.sha256", filePath), []byte(checksum), 0644)
+	if err != nil {
+		t.Fatalf("Failed writing checksum: %s", err)
+	}
+
+	sig := &bytes.Buffer{}
+
+	err = openpgp.ArmoredDetachSign(sig, signer, bytes.NewReader([]byte(content)), nil)
+	if err != nil {
+		t.Fatalf("Failed signing tool: %s", err)
+	}
+
+	err = ioutil.WriteFile(fmt.Sprintf("%s.asc", filePath), sig.Bytes(), 0644)
+	if err != nil {
+		t.Fatalf("Failed writing signature: %s", err)
+	}
+}
+
+func TestBundleRoundTrip(t *testing.T) {
+	_, config, _ := newTestSignedRepo(t, "foo", "1.0.0", "1.1.0")
+
+	workDir, err := ioutil.TempDir("", "dbt-bundle-test")
+	if err != nil {
+		t.Fatalf("Failed creating work dir: %s", err)
+	}
+
+	defer os.RemoveAll(workDir)
+
+	exporter := &DBT{Config: config}
+	bundle := fmt.Sprintf("%s/bundle.tgz", workDir)
+
+	err = exporter.ExportBundle([]ToolSpec{{Name: "foo", Version: "1.0.0"}}, bundle)
+	if err != nil {
+		t.Fatalf("Failed exporting bundle: %s", err)
+	}
+
+	inputs := []struct {
+		name   string
+		tamper func(name string, content []byte) []byte
+		err    bool
+	}{
+		{
+			"good",
+			nil,
+			false,
+		},
+		{
+			"tampered tool",
+			func(name string, content []byte) []byte {
+				if name == fmt.Sprintf("%s/foo/1.0.0/%s", BUNDLE_TOOLS_DIR, ToolFileName("foo", runtime.GOOS)) {
+					return []byte("#!/bin/sh\nrm -rf /\n")
+				}
+				return content
+			},
+			true,
+		},
+		{
+			"path traversal",
+			func(name string, content []byte) []byte {
+				return content
+			},
+			true,
+		},
+	}
+
+	for _, tc := range inputs {
+		t.Run(tc.name, func(t *testing.T) {
+			homedir := fmt.Sprintf("%s/%s", workDir, tc.name)
+			err := os.MkdirAll(fmt.Sprintf("%s/%s", homedir, DbtDir), 0755)
+			if err != nil {
+				t.Fatalf("Failed creating homedir: %s", err)
+			}
+
+			src := bundle
+			if tc.tamper != nil {
+				src = fmt.Sprintf("%s/%s.tgz", workDir, tc.name)
+				rewriteTestBundle(t, bundle, src, tc.tamper, tc.name == "path traversal")
+			}
+
+			importer := &DBT{}
+			err = importer.ImportBundle(src, homedir, false)
+			localPath := CachedToolPath(homedir, "foo", "1.0.0")
+
+			if tc.err {
+				assert.NotNil(t, err, "Bad bundle is rejected.")
+				_, statErr := os.Stat(localPath)
+				assert.True(t, os.IsNotExist(statErr), "Nothing is installed from a bad bundle.")
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("Failed importing bundle: %s", err)
+			}
+
+			content, err := ioutil.ReadFile(localPath)
+			if err != nil {
+				t.Fatalf("Failed reading imported tool: %s", err)
+			}
+
+			assert.Equal(t, "#!/bin/sh\necho foo 1.0.0\n", string(content), "Requested version is imported.")
+
+			_, err = importer.verifyTool(homedir, "foo", "")
+			assert.Nil(t, err, "Imported tool verifies offline.")
+		})
+	}
+}
+
+func TestImportBundleTrust(t *testing.T) {
+	trustedRoot, trustedConfig, _ := newTestSignedRepo(t, "foo", "1.0.0")
+	otherRoot, otherConfig, _ := newTestSignedRepo(t, "foo", "1.0.0")
+
+	workDir := t.TempDir()
+
+	bundles := map[string]string{
+		"trusted": fmt.Sprintf("%s/trusted.tgz", workDir),
+		"other":   fmt.Sprintf("%s/other.tgz", workDir),
+	}
+
+	for name, config := range map[string]Config{"trusted": trustedConfig, "other": otherConfig} {
+		err := (&DBT{Config: config}).ExportBundle([]ToolSpec{{Name: "foo", Version: "1.0.0"}}, bundles[name])
+		if err != nil {
+			t.Fatalf("Failed exporting %s bundle: %s", name, err)
+		}
+	}
+
+	trustedKeys, err := ioutil.ReadFile(fmt.Sprintf("%s/truststore", trustedRoot))
+	if err != nil {
+		t.Fatalf("Failed reading truststore: %s", err)
+	}
+
+	otherKeys, err := ioutil.ReadFile(fmt.Sprintf("%s/truststore", otherRoot))
+	if err != nil {
+		t.Fatalf("Failed reading truststore: %s", err)
+	}
+
+	toolsKeys := []byte("tools keys")
+
+	inputs := []struct {
+		name        string
+		bundle      string
+		localTrust  bool
+		localTools  bool
+		trustBundle bool
+		err         bool
+		truststore  []byte
+	}{
+		{"first import", "other", false, false, false, false, otherKeys},
+		{"trusted bundle", "trusted", true, false, false, false, trustedKeys},
+		{"untrusted bundle", "other", true, false, false, true, trustedKeys},
+		{"untrusted bundle confirmed", "other", true, false, true, false, otherKeys},
+		{"tools truststore kept", "other", true, true, true, false, otherKeys},
+	}
+
+	for _, tc := range inputs {
+		t.Run(tc.name, func(t *testing.T) {
+			homedir := t.TempDir()
+
+			err := GenerateDbtDir(homedir, false)
+			if err != nil {
+				t.Fatalf("Failed creating dbt dir: %s", err)
+			}
+
+			if tc.localTrust {
+				err = ioutil.WriteFile(TruststorePath(homedir), trustedKeys, 0644)
+				if err != nil {
+					t.Fatalf("Failed writing truststore: %s", err)
+				}
+			}
+
+			if tc.localTools {
+				err = ioutil.WriteFile(ToolsTruststorePath(homedir), toolsKeys, 0644)
+				if err != nil {
+					t.Fatalf("Failed writing tools truststore: %s", err)
+				}
+			}
+
+			importer := &DBT{}
+			err = importer.ImportBundle(bundles[tc.bundle], homedir, tc.trustBundle)
+
+			if tc.err {
+				if assert.NotNil(t, err, "Bundle not signed by a trusted key is rejected.") {
+					assert.True(t, errors.Is(err, ErrSignatureMismatch), "Error %q is a signature mismatch.", err)
+				}
+
+				_, statErr := os.Stat(CachedToolPath(homedir, "foo", "1.0.0"))
+				assert.True(t, os.IsNotExist(statErr), "Nothing is installed from an untrusted bundle.")
+			} else if err != nil {
+				t.Fatalf("Failed importing bundle: %s", err)
+			}
+
+			truststore, err := ioutil.ReadFile(TruststorePath(homedir))
+			if err != nil {
+				t.Fatalf("Failed reading truststore: %s", err)
+			}
+
+			assert.Equal(t, string(tc.truststore), string(truststore), "Truststore meets expectations.")
+
+			if tc.localTools {
+				tools, err := ioutil.ReadFile(ToolsTruststorePath(homedir))
+				if err != nil {
+					t.Fatalf("Failed reading tools truststore: %s", err)
+				}
+
+				assert.Equal(t, string(toolsKeys), string(tools), "Tools truststore is kept.")
+			}
+		})
+	}
+}
+
+func TestBundleRoundTripETags(t *testing.T) {
+	repoRoot, config, _ := newTestSignedRepo(t, "foo", "1.0.0")
+	config = withTestETags(t, repoRoot, config)
+
+	bundle := fmt.Sprintf("%s/bundle.tgz", t.TempDir())
+
+	err := (&DBT{Config: config}).ExportBundle([]ToolSpec{{Name: "foo", Version: "1.0.0"}}, bundle)
+	if err != nil {
+		t.Fatalf("Failed exporting bundle: %s", err)
+	}
+
+	// ETags only mean anything to the machine that fetched the files, so they're left out
+	rewriteTestBundle(t, bundle, fmt.Sprintf("%s.copy", bundle), func(name string, content []byte) []byte {
+		assert.False(t, strings.HasSuffix(name, ETAG_SUFFIX), "%s isn't bundled.", name)
+		return content
+	}, false)
+
+	homedir := t.TempDir()
+
+	importer := &DBT{}
+
+	err = importer.ImportBundle(bundle, homedir, false)
+	if err != nil {
+		t.Fatalf("Failed importing bundle: %s", err)
+	}
+
+	_, err = importer.verifyTool(homedir, "foo", "")
+	assert.Nil(t, err, "Imported tool verifies offline.")
+}
+
+// rewriteTestBundle copies a bundle, passing each file through tamper, and optionally slipping in a file that tries to escape the dbt dir.
+func rewriteTestBundle(t *testing.T, src string, dst string, tamper func(name string, content []byte) []byte, traverse bool) {
+	in, err := os.Open(src)
+	if err != nil {
+		t.Fatalf("Failed opening bundle: %s", err)
+	}
+
+	defer in.Close()
+
+	gzIn, err := gzip.NewReader(in)
+	if err != nil {
+		t.Fatalf("Failed reading bundle: %s", err)
+	}
+
+	out, err := os.Create(dst)
+	if err != nil {
+		t.Fatalf("Failed creating bundle: %s", err)
+	}
+
+	defer out.Close()
+
+	gzOut := gzip.NewWriter(out)
+	tw := tar.NewWriter(gzOut)
+	tr := tar.NewReader(gzIn)
+
+	writeEntry := func(name string, content []byte) {
+		err := tw.WriteHeader(&tar.Header{Name: name, Mode: 0755, Size: int64(len(content)), Typeflag: tar.TypeReg})
+		if err != nil {
+			t.Fatalf("Failed writing header: %s", err)
+		}
+
+		_, err = tw.Write(content)
+		if err != nil {
+			t.Fatalf("Failed writing content: %s", err)
+		}
+	}
+
+	if traverse {
+		writeEntry("tools/../../../evil", []byte("evil"))
+	}
+
+	for {
+		header, err := tr.Next()
+		if err != nil {
+			break
+		}
+
+		content, err := ioutil.ReadAll(tr)
+		if err != nil {
+			t.Fatalf("Failed reading content: %s", err)
+		}
+
+		writeEntry(header.Name, tamper(header.Name, content))
+	}
+
+	_ = tw.Close()
+	_ = gzOut.Close()
+}
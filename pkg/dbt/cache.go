@@ -0,0 +1,377 @@
+// Copyright © 2019 Nik Ogura <nik.ogura@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package dbt
+
+import (
+	"fmt"
+	"github.com/pkg/errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"sort"
+	"time"
+)
+
+// LATEST_LINK the symlink in a tool's cache dir pointing at the latest version of the tool
+const LATEST_LINK = "latest"
+
+// CachedTool a tool in the local cache, and which versions of it are there.  Versions are sorted oldest first.
+type CachedTool struct {
+	Name     string   `json:"name" yaml:"name"`
+	Versions []string `json:"versions" yaml:"versions"`
+	Latest   string   `json:"latest" yaml:"latest"`
+}
+
+// CachedToolDir returns the directory holding every cached version of a tool.  Usually ~/.dbt/tools/<tool>
+func CachedToolDir(homedir string, toolName string) string {
+	return fmt.Sprintf("%s/%s", ToolDir(homedir), toolName)
+}
+
+// CachedToolPath returns where a version of a tool's binary is cached.  Usually ~/.dbt/tools/<tool>/<version>/<tool>
+func CachedToolPath(homedir string, toolName string, version string) string {
+	return fmt.Sprintf("%s/%s/%s", CachedToolDir(homedir, toolName), version, ToolFileName(toolName, runtime.GOOS))
+}
+
+// ListCachedTools reports the tools in the local cache, and the versions of each.
+func ListCachedTools(homedir string) (tools []CachedTool, err error) {
+	tools = make([]CachedTool, 0)
+
+	entries, err := ioutil.ReadDir(ToolDir(homedir))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return tools, nil
+		}
+
+		err = errors.Wrapf(err, "failed to read %s", ToolDir(homedir))
+		return tools, err
+	}
+
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+
+		versions, err := cachedVersions(homedir, entry.Name())
+		if err != nil {
+			return tools, err
+		}
+
+		if len(versions) == 0 {
+			continue
+		}
+
+		latest, err := LatestCachedVersion(homedir, entry.Name())
+		if err != nil {
+			return tools, err
+		}
+
+		tools = append(tools, CachedTool{
+			Name:     entry.Name(),
+			Versions: versions,
+			Latest:   latest,
+		})
+	}
+
+	return tools, err
+}
+
+// LatestCachedVersion returns the version of a tool its latest link points to.  If there's no link, say because the filesystem doesn't do symlinks, it's the highest version cached.  Empty if the tool isn't cached at all.
+func LatestCachedVersion(homedir string, toolName string) (version string, err error) {
+	versions, err := cachedVersions(homedir, toolName)
+	if err != nil {
+		return version, err
+	}
+
+	target, linkErr := os.Readlink(fmt.Sprintf("%s/%s", CachedToolDir(homedir, toolName), LATEST_LINK))
+	if linkErr == nil && StringInSlice(target, versions) {
+		version = target
+		return version, err
+	}
+
+	version = LatestVersion(versions)
+
+	return version, err
+}
+
+// LatestCachedVersionSatisfying returns the highest cached version of a tool within a semver range, for running a range offline.  An invalid range is an error, as is a range no cached version is in.
+func LatestCachedVersionSatisfying(homedir string, toolName string, constraint string) (version string, err error) {
+	versions, err := cachedVersions(homedir, toolName)
+	if err != nil {
+		return version, err
+	}
+
+	version, err = LatestVersionSatisfying(versions, constraint)
+	if err != nil {
+		return version, err
+	}
+
+	if version == "" {
+		err = errors.Wrapf(ErrVersionNotFound, "no downloaded version of %s satisfies %s", toolName, constraint)
+		return version, err
+	}
+
+	return version, err
+}
+
+// cachedVersions returns the versions of a tool that have a binary in the cache, oldest first.
+func cachedVersions(homedir string, toolName string) (versions []string, err error) {
+	versions = make([]string, 0)
+	toolDir := CachedToolDir(homedir, toolName)
+	semverMatch := regexp.MustCompile(`^\d+\.\d+\.\d+$`)
+
+	entries, err := ioutil.ReadDir(toolDir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return versions, nil
+		}
+
+		err = errors.Wrapf(err, "failed to read %s", toolDir)
+		return versions, err
+	}
+
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+
+		if !semverMatch.MatchString(entry.Name()) {
+			continue
+		}
+
+		if _, err := os.Stat(CachedToolPath(homedir, toolName, entry.Name())); err != nil {
+			continue
+		}
+
+		versions = append(versions, entry.Name())
+	}
+
+	sort.Slice(versions, func(i, j int) bool {
+		return VersionAIsNewerThanB(versions[j], versions[i])
+	})
+
+	return versions, err
+}
+
+// linkLatestCachedVersion points a tool's latest link at the given version.  A new link is renamed over the old one, so there's never a moment without one.
+func linkLatestCachedVersion(homedir string, toolName string, version string) (err error) {
+	toolDir := CachedToolDir(homedir, toolName)
+	linkPath := fmt.Sprintf("%s/%s", toolDir, LATEST_LINK)
+	tmpPath := fmt.Sprintf("%s/.%s.tmp", toolDir, LATEST_LINK)
+
+	_ = os.Remove(tmpPath)
+
+	err = os.Symlink(version, tmpPath)
+	if err != nil {
+		err = errors.Wrapf(err, "failed to link %s to %s", tmpPath, version)
+		return err
+	}
+
+	err = os.Rename(tmpPath, linkPath)
+	if err != nil {
+		_ = os.Remove(tmpPath)
+		err = errors.Wrapf(err, "failed to replace %s", linkPath)
+		return err
+	}
+
+	return err
+}
+
+// removeLegacyCachedTool clears out a tool cached the way dbt used to, as a bare binary directly in the tool dir, so the tool's versioned dir can take its place.  There's no telling which version it was, so it's simply downloaded again.
+func removeLegacyCachedTool(homedir string, toolName string) (err error) {
+	legacyPath := fmt.Sprintf("%s/%s", ToolDir(homedir), ToolFileName(toolName, runtime.GOOS))
+
+	info, err := os.Lstat(legacyPath)
+	if err != nil || info.IsDir() {
+		return nil
+	}
+
+	for _, suffix := range []string{"", ".sha256", ".asc"} {
+		err = os.Remove(legacyPath + suffix)
+		if err != nil && !os.IsNotExist(err) {
+			err = errors.Wrapf(err, "failed to remove %s", legacyPath+suffix)
+			return err
+		}
+	}
+
+	return nil
+}
+
+// PruneOptions controls what PruneToolCache evicts.  Zero values mean no limit.
+type PruneOptions struct {
+	// MaxAge evicts versions that haven't been run for longer than this.
+	MaxAge time.Duration
+	// MaxBytes evicts the least recently run versions until the cache fits in this many bytes.
+	MaxBytes int64
+}
+
+// cachedToolFiles is a version of a tool in the cache, binary, checksum, and signature.
+type cachedToolFiles struct {
+	tool    string
+	dir     string
+	size    int64
+	lastRun time.Time
+	latest  bool
+}
+
+// PruneToolCache reclaims disk from the local tool cache.  Versions are evicted whole, binary, checksum, and signature together.  Running a tool touches its binary, so the binary's mtime is when it was last used.  Versions older than opts.MaxAge go first, then the least recently used ones until the cache is under opts.MaxBytes.  The latest version of each tool is always kept, so every tool still runs offline.  Anything evicted is simply downloaded again the next time it's asked for.  A version is only removed under its tool's lock, so one that's being downloaded is left alone.
+func PruneToolCache(homedir string, opts PruneOptions) (err error) {
+	versions, err := cachedToolGroups(homedir)
+	if err != nil {
+		return err
+	}
+
+	// least recently used first
+	sort.Slice(versions, func(i, j int) bool {
+		return versions[i].lastRun.Before(versions[j].lastRun)
+	})
+
+	var total int64
+	for _, version := range versions {
+		total += version.size
+	}
+
+	cutoff := time.Now().Add(-opts.MaxAge)
+
+	for _, version := range versions {
+		if version.latest {
+			continue
+		}
+
+		orphaned := version.lastRun.IsZero()
+		expired := opts.MaxAge > 0 && version.lastRun.Before(cutoff)
+		overBudget := opts.MaxBytes > 0 && total > opts.MaxBytes
+
+		if !orphaned && !expired && !overBudget {
+			continue
+		}
+
+		removed, err := removeCachedVersion(homedir, version)
+		if err != nil {
+			return err
+		}
+
+		if removed {
+			total -= version.size
+		}
+	}
+
+	return err
+}
+
+// removeCachedVersion removes a version from the cache, holding the tool's lock, so it can't go while it's being downloaded.  A download that finished, or a run, since the cache was looked at leaves the binary newer than it was, and the version is kept.
+func removeCachedVersion(homedir string, version *cachedToolFiles) (removed bool, err error) {
+	// bare binaries from before the cache held versions have no tool dir to lock
+	if version.tool != "" {
+		unlock, err := lockCachedTool(homedir, version.tool)
+		if err != nil {
+			return removed, err
+		}
+
+		defer unlock()
+
+		info, statErr := os.Stat(filepath.Join(version.dir, ToolFileName(version.tool, runtime.GOOS)))
+		if statErr == nil && info.ModTime().After(version.lastRun) {
+			return removed, err
+		}
+	}
+
+	err = os.RemoveAll(version.dir)
+	if err != nil {
+		err = errors.Wrapf(err, "failed to remove %s", version.dir)
+		return removed, err
+	}
+
+	removed = true
+
+	return removed, err
+}
+
+// cachedToolGroups gathers up the versions in the tool cache.  Version dirs whose binary is gone, and bare binaries left over from before the cache held versions, have a zero lastRun.  They're no use to anyone, so they always go.
+func cachedToolGroups(homedir string) (versions []*cachedToolFiles, err error) {
+	versions = make([]*cachedToolFiles, 0)
+	toolDir := ToolDir(homedir)
+
+	tools, err := ioutil.ReadDir(toolDir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return versions, nil
+		}
+
+		err = errors.Wrapf(err, "failed to read %s", toolDir)
+		return versions, err
+	}
+
+	for _, tool := range tools {
+		if !tool.IsDir() {
+			versions = append(versions, &cachedToolFiles{dir: filepath.Join(toolDir, tool.Name()), size: tool.Size()})
+			continue
+		}
+
+		latest, err := LatestCachedVersion(homedir, tool.Name())
+		if err != nil {
+			return versions, err
+		}
+
+		entries, err := ioutil.ReadDir(CachedToolDir(homedir, tool.Name()))
+		if err != nil {
+			err = errors.Wrapf(err, "failed to read %s", CachedToolDir(homedir, tool.Name()))
+			return versions, err
+		}
+
+		for _, entry := range entries {
+			if !entry.IsDir() {
+				continue
+			}
+
+			version := &cachedToolFiles{
+				tool:   tool.Name(),
+				dir:    filepath.Join(CachedToolDir(homedir, tool.Name()), entry.Name()),
+				latest: entry.Name() == latest,
+			}
+
+			files, err := ioutil.ReadDir(version.dir)
+			if err != nil {
+				err = errors.Wrapf(err, "failed to read %s", version.dir)
+				return versions, err
+			}
+
+			for _, f := range files {
+				version.size += f.Size()
+
+				if f.Name() == ToolFileName(tool.Name(), runtime.GOOS) {
+					version.lastRun = f.ModTime()
+				}
+			}
+
+			versions = append(versions, version)
+		}
+	}
+
+	return versions, err
+}
+
+// touchCachedTool marks a tool as just used, for PruneToolCache's benefit.
+func touchCachedTool(localPath string) (err error) {
+	now := time.Now()
+
+	err = os.Chtimes(localPath, now, now)
+	if err != nil {
+		err = errors.Wrapf(err, "failed to touch %s", localPath)
+	}
+
+	return err
+}
@@ -0,0 +1,320 @@
+// Copyright © 2019 Nik Ogura <nik.ogura@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package dbt
+
+import (
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestListCachedTools(t *testing.T) {
+	homedir, err := ioutil.TempDir("", "dbt-cache")
+	if err != nil {
+		t.Fatalf("Error creating temp dir: %s", err)
+	}
+
+	defer os.RemoveAll(homedir)
+
+	now := time.Now()
+
+	writeTestCachedTool(t, homedir, "foo", "1.0.0", 10, now)
+	writeTestCachedTool(t, homedir, "foo", "1.10.0", 10, now)
+	writeTestCachedTool(t, homedir, "foo", "1.2.0", 10, now)
+	writeTestCachedTool(t, homedir, "bar", "0.1.0", 10, now)
+	writeTestCachedTool(t, homedir, "bar", "0.2.0", 10, now)
+
+	// the link wins over the highest version
+	err = linkLatestCachedVersion(homedir, "bar", "0.1.0")
+	if err != nil {
+		t.Fatalf("Error linking latest: %s", err)
+	}
+
+	// a version dir without a binary doesn't count
+	err = os.MkdirAll(fmt.Sprintf("%s/9.9.9", CachedToolDir(homedir, "foo")), 0755)
+	if err != nil {
+		t.Fatalf("Error creating empty version dir: %s", err)
+	}
+
+	// nor does a tool with no versions at all
+	err = os.MkdirAll(CachedToolDir(homedir, "empty"), 0755)
+	if err != nil {
+		t.Fatalf("Error creating empty tool dir: %s", err)
+	}
+
+	tools, err := ListCachedTools(homedir)
+	if err != nil {
+		t.Fatalf("Error listing cached tools: %s", err)
+	}
+
+	expected := []CachedTool{
+		{Name: "bar", Versions: []string{"0.1.0", "0.2.0"}, Latest: "0.1.0"},
+		{Name: "foo", Versions: []string{"1.0.0", "1.2.0", "1.10.0"}, Latest: "1.10.0"},
+	}
+
+	assert.Equal(t, expected, tools, "Cached tools meet expectations.")
+}
+
+func TestListCachedToolsEmpty(t *testing.T) {
+	homedir, err := ioutil.TempDir("", "dbt-cache")
+	if err != nil {
+		t.Fatalf("Error creating temp dir: %s", err)
+	}
+
+	defer os.RemoveAll(homedir)
+
+	tools, err := ListCachedTools(homedir)
+	assert.Nil(t, err, "Nothing cached is not an error.")
+	assert.Empty(t, tools, "Nothing cached lists nothing.")
+}
+
+func TestFetchToolVersionedCache(t *testing.T) {
+	_, config, _ := newTestSignedRepo(t, "foo", "1.0.0", "2.0.0")
+
+	homedir, err := ioutil.TempDir("", "dbt-cache")
+	if err != nil {
+		t.Fatalf("Error creating temp dir: %s", err)
+	}
+
+	defer os.RemoveAll(homedir)
+
+	err = makeStagingDbtDir(homedir, false)
+	if err != nil {
+		t.Fatalf("Error creating dbt dir: %s", err)
+	}
+
+	obj := &DBT{Config: config, Logger: log.New(ioutil.Discard, "", 0)}
+
+	err = obj.FetchTrustStore(homedir)
+	if err != nil {
+		t.Fatalf("Error fetching truststore: %s", err)
+	}
+
+	// a tool cached the old way gets cleared out of the way
+	legacyPath := fmt.Sprintf("%s/%s", ToolDir(homedir), ToolFileName("foo", runtime.GOOS))
+	err = ioutil.WriteFile(legacyPath, []byte("old"), 0755)
+	if err != nil {
+		t.Fatalf("Error writing legacy tool: %s", err)
+	}
+
+	inputs := []struct {
+		name    string
+		version string
+		path    string
+	}{
+		{"old version", "1.0.0", CachedToolPath(homedir, "foo", "1.0.0")},
+		{"latest", "", CachedToolPath(homedir, "foo", "2.0.0")},
+	}
+
+	for _, tc := range inputs {
+		t.Run(tc.name, func(t *testing.T) {
+			localPath, err := obj.FetchTool("foo", tc.version, homedir)
+			if err != nil {
+				t.Fatalf("Error fetching tool: %s", err)
+			}
+
+			assert.Equal(t, tc.path, localPath, "Tool is cached by version.")
+		})
+	}
+
+	tools, err := ListCachedTools(homedir)
+	if err != nil {
+		t.Fatalf("Error listing cached tools: %s", err)
+	}
+
+	assert.Equal(t, []CachedTool{{Name: "foo", Versions: []string{"1.0.0", "2.0.0"}, Latest: "2.0.0"}}, tools, "Both versions are cached side by side.")
+
+	target, err := os.Readlink(fmt.Sprintf("%s/%s", CachedToolDir(homedir, "foo"), LATEST_LINK))
+	assert.Nil(t, err, "Latest link exists.")
+	assert.Equal(t, "2.0.0", target, "Latest link points at the latest version.")
+
+	// offline, the latest is what runs
+	localPath, err := obj.verifyTool(homedir, "foo", "")
+	assert.Nil(t, err, "Latest cached version verifies.")
+	assert.Equal(t, CachedToolPath(homedir, "foo", "2.0.0"), localPath, "Latest cached version is found offline.")
+}
+
+func TestPruneToolCache(t *testing.T) {
+	now := time.Now()
+
+	// version, age, and size of each cached version of foo.  3.0.0 is the latest.
+	cached := []struct {
+		version string
+		age     time.Duration
+		size    int
+	}{
+		{"3.0.0", 200 * 24 * time.Hour, 100},
+		{"2.0.0", time.Hour, 100},
+		{"1.1.0", 10 * 24 * time.Hour, 100},
+		{"1.0.0", 100 * 24 * time.Hour, 100},
+	}
+
+	inputs := []struct {
+		name string
+		opts PruneOptions
+		kept []string
+	}{
+		{"no limits", PruneOptions{}, []string{"1.0.0", "1.1.0", "2.0.0", "3.0.0"}},
+		{"max age", PruneOptions{MaxAge: 7 * 24 * time.Hour}, []string{"2.0.0", "3.0.0"}},
+		{"max bytes", PruneOptions{MaxBytes: 350}, []string{"1.1.0", "2.0.0", "3.0.0"}},
+		{"max bytes fits", PruneOptions{MaxBytes: 10000}, []string{"1.0.0", "1.1.0", "2.0.0", "3.0.0"}},
+		{"both", PruneOptions{MaxAge: 50 * 24 * time.Hour, MaxBytes: 250}, []string{"2.0.0", "3.0.0"}},
+		{"nothing fits", PruneOptions{MaxBytes: 1}, []string{"3.0.0"}},
+	}
+
+	for _, tc := range inputs {
+		t.Run(tc.name, func(t *testing.T) {
+			homedir, err := ioutil.TempDir("", "dbt-prune")
+			if err != nil {
+				t.Fatalf("Error creating temp dir: %s", err)
+			}
+
+			defer os.RemoveAll(homedir)
+
+			for _, c := range cached {
+				writeTestCachedTool(t, homedir, "foo", c.version, c.size, now.Add(-c.age))
+			}
+
+			err = linkLatestCachedVersion(homedir, "foo", "3.0.0")
+			if err != nil {
+				t.Fatalf("Error linking latest: %s", err)
+			}
+
+			// a bare binary from before the cache held versions
+			legacyPath := fmt.Sprintf("%s/legacy", ToolDir(homedir))
+			err = ioutil.WriteFile(legacyPath, []byte("x"), 0755)
+			if err != nil {
+				t.Fatalf("Error writing legacy tool: %s", err)
+			}
+
+			err = PruneToolCache(homedir, tc.opts)
+			if err != nil {
+				t.Fatalf("Error pruning cache: %s", err)
+			}
+
+			kept, err := cachedVersions(homedir, "foo")
+			if err != nil {
+				t.Fatalf("Error listing versions: %s", err)
+			}
+
+			sort.Strings(kept)
+
+			assert.Equal(t, tc.kept, kept, "Cached versions kept meet expectations.")
+
+			_, err = os.Stat(legacyPath)
+			assert.True(t, os.IsNotExist(err), "Legacy tool is always pruned.")
+
+			// versions go whole, sidecars and all
+			entries, _ := ioutil.ReadDir(CachedToolDir(homedir, "foo"))
+			for _, entry := range entries {
+				if entry.Name() != LATEST_LINK && entry.Name() != CACHE_LOCK_FILE {
+					assert.True(t, strings.Contains(strings.Join(kept, " "), entry.Name()), "%s was left behind", entry.Name())
+				}
+			}
+		})
+	}
+}
+
+func TestPruneToolCacheDownloading(t *testing.T) {
+	homedir := t.TempDir()
+	now := time.Now()
+
+	writeTestCachedTool(t, homedir, "foo", "2.0.0", 100, now)
+
+	err := linkLatestCachedVersion(homedir, "foo", "2.0.0")
+	if err != nil {
+		t.Fatalf("Error linking latest: %s", err)
+	}
+
+	// a download of 1.0.0 that has its dir, but not yet its binary, looks orphaned
+	unlock, err := lockCachedTool(homedir, "foo")
+	if err != nil {
+		t.Fatalf("Error locking tool: %s", err)
+	}
+
+	localPath := CachedToolPath(homedir, "foo", "1.0.0")
+
+	err = os.MkdirAll(filepath.Dir(localPath), 0755)
+	if err != nil {
+		t.Fatalf("Error creating version dir: %s", err)
+	}
+
+	pruned := make(chan error, 1)
+
+	go func() {
+		pruned <- PruneToolCache(homedir, PruneOptions{})
+	}()
+
+	// the prune sees the half done download, and waits on the lock for it
+	time.Sleep(200 * time.Millisecond)
+
+	err = ioutil.WriteFile(localPath, []byte("#!/bin/sh\necho foo\n"), 0755)
+	if err != nil {
+		t.Fatalf("Error writing tool: %s", err)
+	}
+
+	unlock()
+
+	err = <-pruned
+	if err != nil {
+		t.Fatalf("Error pruning cache: %s", err)
+	}
+
+	_, err = os.Stat(localPath)
+	assert.NoError(t, err, "Version downloaded while pruning is kept.")
+}
+
+func TestPruneToolCacheMissingDir(t *testing.T) {
+	homedir, err := ioutil.TempDir("", "dbt-prune")
+	if err != nil {
+		t.Fatalf("Error creating temp dir: %s", err)
+	}
+
+	defer os.RemoveAll(homedir)
+
+	err = PruneToolCache(homedir, PruneOptions{MaxAge: time.Hour})
+	assert.Nil(t, err, "Nothing cached is nothing to prune.")
+}
+
+// writeTestCachedTool puts a version of a tool in the cache.  The sidecars are 5 bytes each, and count towards size, to keep the budget math easy.
+func writeTestCachedTool(t *testing.T, homedir string, toolName string, version string, size int, mtime time.Time) {
+	localPath := CachedToolPath(homedir, toolName, version)
+
+	err := os.MkdirAll(filepath.Dir(localPath), 0755)
+	if err != nil {
+		t.Fatalf("Error creating version dir: %s", err)
+	}
+
+	files := map[string]int{localPath: size - 10, localPath + ".sha256": 5, localPath + ".asc": 5}
+	for filePath, fileSize := range files {
+		err = ioutil.WriteFile(filePath, []byte(strings.Repeat("x", fileSize)), 0755)
+		if err != nil {
+			t.Fatalf("Error writing %s: %s", filePath, err)
+		}
+
+		err = os.Chtimes(filePath, mtime, mtime)
+		if err != nil {
+			t.Fatalf("Error setting mtime on %s: %s", filePath, err)
+		}
+	}
+}
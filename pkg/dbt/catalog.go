@@ -2,27 +2,60 @@ package dbt
 
 import (
 	"fmt"
-	"github.com/aws/aws-sdk-go/aws"
-	"github.com/aws/aws-sdk-go/service/s3"
-	"github.com/aws/aws-sdk-go/service/s3/s3manager"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/s3/manager"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
 	"github.com/pkg/errors"
 	"golang.org/x/net/html"
 	"io/ioutil"
 	"net/http"
+	"os"
+	"sort"
 	"strings"
-	"time"
+	"sync"
 )
 
-// FetchCatalog shows you what tools are available in your trusted repo.  Repo is figured out from the config in ~/.dbt/conf/dbt.json
-func (dbt *DBT) FetchCatalog(showVersions bool) (err error) {
+// DEFAULT_CATALOG_CONCURRENCY How many tools ListCatalog looks up at once, unless the config says otherwise.
+const DEFAULT_CATALOG_CONCURRENCY = 8
+
+// DESCRIPTION_UNAVAILABLE Stands in for a description that couldn't be fetched or verified.
+const DESCRIPTION_UNAVAILABLE = "<description unavailable>"
+
+// FetchCatalog shows you what tools are available in your trusted repo.  Repo is figured out from the config in ~/.dbt/conf/dbt.json.  Descriptions are verified against the truststore under homedir, which defaults to the user's.
+func (dbt *DBT) FetchCatalog(showVersions bool, homedir string) (err error) {
 	fmt.Printf("Fetching information from the repository...\n")
 
-	tools, err := dbt.FetchToolNames()
+	tools, err := dbt.ListCatalog(showVersions, homedir)
+	if err != nil {
+		return err
+	}
+
+	printCatalog(tools)
+
+	return err
+}
+
+// FetchCatalogSearch shows you the tools in your trusted repo matching the query, best matches first.  See SearchCatalog.
+func (dbt *DBT) FetchCatalogSearch(query string, showVersions bool, homedir string) (err error) {
+	fmt.Printf("Searching the repository...\n")
+
+	tools, err := dbt.SearchCatalog(query, showVersions, homedir)
 	if err != nil {
-		err = errors.Wrap(err, "failed to fetch tools from repo")
 		return err
 	}
 
+	if len(tools) == 0 {
+		fmt.Printf("No tools match %q.\n", query)
+		return err
+	}
+
+	printCatalog(tools)
+
+	return err
+}
+
+// printCatalog prints catalog entries as a table.
+func printCatalog(tools []Tool) {
 	// figure out the longest name and set up the fixed with spacing based on it
 	largest := 0
 	spacing := 4
@@ -45,33 +78,13 @@ func (dbt *DBT) FetchCatalog(showVersions bool) (err error) {
 	fmt.Printf("\n\n")
 
 	for _, tool := range tools {
-		version, err := dbt.FindLatestVersion(tool.Name)
-		if err != nil {
-			err = errors.Wrapf(err, "failed to get latest version of %s from %s", tool.Name, dbt.Config.Tools.Repo)
-			return err
-		}
-
-		description, err := dbt.FetchToolDescription(tool.Name, version)
-		if err != nil {
-			err = errors.Wrapf(err, "Failed to get description of %s from %s", tool.Name, dbt.Config.Tools.Repo)
-			return err
-		}
-
 		tool.FormattedName = fmt.Sprintf(formatstring, tool.Name, pad)
 
-		fmt.Printf("\t%s\t\t%s\t\t\t%s\n", tool.FormattedName, version, description)
+		fmt.Printf("\t%s\t\t%s\t\t\t%s\n", tool.FormattedName, tool.Version, tool.Description)
 
-		if showVersions {
-			versions, err := dbt.FetchToolVersions(tool.Name)
-			if err != nil {
-				err = errors.Wrapf(err, "failed to get versions of %s from %s", tool.Name, dbt.Config.Tools.Repo)
-				return err
-			}
-
-			for _, v := range versions {
-				if v != version {
-					fmt.Printf("\t\t\t\t\t%s\n", v)
-				}
+		for _, v := range tool.Versions {
+			if v != tool.Version {
+				fmt.Printf("\t\t\t\t\t%s\n", v)
 			}
 		}
 	}
@@ -79,58 +92,252 @@ func (dbt *DBT) FetchCatalog(showVersions bool) (err error) {
 	fmt.Printf("\n\n")
 	fmt.Printf("Further information on any tool can be shown by running 'dbt <command> help'.\n")
 	fmt.Printf("\n\n")
+}
+
+// ListCatalog returns the tools in the trusted repo, sorted by name, with their latest versions and descriptions, and all their versions if showVersions is set.  Tools are looked up concurrently, Tools.CatalogConcurrency at a time.  A description that can't be fetched or verified doesn't spoil the listing.  It's just DESCRIPTION_UNAVAILABLE.
+func (dbt *DBT) ListCatalog(showVersions bool, homedir string) (tools []Tool, err error) {
+	tools, err = dbt.FetchToolNames()
+	if err != nil {
+		err = errors.Wrap(err, "failed to fetch tools from repo")
+		return tools, err
+	}
+
+	sort.Slice(tools, func(i, j int) bool { return tools[i].Name < tools[j].Name })
+
+	workers := dbt.Config.Tools.CatalogConcurrency
+	if workers < 1 {
+		workers = DEFAULT_CATALOG_CONCURRENCY
+	}
+
+	// each worker only ever writes its own tool's slot, so the results land in order without any locking
+	errs := make([]error, len(tools))
+	indices := make(chan int)
+
+	var wg sync.WaitGroup
+
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			for i := range indices {
+				errs[i] = dbt.catalogTool(&tools[i], showVersions, homedir)
+			}
+		}()
+	}
+
+	for i := range tools {
+		indices <- i
+	}
+
+	close(indices)
+	wg.Wait()
+
+	for _, e := range errs {
+		if e != nil {
+			err = e
+			return tools, err
+		}
+	}
+
+	return tools, err
+}
+
+// SearchCatalog returns the tools in the trusted repo whose names or descriptions contain every word of the query, ignoring case, best matches first.  A match on the name beats one on the description, and an exact name beats a prefix beats the rest.  It's ListCatalog filtered client side, so it works against any repo.
+func (dbt *DBT) SearchCatalog(query string, showVersions bool, homedir string) (tools []Tool, err error) {
+	terms := strings.Fields(strings.ToLower(query))
+	if len(terms) == 0 {
+		err = errors.New("nothing to search for")
+		return tools, err
+	}
+
+	all, err := dbt.ListCatalog(showVersions, homedir)
+	if err != nil {
+		return tools, err
+	}
+
+	tools = make([]Tool, 0)
+	scores := make(map[string]int)
+
+	for _, tool := range all {
+		score := catalogMatch(tool, terms)
+		if score > 0 {
+			tools = append(tools, tool)
+			scores[tool.Name] = score
+		}
+	}
+
+	// ListCatalog sorted them by name, so a stable sort leaves ties in alphabetical order
+	sort.SliceStable(tools, func(i, j int) bool { return scores[tools[i].Name] > scores[tools[j].Name] })
+
+	return tools, err
+}
+
+// catalogMatch scores how well a tool matches the search terms.  Every term has to match somewhere.  Zero is no match.
+func catalogMatch(tool Tool, terms []string) (score int) {
+	name := strings.ToLower(tool.Name)
+	description := ""
+
+	if tool.Description != DESCRIPTION_UNAVAILABLE {
+		description = strings.ToLower(tool.Description)
+	}
+
+	for _, term := range terms {
+		switch {
+		case name == term:
+			score += 8
+		case strings.HasPrefix(name, term):
+			score += 4
+		case strings.Contains(name, term):
+			score += 2
+		case strings.Contains(description, term):
+			score++
+		default:
+			return 0
+		}
+	}
+
+	return score
+}
+
+// catalogTool fills in a tool's catalog entry.
+func (dbt *DBT) catalogTool(tool *Tool, showVersions bool, homedir string) (err error) {
+	tool.Version, err = dbt.FindLatestVersion(tool.Name)
+	if err != nil {
+		err = errors.Wrapf(err, "failed to get latest version of %s from %s", tool.Name, dbt.Config.Tools.Repo)
+		return err
+	}
+
+	tool.Description, err = dbt.FetchToolDescription(tool.Name, tool.Version, homedir)
+	if err != nil {
+		dbt.VerboseOutput("Failed to get description of %s from %s: %s", tool.Name, dbt.Config.Tools.Repo, err)
+		tool.Description = DESCRIPTION_UNAVAILABLE
+		err = nil
+	}
+
+	if showVersions {
+		tool.Versions, err = dbt.FetchToolVersions(tool.Name)
+		if err != nil {
+			err = errors.Wrapf(err, "failed to get versions of %s from %s", tool.Name, dbt.Config.Tools.Repo)
+			return err
+		}
+	}
 
 	return err
 }
 
-// FetchToolDescription fetches the tool description from the repository.
-func (dbt *DBT) FetchToolDescription(tool string, version string) (description string, err error) {
+// FetchToolDescription fetches the tool description from the repository, and verifies its signature against the truststore under homedir, just like the tool itself.  A description that doesn't verify is an error.
+func (dbt *DBT) FetchToolDescription(tool string, version string, homedir string) (description string, err error) {
 	uri := fmt.Sprintf("%s/%s/%s/description.txt", dbt.Config.Tools.Repo, tool, version)
 
-	isS3, s3Meta := S3Url(uri)
+	dbt.VerboseOutput("Fetching tool description from  from %s", uri)
 
-	if isS3 {
-		return dbt.S3FetchDescription(s3Meta)
+	description, err = dbt.fetchDescriptionFile(uri)
+	if err != nil {
+		return description, err
 	}
 
-	client := &http.Client{
-		Timeout: 10 * time.Second,
+	signature, err := dbt.fetchDescriptionFile(fmt.Sprintf("%s.asc", uri))
+	if err != nil {
+		err = errors.Wrapf(err, "failed to fetch signature for description of %s", tool)
+		return "", err
 	}
 
-	dbt.VerboseOutput("Fetching tool description from  from %s", uri)
+	err = dbt.verifyDescription(homedir, description, signature)
+	if err != nil {
+		err = errors.Wrapf(err, "description of %s failed to verify", tool)
+		return "", err
+	}
+
+	return description, err
+}
+
+// fetchDescriptionFile fetches a small text file from the repository into memory
+func (dbt *DBT) fetchDescriptionFile(uri string) (content string, err error) {
+	isS3, s3Meta := dbt.s3Url(uri)
+
+	if isS3 {
+		return dbt.S3FetchDescription(s3Meta)
+	}
+
+	client := dbt.HttpClient()
 
-	req, err := http.NewRequest("GET", uri, nil)
+	req, err := http.NewRequestWithContext(dbt.RequestContext(), "GET", uri, nil)
 	if err != nil {
 		err = errors.Wrapf(err, "failed to create request for url: %s", uri)
-		return description, err
+		return content, err
 	}
 
 	err = dbt.AuthHeaders(req)
 	if err != nil {
 		err = errors.Wrapf(err, "failed adding auth headers")
-		return description, err
+		return content, err
 	}
 
 	resp, err := client.Do(req)
 
 	if err != nil {
 		err = errors.Wrapf(err, "Error looking for command description in repo %q", uri)
-		return description, err
+		return content, err
 	}
 
 	if resp != nil {
 		defer resp.Body.Close()
+
+		if resp.StatusCode != http.StatusOK {
+			err = fmt.Errorf("unexpected status %d fetching %s", resp.StatusCode, uri)
+			return content, err
+		}
+
 		responseBytes, err := ioutil.ReadAll(resp.Body)
 
 		if err != nil {
 			err = errors.Wrap(err, "Error reading description")
-			return description, err
+			return content, err
 		}
 
-		description = string(responseBytes)
+		content = string(responseBytes)
 	}
 
-	return description, err
+	return content, err
+}
+
+// verifyDescription checks a description's signature against the tools truststore.  VerifyToolFileSignature works on files, so that's what they become, briefly.
+func (dbt *DBT) verifyDescription(homedir string, description string, signature string) (err error) {
+	tmpDir, err := ioutil.TempDir("", "dbt-description")
+	if err != nil {
+		err = errors.Wrap(err, "failed to create temp dir")
+		return err
+	}
+
+	defer os.RemoveAll(tmpDir)
+
+	descriptionFile := fmt.Sprintf("%s/description.txt", tmpDir)
+
+	err = ioutil.WriteFile(descriptionFile, []byte(description), 0644)
+	if err != nil {
+		err = errors.Wrapf(err, "failed to write %s", descriptionFile)
+		return err
+	}
+
+	err = ioutil.WriteFile(fmt.Sprintf("%s.asc", descriptionFile), []byte(signature), 0644)
+	if err != nil {
+		err = errors.Wrapf(err, "failed to write signature for %s", descriptionFile)
+		return err
+	}
+
+	ok, err := dbt.VerifyToolFileSignature(homedir, descriptionFile)
+	if err != nil {
+		return err
+	}
+
+	if !ok {
+		err = errors.Wrap(ErrSignatureMismatch, "signature failed to verify")
+		return err
+	}
+
+	return err
 }
 
 // FetchToolNames returns a list of tool names found in the trusted repo
@@ -141,7 +348,7 @@ func (dbt *DBT) FetchToolNames() (tools []Tool, err error) {
 	// Then add one cos we definitely need one for http gets
 	uri := fmt.Sprintf("%s/", munged)
 
-	isS3, s3Meta := S3Url(uri)
+	isS3, s3Meta := dbt.s3Url(uri)
 
 	if isS3 {
 		return dbt.S3FetchToolNames(s3Meta)
@@ -149,11 +356,9 @@ func (dbt *DBT) FetchToolNames() (tools []Tool, err error) {
 
 	dbt.VerboseOutput("Fetching tool names from %s", uri)
 
-	client := &http.Client{
-		Timeout: 10 * time.Second,
-	}
+	client := dbt.HttpClient()
 
-	req, err := http.NewRequest("GET", uri, nil)
+	req, err := http.NewRequestWithContext(dbt.RequestContext(), "GET", uri, nil)
 	if err != nil {
 		err = errors.Wrapf(err, "failed to create request for url: %s", uri)
 		return tools, err
@@ -213,20 +418,21 @@ type Tool struct {
 	FormattedName string
 	Version       string
 	Description   string
+	Versions      []string
 }
 
 // S3FetchDescription fetches the tool description from S3
 func (dbt *DBT) S3FetchDescription(meta S3Meta) (description string, err error) {
 	dbt.VerboseOutput("Fetching tool description from  from %s", meta.Url)
-	downloader := s3manager.NewDownloader(dbt.S3Session)
+	downloader := dbt.s3Downloader(meta)
 	downloadOptions := &s3.GetObjectInput{
 		Bucket: aws.String(meta.Bucket),
 		Key:    aws.String(meta.Key),
 	}
 
-	buf := &aws.WriteAtBuffer{}
+	buf := manager.NewWriteAtBuffer([]byte{})
 
-	_, err = downloader.Download(buf, downloadOptions)
+	_, err = downloader.Download(dbt.RequestContext(), buf, downloadOptions)
 	if err != nil {
 		err = errors.Wrapf(err, "unable to download description from %s", meta.Url)
 		return description, err
@@ -240,7 +446,6 @@ func (dbt *DBT) S3FetchDescription(meta S3Meta) (description string, err error)
 // S3FetchToolNames fetches the list of available tools from S3
 func (dbt *DBT) S3FetchToolNames(meta S3Meta) (tools []Tool, err error) {
 	tools = make([]Tool, 0)
-	svc := s3.New(dbt.S3Session)
 
 	dbt.VerboseOutput("Fetching tool names from %s", meta.Url)
 
@@ -250,7 +455,7 @@ func (dbt *DBT) S3FetchToolNames(meta S3Meta) (tools []Tool, err error) {
 		Delimiter: aws.String("/"),
 	}
 
-	resp, err := svc.ListObjects(options)
+	resp, err := dbt.S3Client.ListObjects(dbt.RequestContext(), options, dbt.s3Options(meta)...)
 	if err != nil {
 		err = errors.Wrapf(err, "failed to list objects at %s", meta.Key)
 		dbt.VerboseOutput("Error: %s", err)
@@ -258,7 +463,7 @@ func (dbt *DBT) S3FetchToolNames(meta S3Meta) (tools []Tool, err error) {
 	}
 
 	for _, p := range resp.CommonPrefixes {
-		name := *p.Prefix
+		name := aws.ToString(p.Prefix)
 		name = strings.TrimSuffix(name, "/")
 		tools = append(tools, Tool{Name: name})
 	}
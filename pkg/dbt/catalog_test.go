@@ -1,7 +1,12 @@
 package dbt
 
 import (
+	"fmt"
 	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"os"
+	"runtime"
+	"strings"
 	"testing"
 )
 
@@ -23,16 +28,21 @@ func TestFetchDescription(t *testing.T) {
 		{
 			"s3",
 			&DBT{
-				Config:    s3DbtConfig,
-				Verbose:   true,
-				S3Session: s3Session,
+				Config:   s3DbtConfig,
+				Verbose:  true,
+				S3Client: s3Client,
 			},
 			homeDirS3,
 		},
 	}
 
 	for _, tc := range inputs {
-		desc, err := tc.obj.FetchToolDescription("catalog", VERSION)
+		err := tc.obj.FetchTrustStore(tc.homedir)
+		if err != nil {
+			t.Fatalf("Error fetching truststore: %s", err)
+		}
+
+		desc, err := tc.obj.FetchToolDescription("catalog", VERSION, tc.homedir)
 		if err != nil {
 			t.Errorf("Error fetching description for 'catalog': %s", err)
 		}
@@ -41,6 +51,140 @@ func TestFetchDescription(t *testing.T) {
 	}
 }
 
+func TestFetchDescriptionSignature(t *testing.T) {
+	repoRoot, config, signer := newTestSignedRepo(t, "foo", "1.0.0", "1.1.0", "1.2.0")
+
+	homedir, err := ioutil.TempDir("", "dbt-description")
+	if err != nil {
+		t.Fatalf("Failed creating homedir: %s", err)
+	}
+
+	defer os.RemoveAll(homedir)
+
+	err = os.MkdirAll(fmt.Sprintf("%s/%s", homedir, DbtDir), 0755)
+	if err != nil {
+		t.Fatalf("Failed creating dbt dir: %s", err)
+	}
+
+	err = GenerateDbtDir(homedir, false)
+	if err != nil {
+		t.Fatalf("Failed generating dbt dir: %s", err)
+	}
+
+	obj := &DBT{Config: config}
+
+	err = obj.FetchTrustStore(homedir)
+	if err != nil {
+		t.Fatalf("Failed fetching truststore: %s", err)
+	}
+
+	// 1.0.0 is signed properly, 1.1.0 has been altered since signing, and 1.2.0 isn't signed at all
+	for _, version := range []string{"1.0.0", "1.1.0"} {
+		writeTestSignedTool(t, signer, fmt.Sprintf("%s/dbt-tools/foo/%s/description.txt", repoRoot, version), "A fine tool.")
+	}
+
+	err = ioutil.WriteFile(fmt.Sprintf("%s/dbt-tools/foo/1.1.0/description.txt", repoRoot), []byte("Definitely not malware."), 0644)
+	if err != nil {
+		t.Fatalf("Failed altering description: %s", err)
+	}
+
+	err = ioutil.WriteFile(fmt.Sprintf("%s/dbt-tools/foo/1.2.0/description.txt", repoRoot), []byte("Trust me."), 0644)
+	if err != nil {
+		t.Fatalf("Failed writing description: %s", err)
+	}
+
+	inputs := []struct {
+		version     string
+		description string
+		err         bool
+	}{
+		{"1.0.0", "A fine tool.", false},
+		{"1.1.0", "", true},
+		{"1.2.0", "", true},
+	}
+
+	for _, tc := range inputs {
+		t.Run(tc.version, func(t *testing.T) {
+			desc, err := obj.FetchToolDescription("foo", tc.version, homedir)
+			if tc.err {
+				assert.NotNil(t, err, "Unverified description is rejected.")
+				assert.Empty(t, desc, "Unverified description is not returned.")
+				return
+			}
+
+			if err != nil {
+				t.Errorf("Error fetching description: %s", err)
+			}
+
+			assert.Equal(t, tc.description, desc, "Fetched description meets expectations.")
+		})
+	}
+}
+
+func TestListCatalogConcurrent(t *testing.T) {
+	repoRoot, config, signer := newTestSignedRepo(t, "foo", "1.0.0", "1.1.0")
+
+	for _, name := range []string{"bar", "baz", "qux"} {
+		writeTestSignedTool(t, signer, fmt.Sprintf("%s/dbt-tools/%s/2.0.0/%s/%s/%s", repoRoot, name, runtime.GOOS, runtime.GOARCH, name), fmt.Sprintf("#!/bin/sh\necho %s\n", name))
+	}
+
+	// foo and qux are described properly, bar's description isn't signed, and baz hasn't got one at all
+	writeTestSignedTool(t, signer, fmt.Sprintf("%s/dbt-tools/foo/1.1.0/description.txt", repoRoot), "A fine tool.")
+	writeTestSignedTool(t, signer, fmt.Sprintf("%s/dbt-tools/qux/2.0.0/description.txt", repoRoot), "Another fine tool.")
+
+	err := ioutil.WriteFile(fmt.Sprintf("%s/dbt-tools/bar/2.0.0/description.txt", repoRoot), []byte("Trust me."), 0644)
+	if err != nil {
+		t.Fatalf("Failed writing description: %s", err)
+	}
+
+	homedir, err := ioutil.TempDir("", "dbt-catalog")
+	if err != nil {
+		t.Fatalf("Failed creating homedir: %s", err)
+	}
+
+	defer os.RemoveAll(homedir)
+
+	err = GenerateDbtDir(homedir, false)
+	if err != nil {
+		t.Fatalf("Failed generating dbt dir: %s", err)
+	}
+
+	inputs := []struct {
+		name    string
+		workers int
+	}{
+		{"default", 0},
+		{"one at a time", 1},
+		{"two at a time", 2},
+	}
+
+	expected := []Tool{
+		{Name: "bar", Version: "2.0.0", Description: DESCRIPTION_UNAVAILABLE, Versions: []string{"2.0.0"}},
+		{Name: "baz", Version: "2.0.0", Description: DESCRIPTION_UNAVAILABLE, Versions: []string{"2.0.0"}},
+		{Name: "foo", Version: "1.1.0", Description: "A fine tool.", Versions: []string{"1.0.0", "1.1.0"}},
+		{Name: "qux", Version: "2.0.0", Description: "Another fine tool.", Versions: []string{"2.0.0"}},
+	}
+
+	for _, tc := range inputs {
+		t.Run(tc.name, func(t *testing.T) {
+			config.Tools.CatalogConcurrency = tc.workers
+			obj := &DBT{Config: config}
+
+			err := obj.FetchTrustStore(homedir)
+			if err != nil {
+				t.Fatalf("Failed fetching truststore: %s", err)
+			}
+
+			tools, err := obj.ListCatalog(true, homedir)
+			if err != nil {
+				t.Fatalf("Error listing catalog: %s", err)
+			}
+
+			assert.Equal(t, expected, tools, "Catalog is complete and in order.")
+		})
+	}
+}
+
 func TestFetchTools(t *testing.T) {
 	inputs := []struct {
 		name    string
@@ -59,9 +203,9 @@ func TestFetchTools(t *testing.T) {
 		{
 			"s3",
 			&DBT{
-				Config:    s3DbtConfig,
-				Verbose:   true,
-				S3Session: s3Session,
+				Config:   s3DbtConfig,
+				Verbose:  true,
+				S3Client: s3Client,
 			},
 			homeDirS3,
 		},
@@ -116,9 +260,9 @@ func TestListCatalog(t *testing.T) {
 		{
 			"s3",
 			&DBT{
-				Config:    s3DbtConfig,
-				Verbose:   true,
-				S3Session: s3Session,
+				Config:   s3DbtConfig,
+				Verbose:  true,
+				S3Client: s3Client,
 			},
 			homeDirS3,
 		},
@@ -127,7 +271,12 @@ func TestListCatalog(t *testing.T) {
 	for _, tc := range inputs {
 		t.Run(tc.name, func(t *testing.T) {
 
-			err := tc.obj.FetchCatalog(true)
+			err := tc.obj.FetchTrustStore(tc.homedir)
+			if err != nil {
+				t.Fatalf("Error fetching truststore: %s", err)
+			}
+
+			err = tc.obj.FetchCatalog(true, tc.homedir)
 			if err != nil {
 				t.Errorf("Error listing tools: %s\n", err)
 			}
@@ -136,3 +285,92 @@ func TestListCatalog(t *testing.T) {
 		})
 	}
 }
+
+func TestCatalogMatch(t *testing.T) {
+	inputs := []struct {
+		name     string
+		tool     Tool
+		query    string
+		expected int
+	}{
+		{"exact name", Tool{Name: "deploy"}, "deploy", 8},
+		{"name prefix", Tool{Name: "deployer"}, "deploy", 4},
+		{"name substring", Tool{Name: "k8s-deploy"}, "deploy", 2},
+		{"description", Tool{Name: "ship", Description: "Deploys things."}, "deploy", 1},
+		{"ignores case", Tool{Name: "Deploy"}, "DEPLOY", 8},
+		{"every term matches", Tool{Name: "aws-creds", Description: "Fetches AWS credentials."}, "aws fetches", 5},
+		{"one term misses", Tool{Name: "aws-creds", Description: "Fetches AWS credentials."}, "aws gcp", 0},
+		{"no match", Tool{Name: "foo", Description: "A fine tool."}, "bar", 0},
+		{"unavailable description", Tool{Name: "foo", Description: DESCRIPTION_UNAVAILABLE}, "unavailable", 0},
+	}
+
+	for _, tc := range inputs {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, tc.expected, catalogMatch(tc.tool, strings.Fields(strings.ToLower(tc.query))), "match score meets expectations")
+		})
+	}
+}
+
+func TestSearchCatalog(t *testing.T) {
+	repoRoot, config, signer := newTestSignedRepo(t, "deploy", "1.0.0")
+
+	for _, name := range []string{"deployer", "k8s-deploy", "ship", "lint"} {
+		writeTestSignedTool(t, signer, fmt.Sprintf("%s/dbt-tools/%s/1.0.0/%s/%s/%s", repoRoot, name, runtime.GOOS, runtime.GOARCH, name), fmt.Sprintf("#!/bin/sh\necho %s\n", name))
+	}
+
+	writeTestSignedTool(t, signer, fmt.Sprintf("%s/dbt-tools/ship/1.0.0/description.txt", repoRoot), "Deploys things to production.")
+	writeTestSignedTool(t, signer, fmt.Sprintf("%s/dbt-tools/lint/1.0.0/description.txt", repoRoot), "Checks code.")
+
+	homedir, err := ioutil.TempDir("", "dbt-catalog")
+	if err != nil {
+		t.Fatalf("Failed creating homedir: %s", err)
+	}
+
+	defer os.RemoveAll(homedir)
+
+	err = GenerateDbtDir(homedir, false)
+	if err != nil {
+		t.Fatalf("Failed generating dbt dir: %s", err)
+	}
+
+	obj := &DBT{Config: config}
+
+	err = obj.FetchTrustStore(homedir)
+	if err != nil {
+		t.Fatalf("Failed fetching truststore: %s", err)
+	}
+
+	inputs := []struct {
+		name     string
+		query    string
+		expected []string
+		err      bool
+	}{
+		{"ranked", "DEPLOY", []string{"deploy", "deployer", "k8s-deploy", "ship"}, false},
+		{"description only", "code", []string{"lint"}, false},
+		{"several words", "deploys production", []string{"ship"}, false},
+		{"nothing matches", "nope", []string{}, false},
+		{"empty query", "  ", nil, true},
+	}
+
+	for _, tc := range inputs {
+		t.Run(tc.name, func(t *testing.T) {
+			tools, err := obj.SearchCatalog(tc.query, false, homedir)
+			if tc.err {
+				assert.Error(t, err, "empty query is an error")
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("Error searching catalog: %s", err)
+			}
+
+			names := make([]string, 0)
+			for _, tool := range tools {
+				names = append(names, tool.Name)
+			}
+
+			assert.Equal(t, tc.expected, names, "search results meet expectations")
+		})
+	}
+}
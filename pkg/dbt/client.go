@@ -0,0 +1,202 @@
+// Copyright © 2019 Nik Ogura <nik.ogura@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package dbt
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"github.com/pkg/errors"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"sync"
+	"time"
+)
+
+// DBT_INSECURE_SKIP_TLS_VERIFY_ENV_VAR Env var that, set to 1, turns off TLS verification.  Strictly for local development against self signed reposervers.  Deliberately not a config file option.
+const DBT_INSECURE_SKIP_TLS_VERIFY_ENV_VAR = "DBT_INSECURE_SKIP_TLS_VERIFY"
+
+// HTTP_TIMEOUT the most any single request, including downloading the body, may take
+const HTTP_TIMEOUT = 300 * time.Second
+
+// HTTP_CONNECT_TIMEOUT how long to wait for a connection, and for a TLS handshake on it
+const HTTP_CONNECT_TIMEOUT = 10 * time.Second
+
+// HTTP_RESPONSE_TIMEOUT how long to wait for a server to start answering once the request is sent
+const HTTP_RESPONSE_TIMEOUT = 30 * time.Second
+
+var defaultHttpClient *http.Client
+var defaultHttpClientOnce sync.Once
+
+// NewHttpClient creates the http.Client dbt uses for all its requests.  Connections are pooled and kept alive, so the listing, checksum, signature, and binary fetches of a tool run all share one connection.  Requests go through config.Dbt.ProxyUrl if it's set, otherwise the usual HTTPS_PROXY, HTTP_PROXY and NO_PROXY env vars are honored.  If the proxy url or client certificate is bad, no client is returned, just the error.
+func NewHttpClient(config Config) (client *http.Client, err error) {
+	transport := &http.Transport{
+		Proxy: http.ProxyFromEnvironment,
+		DialContext: (&net.Dialer{
+			Timeout:   HTTP_CONNECT_TIMEOUT,
+			KeepAlive: 30 * time.Second,
+		}).DialContext,
+		ForceAttemptHTTP2:     true,
+		MaxIdleConns:          100,
+		MaxIdleConnsPerHost:   10,
+		IdleConnTimeout:       90 * time.Second,
+		TLSHandshakeTimeout:   HTTP_CONNECT_TIMEOUT,
+		ResponseHeaderTimeout: HTTP_RESPONSE_TIMEOUT,
+		ExpectContinueTimeout: 1 * time.Second,
+	}
+
+	// a client that ignored the proxy it was told to use, or went without its certificate, would only fail later in some baffling way
+	if config.Dbt.ProxyUrl != "" {
+		proxyUrl, err := url.Parse(config.Dbt.ProxyUrl)
+		if err != nil {
+			err = errors.Wrapf(err, "failed to parse proxy url %q", config.Dbt.ProxyUrl)
+			return client, err
+		}
+
+		transport.Proxy = http.ProxyURL(proxyUrl)
+	}
+
+	tlsConfig, err := ClientTLSConfig(config)
+	if err != nil {
+		return client, err
+	}
+
+	transport.TLSClientConfig = tlsConfig
+
+	client = &http.Client{
+		Transport: transport,
+		Timeout:   HTTP_TIMEOUT,
+	}
+
+	if os.Getenv(DBT_INSECURE_SKIP_TLS_VERIFY_ENV_VAR) == "1" {
+		logger := log.New(os.Stderr, "", 0)
+
+		// a configured CA means someone went to the trouble of doing it properly
+		if config.Dbt.CACertFile != "" {
+			logger.Printf("Ignoring %s, since dbt.cacert is set.", DBT_INSECURE_SKIP_TLS_VERIFY_ENV_VAR)
+			return client, err
+		}
+
+		if transport.TLSClientConfig == nil {
+			transport.TLSClientConfig = &tls.Config{}
+		}
+
+		transport.TLSClientConfig.InsecureSkipVerify = true
+		client.Transport = &insecureTransport{wrapped: transport, logger: logger}
+	}
+
+	return client, err
+}
+
+// insecureTransport makes noise about every request sent without TLS verification, so nobody can forget it's turned off.
+type insecureTransport struct {
+	wrapped http.RoundTripper
+	logger  *log.Logger
+}
+
+// RoundTrip prints the warning, then sends the request.
+func (i *insecureTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.URL.Scheme == "https" {
+		i.logger.Printf("WARNING: TLS verification is DISABLED by %s.  Fetching %s without checking who's on the other end.  Never do this against a production repository.", DBT_INSECURE_SKIP_TLS_VERIFY_ENV_VAR, req.URL)
+	}
+
+	return i.wrapped.RoundTrip(req)
+}
+
+// ClientTLSConfig builds the TLS config for the client certificate and extra CAs in config.Dbt.  With neither set it returns nil, which means Go's defaults.  A cert without a key, or a pair that won't load, is an error.
+func ClientTLSConfig(config Config) (tlsConfig *tls.Config, err error) {
+	certFile := config.Dbt.ClientCertFile
+	keyFile := config.Dbt.ClientKeyFile
+	caFile := config.Dbt.CACertFile
+
+	if certFile == "" && keyFile == "" && caFile == "" {
+		return tlsConfig, err
+	}
+
+	tlsConfig = &tls.Config{
+		MinVersion: tls.VersionTLS12,
+	}
+
+	if certFile != "" || keyFile != "" {
+		if certFile == "" || keyFile == "" {
+			err = errors.New("dbt.clientcert and dbt.clientkey must be set together")
+			return tlsConfig, err
+		}
+
+		cert, loadErr := tls.LoadX509KeyPair(certFile, keyFile)
+		if loadErr != nil {
+			err = errors.Wrapf(loadErr, "failed to load client certificate %s and key %s", certFile, keyFile)
+			return tlsConfig, err
+		}
+
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+
+	if caFile != "" {
+		tlsConfig.RootCAs, err = CACertPool(caFile)
+		if err != nil {
+			return tlsConfig, err
+		}
+	}
+
+	return tlsConfig, err
+}
+
+// CACertPool returns the system's root CAs plus the ones in the PEM bundle caFile.  The system roots are kept so public hosts like S3 still verify, and verification is never turned off.
+func CACertPool(caFile string) (pool *x509.CertPool, err error) {
+	caBytes, err := os.ReadFile(caFile)
+	if err != nil {
+		err = errors.Wrapf(err, "failed to read CA certificates from %s", caFile)
+		return pool, err
+	}
+
+	pool, poolErr := x509.SystemCertPool()
+	if poolErr != nil || pool == nil {
+		pool = x509.NewCertPool()
+	}
+
+	if !pool.AppendCertsFromPEM(caBytes) {
+		err = fmt.Errorf("%s is not a PEM bundle of CA certificates", caFile)
+		return pool, err
+	}
+
+	return pool, err
+}
+
+// HttpClient returns the client to make requests with.  That's Client if it's set, as it is by NewDbt, otherwise a default client shared by everything in the process.
+func (dbt *DBT) HttpClient() (client *http.Client) {
+	if dbt.Client != nil {
+		return dbt.Client
+	}
+
+	defaultHttpClientOnce.Do(func() {
+		defaultHttpClient, _ = NewHttpClient(Config{})
+	})
+
+	return defaultHttpClient
+}
+
+// RequestContext returns the context requests are made with.  That's Context if it's set, otherwise the background context, which never ends.
+func (dbt *DBT) RequestContext() (ctx context.Context) {
+	if dbt.Context != nil {
+		return dbt.Context
+	}
+
+	return context.Background()
+}
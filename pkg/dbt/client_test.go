@@ -0,0 +1,461 @@
+// Copyright © 2019 Nik Ogura <nik.ogura@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package dbt
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"log"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/http/httputil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestNewHttpClient(t *testing.T) {
+	inputs := []struct {
+		name  string
+		proxy string
+		err   bool
+	}{
+		{"no proxy", "", false},
+		{"proxy", "http://proxy.example.com:3128", false},
+		{"bad proxy", "http://proxy example com", true},
+	}
+
+	for _, tc := range inputs {
+		t.Run(tc.name, func(t *testing.T) {
+			client, err := NewHttpClient(Config{Dbt: DbtConfig{ProxyUrl: tc.proxy}})
+			if tc.err {
+				assert.NotNil(t, err, "Bad proxy url is an error.")
+				assert.Nil(t, client, "No client is returned along with an error.")
+
+				_, err = NewDbtFromConfig(Config{Dbt: DbtConfig{Repo: "https://dbt.s3.us-east-1.amazonaws.com", ProxyUrl: tc.proxy}})
+				if assert.NotNil(t, err, "Bad proxy url stops dbt.") {
+					assert.Contains(t, err.Error(), "failed to parse proxy url", "Error says what's wrong.")
+				}
+
+				return
+			}
+
+			if err != nil {
+				t.Errorf("Error creating client: %s", err)
+			}
+
+			if tc.proxy != "" {
+				req, _ := http.NewRequest(http.MethodGet, "https://repo.example.com/dbt/", nil)
+				proxyUrl, err := client.Transport.(*http.Transport).Proxy(req)
+				if err != nil {
+					t.Errorf("Error getting proxy: %s", err)
+				}
+
+				assert.Equal(t, tc.proxy, proxyUrl.String(), "Requests go via the configured proxy.")
+			}
+		})
+	}
+}
+
+func TestHttpClientReusesConnections(t *testing.T) {
+	var connections int32
+
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`<a href="1.0.0/">1.0.0/</a>`))
+	}))
+
+	server.Config.ConnState = func(conn net.Conn, state http.ConnState) {
+		if state == http.StateNew {
+			atomic.AddInt32(&connections, 1)
+		}
+	}
+
+	server.Start()
+	defer server.Close()
+
+	client, err := NewHttpClient(Config{})
+	if err != nil {
+		t.Fatalf("Error creating client: %s", err)
+	}
+
+	obj := &DBT{
+		Config: Config{Tools: ToolsConfig{Repo: server.URL}},
+		Client: client,
+	}
+
+	for i := 0; i < 5; i++ {
+		_, err := obj.FetchToolVersions("foo")
+		if err != nil {
+			t.Errorf("Error fetching versions: %s", err)
+		}
+	}
+
+	assert.Equal(t, int32(1), atomic.LoadInt32(&connections), "Requests share one connection.")
+
+	assert.Same(t, (&DBT{}).HttpClient(), (&DBT{}).HttpClient(), "DBTs without a client share the default one.")
+}
+
+func TestHttpClientProxy(t *testing.T) {
+	_, config, _ := newTestSignedRepo(t, "foo", "1.0.0")
+
+	repoUrl, err := url.Parse(config.Tools.Repo)
+	if err != nil {
+		t.Fatalf("Error parsing repo url: %s", err)
+	}
+
+	repoUrl.Path = ""
+
+	// a proxy that knows where the unresolvable repo host really lives, and keeps track of what passed through it
+	proxied := make([]string, 0)
+	var mutex sync.Mutex
+
+	forward := httputil.NewSingleHostReverseProxy(repoUrl)
+
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mutex.Lock()
+		proxied = append(proxied, r.URL.Path)
+		mutex.Unlock()
+
+		forward.ServeHTTP(w, r)
+	}))
+	defer proxy.Close()
+
+	unreachable := "http://dbt-repo.invalid"
+
+	config.Dbt.TrustStore = fmt.Sprintf("%s/truststore", unreachable)
+	config.Tools.Repo = fmt.Sprintf("%s/dbt-tools", unreachable)
+	config.Dbt.ProxyUrl = proxy.URL
+
+	client, err := NewHttpClient(config)
+	if err != nil {
+		t.Fatalf("Error creating client: %s", err)
+	}
+
+	homedir, err := ioutil.TempDir("", "dbt-proxy")
+	if err != nil {
+		t.Fatalf("Failed creating homedir: %s", err)
+	}
+
+	defer os.RemoveAll(homedir)
+
+	err = os.MkdirAll(fmt.Sprintf("%s/%s", homedir, DbtDir), 0755)
+	if err != nil {
+		t.Fatalf("Failed creating dbt dir: %s", err)
+	}
+
+	err = GenerateDbtDir(homedir, false)
+	if err != nil {
+		t.Fatalf("Failed generating dbt dir: %s", err)
+	}
+
+	obj := &DBT{
+		Config: config,
+		Client: client,
+		Logger: log.New(ioutil.Discard, "", 0),
+	}
+
+	err = obj.FetchTrustStore(homedir)
+	assert.Nil(t, err, "Truststore fetched via proxy.")
+
+	versions, err := obj.FetchToolVersions("foo")
+	assert.Nil(t, err, "Versions listed via proxy.")
+	assert.Equal(t, []string{"1.0.0"}, versions, "Versions listed via proxy meet expectations.")
+
+	toolFile := fmt.Sprintf("%s/foo", homedir)
+	err = obj.FetchFile(fmt.Sprintf("%s/foo/1.0.0/%s/%s/%s", config.Tools.Repo, runtime.GOOS, runtime.GOARCH, ToolFileName("foo", runtime.GOOS)), toolFile)
+	assert.Nil(t, err, "File fetched via proxy.")
+
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	assert.Contains(t, proxied, "/truststore", "Truststore fetch went through the proxy.")
+	assert.Contains(t, proxied, "/dbt-tools/foo/", "Version listing went through the proxy.")
+	assert.Contains(t, proxied, fmt.Sprintf("/dbt-tools/foo/1.0.0/%s/%s/%s", runtime.GOOS, runtime.GOARCH, ToolFileName("foo", runtime.GOOS)), "File fetch went through the proxy.")
+}
+
+func TestHttpClientMutualTLS(t *testing.T) {
+	dir, err := ioutil.TempDir("", "dbt-mtls")
+	if err != nil {
+		t.Fatalf("Error creating temp dir: %s", err)
+	}
+
+	defer os.RemoveAll(dir)
+
+	clientCert, clientKey := writeTestClientCert(t, dir)
+
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(r.TLS.PeerCertificates[0].Subject.CommonName))
+	}))
+
+	// the client cert is self signed, so it's its own CA
+	clientPool := x509.NewCertPool()
+	clientPem, _ := ioutil.ReadFile(clientCert)
+	clientPool.AppendCertsFromPEM(clientPem)
+
+	server.TLS = &tls.Config{
+		ClientAuth: tls.RequireAndVerifyClientCert,
+		ClientCAs:  clientPool,
+	}
+	server.StartTLS()
+
+	defer server.Close()
+
+	caFile := filepath.Join(dir, "ca.pem")
+	err = ioutil.WriteFile(caFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw}), 0644)
+	if err != nil {
+		t.Fatalf("Error writing CA file: %s", err)
+	}
+
+	inputs := []struct {
+		name      string
+		dbtConfig DbtConfig
+		configErr bool
+		getErr    bool
+	}{
+		{"no tls config", DbtConfig{}, false, true},
+		{"ca only", DbtConfig{CACertFile: caFile}, false, true},
+		{"cert and ca", DbtConfig{ClientCertFile: clientCert, ClientKeyFile: clientKey, CACertFile: caFile}, false, false},
+		{"cert without key", DbtConfig{ClientCertFile: clientCert, CACertFile: caFile}, true, false},
+		{"missing cert", DbtConfig{ClientCertFile: filepath.Join(dir, "nope.pem"), ClientKeyFile: clientKey}, true, false},
+		{"ca file holds no certs", DbtConfig{CACertFile: clientKey}, true, false},
+	}
+
+	for _, tc := range inputs {
+		t.Run(tc.name, func(t *testing.T) {
+			client, err := NewHttpClient(Config{Dbt: tc.dbtConfig})
+			if tc.configErr {
+				assert.NotNil(t, err, "Unusable TLS config fails fast.")
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("Error creating client: %s", err)
+			}
+
+			resp, err := client.Get(server.URL)
+			if tc.getErr {
+				assert.NotNil(t, err, "Server rejects clients without a trusted cert.")
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("Error fetching from mTLS server: %s", err)
+			}
+
+			defer resp.Body.Close()
+
+			body, _ := ioutil.ReadAll(resp.Body)
+			assert.Equal(t, "dbt-test-client", string(body), "Server saw our client cert.")
+		})
+	}
+}
+
+// writeTestClientCert writes a self signed client certificate and its key to dir
+func writeTestClientCert(t *testing.T, dir string) (certFile string, keyFile string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("Error generating key: %s", err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "dbt-test-client"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("Error creating certificate: %s", err)
+	}
+
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatalf("Error marshalling key: %s", err)
+	}
+
+	certFile = filepath.Join(dir, "client.pem")
+	keyFile = filepath.Join(dir, "client-key.pem")
+
+	err = ioutil.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0644)
+	if err != nil {
+		t.Fatalf("Error writing cert: %s", err)
+	}
+
+	err = ioutil.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer}), 0600)
+	if err != nil {
+		t.Fatalf("Error writing key: %s", err)
+	}
+
+	return certFile, keyFile
+}
+
+func TestCACertPool(t *testing.T) {
+	dir, err := ioutil.TempDir("", "dbt-ca")
+	if err != nil {
+		t.Fatalf("Error creating temp dir: %s", err)
+	}
+
+	defer os.RemoveAll(dir)
+
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer server.Close()
+
+	caFile := filepath.Join(dir, "ca.pem")
+	_ = ioutil.WriteFile(caFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw}), 0644)
+
+	garbageFile := filepath.Join(dir, "garbage.pem")
+	_ = ioutil.WriteFile(garbageFile, []byte("this is not a certificate\n"), 0644)
+
+	inputs := []struct {
+		name   string
+		caFile string
+		err    bool
+	}{
+		{"internal ca", caFile, false},
+		{"not pem", garbageFile, true},
+		{"missing", filepath.Join(dir, "missing.pem"), true},
+	}
+
+	for _, tc := range inputs {
+		t.Run(tc.name, func(t *testing.T) {
+			pool, err := CACertPool(tc.caFile)
+			if tc.err {
+				assert.NotNil(t, err, "Unusable CA file is an error.")
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("Error building pool: %s", err)
+			}
+
+			_, err = server.Certificate().Verify(x509.VerifyOptions{Roots: pool, DNSName: "127.0.0.1"})
+			assert.Nil(t, err, "Certificate signed by the configured CA verifies.")
+		})
+	}
+}
+
+func TestHttpClientInsecureSkipVerify(t *testing.T) {
+	dir, err := ioutil.TempDir("", "dbt-insecure")
+	if err != nil {
+		t.Fatalf("Error creating temp dir: %s", err)
+	}
+
+	defer os.RemoveAll(dir)
+
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer server.Close()
+
+	// a CA file that doesn't sign the server's cert, to show the env var is ignored when one is set
+	otherCa, _ := writeTestClientCert(t, dir)
+
+	inputs := []struct {
+		name   string
+		envVar string
+		caFile string
+		err    bool
+	}{
+		{"verified", "", "", true},
+		{"not 1", "true", "", true},
+		{"skip verify", "1", "", false},
+		{"ignored with ca", "1", otherCa, true},
+	}
+
+	for _, tc := range inputs {
+		t.Run(tc.name, func(t *testing.T) {
+			_ = os.Setenv(DBT_INSECURE_SKIP_TLS_VERIFY_ENV_VAR, tc.envVar)
+			defer os.Unsetenv(DBT_INSECURE_SKIP_TLS_VERIFY_ENV_VAR)
+
+			client, err := NewHttpClient(Config{Dbt: DbtConfig{CACertFile: tc.caFile}})
+			if err != nil {
+				t.Fatalf("Error creating client: %s", err)
+			}
+
+			resp, err := client.Get(server.URL)
+			if tc.err {
+				assert.NotNil(t, err, "Self signed cert is rejected.")
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("Error fetching from self signed server: %s", err)
+			}
+
+			resp.Body.Close()
+		})
+	}
+}
+
+func TestRequestContext(t *testing.T) {
+	// a repo that never gets around to answering
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-release:
+		case <-r.Context().Done():
+		}
+	}))
+
+	defer server.Close()
+	defer close(release)
+
+	inputs := []struct {
+		name    string
+		timeout time.Duration
+	}{
+		{"short", 100 * time.Millisecond},
+		{"shorter", 10 * time.Millisecond},
+	}
+
+	for _, tc := range inputs {
+		t.Run(tc.name, func(t *testing.T) {
+			ctx, cancel := context.WithTimeout(context.Background(), tc.timeout)
+			defer cancel()
+
+			obj := &DBT{
+				Config:  Config{Tools: ToolsConfig{Repo: fmt.Sprintf("%s/dbt-tools", server.URL)}},
+				Context: ctx,
+			}
+
+			start := time.Now()
+
+			_, err := obj.FindLatestVersion("foo")
+
+			assert.NotNil(t, err, "Request is abandoned.")
+			assert.Less(t, int64(time.Since(start)), int64(HTTP_RESPONSE_TIMEOUT), "Request is abandoned at the deadline, not the client's timeout.")
+			assert.Equal(t, context.DeadlineExceeded, obj.RequestContext().Err(), "Deadline has passed.")
+		})
+	}
+}
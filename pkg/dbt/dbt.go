@@ -15,115 +15,350 @@
 package dbt
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
-	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/s3/manager"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
 	"github.com/mitchellh/go-homedir"
 	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	"gopkg.in/yaml.v3"
 	"io/ioutil"
 	"log"
 	"net/http"
+	"net/url"
 	"os"
 	"os/exec"
+	"path/filepath"
+	"regexp"
 	"runtime"
-	"syscall"
+	"sort"
+	"strings"
+	"sync"
 	"time"
 )
 
 // DbtDir is the standard dbt directory.  Usually ~/.dbt
 const DbtDir = ".dbt"
 
-// TrustDir is the directory under the dbt dir where the trust store is downloaded to
-const TrustDir = DbtDir + "/trust"
+// XDG_CONFIG_HOME_ENV_VAR XDG Base Directory env var for user config.  If set, dbt config and truststore live under $XDG_CONFIG_HOME/dbt
+const XDG_CONFIG_HOME_ENV_VAR = "XDG_CONFIG_HOME"
 
-// ToolDir is the directory where tools get downloaded to
-const ToolDir = DbtDir + "/tools"
+// XDG_CACHE_HOME_ENV_VAR XDG Base Directory env var for user cache.  If set, downloaded tools live under $XDG_CACHE_HOME/dbt
+const XDG_CACHE_HOME_ENV_VAR = "XDG_CACHE_HOME"
 
-// ConfigDir is the directory where Dbt expects to find configuration info
-const ConfigDir = DbtDir + "/conf"
+// TrustDir returns the directory where the trust store is downloaded to.  Usually ~/.dbt/trust
+func TrustDir(homedir string) string {
+	return fmt.Sprintf("%s/trust", dbtBaseDir(homedir, XDG_CONFIG_HOME_ENV_VAR))
+}
+
+// ToolDir returns the directory where tools get downloaded to.  Usually ~/.dbt/tools
+func ToolDir(homedir string) string {
+	return fmt.Sprintf("%s/tools", dbtBaseDir(homedir, XDG_CACHE_HOME_ENV_VAR))
+}
+
+// ToolFileName returns the file name of a tool's binary on the given OS.  Windows binaries carry a .exe suffix, both in the repository and once downloaded.
+func ToolFileName(toolName string, goos string) string {
+	if goos == "windows" {
+		return fmt.Sprintf("%s.exe", toolName)
+	}
+
+	return toolName
+}
+
+// ConfigDir returns the directory where Dbt expects to find configuration info.  Usually ~/.dbt/conf
+func ConfigDir(homedir string) string {
+	return fmt.Sprintf("%s/conf", dbtBaseDir(homedir, XDG_CONFIG_HOME_ENV_VAR))
+}
+
+// ConfigFilePath returns the actual dbt config file path.  dbt.json is preferred, but dbt.yaml or dbt.yml will be used if they exist and dbt.json does not.
+func ConfigFilePath(homedir string) string {
+	configDir := ConfigDir(homedir)
+	jsonPath := fmt.Sprintf("%s/dbt.json", configDir)
+
+	if _, err := os.Stat(jsonPath); err == nil {
+		return jsonPath
+	}
+
+	for _, name := range []string{"dbt.yaml", "dbt.yml"} {
+		yamlPath := fmt.Sprintf("%s/%s", configDir, name)
+		if _, err := os.Stat(yamlPath); err == nil {
+			return yamlPath
+		}
+	}
+
+	return jsonPath
+}
+
+// IsYamlFile returns true if the file name given has a yaml extension.
+func IsYamlFile(fileName string) bool {
+	ext := strings.ToLower(filepath.Ext(fileName))
+	return ext == ".yaml" || ext == ".yml"
+}
+
+// TruststorePath returns the actual file path to the downloaded trust store
+func TruststorePath(homedir string) string {
+	return fmt.Sprintf("%s/truststore", TrustDir(homedir))
+}
+
+// ToolsTruststorePath returns the file path to the downloaded tools truststore, if the config has one
+func ToolsTruststorePath(homedir string) string {
+	return fmt.Sprintf("%s/tools-truststore", TrustDir(homedir))
+}
+
+// toolsTruststorePath returns the truststore tools are verified against.  That's the tools truststore if one's been downloaded, otherwise the one truststore.  FetchTrustStore removes the tools truststore when the config no longer has one, so this follows the config even offline.
+func toolsTruststorePath(homedir string) string {
+	toolsTruststore := ToolsTruststorePath(homedir)
+
+	if _, err := os.Stat(toolsTruststore); err == nil {
+		return toolsTruststore
+	}
+
+	return TruststorePath(homedir)
+}
+
+// dbtBaseDir returns $<xdgEnvVar>/dbt if the XDG var is set, otherwise <homedir>/.dbt.  An existing ~/.dbt, or a DBT_HOME override, always wins so that existing installs keep working.
+func dbtBaseDir(homedir string, xdgEnvVar string) (dir string) {
+	dir = fmt.Sprintf("%s/%s", homedir, DbtDir)
+
+	xdgHome := os.Getenv(xdgEnvVar)
+	if xdgHome == "" {
+		return dir
+	}
+
+	if _, ok := dbtHomeOverride(); ok {
+		return dir
+	}
+
+	if _, err := os.Stat(dir); err == nil {
+		return dir
+	}
 
-// ConfigFilePath is the actual dbt config file path
-const ConfigFilePath = ConfigDir + "/dbt.json"
+	dir = fmt.Sprintf("%s/dbt", xdgHome)
 
-// TruststorePath is the actual file path to the downloaded trust store
-const TruststorePath = TrustDir + "/truststore"
+	return dir
+}
 
 // VERSION DBT's version
 const VERSION = "3.6.1"
 
+// DBT_HOME_ENV_VAR Env var that, if set to an existing directory, is used in place of the user's homedir.
+const DBT_HOME_ENV_VAR = "DBT_HOME"
+
+// DBT_REPO_ENV_VAR Env var that overrides the dbt repository url in the config file
+const DBT_REPO_ENV_VAR = "DBT_REPO"
+
+// DBT_TRUSTSTORE_ENV_VAR Env var that overrides the truststore url in the config file
+const DBT_TRUSTSTORE_ENV_VAR = "DBT_TRUSTSTORE"
+
+// DBT_TOOLS_REPO_ENV_VAR Env var that overrides the tools repository url in the config file
+const DBT_TOOLS_REPO_ENV_VAR = "DBT_TOOLS_REPO"
+
+// DBT_USERNAME_ENV_VAR Env var that overrides the basic auth username in the config file
+const DBT_USERNAME_ENV_VAR = "DBT_USERNAME"
+
+// DBT_PASSWORD_ENV_VAR Env var that overrides the basic auth password in the config file
+const DBT_PASSWORD_ENV_VAR = "DBT_PASSWORD"
+
+// DBT_TOKEN_ENV_VAR Env var that overrides the auth token in the config file
+const DBT_TOKEN_ENV_VAR = "DBT_TOKEN"
+
+// DBT_PINNED_VERSION_ENV_VAR Env var that overrides the pinned dbt version in the config file
+const DBT_PINNED_VERSION_ENV_VAR = "DBT_PINNED_VERSION"
+
+// DBT_SERVER_ENV_VAR Env var that selects which server to use from a multi-server config file
+const DBT_SERVER_ENV_VAR = "DBT_SERVER"
+
+// DBT_TARGET_OS_ENV_VAR Env var that overrides the OS FetchToolForPlatform downloads tools for
+const DBT_TARGET_OS_ENV_VAR = "DBT_TARGET_OS"
+
+// DBT_TARGET_ARCH_ENV_VAR Env var that overrides the architecture FetchToolForPlatform downloads tools for
+const DBT_TARGET_ARCH_ENV_VAR = "DBT_TARGET_ARCH"
+
 // DBT the dbt object itself
 type DBT struct {
-	Config    Config
-	Verbose   bool
-	Logger    *log.Logger
-	S3Session *session.Session
+	Config   Config
+	Verbose  bool
+	Logger   *log.Logger
+	S3Client *s3.Client
+	Client   *http.Client
+
+	// StructuredLogger, if set, takes log messages as JSON lines in place of Logger.  NewDbt sets it if DBT_LOG_FORMAT is 'json'.
+	StructuredLogger *logrus.Logger
+
+	// Context, if set, bounds every request dbt makes.  Once it's cancelled, or its deadline passes, whatever's in flight is abandoned.
+	Context context.Context
+
+	// Homedir where the dbt dir holding cached credentials is.  NewDbt sets it, and if it's empty, the user's homedir is used.
+	Homedir string
+
+	// s3Regions the regions of the S3 buckets worked out so far, by bucket.  See S3BucketRegion.
+	s3Regions     map[string]string
+	s3RegionsLock sync.Mutex
 }
 
 // Config  configuration of the dbt object
 type Config struct {
-	Dbt          DbtConfig   `json:"dbt"`
-	Tools        ToolsConfig `json:"tools"`
-	Username     string      `json:"username,omitempty"`
-	Password     string      `json:"password,omitempty"`
-	UsernameFunc string      `json:"usernamefunc,omitempty"`
-	PasswordFunc string      `json:"passwordfunc,omitempty"`
-	Pubkey       string      `json:"pubkey,omitempty"`
-	PubkeyPath   string      `json:"pubkeypath,omitempty"`
-	PubkeyFunc   string      `json:"pubkeyfunc,omitempty"`
+	Dbt          DbtConfig   `json:"dbt" yaml:"dbt"`
+	Tools        ToolsConfig `json:"tools" yaml:"tools"`
+	Username     string      `json:"username,omitempty" yaml:"username,omitempty"`
+	Password     string      `json:"password,omitempty" yaml:"password,omitempty"`
+	UsernameFunc string      `json:"usernamefunc,omitempty" yaml:"usernamefunc,omitempty"`
+	PasswordFunc string      `json:"passwordfunc,omitempty" yaml:"passwordfunc,omitempty"`
+	Pubkey       string      `json:"pubkey,omitempty" yaml:"pubkey,omitempty"`
+	PubkeyPath   string      `json:"pubkeypath,omitempty" yaml:"pubkeypath,omitempty"`
+	PubkeyFunc   string      `json:"pubkeyfunc,omitempty" yaml:"pubkeyfunc,omitempty"`
+	Token        string      `json:"token,omitempty" yaml:"token,omitempty"`
+
+	// OIDC if an issuer is set, requests carry a bearer token from 'dbt login' rather than the credentials above
+	OIDC OIDCConfig `json:"oidc,omitempty" yaml:"oidc,omitempty"`
+
+	// SshAgent if true, and no pubkey is configured, requests are authenticated with a JWT signed by the first RSA key in the running ssh-agent
+	SshAgent bool `json:"sshagent,omitempty" yaml:"sshagent,omitempty"`
+
+	// Credentials per host credentials, keyed by hostname, or hostname:port.  Requests to a host listed here use its credentials instead of the ones above, so repos on different hosts can use different auth.
+	Credentials map[string]CredentialSet `json:"credentials,omitempty" yaml:"credentials,omitempty"`
+
+	// PreRunHook and PostRunHook are executables run before and after every tool.  A pre-run hook that exits non-zero stops the tool from running.  A post-run hook is told the tool's exit code.
+	PreRunHook  string `json:"prerunhook,omitempty" yaml:"prerunhook,omitempty"`
+	PostRunHook string `json:"postrunhook,omitempty" yaml:"postrunhook,omitempty"`
+
+	// TelemetryUrl if set, each successful tool run is reported there, anonymously.  Off unless set.
+	TelemetryUrl string `json:"telemetryurl,omitempty" yaml:"telemetryurl,omitempty"`
+
+	// ToolFileMode the octal mode, such as '0750', that downloaded tools and their checksums and signatures get.  Empty means DEFAULT_TOOL_FILE_MODE.
+	ToolFileMode string `json:"toolfilemode,omitempty" yaml:"toolfilemode,omitempty"`
+
+	// FuncTimeout how long UsernameFunc, PasswordFunc, and PubkeyFunc may take, such as '30s'.  Empty means DEFAULT_FUNC_TIMEOUT.
+	FuncTimeout string `json:"functimeout,omitempty" yaml:"functimeout,omitempty"`
+
+	// Servers holds per server configs for multi-server setups.  The selected server's values replace the ones above.
+	Servers       map[string]Config `json:"servers,omitempty" yaml:"servers,omitempty"`
+	DefaultServer string            `json:"defaultserver,omitempty" yaml:"defaultserver,omitempty"`
+
+	// ServerName the name of the server SelectServer chose, if the config has servers.  Cached credentials are kept per server under it.
+	ServerName string `json:"-" yaml:"-"`
+}
+
+// CredentialSet the credentials for the repos on one host.  The fields mean the same as their namesakes in Config.
+type CredentialSet struct {
+	Username     string `json:"username,omitempty" yaml:"username,omitempty"`
+	Password     string `json:"password,omitempty" yaml:"password,omitempty"`
+	UsernameFunc string `json:"usernamefunc,omitempty" yaml:"usernamefunc,omitempty"`
+	PasswordFunc string `json:"passwordfunc,omitempty" yaml:"passwordfunc,omitempty"`
+	Pubkey       string `json:"pubkey,omitempty" yaml:"pubkey,omitempty"`
+	PubkeyPath   string `json:"pubkeypath,omitempty" yaml:"pubkeypath,omitempty"`
+	PubkeyFunc   string `json:"pubkeyfunc,omitempty" yaml:"pubkeyfunc,omitempty"`
+	Token        string `json:"token,omitempty" yaml:"token,omitempty"`
+	SshAgent     bool   `json:"sshagent,omitempty" yaml:"sshagent,omitempty"`
 }
 
 // DbtConfig internal config of dbt
 type DbtConfig struct {
-	Repo       string `json:"repository"`
-	TrustStore string `json:"truststore"`
+	Repo       string `json:"repository" yaml:"repository"`
+	TrustStore string `json:"truststore" yaml:"truststore"`
+	ProxyUrl   string `json:"proxy,omitempty" yaml:"proxy,omitempty"`
+
+	// ToolsTrustStore if set, tools are verified against this truststore instead of TrustStore, so tools can be signed with other keys than dbt itself.
+	ToolsTrustStore string `json:"toolstruststore,omitempty" yaml:"toolstruststore,omitempty"`
+
+	// ClientCertFile and ClientKeyFile are the PEM encoded certificate and key presented to servers demanding mutual TLS.  CACertFile is a PEM bundle of extra CAs to trust.
+	ClientCertFile string `json:"clientcert,omitempty" yaml:"clientcert,omitempty"`
+	ClientKeyFile  string `json:"clientkey,omitempty" yaml:"clientkey,omitempty"`
+	CACertFile     string `json:"cacert,omitempty" yaml:"cacert,omitempty"`
+
+	// PinnedVersion, if set, is the version of dbt to converge to, whether or not it's the latest.
+	PinnedVersion string `json:"pinnedversion,omitempty" yaml:"pinnedversion,omitempty"`
+
+	// UpgradeCheckIntervalSeconds is how long to wait between checks for a new version of dbt.  Zero means DEFAULT_UPGRADE_CHECK_INTERVAL_SECONDS, and negative means every run.
+	UpgradeCheckIntervalSeconds int `json:"upgradecheckintervalseconds,omitempty" yaml:"upgradecheckintervalseconds,omitempty"`
+
+	// S3Endpoint is the url of an S3 compatible store such as MinIO or Ceph RGW.  If set, repo urls on its host are treated as S3, and S3 requests go there instead of to AWS.  S3ForcePathStyle addresses buckets as https://<endpoint>/<bucket> rather than https://<bucket>.<endpoint>.
+	S3Endpoint       string `json:"s3endpoint,omitempty" yaml:"s3endpoint,omitempty"`
+	S3ForcePathStyle bool   `json:"s3forcepathstyle,omitempty" yaml:"s3forcepathstyle,omitempty"`
 }
 
 // ToolsConfig is the config information for the tools to be downloaded and run
 type ToolsConfig struct {
-	Repo string `json:"repository"`
+	Repo string `json:"repository" yaml:"repository"`
+
+	// CatalogConcurrency is how many tools the catalog looks up at once.  Zero means DEFAULT_CATALOG_CONCURRENCY.
+	CatalogConcurrency int `json:"catalogconcurrency,omitempty" yaml:"catalogconcurrency,omitempty"`
+
+	// Digests pins tools, by name, to the sha256 of the exact binary allowed to run, with or without a 'sha256:' prefix.
+	Digests map[string]string `json:"digests,omitempty" yaml:"digests,omitempty"`
 }
 
 // NewDbt  creates a new dbt object
 func NewDbt(homedir string) (dbt *DBT, err error) {
-	config, err := LoadDbtConfig(homedir, false)
+	return NewDbtForServer(homedir, "")
+}
+
+// NewDbtForServer creates a new dbt object using the named server from a multi-server config.  An empty server name falls back to DBT_SERVER and then the config's default server.
+func NewDbtForServer(homedir string, server string) (dbt *DBT, err error) {
+	config, err := LoadDbtConfigForServer(homedir, server, false)
 	if err != nil {
 		err = errors.Wrapf(err, "failed to load config file")
 	}
 
+	dbt, configErr := NewDbtFromConfig(config)
+	if err == nil {
+		err = configErr
+	}
+
+	dbt.Homedir = homedir
+
+	return dbt, err
+}
+
+// NewDbtFromConfig creates a new dbt object from an already loaded config, with an http client, and an S3 session if the repo is in S3.
+func NewDbtFromConfig(config Config) (dbt *DBT, err error) {
+	client, err := NewHttpClient(config)
+	if err != nil {
+		err = errors.Wrapf(err, "failed to create http client")
+		dbt = &DBT{Config: config, Logger: log.New(os.Stderr, "", 0), StructuredLogger: NewStructuredLogger()}
+		return dbt, err
+	}
+
 	dbt = &DBT{
 		Config:  config,
 		Verbose: false,
 		Logger:  log.New(os.Stderr, "", 0),
-	}
+		Client:  client,
 
-	ok, s3meta := S3Url(config.Dbt.Repo)
-	if err != nil {
-		err = errors.Wrapf(err, "failed checking to see if repo url is in s3")
-		return dbt, err
+		StructuredLogger: NewStructuredLogger(),
 	}
 
+	ok, s3meta := dbt.s3Url(config.Dbt.Repo)
 	if ok {
-		if dbt.S3Session == nil {
-			s3Session, err := DefaultSession(&s3meta)
+		if dbt.S3Client == nil {
+			// S3 goes through the same proxy and connection pool as everything else
+			s3Client, err := NewS3Client(config, s3meta, dbt.Client)
 			if err != nil {
-				err = errors.Wrapf(err, "failed to create s3 session")
 				return dbt, err
 			}
 
-			dbt.S3Session = s3Session
+			dbt.S3Client = s3Client
 		}
 	}
 
 	return dbt, err
 }
 
-// SetVerbose Sets the verbose option on the dbt object
+// SetVerbose Sets the verbose option on the dbt object.  Verbose output shows the urls being fetched, checksum comparisons, and which truststore key verified a signature.
 func (dbt *DBT) SetVerbose(verbose bool) {
 	dbt.Verbose = verbose
 }
 
 // LoadDbtConfig loads the dbt config from the expected location on the filesystem
 func LoadDbtConfig(homedir string, verbose bool) (config Config, err error) {
+	return LoadDbtConfigForServer(homedir, "", verbose)
+}
+
+// LoadDbtConfigForServer loads the dbt config from the expected location on the filesystem.  If the config holds multiple servers, the named server is selected.  See SelectServer for how an empty server name is resolved.
+func LoadDbtConfigForServer(homedir string, server string, verbose bool) (config Config, err error) {
 	if homedir == "" {
 		homedir, err = GetHomeDir()
 		if err != nil {
@@ -135,302 +370,917 @@ func LoadDbtConfig(homedir string, verbose bool) (config Config, err error) {
 	logger := log.New(os.Stderr, "", 0)
 
 	if verbose {
-		logger.Printf("Looking for dbt config in %s/.dbt", homedir)
+		logger.Printf("Looking for dbt config in %s", ConfigDir(homedir))
 	}
 
-	filePath := fmt.Sprintf("%s/%s", homedir, ConfigFilePath)
+	filePath := ConfigFilePath(homedir)
 
 	if verbose {
 		logger.Printf("Loading config from %s", filePath)
 	}
 
+	config, err = ParseDbtConfigFile(filePath)
+	if err != nil {
+		// No config file is fine if the environment tells us everything we need to know.
+		if os.IsNotExist(err) && EnvConfigComplete() {
+			if verbose {
+				logger.Printf("No config file at %s.  Using config from environment.", filePath)
+			}
+
+			config = ApplyEnvOverrides(Config{})
+			err = nil
+			return config, err
+		}
+
+		return config, err
+	}
+
+	config, err = SelectServer(config, server)
+	if err != nil {
+		err = errors.Wrapf(err, "failed selecting server from %s", filePath)
+		return config, err
+	}
+
+	if verbose && len(config.Servers) > 0 {
+		logger.Printf("Using server config for %s", config.Dbt.Repo)
+	}
+
+	config = ApplyEnvOverrides(config)
+
+	return config, err
+}
+
+// ParseDbtConfigFile reads and parses a dbt config file, as YAML or JSON depending on the file extension.  No server selection or environment overrides are applied.
+func ParseDbtConfigFile(filePath string) (config Config, err error) {
 	mdBytes, err := os.ReadFile(filePath)
 	if err != nil {
 		return config, err
 	}
 
-	err = json.Unmarshal(mdBytes, &config)
+	if IsYamlFile(filePath) {
+		err = yaml.Unmarshal(mdBytes, &config)
+	} else {
+		err = json.Unmarshal(mdBytes, &config)
+	}
+
 	if err != nil {
+		err = errors.Wrapf(err, "failed to parse config file %s", filePath)
 		return config, err
 	}
 
 	return config, err
 }
 
-// GenerateDbtDir generates the necessary dbt dirs in the user's homedir if they don't already exist.  If they do exist, it does nothing.
-func GenerateDbtDir(homedir string, verbose bool) (err error) {
-	if homedir == "" {
-		homedir, err = GetHomeDir()
-		if err != nil {
-			err = errors.Wrapf(err, "failed to get homedir")
-			return err
+// SelectServer resolves a multi-server config down to a single server, populating the top level Config fields from the chosen server.  The server is chosen by name if given, otherwise by DBT_SERVER, otherwise by the config's DefaultServer.  A config with a single server needs no name at all.  Configs without servers are returned unchanged.
+func SelectServer(config Config, server string) (selected Config, err error) {
+	if len(config.Servers) == 0 {
+		selected = config
+		return selected, err
+	}
+
+	if server == "" {
+		server = os.Getenv(DBT_SERVER_ENV_VAR)
+	}
+
+	if server == "" {
+		server = config.DefaultServer
+	}
+
+	if server == "" && len(config.Servers) == 1 {
+		for name := range config.Servers {
+			server = name
 		}
 	}
 
-	logger := log.New(os.Stderr, "", 0)
+	if server == "" {
+		err = fmt.Errorf("multiple servers configured, but no default server set.  Choose one of: %s", strings.Join(ServerNames(config), ", "))
+		return selected, err
+	}
 
-	if verbose {
-		logger.Printf("Creating DBT directory in %s.dbt", homedir)
+	selected, ok := config.Servers[server]
+	if !ok {
+		err = fmt.Errorf("server %q not found in config.  Choose one of: %s", server, strings.Join(ServerNames(config), ", "))
+		return selected, err
 	}
 
-	dbtPath := fmt.Sprintf("%s/%s", homedir, DbtDir)
+	// keep the full list around so callers can see what else is available
+	selected.Servers = config.Servers
+	selected.DefaultServer = config.DefaultServer
+	selected.ServerName = server
 
-	if _, err := os.Stat(dbtPath); os.IsNotExist(err) {
-		err = os.Mkdir(dbtPath, 0755)
-		if err != nil {
-			err = errors.Wrapf(err, "failed to create directory %s", dbtPath)
-			return err
-		}
+	// hooks are the machine's policy, whichever server the tools come from
+	if selected.PreRunHook == "" {
+		selected.PreRunHook = config.PreRunHook
 	}
 
-	trustPath := fmt.Sprintf("%s/%s", homedir, TrustDir)
+	if selected.PostRunHook == "" {
+		selected.PostRunHook = config.PostRunHook
+	}
 
-	if _, err := os.Stat(trustPath); os.IsNotExist(err) {
-		err = os.Mkdir(trustPath, 0755)
-		if err != nil {
-			err = errors.Wrapf(err, "failed to create directory %s", trustPath)
-			return err
-		}
+	if selected.TelemetryUrl == "" {
+		selected.TelemetryUrl = config.TelemetryUrl
 	}
 
-	toolPath := fmt.Sprintf("%s/%s", homedir, ToolDir)
-	err = os.Mkdir(toolPath, 0755)
-	if err != nil {
-		err = errors.Wrapf(err, "failed to create directory %s", toolPath)
-		return err
+	if selected.FuncTimeout == "" {
+		selected.FuncTimeout = config.FuncTimeout
 	}
 
-	configPath := fmt.Sprintf("%s/%s", homedir, ConfigDir)
-	err = os.Mkdir(configPath, 0755)
-	if err != nil {
-		err = errors.Wrapf(err, "failed to create directory %s", configPath)
-		return err
+	return selected, err
+}
+
+// ServerNames returns the sorted names of the servers in a multi-server config
+func ServerNames(config Config) (names []string) {
+	names = make([]string, 0)
+
+	for name := range config.Servers {
+		names = append(names, name)
 	}
 
-	return err
+	sort.Strings(names)
+
+	return names
 }
 
-// GetHomeDir get's the current user's homedir
-func GetHomeDir() (dir string, err error) {
-	dir, err = homedir.Dir()
-	return dir, err
+// ApplyEnvOverrides overlays any of the DBT_* config environment variables that are set on top of the config given.  Env vars win over file values.
+func ApplyEnvOverrides(config Config) Config {
+	overrides := []struct {
+		envVar string
+		field  *string
+	}{
+		{DBT_REPO_ENV_VAR, &config.Dbt.Repo},
+		{DBT_TRUSTSTORE_ENV_VAR, &config.Dbt.TrustStore},
+		{DBT_PINNED_VERSION_ENV_VAR, &config.Dbt.PinnedVersion},
+		{DBT_TOOLS_REPO_ENV_VAR, &config.Tools.Repo},
+		{DBT_USERNAME_ENV_VAR, &config.Username},
+		{DBT_PASSWORD_ENV_VAR, &config.Password},
+		{DBT_TOKEN_ENV_VAR, &config.Token},
+	}
+
+	for _, o := range overrides {
+		if value := os.Getenv(o.envVar); value != "" {
+			*o.field = value
+		}
+	}
+
+	return config
 }
 
-// FetchTrustStore writes the downloaded trusted signing public keys to disk.
-func (dbt *DBT) FetchTrustStore(homedir string) (err error) {
-	uri := dbt.Config.Dbt.TrustStore
+// EnvConfigComplete returns true if the environment alone has enough information to run dbt without a config file.
+func EnvConfigComplete() bool {
+	return os.Getenv(DBT_REPO_ENV_VAR) != "" && os.Getenv(DBT_TRUSTSTORE_ENV_VAR) != "" && os.Getenv(DBT_TOOLS_REPO_ENV_VAR) != ""
+}
 
-	dbt.VerboseOutput("Fetching truststore from %q\n", uri)
+// ConfigProblem is a single problem found in a dbt config.  Field is the config file key the problem applies to.
+type ConfigProblem struct {
+	Field   string
+	Message string
+}
 
-	isS3, s3Meta := S3Url(uri)
+// String returns a human readable form of the problem
+func (p ConfigProblem) String() string {
+	return fmt.Sprintf("%s: %s", p.Field, p.Message)
+}
 
-	if isS3 {
-		return dbt.S3FetchTruststore(homedir, s3Meta)
-	}
+// ValidateConfig checks a dbt config for common errors that otherwise show up as confusing failures later on.  It does not touch the network.  See ValidateTrustStore for that.
+func ValidateConfig(config Config) (problems []ConfigProblem) {
+	problems = make([]ConfigProblem, 0)
 
-	client := &http.Client{
-		Timeout: 10 * time.Second,
+	type checkedUrl struct {
+		field  string
+		value  string
+		isRepo bool
 	}
 
-	req, err := http.NewRequest("GET", uri, nil)
-	if err != nil {
-		err = errors.Wrapf(err, "failed to create request for url: %s", uri)
-		return err
+	urls := []checkedUrl{
+		{"dbt.repository", config.Dbt.Repo, true},
+		{"dbt.truststore", config.Dbt.TrustStore, false},
+		{"tools.repository", config.Tools.Repo, true},
 	}
 
-	err = dbt.AuthHeaders(req)
-	if err != nil {
-		err = errors.Wrapf(err, "failed adding auth headers")
-		return err
+	if config.Dbt.ToolsTrustStore != "" {
+		urls = append(urls, checkedUrl{"dbt.toolstruststore", config.Dbt.ToolsTrustStore, false})
 	}
 
-	resp, err := client.Do(req)
-	if err != nil {
-		err = errors.Wrapf(err, "failed to fetch truststore from %s", uri)
-		return err
+	if config.Dbt.S3Endpoint != "" {
+		urls = append(urls, checkedUrl{"dbt.s3endpoint", config.Dbt.S3Endpoint, false})
 	}
-	if resp != nil {
-		defer resp.Body.Close()
 
-		bodyBytes, err := ioutil.ReadAll(resp.Body)
-		if err != nil {
-			err = errors.Wrapf(err, "failed to read truststore contents")
-			return err
+	parsed := make(map[string]*url.URL)
+
+	for _, u := range urls {
+		if u.value == "" {
+			problems = append(problems, ConfigProblem{u.field, "is not set"})
+			continue
 		}
 
-		keytext := string(bodyBytes)
+		p, err := url.Parse(u.value)
+		if err != nil {
+			problems = append(problems, ConfigProblem{u.field, fmt.Sprintf("%q is not a valid url: %s", u.value, err)})
+			continue
+		}
 
-		// don't write anything if we have an empty string
-		if keytext != "" {
-			filePath := fmt.Sprintf("%s/%s", homedir, TruststorePath)
-			err = ioutil.WriteFile(filePath, []byte(keytext), 0644)
-			if err != nil {
-				err = errors.Wrapf(err, "failed to write trust file")
-				return err
-			}
+		if p.Scheme != "http" && p.Scheme != "https" {
+			problems = append(problems, ConfigProblem{u.field, fmt.Sprintf("%q must be an http or https url", u.value)})
+			continue
 		}
-	}
 
-	return err
-}
+		if p.Host == "" {
+			problems = append(problems, ConfigProblem{u.field, fmt.Sprintf("%q has no host", u.value)})
+			continue
+		}
 
-// IsCurrent returns whether the currently running version is the latest version, and possibly an error if the version check fails
-func (dbt *DBT) IsCurrent(binaryPath string) (ok bool, err error) {
-	latest, err := dbt.FindLatestVersion("")
-	if err != nil {
-		err = errors.Wrap(err, "failed to fetch dbt versions")
-		return ok, err
-	}
+		parsed[u.field] = p
 
-	dbt.VerboseOutput("Latest version: %s\n", latest)
+		// repo urls get joined with a '/', so a trailing slash produces '//' in every request
+		if u.isRepo && strings.HasSuffix(u.value, "/") {
+			problems = append(problems, ConfigProblem{u.field, fmt.Sprintf("%q should not end with a trailing slash", u.value)})
+		}
 
-	latestDbtVersionUrl := fmt.Sprintf("%s/%s/%s/%s/dbt", dbt.Config.Dbt.Repo, latest, runtime.GOOS, runtime.GOARCH)
+		// with a custom endpoint, urls on it aren't expected to look like AWS
+		if strings.Contains(p.Host, "amazonaws.com") && config.Dbt.S3Endpoint == "" {
+			if ok, _ := S3Url(u.value); !ok {
+				problems = append(problems, ConfigProblem{u.field, fmt.Sprintf("%q looks like S3, but only virtual host style urls such as https://<bucket>.s3.<region>.amazonaws.com are supported", u.value)})
+			}
+		}
+	}
 
-	dbt.VerboseOutput("Latest version url: %s\n", latestDbtVersionUrl)
+	// the proxy is optional, but if it's set it has to be usable
+	if config.Dbt.ProxyUrl != "" {
+		p, err := url.Parse(config.Dbt.ProxyUrl)
+		if err != nil || p.Host == "" {
+			problems = append(problems, ConfigProblem{"dbt.proxy", fmt.Sprintf("%q is not a valid proxy url", config.Dbt.ProxyUrl)})
+		}
+	}
 
-	ok, err = dbt.VerifyFileVersion(latestDbtVersionUrl, binaryPath)
-	if err != nil {
-		err = errors.Wrap(err, "failed to check latest version")
-		return ok, err
+	if config.Dbt.PinnedVersion != "" && !regexp.MustCompile(`^\d+\.\d+\.\d+$`).MatchString(config.Dbt.PinnedVersion) {
+		problems = append(problems, ConfigProblem{"dbt.pinnedversion", fmt.Sprintf("%q is not a semantic version such as 1.2.3", config.Dbt.PinnedVersion)})
 	}
 
-	if !ok {
-		dbt.VerboseOutput("File at %s does not match latest", binaryPath)
-		_, _ = fmt.Fprint(os.Stderr, fmt.Sprintf("Newer version of dbt available: %s\n\n", latest))
+	if _, err := ParseToolFileMode(config.ToolFileMode); err != nil {
+		problems = append(problems, ConfigProblem{"toolfilemode", err.Error()})
 	}
 
-	return ok, err
-}
+	if _, err := ParseFuncTimeout(config.FuncTimeout); err != nil {
+		problems = append(problems, ConfigProblem{"functimeout", err.Error()})
+	}
 
-// UpgradeInPlace upgraded dbt in place
-func (dbt *DBT) UpgradeInPlace(binaryPath string) (err error) {
-	dbt.VerboseOutput("Attempting upgrade in place")
-	tmpDir, err := ioutil.TempDir("", "dbt")
-	if err != nil {
-		err = errors.Wrap(err, "failed to create temp dir")
-		return err
+	for host := range config.Credentials {
+		if host == "" || strings.ContainsAny(host, "/@") {
+			problems = append(problems, ConfigProblem{"credentials", fmt.Sprintf("%q should be a hostname, or hostname:port, such as 'repo.example.com', not a url", host)})
+		}
 	}
 
-	dbt.VerboseOutput("  Temp Dir: %s", tmpDir)
+	repo, repoOk := parsed["dbt.repository"]
+	truststore, trustOk := parsed["dbt.truststore"]
 
-	//defer os.RemoveAll(tmpDir)
+	if repoOk && trustOk && repo.Host != truststore.Host {
+		problems = append(problems, ConfigProblem{"dbt.truststore", fmt.Sprintf("host %q does not match dbt.repository host %q", truststore.Host, repo.Host)})
+	}
 
-	newBinaryFile := fmt.Sprintf("%s/dbt", tmpDir)
+	return problems
+}
 
-	dbt.VerboseOutput("  New binary file: %s", newBinaryFile)
+// ValidateTrustStore fetches the configured truststores and checks that they actually contain a PGP public key block.
+func (dbt *DBT) ValidateTrustStore() (problems []ConfigProblem) {
+	problems = dbt.validateTrustStore("dbt.truststore", dbt.Config.Dbt.TrustStore)
 
-	latest, err := dbt.FindLatestVersion("")
-	if err != nil {
-		err = errors.Wrap(err, "failed to find latest dbt version")
-		return err
+	if dbt.Config.Dbt.ToolsTrustStore != "" {
+		problems = append(problems, dbt.validateTrustStore("dbt.toolstruststore", dbt.Config.Dbt.ToolsTrustStore)...)
 	}
 
-	dbt.VerboseOutput("  Latest: %s", latest)
+	return problems
+}
 
-	latestDbtVersionUrl := fmt.Sprintf("%s/%s/%s/%s/dbt", dbt.Config.Dbt.Repo, latest, runtime.GOOS, runtime.GOARCH)
+// validateTrustStore fetches the truststore at uri and checks that it actually contains a PGP public key block.  Problems are reported against field.
+func (dbt *DBT) validateTrustStore(field string, uri string) (problems []ConfigProblem) {
+	problems = make([]ConfigProblem, 0)
+	var keytext string
 
-	dbt.VerboseOutput("  Fetching from: %s", latestDbtVersionUrl)
+	isS3, s3Meta := dbt.s3Url(uri)
 
-	err = dbt.FetchFile(latestDbtVersionUrl, newBinaryFile)
-	if err != nil {
-		err = errors.Wrap(err, "failed to fetch new dbt binary")
-		return err
-	}
+	if isS3 {
+		buf := manager.NewWriteAtBuffer([]byte{})
+		downloader := dbt.s3Downloader(s3Meta)
+		_, err := downloader.Download(dbt.RequestContext(), buf, &s3.GetObjectInput{
+			Bucket: aws.String(s3Meta.Bucket),
+			Key:    aws.String(s3Meta.Key),
+		})
+		if err != nil {
+			problems = append(problems, ConfigProblem{field, fmt.Sprintf("failed to download truststore from %s: %s", uri, err)})
+			return problems
+		}
 
-	dbt.VerboseOutput("  Verifying %s", newBinaryFile)
-	ok, err := dbt.VerifyFileVersion(latestDbtVersionUrl, newBinaryFile)
-	if err != nil {
-		err = errors.Wrap(err, "failed to verify downloaded binary")
-		return err
-	}
+		keytext = string(buf.Bytes())
 
-	if ok {
-		dbt.VerboseOutput("  It's good.  Moving it into place.")
-		// This is slightly more painful than it might otherwise be in order to handle modern linux systems where /tmp is tmpfs (can't just rename cross partition).
-		// So instead we read the file, write the file to a temp file, and then rename.
-		newBinaryTempFile := fmt.Sprintf("%s.new", binaryPath)
+	} else {
+		client := dbt.HttpClient()
 
-		b, err := ioutil.ReadFile(newBinaryFile)
+		req, err := http.NewRequestWithContext(dbt.RequestContext(), "GET", uri, nil)
 		if err != nil {
-			err = errors.Wrapf(err, "failed to read new binary file %s", newBinaryFile)
-			return err
+			problems = append(problems, ConfigProblem{field, fmt.Sprintf("failed to create request for url %s: %s", uri, err)})
+			return problems
 		}
 
-		dbt.VerboseOutput("  Writing to %s", newBinaryTempFile)
+		err = dbt.AuthHeaders(req)
+		if err != nil {
+			problems = append(problems, ConfigProblem{field, fmt.Sprintf("failed adding auth headers: %s", err)})
+			return problems
+		}
 
-		err = ioutil.WriteFile(newBinaryTempFile, b, 0755)
+		resp, err := client.Do(req)
 		if err != nil {
-			err = errors.Wrapf(err, "failed to write new binary temp file %s", newBinaryTempFile)
-			return err
+			problems = append(problems, ConfigProblem{field, fmt.Sprintf("failed to fetch truststore from %s: %s", uri, err)})
+			return problems
 		}
 
-		dbt.VerboseOutput("  renaming %s to %s", newBinaryTempFile, binaryPath)
+		defer resp.Body.Close()
 
-		err = os.Rename(newBinaryTempFile, binaryPath)
+		if resp.StatusCode != http.StatusOK {
+			problems = append(problems, ConfigProblem{field, fmt.Sprintf("fetching %s returned %s", uri, resp.Status)})
+			return problems
+		}
+
+		bodyBytes, err := ioutil.ReadAll(resp.Body)
 		if err != nil {
-			err = errors.Wrap(err, "failed to move new binary into place")
-			return err
+			problems = append(problems, ConfigProblem{field, fmt.Sprintf("failed to read truststore contents: %s", err)})
+			return problems
 		}
 
-		dbt.VerboseOutput("  Chmodding %s to 0755", binaryPath)
+		keytext = string(bodyBytes)
+	}
+
+	if !strings.Contains(keytext, "-----BEGIN PGP PUBLIC KEY BLOCK-----") {
+		problems = append(problems, ConfigProblem{field, fmt.Sprintf("%s does not contain a PGP public key block", uri)})
+	}
+
+	return problems
+}
+
+// GenerateDbtDir generates the necessary dbt dirs in the user's homedir if they don't already exist.  If they do exist, it does nothing.
+func GenerateDbtDir(homedir string, verbose bool) (err error) {
+	if homedir == "" {
+		homedir, err = GetHomeDir()
+		if err != nil {
+			err = errors.Wrapf(err, "failed to get homedir")
+			return err
+		}
+	}
+
+	logger := log.New(os.Stderr, "", 0)
+
+	// Under XDG, config and cache are separate trees.  Otherwise they're both ~/.dbt
+	dbtPaths := []string{
+		dbtBaseDir(homedir, XDG_CONFIG_HOME_ENV_VAR),
+		dbtBaseDir(homedir, XDG_CACHE_HOME_ENV_VAR),
+	}
+
+	for _, dbtPath := range dbtPaths {
+		if verbose {
+			logger.Printf("Creating DBT directory in %s", dbtPath)
+		}
+
+		err = os.MkdirAll(dbtPath, 0755)
+		if err != nil {
+			err = errors.Wrapf(err, "failed to create directory %s", dbtPath)
+			return err
+		}
+	}
+
+	// MkdirAll is a no-op for dirs that already exist, so this is safe to run repeatedly.
+	for _, dir := range []string{TrustDir(homedir), ToolDir(homedir), ConfigDir(homedir)} {
+		err = os.MkdirAll(dir, 0755)
+		if err != nil {
+			err = errors.Wrapf(err, "failed to create directory %s", dir)
+			return err
+		}
+	}
+
+	return err
+}
+
+// GetHomeDir get's the current user's homedir.  If DBT_HOME is set and points to an existing directory, that is returned instead.
+func GetHomeDir() (dir string, err error) {
+	override, ok := dbtHomeOverride()
+	if ok {
+		dir = override
+		return dir, err
+	}
+
+	dir, err = homedir.Dir()
+	return dir, err
+}
+
+// dbtHomeOverride returns the value of DBT_HOME, and whether it points to an existing directory.
+func dbtHomeOverride() (dir string, ok bool) {
+	dir = os.Getenv(DBT_HOME_ENV_VAR)
+	if dir == "" {
+		return dir, ok
+	}
+
+	info, err := os.Stat(dir)
+	if err == nil && info.IsDir() {
+		ok = true
+	}
+
+	return dir, ok
+}
+
+// FetchTrustStore writes the downloaded trusted signing public keys to disk.  If the config has a tools truststore, that's downloaded too.  If not, any tools truststore left from before is removed, so tools go back to being verified against the one truststore.
+func (dbt *DBT) FetchTrustStore(homedir string) (err error) {
+	err = dbt.fetchTrustStore(dbt.Config.Dbt.TrustStore, TruststorePath(homedir))
+	if err != nil {
+		return err
+	}
+
+	toolsTruststore := ToolsTruststorePath(homedir)
+
+	if dbt.Config.Dbt.ToolsTrustStore == "" {
+		err = os.Remove(toolsTruststore)
+		if err != nil && !os.IsNotExist(err) {
+			err = errors.Wrapf(err, "failed to remove tools truststore %s", toolsTruststore)
+			return err
+		}
+
+		return nil
+	}
+
+	err = dbt.fetchTrustStore(dbt.Config.Dbt.ToolsTrustStore, toolsTruststore)
+	if err != nil {
+		err = errors.Wrapf(err, "failed to fetch tools truststore")
+		return err
+	}
+
+	return err
+}
+
+// fetchTrustStore downloads the truststore at uri to filePath.
+func (dbt *DBT) fetchTrustStore(uri string, filePath string) (err error) {
+	dbt.VerboseOutput("Fetching truststore from %q\n", uri)
+
+	isS3, s3Meta := dbt.s3Url(uri)
+
+	if isS3 {
+		return dbt.s3FetchTruststoreTo(s3Meta, filePath)
+	}
+
+	client := dbt.HttpClient()
+
+	req, err := http.NewRequestWithContext(dbt.RequestContext(), "GET", uri, nil)
+	if err != nil {
+		err = errors.Wrapf(err, "failed to create request for url: %s", uri)
+		return err
+	}
+
+	err = dbt.AuthHeaders(req)
+	if err != nil {
+		err = errors.Wrapf(err, "failed adding auth headers")
+		return err
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		err = errors.Wrapf(err, "failed to fetch truststore from %s", uri)
+		return err
+	}
+	if resp != nil {
+		defer resp.Body.Close()
+
+		bodyBytes, err := ioutil.ReadAll(resp.Body)
+		if err != nil {
+			err = errors.Wrapf(err, "failed to read truststore contents")
+			return err
+		}
+
+		keytext := string(bodyBytes)
+
+		// don't write anything if we have an empty string
+		if keytext != "" {
+			err = ioutil.WriteFile(filePath, []byte(keytext), 0644)
+			if err != nil {
+				err = errors.Wrapf(err, "failed to write trust file")
+				return err
+			}
+		}
+	}
+
+	return err
+}
+
+// IsCurrent returns whether the currently running version is the target version, and possibly an error if the version check fails.  The target is the pinned version if there is one, otherwise the latest.
+func (dbt *DBT) IsCurrent(binaryPath string) (ok bool, err error) {
+	target, err := dbt.TargetDbtVersion()
+	if err != nil {
+		return ok, err
+	}
+
+	dbt.VerboseOutput("Target version: %s\n", target)
+
+	targetDbtVersionUrl := fmt.Sprintf("%s/%s/%s/%s/dbt", dbt.Config.Dbt.Repo, target, runtime.GOOS, runtime.GOARCH)
+
+	dbt.VerboseOutput("Target version url: %s\n", targetDbtVersionUrl)
+
+	ok, err = dbt.VerifyFileVersion(targetDbtVersionUrl, binaryPath)
+	if err != nil {
+		err = errors.Wrapf(err, "failed to check version %s", target)
+		return ok, err
+	}
+
+	if !ok {
+		dbt.VerboseOutput("File at %s does not match %s", binaryPath, target)
+
+		if dbt.Config.Dbt.PinnedVersion != "" {
+			dbt.logEvent(LogFields{Operation: "upgrade-check", Version: target, Url: targetDbtVersionUrl}, "dbt is pinned to version %s\n\n", target)
+		} else {
+			dbt.logEvent(LogFields{Operation: "upgrade-check", Version: target, Url: targetDbtVersionUrl}, "Newer version of dbt available: %s\n\n", target)
+		}
+	}
+
+	return ok, err
+}
+
+// TargetDbtVersion returns the version of dbt to converge to.  That's the pinned version if there is one, otherwise the latest in the repo.
+func (dbt *DBT) TargetDbtVersion() (version string, err error) {
+	if dbt.Config.Dbt.PinnedVersion != "" {
+		version = dbt.Config.Dbt.PinnedVersion
+		return version, err
+	}
+
+	version, err = dbt.FindLatestVersion("")
+	if err != nil {
+		err = errors.Wrap(err, "failed to fetch dbt versions")
+		return version, err
+	}
+
+	return version, err
+}
+
+// UpgradeInPlace replaces dbt in place with the target version.  If dbt is pinned to an older version than the one running, that's a downgrade.  A lock beside the binary keeps concurrent upgrades from replacing it over each other, and one that finds the binary already upgraded by the time it gets the lock leaves it be.
+func (dbt *DBT) UpgradeInPlace(binaryPath string) (err error) {
+	dbt.VerboseOutput("Attempting upgrade in place")
+
+	// only one dbt replaces the binary at a time.  The rest wait their turn, by which time there's likely nothing left to do.
+	unlock, err := lockFile(binaryPath+UPGRADE_LOCK_SUFFIX, UPGRADE_LOCK_TIMEOUT)
+	if err != nil {
+		err = errors.Wrap(err, "failed to lock dbt binary for upgrade")
+		return err
+	}
+
+	defer unlock()
+
+	target, err := dbt.TargetDbtVersion()
+	if err != nil {
+		return err
+	}
+
+	dbt.VerboseOutput("  Target: %s", target)
+
+	targetDbtVersionUrl := fmt.Sprintf("%s/%s/%s/%s/dbt", dbt.Config.Dbt.Repo, target, runtime.GOOS, runtime.GOARCH)
+
+	current, err := dbt.VerifyFileVersion(targetDbtVersionUrl, binaryPath)
+	if err == nil && current {
+		dbt.VerboseOutput("  %s was upgraded to %s while we waited.", binaryPath, target)
+		return err
+	}
+
+	tmpDir, err := ioutil.TempDir("", "dbt")
+	if err != nil {
+		err = errors.Wrap(err, "failed to create temp dir")
+		return err
+	}
+
+	dbt.VerboseOutput("  Temp Dir: %s", tmpDir)
+
+	//defer os.RemoveAll(tmpDir)
+
+	newBinaryFile := fmt.Sprintf("%s/dbt", tmpDir)
+
+	dbt.VerboseOutput("  New binary file: %s", newBinaryFile)
+
+	ok := dbt.patchUpgrade(binaryPath, targetDbtVersionUrl, tmpDir, newBinaryFile)
+
+	if !ok {
+		dbt.VerboseOutput("  Fetching from: %s", targetDbtVersionUrl)
+
+		err = dbt.FetchFile(targetDbtVersionUrl, newBinaryFile)
+		if err != nil {
+			err = errors.Wrap(err, "failed to fetch new dbt binary")
+			return err
+		}
+
+		dbt.VerboseOutput("  Verifying %s", newBinaryFile)
+		ok, err = dbt.VerifyFileVersion(targetDbtVersionUrl, newBinaryFile)
+		if err != nil {
+			err = errors.Wrap(err, "failed to verify downloaded binary")
+			return err
+		}
+	}
+
+	if ok {
+		dbt.VerboseOutput("  It's good.  Moving it into place.")
+		// This is slightly more painful than it might otherwise be in order to handle modern linux systems where /tmp is tmpfs (can't just rename cross partition).
+		// So instead we read the file, write the file to a temp file, and then rename.
+		newBinaryTempFile := fmt.Sprintf("%s.new", binaryPath)
+
+		b, err := ioutil.ReadFile(newBinaryFile)
+		if err != nil {
+			err = errors.Wrapf(err, "failed to read new binary file %s", newBinaryFile)
+			return err
+		}
+
+		dbt.VerboseOutput("  Writing to %s", newBinaryTempFile)
+
+		err = ioutil.WriteFile(newBinaryTempFile, b, 0755)
+		if err != nil {
+			err = errors.Wrapf(err, "failed to write new binary temp file %s", newBinaryTempFile)
+			return err
+		}
+
+		dbt.VerboseOutput("  renaming %s to %s", newBinaryTempFile, binaryPath)
+
+		err = os.Rename(newBinaryTempFile, binaryPath)
+		if err != nil {
+			err = errors.Wrap(err, "failed to move new binary into place")
+			return err
+		}
+
+		dbt.VerboseOutput("  Chmodding %s to 0755", binaryPath)
 
 		err = os.Chmod(binaryPath, 0755)
 		if err != nil {
-			err = errors.Wrap(err, "failed to chmod new dbt binary")
+			err = errors.Wrap(err, "failed to chmod new dbt binary")
+			return err
+		}
+	}
+
+	return err
+}
+
+// patchUpgrade tries to build the new dbt binary by patching the current one, which is a lot smaller to download than the whole thing.  It returns true only if the patched binary verifies.  Any failure along the way just means falling back to the full download.
+func (dbt *DBT) patchUpgrade(binaryPath string, targetDbtVersionUrl string, tmpDir string, newBinaryFile string) (ok bool) {
+	patchUrl := fmt.Sprintf("%s%s.%s", targetDbtVersionUrl, BSDIFF_SUFFIX, VERSION)
+
+	exists, err := dbt.FileExists(patchUrl)
+	if err != nil || !exists {
+		dbt.VerboseOutput("  No patch from %s available.", VERSION)
+		return ok
+	}
+
+	patchFile := fmt.Sprintf("%s/dbt%s", tmpDir, BSDIFF_SUFFIX)
+
+	dbt.VerboseOutput("  Fetching patch from: %s", patchUrl)
+
+	err = dbt.FetchFile(patchUrl, patchFile)
+	if err != nil {
+		dbt.VerboseOutput("  Failed to fetch patch: %s", err)
+		return ok
+	}
+
+	err = PatchFile(binaryPath, patchFile, newBinaryFile)
+	if err != nil {
+		dbt.VerboseOutput("  Failed to apply patch: %s", err)
+		return ok
+	}
+
+	dbt.VerboseOutput("  Verifying patched %s", newBinaryFile)
+
+	ok, err = dbt.VerifyFileVersion(targetDbtVersionUrl, newBinaryFile)
+	if err != nil {
+		dbt.VerboseOutput("  Failed to verify patched binary: %s", err)
+		ok = false
+		return ok
+	}
+
+	if !ok {
+		dbt.VerboseOutput("  Patched binary doesn't match %s.", targetDbtVersionUrl)
+	}
+
+	return ok
+}
+
+// TOOL_SPEC_LATEST Version in a tool spec that means the latest version, same as no version at all, e.g. 'foo@latest'.
+const TOOL_SPEC_LATEST = "latest"
+
+// ParseToolSpec splits a tool spec of the form 'name@version' into the tool name and version.  The version can be a semver range, such as 'name@^1.2' or 'name@~1.2.3', which IsVersionRange reports.  A bare name, or 'name@latest', means the latest version, which is an empty version.  Anything after the '@' that isn't a semantic version, a range, or 'latest' is taken to be part of the name.
+func ParseToolSpec(arg string) (name string, version string) {
+	name = arg
+
+	i := strings.LastIndex(arg, "@")
+	if i < 1 {
+		return name, version
+	}
+
+	spec := arg[i+1:]
+
+	if spec == TOOL_SPEC_LATEST {
+		name = arg[:i]
+		return name, version
+	}
+
+	if exactVersion.MatchString(spec) || IsVersionRange(spec) {
+		name = arg[:i]
+		version = spec
+	}
+
+	return name, version
+}
+
+// TOOL_SPEC_DIGEST_PREFIX Marks the sha256 digest that can end a tool spec, e.g. 'foo@sha256:<hex>' or 'foo@1.2.3@sha256:<hex>'.
+const TOOL_SPEC_DIGEST_PREFIX = "sha256:"
+
+// ParseToolDigest splits a sha256 digest off the end of a tool spec, leaving the rest for ParseToolSpec.  A spec without one has an empty digest.
+func ParseToolDigest(arg string) (spec string, digest string) {
+	spec = arg
+
+	i := strings.LastIndex(arg, fmt.Sprintf("@%s", TOOL_SPEC_DIGEST_PREFIX))
+	if i < 1 {
+		return spec, digest
+	}
+
+	candidate := strings.ToLower(arg[i+1+len(TOOL_SPEC_DIGEST_PREFIX):])
+
+	if regexp.MustCompile(`^[0-9a-f]{64}$`).MatchString(candidate) {
+		spec = arg[:i]
+		digest = candidate
+	}
+
+	return spec, digest
+}
+
+// ToolDigest returns the sha256 digest a tool is pinned to, either by its spec, or failing that, by tools.digests in the config.  Empty means it isn't pinned.  A spec and config that disagree are an error.
+func (dbt *DBT) ToolDigest(toolName string, specDigest string) (digest string, err error) {
+	configDigest := strings.ToLower(strings.TrimPrefix(dbt.Config.Tools.Digests[toolName], TOOL_SPEC_DIGEST_PREFIX))
+
+	if specDigest != "" && configDigest != "" && specDigest != configDigest {
+		err = fmt.Errorf("conflicting digests of %s requested: %s and %s", toolName, specDigest, configDigest)
+		return digest, err
+	}
+
+	digest = specDigest
+	if digest == "" {
+		digest = configDigest
+	}
+
+	return digest, err
+}
+
+// VerifyToolDigest checks that the binary at localPath is exactly the one a tool is pinned to.  It's on top of the usual checksum and signature checks, and catches a version that's been republished with different bytes, signed or not.  An empty digest pins nothing.
+func VerifyToolDigest(localPath string, toolName string, digest string) (err error) {
+	if digest == "" {
+		return err
+	}
+
+	actual, err := FileSha256(localPath)
+	if err != nil {
+		err = errors.Wrapf(err, "failed to checksum %s", localPath)
+		return err
+	}
+
+	if actual != digest {
+		err = errors.Wrapf(ErrChecksumMismatch, "%s has sha256 %s, but is pinned to %s", toolName, actual, digest)
+		return err
+	}
+
+	return err
+}
+
+// RunTool runs the dbt tool indicated by the args.  The tool can be given as 'name@version', in which case version must be empty or agree.  Any tools it declares as dependencies are fetched first.  A tool pinned to a digest, as 'name@sha256:<hex>', 'name@version@sha256:<hex>', or in tools.digests, only runs if it's exactly that binary.
+func (dbt *DBT) RunTool(version string, args []string, homedir string, offline bool) (err error) {
+	if args[0] == "--" {
+		args = args[1:]
+	}
+
+	spec, specDigest := ParseToolDigest(args[0])
+	toolName, specVersion := ParseToolSpec(spec)
+	args = append([]string{toolName}, args[1:]...)
+
+	digest, err := dbt.ToolDigest(toolName, specDigest)
+	if err != nil {
+		return err
+	}
+
+	if specVersion != "" {
+		if version != "" && version != specVersion {
+			err = fmt.Errorf("conflicting versions of %s requested: %s and %s", toolName, version, specVersion)
+			return err
+		}
+
+		version = specVersion
+	}
+
+	// a range means the newest version in it, of those downloaded if offline
+	if IsVersionRange(version) {
+		version, err = dbt.resolveVersionRange(homedir, toolName, version, offline)
+		if err != nil {
+			return err
+		}
+	}
+
+	// if offline, if tool is present and verifies, run it
+	if offline {
+		// the policy from the last online run still holds
+		err = dbt.enforceCachedToolPolicy(homedir, toolName, version)
+		if err != nil {
+			err = errors.Wrap(err, "offline run failed")
+			return err
+		}
+
+		err = dbt.verifyAndRun(homedir, version, digest, args)
+		if err != nil {
+			err = errors.Wrap(err, "offline run failed")
+			return err
+		}
+
+		return err
+	}
+
+	// operators can rule out known bad versions, so don't even download one
+	policy, err := dbt.FetchToolPolicy(toolName, homedir)
+	if err != nil {
+		return err
+	}
+
+	if version != "" {
+		err = enforceToolPolicy(policy, toolName, version)
+		if err != nil {
 			return err
 		}
 	}
 
+	localPath, err := dbt.FetchTool(toolName, version, homedir)
+	if err != nil {
+		return err
+	}
+
+	// the latest version, or a cached one if the tool's gone from the repo, is held to the policy too
+	err = enforceToolPolicy(policy, toolName, filepath.Base(filepath.Dir(localPath)))
+	if err != nil {
+		return err
+	}
+
+	err = VerifyToolDigest(localPath, toolName, digest)
+	if err != nil {
+		return err
+	}
+
+	// tools that run other tools can declare them, and have them fetched up front
+	err = dbt.ResolveDependencies(toolName, version, homedir)
+	if err != nil {
+		err = errors.Wrapf(err, "failed to fetch dependencies of %s", toolName)
+		return err
+	}
+
+	// finally run it
+	err = dbt.runExec(localPath, args, false)
+	if err != nil {
+		err = errors.Wrap(err, "run failed")
+		return err
+	}
+
 	return err
 }
 
-// RunTool runs the dbt tool indicated by the args
-func (dbt *DBT) RunTool(version string, args []string, homedir string, offline bool) (err error) {
-	toolName := args[0]
+// resolveVersionRange picks the newest version of a tool within a semver range, from the repo, or when offline, from the cache.
+func (dbt *DBT) resolveVersionRange(homedir string, toolName string, constraint string, offline bool) (version string, err error) {
+	if offline {
+		version, err = LatestCachedVersionSatisfying(homedir, toolName, constraint)
+	} else {
+		version, err = dbt.FindLatestVersionSatisfying(toolName, constraint)
+	}
 
-	if toolName == "--" {
-		toolName = args[1]
-		args = args[1:]
+	if err != nil {
+		return version, err
 	}
 
-	localPath := fmt.Sprintf("%s/%s/%s", homedir, ToolDir, toolName)
+	dbt.VerboseOutput("Using %s version %s for %s", toolName, version, constraint)
 
-	// if offline, if tool is present and verifies, run it
-	if offline {
-		err = dbt.verifyAndRun(homedir, args)
+	return version, err
+}
+
+// FetchTool makes sure the requested version of a tool is downloaded and verified, and returns where it lives.  It never runs the tool, so it's what to use to pre-warm a cache or stage tools for offline use.  An empty version means the latest, and a semver range like '^1.2' the newest version in it.  A tool that isn't in the repo at all is still usable if it was downloaded before.
+func (dbt *DBT) FetchTool(toolName string, version string, homedir string) (localPath string, err error) {
+	if IsVersionRange(version) {
+		version, err = dbt.resolveVersionRange(homedir, toolName, version, false)
 		if err != nil {
-			err = errors.Wrap(err, "offline run failed")
-			return err
+			return localPath, err
 		}
-
-		return err
 	}
 
-	// we're not offline, so find the latest
 	latestVersion, err := dbt.FindLatestVersion(toolName)
 	if err != nil {
 		err = errors.Wrap(err, "failed to find latest version")
-		return err
+		return localPath, err
 	}
 
-	// if it's not in the repo, it might still be on the filesystem
+	// if it's not in the repo, it might still be in the cache
 	if latestVersion == "" {
-		// if it is indeed on the filesystem
-		if _, err := os.Stat(localPath); !os.IsNotExist(err) {
-			// attempt to run it in offline mode
-			err = dbt.verifyAndRun(homedir, args)
-			if err != nil {
-				err = errors.Wrap(err, "offline run failed")
-				return err
-			}
-
-			// and return if it's successful
-			return err
+		// use it if it verifies
+		localPath, err = dbt.verifyTool(homedir, toolName, version)
+		if err != nil {
+			err = errors.Wrapf(err, "tool %s is not in repo, and offline verification failed", toolName)
+			return localPath, err
 		}
 
-		// It's not in the repo, and not on the filesystem, there's not a damn thing we can do.  Fail.
-		err = fmt.Errorf("Tool %s is not in repo, and has not been previously downloaded.  Cannot run.\n", toolName)
-		return err
+		return localPath, err
 	}
 
 	// if version is unset, version is latest version
@@ -438,110 +1288,378 @@ func (dbt *DBT) RunTool(version string, args []string, homedir string, offline b
 		version = latestVersion
 	}
 
-	// url should be http(s)://tool-repo/toolName/version/os/arch/tool
-	toolUrl := fmt.Sprintf("%s/%s/%s/%s/%s/%s", dbt.Config.Tools.Repo, toolName, version, runtime.GOOS, runtime.GOARCH, toolName)
+	localPath = CachedToolPath(homedir, toolName, version)
+
+	err = removeLegacyCachedTool(homedir, toolName)
+	if err != nil {
+		return localPath, err
+	}
+
+	// another dbt fetching the same tool waits here, and then finds it already cached
+	unlock, err := lockCachedTool(homedir, toolName)
+	if err != nil {
+		return localPath, err
+	}
+
+	defer unlock()
+
+	// url should be http(s)://tool-repo/toolName/version/os/arch/tool, or tool.exe on windows
+	toolUrl := fmt.Sprintf("%s/%s/%s/%s/%s/%s", dbt.Config.Tools.Repo, toolName, version, runtime.GOOS, runtime.GOARCH, ToolFileName(toolName, runtime.GOOS))
+
+	uptodate := false
+	remoteChecksum := ""
 
 	if _, err := os.Stat(localPath); !os.IsNotExist(err) {
+		// check to see if what we have is what's in the repo
+		remoteChecksum, err = dbt.fetchCachedChecksum(toolUrl, localPath)
+		if err != nil {
+			err = errors.Wrap(err, "failed to verify file version")
+			return localPath, err
+		}
 
-		// check to see if the latest version is what we have
-		uptodate, err := dbt.VerifyFileVersion(toolUrl, localPath)
+		uptodate, err = dbt.VerifyFileChecksum(localPath, remoteChecksum)
 		if err != nil {
 			err = errors.Wrap(err, "failed to verify file version")
-			return err
+			return localPath, err
 		}
+	}
+
+	// The repo's checksum is the authority.  A local .sha256 that disagrees with it is stale at best, and tampered with at worst, so it's replaced.
+	if uptodate {
+		// a download that died after the binary leaves the cache without its sidecars, so fill them in
+		for _, sidecar := range missingSidecars(localPath) {
+			fileUrl := fmt.Sprintf("%s%s", toolUrl, strings.TrimPrefix(sidecar, localPath))
+
+			dbt.VerboseOutput("Repairing cache.  %s is missing.", sidecar)
 
-		// if yes, run it
-		if uptodate {
-			err = dbt.verifyAndRun(homedir, args)
+			err = dbt.FetchFile(fileUrl, sidecar)
 			if err != nil {
-				err = errors.Wrap(err, "run failed")
-				return err
+				err = errors.Wrapf(err, "failed to fetch %s for %s", fileUrl, toolName)
+				return localPath, err
+			}
+		}
+
+		err = dbt.verifyToolFileChecksum(homedir, localPath, remoteChecksum)
+		if err != nil {
+			// whatever's wrong with the cached copy, a fresh one from the repo fixes it
+			dbt.logEvent(LogFields{Operation: "verify", Tool: toolName, Version: version, Url: toolUrl}, "Cached %s version %s failed verification: %s.  Downloading it again.", toolName, version, err)
+
+			for _, suffix := range []string{"", ".sha256", ".asc"} {
+				_ = os.Remove(localPath + suffix)
+			}
+
+			uptodate = false
+			err = nil
+		}
+	}
+
+	if uptodate {
+		localChecksumPath := fmt.Sprintf("%s.sha256", localPath)
+
+		localChecksum, readErr := ioutil.ReadFile(localChecksumPath)
+		if readErr != nil || string(localChecksum) != remoteChecksum {
+			dbt.VerboseOutput("Replacing local checksum %q with the repo's", localChecksumPath)
+
+			err = ioutil.WriteFile(localChecksumPath, []byte(remoteChecksum), 0644)
+			if err != nil {
+				err = errors.Wrapf(err, "failed to write %s", localChecksumPath)
+				return localPath, err
+			}
+		}
+	} else {
+		err = os.MkdirAll(filepath.Dir(localPath), 0755)
+		if err != nil {
+			err = errors.Wrapf(err, "failed to create dir for %s", localPath)
+			return localPath, err
+		}
+
+		dbt.logEvent(LogFields{Operation: "download", Tool: toolName, Version: version, Url: toolUrl}, "Downloading binary tool %q version %s.", toolName, version)
+
+		err = dbt.FetchToolBinary(toolUrl, localPath)
+		if err != nil {
+			// a version that isn't there is worth telling apart from a download that went wrong
+			if exists, existsErr := dbt.ToolVersionExists(toolName, version); existsErr == nil && !exists {
+				_ = os.RemoveAll(filepath.Dir(localPath))
+				err = errors.Wrapf(ErrVersionNotFound, "version %s of tool %s is not in repo", version, toolName)
+				return localPath, err
+			}
+
+			err = errors.Wrapf(err, "failed to fetch binary for %s from %s", toolName, toolUrl)
+			return localPath, err
+		}
+
+		for _, suffix := range []string{".sha256", ".asc"} {
+			fileUrl := fmt.Sprintf("%s%s", toolUrl, suffix)
+
+			err = dbt.FetchFile(fileUrl, fmt.Sprintf("%s%s", localPath, suffix))
+			if err != nil {
+				err = errors.Wrapf(err, "failed to fetch %s for %s", fileUrl, toolName)
+				return localPath, err
 			}
+		}
+
+		err = dbt.verifyToolFile(homedir, localPath)
+		if err != nil {
+			err = errors.Wrap(err, "verification failed")
+			return localPath, err
+		}
+	}
+
+	if version == latestVersion {
+		// without the link, the highest version cached stands in for the latest, which is nearly always the same thing
+		linkErr := linkLatestCachedVersion(homedir, toolName, version)
+		if linkErr != nil {
+			dbt.VerboseOutput("Failed to link latest version of %s: %s", toolName, linkErr)
+		}
+	}
+
+	return localPath, err
+}
+
+// TargetPlatform returns the OS and architecture to fetch tools for.  Anything not given comes from DBT_TARGET_OS and DBT_TARGET_ARCH, and failing that, the platform dbt is running on.
+func TargetPlatform(goos string, goarch string) (targetOs string, targetArch string) {
+	targetOs = goos
+	if targetOs == "" {
+		targetOs = os.Getenv(DBT_TARGET_OS_ENV_VAR)
+	}
 
+	if targetOs == "" {
+		targetOs = runtime.GOOS
+	}
+
+	targetArch = goarch
+	if targetArch == "" {
+		targetArch = os.Getenv(DBT_TARGET_ARCH_ENV_VAR)
+	}
+
+	if targetArch == "" {
+		targetArch = runtime.GOARCH
+	}
+
+	return targetOs, targetArch
+}
+
+// FetchToolForPlatform downloads a tool built for a possibly different OS and architecture to destPath, along with its checksum and signature, and verifies them.  The tool is never run, so this is how to stage tools for other machines.  An empty version means the latest, and an empty goos or goarch is resolved by TargetPlatform.
+func (dbt *DBT) FetchToolForPlatform(toolName string, version string, goos string, goarch string, destPath string, homedir string) (err error) {
+	goos, goarch = TargetPlatform(goos, goarch)
+
+	if version == "" {
+		version, err = dbt.FindLatestVersion(toolName)
+		if err != nil {
+			err = errors.Wrap(err, "failed to find latest version")
+			return err
+		}
+
+		if version == "" {
+			err = fmt.Errorf("tool %s is not in repo", toolName)
 			return err
 		}
 	}
 
-	// download the binary
-	dbt.Logger.Printf("Downloading binary tool %q version %s.", toolName, version)
-	err = dbt.FetchFile(toolUrl, localPath)
+	toolUrl := fmt.Sprintf("%s/%s/%s/%s/%s/%s", dbt.Config.Tools.Repo, toolName, version, goos, goarch, ToolFileName(toolName, goos))
+
+	dbt.VerboseOutput("Fetching %s version %s for %s/%s", toolName, version, goos, goarch)
+
+	err = os.MkdirAll(filepath.Dir(destPath), 0755)
 	if err != nil {
-		err = errors.Wrap(err, fmt.Sprintf("failed to fetch binary for %s from %s", toolName, toolUrl))
+		err = errors.Wrapf(err, "failed to create dir for %s", destPath)
 		return err
 	}
 
-	// download the checksum
-	toolChecksumUrl := fmt.Sprintf("%s.sha256", toolUrl)
-	toolChecksumFile := fmt.Sprintf("%s.sha256", localPath)
-
-	err = dbt.FetchFile(toolChecksumUrl, toolChecksumFile)
+	err = dbt.FetchToolBinary(toolUrl, destPath)
 	if err != nil {
-		err = errors.Wrap(err, fmt.Sprintf("failed to fetch checksum for %s from %s", toolName, toolChecksumUrl))
+		err = errors.Wrapf(err, "failed to fetch %s", toolUrl)
 		return err
 	}
 
-	// download the signature
-	toolSignatureUrl := fmt.Sprintf("%s.asc", toolUrl)
-	toolSignatureFile := fmt.Sprintf("%s.asc", localPath)
+	for _, suffix := range []string{".sha256", ".asc"} {
+		fileUrl := fmt.Sprintf("%s%s", toolUrl, suffix)
 
-	err = dbt.FetchFile(toolSignatureUrl, toolSignatureFile)
-	if err != nil {
-		err = errors.Wrap(err, fmt.Sprintf("failed to fetch signature for %s from %s", toolName, toolSignatureUrl))
-		return err
+		err = dbt.FetchFile(fileUrl, fmt.Sprintf("%s%s", destPath, suffix))
+		if err != nil {
+			err = errors.Wrapf(err, "failed to fetch %s", fileUrl)
+			return err
+		}
 	}
 
-	// finally run it
-	err = dbt.verifyAndRun(homedir, args)
+	err = dbt.verifyToolFile(homedir, destPath)
 
 	return err
 }
 
-func (dbt *DBT) verifyAndRun(homedir string, args []string) (err error) {
-	toolName := args[0]
-	if toolName == "--" {
-		toolName = args[1]
+// verifyAndRun runs a cached version of a tool, as long as it verifies, and is the binary it's pinned to if digest is set.  An empty version means the latest cached.
+func (dbt *DBT) verifyAndRun(homedir string, version string, digest string, args []string) (err error) {
+	if args[0] == "--" {
 		args = args[1:]
 	}
 
-	localPath := fmt.Sprintf("%s/%s/%s", homedir, ToolDir, toolName)
-	localChecksumPath := fmt.Sprintf("%s/%s/%s.sha256", homedir, ToolDir, toolName)
+	localPath, err := dbt.verifyTool(homedir, args[0], version)
+	if err != nil {
+		return err
+	}
 
-	dbt.VerboseOutput("Verifying %q", localPath)
+	err = VerifyToolDigest(localPath, args[0], digest)
+	if err != nil {
+		return err
+	}
 
-	checksumBytes, err := ioutil.ReadFile(localChecksumPath)
+	err = dbt.runExec(localPath, args, true)
 	if err != nil {
-		err = errors.Wrap(err, "error reading local checksum file")
+		err = errors.Wrap(err, "failed to run already downloaded tool")
 		return err
 	}
 
-	if _, err := os.Stat(localPath); !os.IsNotExist(err) {
-		checksumOk, err := dbt.VerifyFileChecksum(localPath, string(checksumBytes))
-		if err != nil {
-			err = errors.Wrap(err, "error validating checksum")
-			return err
-		}
+	return err
+}
 
-		if !checksumOk {
-			err = fmt.Errorf("checksum of %s failed to verify", toolName)
+// enforceCachedToolPolicy holds a cached version of a tool to the policy cached for it.  An empty version means the latest cached.  A tool that isn't cached is left to verifyTool to complain about.
+func (dbt *DBT) enforceCachedToolPolicy(homedir string, toolName string, version string) (err error) {
+	if version == "" {
+		version, err = LatestCachedVersion(homedir, toolName)
+		if err != nil || version == "" {
 			return err
 		}
+	}
+
+	policy, err := dbt.CachedToolPolicy(toolName, homedir)
+	if err != nil {
+		return err
+	}
+
+	return enforceToolPolicy(policy, toolName, version)
+}
 
-		signatureOk, err := dbt.VerifyFileSignature(homedir, localPath)
+// verifyTool checks the checksum and signature of a cached version of a tool, returning its path if both are good.  An empty version means the latest cached.
+func (dbt *DBT) verifyTool(homedir string, toolName string, version string) (localPath string, err error) {
+	if version == "" {
+		version, err = LatestCachedVersion(homedir, toolName)
 		if err != nil {
-			err = errors.Wrap(err, "error validating signature")
-			return err
+			return localPath, err
 		}
 
-		if !signatureOk {
-			err = fmt.Errorf("signature of %s failed to verify", toolName)
-			return err
+		if version == "" {
+			err = errors.Wrapf(ErrToolNotFound, "tool %s has not been downloaded", toolName)
+			return localPath, err
 		}
+	}
+
+	localPath = CachedToolPath(homedir, toolName, version)
+
+	if _, statErr := os.Stat(localPath); os.IsNotExist(statErr) {
+		err = errors.Wrapf(ErrVersionNotFound, "version %s of tool %s has not been downloaded", version, toolName)
+		return localPath, err
+	}
+
+	if missing := missingSidecars(localPath); len(missing) > 0 {
+		err = fmt.Errorf("tool cache for %s version %s is incomplete, %s missing.  Re-run online to repair it", toolName, version, strings.Join(missing, " and "))
+		return localPath, err
+	}
+
+	err = dbt.verifyToolFile(homedir, localPath)
+	if err != nil {
+		err = errors.Wrapf(err, "cached %s version %s failed verification, and can't be downloaded again offline.  Re-run online to repair it", toolName, version)
+	}
+
+	return localPath, err
+}
+
+// missingSidecars returns the checksum and signature files that ought to be next to a cached tool, but aren't.
+func missingSidecars(localPath string) (missing []string) {
+	missing = make([]string, 0)
+
+	for _, suffix := range []string{".sha256", ".asc"} {
+		if _, err := os.Stat(localPath + suffix); os.IsNotExist(err) {
+			missing = append(missing, localPath+suffix)
+		}
+	}
+
+	return missing
+}
+
+// fetchCachedChecksum fetches the repo's checksum for a cached tool into the cache, and returns it.  Since it's fetched into the cache, it's only downloaded again if the repo's copy has changed.
+func (dbt *DBT) fetchCachedChecksum(toolUrl string, localPath string) (checksum string, err error) {
+	checksumPath := fmt.Sprintf("%s.sha256", localPath)
+
+	err = dbt.fetchFile(fmt.Sprintf("%s.sha256", toolUrl), checksumPath, false)
+	if err != nil {
+		return checksum, err
+	}
+
+	checksumBytes, err := ioutil.ReadFile(checksumPath)
+	if err != nil {
+		err = errors.Wrapf(err, "failed to read %s", checksumPath)
+		return checksum, err
+	}
+
+	checksum = string(checksumBytes)
+
+	return checksum, err
+}
+
+// verifyToolFile checks a tool binary against the .sha256 and .asc files next to it, and the tools truststore under homedir.
+func (dbt *DBT) verifyToolFile(homedir string, localPath string) (err error) {
+	return dbt.verifyToolFileChecksum(homedir, localPath, "")
+}
+
+// verifyToolFileChecksum checks a tool binary against the given checksum, and the .asc file next to it.  If no checksum is given, the .sha256 file next to it is used instead.
+func (dbt *DBT) verifyToolFileChecksum(homedir string, localPath string, checksum string) (err error) {
+	toolName := filepath.Base(localPath)
+
+	dbt.VerboseOutput("Verifying %q", localPath)
 
-		err = dbt.runExec(homedir, args)
+	if checksum == "" {
+		checksumBytes, err := ioutil.ReadFile(fmt.Sprintf("%s.sha256", localPath))
 		if err != nil {
-			err = errors.Wrap(err, "failed to run already downloaded tool")
+			err = errors.Wrap(err, "error reading local checksum file")
 			return err
 		}
+
+		checksum = string(checksumBytes)
+	}
+
+	if _, err := os.Stat(localPath); os.IsNotExist(err) {
+		err = fmt.Errorf("tool %s has not been downloaded", toolName)
+		return err
+	}
+
+	// The checksum and the signature each read the whole binary, so they're checked at the same time.  Each goroutine only sets its own results.
+	var checksumOk, signatureOk bool
+	var checksumErr, signatureErr error
+	var wg sync.WaitGroup
+
+	wg.Add(2)
+
+	go func() {
+		defer wg.Done()
+		checksumOk, checksumErr = dbt.VerifyFileChecksum(localPath, checksum)
+	}()
+
+	go func() {
+		defer wg.Done()
+		signatureOk, signatureErr = dbt.VerifyToolFileSignature(homedir, localPath)
+	}()
+
+	wg.Wait()
+
+	// If both fail, the checksum's reported, same as when they were checked one after the other.
+	if checksumErr != nil {
+		err = errors.Wrap(checksumErr, "error validating checksum")
+		return err
+	}
+
+	if !checksumOk {
+		err = errors.Wrapf(ErrChecksumMismatch, "checksum of %s failed to verify", toolName)
+		return err
+	}
+
+	if signatureErr != nil {
+		err = errors.Wrap(signatureErr, "error validating signature")
+		return err
+	}
+
+	if !signatureOk {
+		err = errors.Wrapf(ErrSignatureMismatch, "signature of %s failed to verify", toolName)
+		return err
 	}
 
 	return err
@@ -549,45 +1667,128 @@ func (dbt *DBT) verifyAndRun(homedir string, args []string) (err error) {
 
 var testExec bool
 
-func (dbt *DBT) runExec(homedir string, args []string) (err error) {
+// ToolExitError is returned when a tool run as a child process exits non-zero.  Callers should exit with Code, so whoever ran dbt sees the tool's exit code, not dbt's.
+type ToolExitError struct {
+	Tool string
+	Code int
+}
+
+// Error implements the error interface
+func (e *ToolExitError) Error() string {
+	return fmt.Sprintf("%s exited with code %d", e.Tool, e.Code)
+}
+
+// ToolExitCode returns the exit code of the tool behind an error returned by RunTool, and whether there was one.
+func ToolExitCode(err error) (code int, ok bool) {
+	exitErr, ok := errors.Cause(err).(*ToolExitError)
+	if ok {
+		code = exitErr.Code
+	}
+
+	return code, ok
+}
+
+// runExec runs a verified tool.  Offline, nothing's reported to telemetry.
+func (dbt *DBT) runExec(localPath string, args []string, offline bool) (err error) {
 	toolName := args[0]
-	localPath := fmt.Sprintf("%s/%s/%s", homedir, ToolDir, toolName)
 
 	env := os.Environ()
 
+	// not being able to record the run is no reason not to run the tool
+	err = touchCachedTool(localPath)
+	if err != nil {
+		dbt.VerboseOutput("Failed to record the run of %s: %s", toolName, err)
+		err = nil
+	}
+
+	err = dbt.runHook(HOOK_PRE_RUN, dbt.Config.PreRunHook, localPath, args, 0)
+	if err != nil {
+		err = errors.Wrapf(ErrRunVetoed, "not running %s: %s", toolName, err)
+		return err
+	}
+
 	if testExec {
 		cs := []string{"-test.run=TestHelperProcess", "--", localPath}
 		cs = append(cs, args...)
 		cmd := exec.Command(os.Args[0], cs...)
 		bytes, err := cmd.Output()
+
+		fmt.Printf("\nTest Command Output: %q\n", string(bytes))
+
 		if err != nil {
-			err = errors.Wrap(err, "error running exec")
-			return err
+			err = toolExitError(toolName, err)
 		}
 
-		fmt.Printf("\nTest Command Output: %q\n", string(bytes))
+		return dbt.afterRun(localPath, args, err, offline)
+	}
 
-	} else {
-		err = syscall.Exec(localPath, args, env)
+	// exec never comes back, so if there's anything to do afterwards, the tool runs as a child
+	if dbt.Config.PostRunHook != "" || (!offline && dbt.TelemetryEnabled()) {
+		err = spawnTool(localPath, args, env)
 		if err != nil {
-			err = errors.Wrap(err, "error running exec")
-			return err
+			err = toolExitError(toolName, err)
 		}
 
+		return dbt.afterRun(localPath, args, err, offline)
+	}
+
+	err = execTool(localPath, args, env)
+	if err != nil {
+		return toolExitError(toolName, err)
 	}
 
 	return err
 }
 
+// afterRun reports a successful run to telemetry, runs the post-run hook, if there is one, and passes on the result of running the tool.  Neither failing changes how the run went.  Once the hook's done, the report gets at most TELEMETRY_EXIT_WAIT more to finish.
+func (dbt *DBT) afterRun(localPath string, args []string, runErr error, offline bool) (err error) {
+	reported := make(chan struct{})
+
+	// the report and the hook go at the same time, so the most the report adds is whatever the hook doesn't cover
+	go func() {
+		defer close(reported)
+
+		if runErr == nil && !offline && dbt.TelemetryEnabled() {
+			dbt.reportRun(localPath, args)
+		}
+	}()
+
+	hookErr := dbt.runHook(HOOK_POST_RUN, dbt.Config.PostRunHook, localPath, args, runExitCode(runErr))
+	if hookErr != nil {
+		dbt.logEvent(LogFields{Operation: "run", Tool: args[0]}, "%s\n", hookErr)
+	}
+
+	select {
+	case <-reported:
+	case <-time.After(TELEMETRY_EXIT_WAIT):
+		dbt.VerboseOutput("Not waiting on telemetry report")
+	}
+
+	return runErr
+}
+
+// toolExitError turns a child process's exit status into a ToolExitError.  Anything else means the tool couldn't be run at all.
+func toolExitError(toolName string, err error) error {
+	if exitErr, ok := err.(*exec.ExitError); ok {
+		return &ToolExitError{Tool: toolName, Code: exitErr.ExitCode()}
+	}
+
+	return errors.Wrap(err, "error running exec")
+}
+
 // VerboseOutput Convenience function so I don't have to write 'if verbose {...}' all the time.
 func (dbt *DBT) VerboseOutput(message string, args ...interface{}) {
 	if dbt.Verbose {
-		if len(args) == 0 {
-			fmt.Printf("%s\n", message)
+		msg := message
+		if len(args) > 0 {
+			msg = fmt.Sprintf(message, args...)
+		}
+
+		if dbt.StructuredLogger != nil {
+			dbt.StructuredLogger.Debug(strings.TrimSpace(msg))
 			return
 		}
 
-		msg := fmt.Sprintf(message, args...)
 		fmt.Printf("%s\n", msg)
 	}
 }
@@ -16,11 +16,11 @@ package dbt
 
 import (
 	"bytes"
+	"context"
 	"fmt"
-	"github.com/aws/aws-sdk-go/aws"
-	"github.com/aws/aws-sdk-go/aws/credentials"
-	"github.com/aws/aws-sdk-go/aws/session"
-	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/credentials"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
 	"github.com/johannesboyne/gofakes3"
 	"github.com/johannesboyne/gofakes3/backend/s3mem"
 	"github.com/nikogura/gomason/pkg/gomason"
@@ -55,8 +55,7 @@ var trustFile string
 var setup bool
 var oldVersion = "3.0.2"
 var testServer *httptest.Server
-var s3Config *aws.Config
-var s3Session *session.Session
+var s3Client *s3.Client
 var s3Backend *s3mem.Backend
 var faker *gofakes3.GoFakeS3
 var homeDirRepoServer string
@@ -74,6 +73,11 @@ var testFilesA map[string]*testFile
 var testFilesB map[string]*testFile
 
 func TestMain(m *testing.M) {
+	// the test binary standing in for a tool doesn't need the test repo
+	if os.Getenv(HELPER_PROCESS_ENV_VAR) == "1" {
+		os.Exit(m.Run())
+	}
+
 	err := setUp()
 	if err != nil {
 		log.Fatalf("Setup Failed: %s", err)
@@ -95,6 +99,10 @@ func setUp() (err error) {
 
 	NOPROGRESS = true
 
+	// keep the test homedirs self contained regardless of the environment running the tests
+	_ = os.Unsetenv(XDG_CONFIG_HOME_ENV_VAR)
+	_ = os.Unsetenv(XDG_CACHE_HOME_ENV_VAR)
+
 	logrus.SetLevel(logrus.DebugLevel)
 
 	tmpDir = dir
@@ -158,33 +166,21 @@ func setUp() (err error) {
 		faker = gofakes3.New(s3Backend)
 		testServer = httptest.NewServer(faker.Server())
 
-		// S3 config
-		s3Config = &aws.Config{
-			Credentials:      credentials.NewStaticCredentials("foo", "bar", ""),
-			Endpoint:         aws.String(testServer.URL),
-			Region:           aws.String("us-east-1"),
-			DisableSSL:       aws.Bool(true),
-			S3ForcePathStyle: aws.Bool(true),
-		}
-		s3Session, err = session.NewSession(s3Config)
-		if err != nil {
-			log.Fatalf("failed creating fake aws session: %s", err)
-		}
+		s3Client = newFakeS3Client(testServer.URL)
 
 		dbtBucket := "dbt"
 		toolsBucket := "dbt-tools"
 
-		s3Client := s3.New(s3Session)
 		cparams := &s3.CreateBucketInput{Bucket: aws.String(dbtBucket)}
 
-		_, err = s3Client.CreateBucket(cparams)
+		_, err = s3Client.CreateBucket(context.Background(), cparams)
 		if err != nil {
 			err = errors.Wrapf(err, "Failed to create bucket %s", dbtBucket)
 			return err
 		}
 
 		cparams = &s3.CreateBucketInput{Bucket: aws.String(toolsBucket)}
-		_, err = s3Client.CreateBucket(cparams)
+		_, err = s3Client.CreateBucket(context.Background(), cparams)
 		if err != nil {
 			err = errors.Wrapf(err, "Failed to create bucket %s", toolsBucket)
 			return err
@@ -243,7 +239,7 @@ func setUp() (err error) {
 				return err
 			}
 
-			configPath := fmt.Sprintf("%s/%s", c.homedir, ConfigDir)
+			configPath := ConfigDir(c.homedir)
 			fileName := fmt.Sprintf("%s/dbt.json", configPath)
 
 			err := os.WriteFile(fileName, []byte(c.config), 0644)
@@ -598,10 +594,8 @@ Expire-Date: 0
 		return err
 	}
 
-	s3Client := s3.New(s3Session)
-
 	// upload the file to the fake s3 endpoint
-	_, err = s3Client.PutObject(&s3.PutObjectInput{
+	_, err = s3Client.PutObject(context.Background(), &s3.PutObjectInput{
 		Bucket: aws.String("dbt"),
 		Key:    aws.String("truststore"),
 		Body:   bytes.NewReader(out),
@@ -675,8 +669,6 @@ func buildSource(meta gomason.Metadata, version string, sourceDir string, testfi
 
 	fmt.Printf("--- Moving %d Test Files into repository ---\n", len(testFilesB))
 
-	s3Client := s3.New(s3Session)
-
 	// Write the files into place
 	for _, f := range testfiles {
 		fmt.Printf("Processing %s\n", f.Name)
@@ -700,7 +692,7 @@ func buildSource(meta gomason.Metadata, version string, sourceDir string, testfi
 		key := strings.TrimPrefix(f.UrlPath, fmt.Sprintf("/%s/", f.Repo))
 
 		// upload the file to the fake s3 endpoint
-		_, err = s3Client.PutObject(&s3.PutObjectInput{
+		_, err = s3Client.PutObject(context.Background(), &s3.PutObjectInput{
 			Bucket: aws.String(f.Repo),
 			Key:    aws.String(key),
 			Body:   bytes.NewReader(input),
@@ -716,9 +708,7 @@ func buildSource(meta gomason.Metadata, version string, sourceDir string, testfi
 			Key:    aws.String(key),
 		}
 
-		headSvc := s3.New(s3Session)
-
-		_, err = headSvc.HeadObject(headOptions)
+		_, err = s3Client.HeadObject(context.Background(), headOptions)
 		if err != nil {
 			err = errors.Wrapf(err, "failed to get metadata for %s", f.Name)
 			return err
@@ -741,11 +731,11 @@ func buildSource(meta gomason.Metadata, version string, sourceDir string, testfi
 					Key:    aws.String(path),
 				}
 
-				_, err = headSvc.HeadObject(headOptions)
+				_, err = s3Client.HeadObject(context.Background(), headOptions)
 				// if there's an error, it doesn't exist
 				if err != nil {
 					// so create it
-					_, err = s3Client.PutObject(&s3.PutObjectInput{
+					_, err = s3Client.PutObject(context.Background(), &s3.PutObjectInput{
 						Bucket: aws.String(f.Repo),
 						Key:    aws.String(path),
 					})
@@ -788,7 +778,7 @@ func buildSource(meta gomason.Metadata, version string, sourceDir string, testfi
 		}
 
 		// upload the checksum to the fake s3 endpoint
-		_, err = s3Client.PutObject(&s3.PutObjectInput{
+		_, err = s3Client.PutObject(context.Background(), &s3.PutObjectInput{
 			Bucket: aws.String(f.Repo),
 			Key:    aws.String(fmt.Sprintf("%s.sha256", key)),
 			Body:   bytes.NewReader([]byte(checksum)),
@@ -804,7 +794,7 @@ func buildSource(meta gomason.Metadata, version string, sourceDir string, testfi
 			Key:    aws.String(key),
 		}
 
-		_, err = headSvc.HeadObject(headOptions)
+		_, err = s3Client.HeadObject(context.Background(), headOptions)
 		if err != nil {
 			err = errors.Wrapf(err, "failed to get metadata for %s", f.Name)
 			return err
@@ -814,6 +804,16 @@ func buildSource(meta gomason.Metadata, version string, sourceDir string, testfi
 	return err
 }
 
+// newFakeS3Client creates an S3 client that talks to the fake S3 at the given url.
+func newFakeS3Client(endpoint string) (client *s3.Client) {
+	return s3.New(s3.Options{
+		Credentials:      aws.NewCredentialsCache(credentials.NewStaticCredentialsProvider("foo", "bar", "")),
+		EndpointResolver: s3.EndpointResolverFromURL(endpoint),
+		Region:           "us-east-1",
+		UsePathStyle:     true,
+	})
+}
+
 func buildTestRepo() (err error) {
 	_ = os.Setenv("GOMASON_NO_USER_CONFIG", "true")
 
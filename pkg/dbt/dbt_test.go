@@ -15,27 +15,32 @@
 package dbt
 
 import (
+	"context"
+	"encoding/json"
 	"fmt"
-	"github.com/aws/aws-sdk-go/aws"
-	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/pkg/errors"
 	"github.com/stretchr/testify/assert"
+	"gopkg.in/yaml.v3"
 	"io/ioutil"
 	"log"
 	"net/http"
 	"os"
+	"path/filepath"
 	"runtime"
+	"strconv"
 	"strings"
 	"testing"
 )
 
 func TestS3List(t *testing.T) {
-	svc := s3.New(s3Session)
 	input := &s3.ListObjectsInput{
 		Bucket:  aws.String("dbt-tools"),
-		MaxKeys: aws.Int64(100),
+		MaxKeys: 100,
 	}
 
-	_, err := svc.ListObjects(input)
+	_, err := s3Client.ListObjects(context.Background(), input)
 	if err != nil {
 		t.Errorf("Error listing s3 objects")
 	}
@@ -61,9 +66,7 @@ func TestRepoGet(t *testing.T) {
 				Key:    aws.String(key),
 			}
 
-			headSvc := s3.New(s3Session)
-
-			_, err = headSvc.HeadObject(headOptions)
+			_, err = s3Client.HeadObject(context.Background(), headOptions)
 			if err != nil {
 				t.Errorf("failed to get metadata for %s: %s", f.Name, err)
 			}
@@ -94,18 +97,18 @@ func TestGenerateDbtDir(t *testing.T) {
 				t.Errorf("dbt dir %s did not create as expected", dbtDirPath)
 			}
 
-			trustPath := fmt.Sprintf("%s/%s", tc.path, TrustDir)
+			trustPath := TrustDir(tc.path)
 
 			if _, err := os.Stat(trustPath); os.IsNotExist(err) {
 				t.Errorf("trust dir %s did not create as expected", trustPath)
 			}
 
-			toolPath := fmt.Sprintf("%s/%s", tc.path, ToolDir)
+			toolPath := ToolDir(tc.path)
 			if _, err := os.Stat(toolPath); os.IsNotExist(err) {
 				t.Errorf("tool dir %s did not create as expected", toolPath)
 			}
 
-			configPath := fmt.Sprintf("%s/%s", tc.path, ConfigDir)
+			configPath := ConfigDir(tc.path)
 			if _, err := os.Stat(configPath); os.IsNotExist(err) {
 				t.Errorf("config dir %s did not create as expected", configPath)
 			}
@@ -114,6 +117,80 @@ func TestGenerateDbtDir(t *testing.T) {
 	}
 }
 
+func TestGenerateDbtDirTwice(t *testing.T) {
+	homedir := fmt.Sprintf("%s/homeDirTwice", tmpDir)
+
+	err := os.MkdirAll(homedir, 0755)
+	if err != nil {
+		t.Fatalf("Error creating homedir %s: %s", homedir, err)
+	}
+
+	err = GenerateDbtDir(homedir, true)
+	if err != nil {
+		t.Errorf("Error generating dbt dir: %s", err)
+	}
+
+	err = GenerateDbtDir(homedir, true)
+	assert.Nil(t, err, "Generating dbt dir a second time should not error.")
+}
+
+func TestXdgDirs(t *testing.T) {
+	homedir := fmt.Sprintf("%s/homeDirXdg", tmpDir)
+	configHome := fmt.Sprintf("%s/xdgConfig", tmpDir)
+	cacheHome := fmt.Sprintf("%s/xdgCache", tmpDir)
+
+	err := os.MkdirAll(homedir, 0755)
+	if err != nil {
+		t.Fatalf("Error creating homedir %s: %s", homedir, err)
+	}
+
+	_ = os.Setenv(XDG_CONFIG_HOME_ENV_VAR, configHome)
+	defer os.Unsetenv(XDG_CONFIG_HOME_ENV_VAR)
+	_ = os.Setenv(XDG_CACHE_HOME_ENV_VAR, cacheHome)
+	defer os.Unsetenv(XDG_CACHE_HOME_ENV_VAR)
+
+	assert.Equal(t, fmt.Sprintf("%s/dbt/conf/dbt.json", configHome), ConfigFilePath(homedir), "Config file under XDG_CONFIG_HOME")
+	assert.Equal(t, fmt.Sprintf("%s/dbt/trust/truststore", configHome), TruststorePath(homedir), "Truststore under XDG_CONFIG_HOME")
+	assert.Equal(t, fmt.Sprintf("%s/dbt/tools", cacheHome), ToolDir(homedir), "Tools under XDG_CACHE_HOME")
+
+	err = GenerateDbtDir(homedir, true)
+	if err != nil {
+		t.Errorf("Error generating dbt dir: %s", err)
+	}
+
+	for _, p := range []string{ConfigDir(homedir), TrustDir(homedir), ToolDir(homedir)} {
+		if _, err := os.Stat(p); os.IsNotExist(err) {
+			t.Errorf("dir %s did not create as expected", p)
+		}
+	}
+
+	// an existing ~/.dbt takes precedence over XDG
+	err = os.MkdirAll(fmt.Sprintf("%s/%s", homedir, DbtDir), 0755)
+	if err != nil {
+		t.Fatalf("Error creating legacy dbt dir: %s", err)
+	}
+
+	assert.Equal(t, fmt.Sprintf("%s/%s/conf/dbt.json", homedir, DbtDir), ConfigFilePath(homedir), "Existing ~/.dbt wins over XDG")
+	assert.Equal(t, fmt.Sprintf("%s/%s/tools", homedir, DbtDir), ToolDir(homedir), "Existing ~/.dbt wins over XDG")
+}
+
+func TestToolFileName(t *testing.T) {
+	inputs := []struct {
+		goos   string
+		output string
+	}{
+		{"linux", "foo"},
+		{"darwin", "foo"},
+		{"windows", "foo.exe"},
+	}
+
+	for _, tc := range inputs {
+		t.Run(tc.goos, func(t *testing.T) {
+			assert.Equal(t, tc.output, ToolFileName("foo", tc.goos), "Tool file name meets expectations.")
+		})
+	}
+}
+
 func TestLoadDbtConfig(t *testing.T) {
 	var inputs = []struct {
 		name     string
@@ -149,6 +226,421 @@ func TestLoadDbtConfig(t *testing.T) {
 	}
 }
 
+func TestLoadDbtConfigFormats(t *testing.T) {
+	expected := Config{
+		Dbt: DbtConfig{
+			Repo:       "http://127.0.0.1:8080/dbt",
+			TrustStore: "http://127.0.0.1:8080/dbt/truststore",
+		},
+		Tools: ToolsConfig{
+			Repo: "http://127.0.0.1:8080/dbt-tools",
+		},
+		UsernameFunc: "echo $USERNAME",
+	}
+
+	jsonBytes, err := json.Marshal(expected)
+	if err != nil {
+		t.Fatalf("Error marshalling json: %s", err)
+	}
+
+	yamlBytes, err := yaml.Marshal(expected)
+	if err != nil {
+		t.Fatalf("Error marshalling yaml: %s", err)
+	}
+
+	inputs := []struct {
+		name     string
+		fileName string
+		contents []byte
+	}{
+		{
+			"json",
+			"dbt.json",
+			jsonBytes,
+		},
+		{
+			"yaml",
+			"dbt.yaml",
+			yamlBytes,
+		},
+		{
+			"yml",
+			"dbt.yml",
+			yamlBytes,
+		},
+	}
+
+	for _, tc := range inputs {
+		t.Run(tc.name, func(t *testing.T) {
+			homedir := fmt.Sprintf("%s/homeDirFormat-%s", tmpDir, tc.name)
+
+			err := GenerateDbtDir(homedir, false)
+			if err != nil {
+				t.Fatalf("Error generating dbt dir: %s", err)
+			}
+
+			fileName := fmt.Sprintf("%s/%s", ConfigDir(homedir), tc.fileName)
+
+			err = os.WriteFile(fileName, tc.contents, 0644)
+			if err != nil {
+				t.Fatalf("Error writing config file to %s: %s", fileName, err)
+			}
+
+			assert.Equal(t, fileName, ConfigFilePath(homedir), "Config file detected.")
+
+			actual, err := LoadDbtConfig(homedir, true)
+			if err != nil {
+				t.Errorf("Error loading config file: %s", err)
+			}
+
+			assert.Equal(t, expected, actual, "Parsed config meets expectations")
+		})
+	}
+
+	// json wins if both exist
+	homedir := fmt.Sprintf("%s/homeDirFormat-yaml", tmpDir)
+	jsonFile := fmt.Sprintf("%s/dbt.json", ConfigDir(homedir))
+
+	err = os.WriteFile(jsonFile, []byte(testDbtConfigContents(port)), 0644)
+	if err != nil {
+		t.Fatalf("Error writing config file to %s: %s", jsonFile, err)
+	}
+
+	assert.Equal(t, jsonFile, ConfigFilePath(homedir), "Json config takes precedence over yaml.")
+}
+
+func TestLoadDbtConfigEnv(t *testing.T) {
+	envVars := map[string]string{
+		DBT_REPO_ENV_VAR:           "http://env.example.com/dbt",
+		DBT_TRUSTSTORE_ENV_VAR:     "http://env.example.com/dbt/truststore",
+		DBT_TOOLS_REPO_ENV_VAR:     "http://env.example.com/dbt-tools",
+		DBT_USERNAME_ENV_VAR:       "envuser",
+		DBT_PASSWORD_ENV_VAR:       "envpass",
+		DBT_TOKEN_ENV_VAR:          "envtoken",
+		DBT_PINNED_VERSION_ENV_VAR: "3.0.0",
+	}
+
+	for k, v := range envVars {
+		_ = os.Setenv(k, v)
+		defer os.Unsetenv(k)
+	}
+
+	expected := Config{
+		Dbt: DbtConfig{
+			Repo:          "http://env.example.com/dbt",
+			TrustStore:    "http://env.example.com/dbt/truststore",
+			PinnedVersion: "3.0.0",
+		},
+		Tools: ToolsConfig{
+			Repo: "http://env.example.com/dbt-tools",
+		},
+		Username: "envuser",
+		Password: "envpass",
+		Token:    "envtoken",
+	}
+
+	// no config file at all
+	homedir := fmt.Sprintf("%s/homeDirEnvOnly", tmpDir)
+
+	actual, err := LoadDbtConfig(homedir, true)
+	if err != nil {
+		t.Errorf("Error loading config from env: %s", err)
+	}
+
+	assert.Equal(t, expected, actual, "Config from env meets expectations.")
+
+	// env wins over the file
+	homedir = fmt.Sprintf("%s/homeDirEnvOverride", tmpDir)
+
+	err = GenerateDbtDir(homedir, false)
+	if err != nil {
+		t.Fatalf("Error generating dbt dir: %s", err)
+	}
+
+	fileName := ConfigFilePath(homedir)
+	err = os.WriteFile(fileName, []byte(testDbtConfigContents(port)), 0644)
+	if err != nil {
+		t.Fatalf("Error writing config file to %s: %s", fileName, err)
+	}
+
+	actual, err = LoadDbtConfig(homedir, true)
+	if err != nil {
+		t.Errorf("Error loading config file: %s", err)
+	}
+
+	assert.Equal(t, expected, actual, "Env overrides file config.")
+
+	// without the required vars, a missing file is still an error
+	_ = os.Unsetenv(DBT_REPO_ENV_VAR)
+
+	_, err = LoadDbtConfig(fmt.Sprintf("%s/homeDirEnvOnly", tmpDir), true)
+	assert.NotNil(t, err, "Missing config file and incomplete env is an error.")
+}
+
+func TestLoadDbtConfigMultiServer(t *testing.T) {
+	contents := `{
+  "servers": {
+    "prod": {
+      "dbt": {
+        "repository": "http://prod.example.com/dbt",
+        "truststore": "http://prod.example.com/dbt/truststore"
+      },
+      "tools": {
+        "repository": "http://prod.example.com/dbt-tools"
+      }
+    },
+    "dev": {
+      "dbt": {
+        "repository": "http://dev.example.com/dbt",
+        "truststore": "http://dev.example.com/dbt/truststore"
+      },
+      "tools": {
+        "repository": "http://dev.example.com/dbt-tools"
+      },
+      "username": "devuser"
+    }
+  },
+  "defaultserver": "prod"
+}`
+
+	homedir := fmt.Sprintf("%s/homeDirMultiServer", tmpDir)
+
+	err := GenerateDbtDir(homedir, false)
+	if err != nil {
+		t.Fatalf("Error generating dbt dir: %s", err)
+	}
+
+	fileName := ConfigFilePath(homedir)
+	err = os.WriteFile(fileName, []byte(contents), 0644)
+	if err != nil {
+		t.Fatalf("Error writing config file to %s: %s", fileName, err)
+	}
+
+	inputs := []struct {
+		name     string
+		server   string
+		env      string
+		repo     string
+		username string
+		err      bool
+	}{
+		{
+			"default",
+			"",
+			"",
+			"http://prod.example.com/dbt",
+			"",
+			false,
+		},
+		{
+			"explicit",
+			"dev",
+			"",
+			"http://dev.example.com/dbt",
+			"devuser",
+			false,
+		},
+		{
+			"env",
+			"",
+			"dev",
+			"http://dev.example.com/dbt",
+			"devuser",
+			false,
+		},
+		{
+			"explicit beats env",
+			"prod",
+			"dev",
+			"http://prod.example.com/dbt",
+			"",
+			false,
+		},
+		{
+			"unknown",
+			"staging",
+			"",
+			"",
+			"",
+			true,
+		},
+	}
+
+	for _, tc := range inputs {
+		t.Run(tc.name, func(t *testing.T) {
+			if tc.env != "" {
+				_ = os.Setenv(DBT_SERVER_ENV_VAR, tc.env)
+				defer os.Unsetenv(DBT_SERVER_ENV_VAR)
+			}
+
+			config, err := LoadDbtConfigForServer(homedir, tc.server, true)
+			if tc.err {
+				assert.NotNil(t, err, "Unknown server is an error.")
+				return
+			}
+
+			if err != nil {
+				t.Errorf("Error loading config file: %s", err)
+			}
+
+			assert.Equal(t, tc.repo, config.Dbt.Repo, "Selected server repo meets expectations.")
+			assert.Equal(t, tc.username, config.Username, "Selected server username meets expectations.")
+			assert.Equal(t, []string{"dev", "prod"}, ServerNames(config), "Server names meet expectations.")
+		})
+	}
+}
+
+func TestValidateConfig(t *testing.T) {
+	inputs := []struct {
+		name   string
+		config Config
+		fields []string
+	}{
+		{
+			"reposerver",
+			Config{
+				Dbt:   DbtConfig{Repo: "http://127.0.0.1:8080/dbt", TrustStore: "http://127.0.0.1:8080/dbt/truststore"},
+				Tools: ToolsConfig{Repo: "http://127.0.0.1:8080/dbt-tools"},
+			},
+			[]string{},
+		},
+		{
+			"s3",
+			Config{
+				Dbt:   DbtConfig{Repo: "https://dbt.s3.us-east-1.amazonaws.com", TrustStore: "https://dbt.s3.us-east-1.amazonaws.com/truststore"},
+				Tools: ToolsConfig{Repo: "https://dbt-tools.s3.us-east-1.amazonaws.com"},
+			},
+			[]string{},
+		},
+		{
+			"missing truststore",
+			Config{
+				Dbt:   DbtConfig{Repo: "http://127.0.0.1:8080/dbt"},
+				Tools: ToolsConfig{Repo: "http://127.0.0.1:8080/dbt-tools"},
+			},
+			[]string{"dbt.truststore"},
+		},
+		{
+			"trailing slash",
+			Config{
+				Dbt:   DbtConfig{Repo: "http://127.0.0.1:8080/dbt/", TrustStore: "http://127.0.0.1:8080/dbt/truststore"},
+				Tools: ToolsConfig{Repo: "http://127.0.0.1:8080/dbt-tools"},
+			},
+			[]string{"dbt.repository"},
+		},
+		{
+			"truststore host mismatch",
+			Config{
+				Dbt:   DbtConfig{Repo: "http://127.0.0.1:8080/dbt", TrustStore: "http://example.com/dbt/truststore"},
+				Tools: ToolsConfig{Repo: "http://127.0.0.1:8080/dbt-tools"},
+			},
+			[]string{"dbt.truststore"},
+		},
+		{
+			"path style s3",
+			Config{
+				Dbt:   DbtConfig{Repo: "https://dbt.s3.us-east-1.amazonaws.com", TrustStore: "https://dbt.s3.us-east-1.amazonaws.com/truststore"},
+				Tools: ToolsConfig{Repo: "https://s3.amazonaws.com/dbt-tools"},
+			},
+			[]string{"tools.repository"},
+		},
+		{
+			"bad proxy",
+			Config{
+				Dbt:   DbtConfig{Repo: "http://127.0.0.1:8080/dbt", TrustStore: "http://127.0.0.1:8080/dbt/truststore", ProxyUrl: "proxy.example.com"},
+				Tools: ToolsConfig{Repo: "http://127.0.0.1:8080/dbt-tools"},
+			},
+			[]string{"dbt.proxy"},
+		},
+		{
+			"bad pinned version",
+			Config{
+				Dbt:   DbtConfig{Repo: "http://127.0.0.1:8080/dbt", TrustStore: "http://127.0.0.1:8080/dbt/truststore", PinnedVersion: "v3.6"},
+				Tools: ToolsConfig{Repo: "http://127.0.0.1:8080/dbt-tools"},
+			},
+			[]string{"dbt.pinnedversion"},
+		},
+		{
+			"bad tool file mode",
+			Config{
+				Dbt:          DbtConfig{Repo: "http://127.0.0.1:8080/dbt", TrustStore: "http://127.0.0.1:8080/dbt/truststore"},
+				Tools:        ToolsConfig{Repo: "http://127.0.0.1:8080/dbt-tools"},
+				ToolFileMode: "0644",
+			},
+			[]string{"toolfilemode"},
+		},
+		{
+			"bad func timeout",
+			Config{
+				Dbt:         DbtConfig{Repo: "http://127.0.0.1:8080/dbt", TrustStore: "http://127.0.0.1:8080/dbt/truststore"},
+				Tools:       ToolsConfig{Repo: "http://127.0.0.1:8080/dbt-tools"},
+				FuncTimeout: "10",
+			},
+			[]string{"functimeout"},
+		},
+		{
+			"credentials keyed by url",
+			Config{
+				Dbt:         DbtConfig{Repo: "http://127.0.0.1:8080/dbt", TrustStore: "http://127.0.0.1:8080/dbt/truststore"},
+				Tools:       ToolsConfig{Repo: "http://127.0.0.1:8080/dbt-tools"},
+				Credentials: map[string]CredentialSet{"https://repo.example.com/dbt": {Token: "foo"}},
+			},
+			[]string{"credentials"},
+		},
+		{
+			"not a url",
+			Config{
+				Dbt:   DbtConfig{Repo: "127.0.0.1/dbt", TrustStore: "http://127.0.0.1:8080/dbt/truststore"},
+				Tools: ToolsConfig{Repo: "http://127.0.0.1:8080/dbt-tools"},
+			},
+			[]string{"dbt.repository"},
+		},
+	}
+
+	for _, tc := range inputs {
+		t.Run(tc.name, func(t *testing.T) {
+			problems := ValidateConfig(tc.config)
+
+			fields := make([]string, 0)
+			for _, p := range problems {
+				fields = append(fields, p.Field)
+			}
+
+			assert.Equal(t, tc.fields, fields, "Problems found meet expectations: %v", problems)
+		})
+	}
+}
+
+func TestValidateTrustStore(t *testing.T) {
+	inputs := []struct {
+		name string
+		obj  *DBT
+	}{
+		{
+			"reposerver",
+			&DBT{
+				Config:  dbtConfig,
+				Verbose: true,
+			},
+		},
+		{
+			"s3",
+			&DBT{
+				Config:   s3DbtConfig,
+				Verbose:  true,
+				S3Client: s3Client,
+			},
+		},
+	}
+
+	for _, tc := range inputs {
+		t.Run(tc.name, func(t *testing.T) {
+			problems := tc.obj.ValidateTrustStore()
+			assert.Empty(t, problems, "Truststore validates.")
+		})
+	}
+}
+
 func TestFetchTrustStore(t *testing.T) {
 	inputs := []struct {
 		name    string
@@ -167,9 +659,9 @@ func TestFetchTrustStore(t *testing.T) {
 		{
 			"s3",
 			&DBT{
-				Config:    s3DbtConfig,
-				Verbose:   true,
-				S3Session: s3Session,
+				Config:   s3DbtConfig,
+				Verbose:  true,
+				S3Client: s3Client,
 			},
 			homeDirS3,
 		},
@@ -183,7 +675,7 @@ func TestFetchTrustStore(t *testing.T) {
 			}
 
 			expected := trustfileContents
-			trustPath := fmt.Sprintf("%s/%s", tc.homedir, TruststorePath)
+			trustPath := TruststorePath(tc.homedir)
 
 			if _, err := os.Stat(trustPath); os.IsNotExist(err) {
 				t.Errorf("File not written")
@@ -224,9 +716,9 @@ func TestDbtIsCurrent(t *testing.T) {
 		{
 			"s3",
 			&DBT{
-				Config:    s3DbtConfig,
-				Verbose:   true,
-				S3Session: s3Session,
+				Config:   s3DbtConfig,
+				Verbose:  true,
+				S3Client: s3Client,
 			},
 			homeDirS3,
 			fmt.Sprintf("https://dbt.s3.us-east-1.amazonaws.com/%s/%s/amd64/dbt", oldVersion, runtime.GOOS),
@@ -236,7 +728,7 @@ func TestDbtIsCurrent(t *testing.T) {
 
 	for _, tc := range inputs {
 		t.Run(tc.name, func(t *testing.T) {
-			targetDir := fmt.Sprintf("%s/%s", tc.homedir, ToolDir)
+			targetDir := ToolDir(tc.homedir)
 			fileUrl := tc.oldUrl
 			fileName := fmt.Sprintf("%s/dbt", targetDir)
 
@@ -292,9 +784,9 @@ func TestDbtUpgradeInPlace(t *testing.T) {
 		{
 			"s3",
 			&DBT{
-				Config:    s3DbtConfig,
-				Verbose:   true,
-				S3Session: s3Session,
+				Config:   s3DbtConfig,
+				Verbose:  true,
+				S3Client: s3Client,
 			},
 			homeDirS3,
 			fmt.Sprintf("https://dbt.s3.us-east-1.amazonaws.com/%s/%s/amd64/dbt", oldVersion, runtime.GOOS),
@@ -304,7 +796,7 @@ func TestDbtUpgradeInPlace(t *testing.T) {
 
 	for _, tc := range inputs {
 		t.Run(tc.name, func(t *testing.T) {
-			targetDir := fmt.Sprintf("%s/%s", tc.homedir, ToolDir)
+			targetDir := ToolDir(tc.homedir)
 			fileUrl := tc.oldUrl
 			fileName := fmt.Sprintf("%s/dbt", targetDir)
 
@@ -335,53 +827,935 @@ func TestDbtUpgradeInPlace(t *testing.T) {
 	}
 }
 
-func TestNewDbt(t *testing.T) {
-	var inputs = []struct {
+func TestDbtUpgradeInPlacePatch(t *testing.T) {
+	inputs := []struct {
 		name    string
-		homedir string
+		current string
+		patch   bool
+		full    bool
 	}{
 		{
-			"reposerver",
-			homeDirRepoServer,
+			"patch only",
+			TEST_PATCH_OLD,
+			true,
+			false,
 		},
 		{
-			"s3",
-			homeDirS3,
+			"no patch",
+			TEST_PATCH_OLD,
+			false,
+			true,
+		},
+		{
+			"patch doesn't fit",
+			"#!/bin/sh\necho dbt something else entirely\n",
+			true,
+			true,
 		},
 	}
 
+	patch, err := ioutil.ReadFile("testfixtures/dbt.bsdiff")
+	if err != nil {
+		t.Fatalf("Failed reading patch fixture: %s", err)
+	}
+
 	for _, tc := range inputs {
 		t.Run(tc.name, func(t *testing.T) {
-			configPath := fmt.Sprintf("%s/%s", tc.homedir, ConfigDir)
-			fileName := fmt.Sprintf("%s/dbt.json", configPath)
+			repoRoot, config, signer := newTestSignedRepo(t, "foo")
 
-			if _, err := os.Stat(fileName); os.IsNotExist(err) {
-				fmt.Printf("Writing test dbt config to %s", fileName)
-				err = GenerateDbtDir(tc.homedir, true)
-				if err != nil {
-					t.Errorf("Error generating dbt dir: %s", err)
-				}
+			newBinary := fmt.Sprintf("%s/dbt/3.7.0/%s/%s/dbt", repoRoot, runtime.GOOS, runtime.GOARCH)
+			writeTestSignedTool(t, signer, newBinary, TEST_PATCH_NEW)
 
-				err = ioutil.WriteFile(fileName, []byte(testDbtConfigContents(port)), 0644)
-				if err != nil {
-					t.Errorf("Error writing config file to %s: %s", fileName, err)
-				}
+			if tc.patch {
+				_ = ioutil.WriteFile(fmt.Sprintf("%s%s.%s", newBinary, BSDIFF_SUFFIX, VERSION), patch, 0644)
 			}
 
-			_, err := NewDbt(tc.homedir)
+			if !tc.full {
+				_ = os.Remove(newBinary)
+			}
+
+			binDir, err := ioutil.TempDir("", "dbt-upgrade")
 			if err != nil {
-				t.Errorf("Error creating DBT object: %s", err)
+				t.Fatalf("Failed creating temp dir: %s", err)
 			}
-		})
-	}
-}
 
-func TestGetHomeDir(t *testing.T) {
-	_, err := GetHomeDir()
-	if err != nil {
-		t.Errorf("Error getting homedir: %s", err)
-	}
-}
+			defer os.RemoveAll(binDir)
+
+			binaryPath := fmt.Sprintf("%s/dbt", binDir)
+			_ = ioutil.WriteFile(binaryPath, []byte(tc.current), 0755)
+
+			dbtObj := &DBT{
+				Config:  config,
+				Verbose: true,
+			}
+
+			err = dbtObj.UpgradeInPlace(binaryPath)
+			if err != nil {
+				t.Fatalf("Error upgrading in place: %s", err)
+			}
+
+			upgraded, _ := ioutil.ReadFile(binaryPath)
+
+			assert.Equal(t, TEST_PATCH_NEW, string(upgraded), "Binary is upgraded.")
+		})
+	}
+}
+
+func TestDbtPinnedVersion(t *testing.T) {
+	inputs := []struct {
+		name   string
+		pinned string
+		output string
+	}{
+		{
+			"latest",
+			"",
+			"#!/bin/sh\necho dbt 3.7.0\n",
+		},
+		{
+			"downgrade",
+			"3.5.0",
+			"#!/bin/sh\necho dbt 3.5.0\n",
+		},
+	}
+
+	for _, tc := range inputs {
+		t.Run(tc.name, func(t *testing.T) {
+			repoRoot, config, signer := newTestSignedRepo(t, "foo")
+
+			for _, version := range []string{"3.5.0", "3.7.0"} {
+				writeTestSignedTool(t, signer, fmt.Sprintf("%s/dbt/%s/%s/%s/dbt", repoRoot, version, runtime.GOOS, runtime.GOARCH), fmt.Sprintf("#!/bin/sh\necho dbt %s\n", version))
+			}
+
+			config.Dbt.PinnedVersion = tc.pinned
+
+			binDir, err := ioutil.TempDir("", "dbt-pinned")
+			if err != nil {
+				t.Fatalf("Failed creating temp dir: %s", err)
+			}
+
+			defer os.RemoveAll(binDir)
+
+			binaryPath := fmt.Sprintf("%s/dbt", binDir)
+			_ = ioutil.WriteFile(binaryPath, []byte("#!/bin/sh\necho dbt 3.6.1\n"), 0755)
+
+			dbtObj := &DBT{
+				Config:  config,
+				Verbose: true,
+			}
+
+			ok, err := dbtObj.IsCurrent(binaryPath)
+			if err != nil {
+				t.Fatalf("Error checking to see if binary is current: %s", err)
+			}
+
+			assert.False(t, ok, "Running version isn't current.")
+
+			err = dbtObj.UpgradeInPlace(binaryPath)
+			if err != nil {
+				t.Fatalf("Error upgrading in place: %s", err)
+			}
+
+			upgraded, _ := ioutil.ReadFile(binaryPath)
+
+			assert.Equal(t, tc.output, string(upgraded), "Binary converges to the target version.")
+
+			ok, err = dbtObj.IsCurrent(binaryPath)
+			if err != nil {
+				t.Fatalf("Error checking to see if binary is current: %s", err)
+			}
+
+			assert.True(t, ok, "Target version shows current.")
+		})
+	}
+}
+
+func TestTargetPlatform(t *testing.T) {
+	inputs := []struct {
+		name     string
+		goos     string
+		goarch   string
+		envOs    string
+		envArch  string
+		wantOs   string
+		wantArch string
+	}{
+		{"host", "", "", "", "", runtime.GOOS, runtime.GOARCH},
+		{"env", "", "", "windows", "arm64", "windows", "arm64"},
+		{"args win", "darwin", "amd64", "windows", "arm64", "darwin", "amd64"},
+		{"mixed", "", "386", "freebsd", "", "freebsd", "386"},
+	}
+
+	for _, tc := range inputs {
+		t.Run(tc.name, func(t *testing.T) {
+			_ = os.Setenv(DBT_TARGET_OS_ENV_VAR, tc.envOs)
+			_ = os.Setenv(DBT_TARGET_ARCH_ENV_VAR, tc.envArch)
+			defer os.Unsetenv(DBT_TARGET_OS_ENV_VAR)
+			defer os.Unsetenv(DBT_TARGET_ARCH_ENV_VAR)
+
+			goos, goarch := TargetPlatform(tc.goos, tc.goarch)
+
+			assert.Equal(t, tc.wantOs, goos, "Target OS meets expectations.")
+			assert.Equal(t, tc.wantArch, goarch, "Target arch meets expectations.")
+		})
+	}
+}
+
+func TestFetchToolForPlatform(t *testing.T) {
+	inputs := []struct {
+		name    string
+		obj     *DBT
+		homedir string
+		goos    string
+		err     bool
+	}{
+		{
+			"linux",
+			&DBT{
+				Config:  dbtConfig,
+				Verbose: true,
+			},
+			homeDirRepoServer,
+			"linux",
+			false,
+		},
+		{
+			"not published",
+			&DBT{
+				Config:  dbtConfig,
+				Verbose: true,
+			},
+			homeDirRepoServer,
+			"plan9",
+			true,
+		},
+	}
+
+	for _, tc := range inputs {
+		t.Run(tc.name, func(t *testing.T) {
+			err := tc.obj.FetchTrustStore(tc.homedir)
+			if err != nil {
+				t.Fatalf("Error fetching truststore: %s", err)
+			}
+
+			destPath := fmt.Sprintf("%s/staged/%s/catalog", tmpDir, tc.goos)
+
+			err = tc.obj.FetchToolForPlatform("catalog", "", tc.goos, "amd64", destPath, tc.homedir)
+			if tc.err {
+				assert.NotNil(t, err, "Unpublished platform fails to fetch.")
+				return
+			}
+
+			if err != nil {
+				t.Errorf("Error fetching tool for platform: %s", err)
+			}
+
+			for _, suffix := range []string{"", ".sha256", ".asc"} {
+				_, err = os.Stat(fmt.Sprintf("%s%s", destPath, suffix))
+				assert.Nil(t, err, "Staged file exists.")
+			}
+		})
+	}
+}
+
+func TestNewDbt(t *testing.T) {
+	var inputs = []struct {
+		name    string
+		homedir string
+	}{
+		{
+			"reposerver",
+			homeDirRepoServer,
+		},
+		{
+			"s3",
+			homeDirS3,
+		},
+	}
+
+	for _, tc := range inputs {
+		t.Run(tc.name, func(t *testing.T) {
+			configPath := ConfigDir(tc.homedir)
+			fileName := fmt.Sprintf("%s/dbt.json", configPath)
+
+			if _, err := os.Stat(fileName); os.IsNotExist(err) {
+				fmt.Printf("Writing test dbt config to %s", fileName)
+				err = GenerateDbtDir(tc.homedir, true)
+				if err != nil {
+					t.Errorf("Error generating dbt dir: %s", err)
+				}
+
+				err = ioutil.WriteFile(fileName, []byte(testDbtConfigContents(port)), 0644)
+				if err != nil {
+					t.Errorf("Error writing config file to %s: %s", fileName, err)
+				}
+			}
+
+			_, err := NewDbt(tc.homedir)
+			if err != nil {
+				t.Errorf("Error creating DBT object: %s", err)
+			}
+		})
+	}
+}
+
+func TestGetHomeDir(t *testing.T) {
+	_, err := GetHomeDir()
+	if err != nil {
+		t.Errorf("Error getting homedir: %s", err)
+	}
+}
+
+func TestGetHomeDirOverride(t *testing.T) {
+	overrideDir := fmt.Sprintf("%s/homeDirOverride", tmpDir)
+
+	err := os.MkdirAll(overrideDir, 0755)
+	if err != nil {
+		t.Fatalf("Error creating override dir %s: %s", overrideDir, err)
+	}
+
+	_ = os.Setenv(DBT_HOME_ENV_VAR, overrideDir)
+	defer os.Unsetenv(DBT_HOME_ENV_VAR)
+
+	dir, err := GetHomeDir()
+	if err != nil {
+		t.Errorf("Error getting homedir: %s", err)
+	}
+
+	assert.Equal(t, overrideDir, dir, "Homedir honors DBT_HOME override.")
+
+	// tool, trust, and config dirs should all land in the override
+	err = GenerateDbtDir("", true)
+	if err != nil {
+		t.Errorf("Error generating dbt dir: %s", err)
+	}
+
+	for _, p := range []string{ToolDir(overrideDir), TrustDir(overrideDir), ConfigDir(overrideDir)} {
+		if _, err := os.Stat(p); os.IsNotExist(err) {
+			t.Errorf("dir %s did not create as expected", p)
+		}
+	}
+
+	configFile := ConfigFilePath(overrideDir)
+	err = os.WriteFile(configFile, []byte(testDbtConfigContents(port)), 0644)
+	if err != nil {
+		t.Fatalf("Error writing config file to %s: %s", configFile, err)
+	}
+
+	config, err := LoadDbtConfig("", true)
+	if err != nil {
+		t.Errorf("Error loading config file: %s", err)
+	}
+
+	assert.Equal(t, testToolUrl(port), config.Tools.Repo, "Config loaded from DBT_HOME override.")
+
+	// truststore is looked up relative to the override when no homedir is given
+	dbtObj := &DBT{Config: dbtConfig}
+	_, err = dbtObj.VerifyFileSignature("", configFile)
+	assert.True(t, err != nil && strings.Contains(err.Error(), overrideDir), "Truststore looked up in DBT_HOME override.")
+
+	// a nonexistent override is ignored
+	_ = os.Setenv(DBT_HOME_ENV_VAR, fmt.Sprintf("%s/nonexistent", tmpDir))
+
+	dir, err = GetHomeDir()
+	if err != nil {
+		t.Errorf("Error getting homedir: %s", err)
+	}
+
+	assert.NotEqual(t, fmt.Sprintf("%s/nonexistent", tmpDir), dir, "Nonexistent DBT_HOME is ignored.")
+}
+
+func TestFetchTool(t *testing.T) {
+	inputs := []struct {
+		name    string
+		obj     *DBT
+		homedir string
+	}{
+		{
+			"reposerver",
+			&DBT{
+				Config:  dbtConfig,
+				Verbose: true,
+			},
+			homeDirRepoServer,
+		},
+		{
+			"s3",
+			&DBT{
+				Config:   s3DbtConfig,
+				Verbose:  true,
+				S3Client: s3Client,
+			},
+			homeDirS3,
+		},
+	}
+
+	for _, tc := range inputs {
+		t.Run(tc.name, func(t *testing.T) {
+			tc.obj.Logger = log.New(os.Stderr, "", 0)
+
+			err := tc.obj.FetchTrustStore(tc.homedir)
+			if err != nil {
+				t.Fatalf("Error fetching truststore: %s", err)
+			}
+
+			localPath, err := tc.obj.FetchTool("catalog", "", tc.homedir)
+			if err != nil {
+				t.Errorf("Error fetching tool: %s", err)
+			}
+
+			assert.Equal(t, CachedToolPath(tc.homedir, "catalog", VERSION), localPath, "Tool path meets expectations.")
+
+			for _, suffix := range []string{"", ".sha256", ".asc"} {
+				_, err = os.Stat(fmt.Sprintf("%s%s", localPath, suffix))
+				assert.Nil(t, err, "Fetched file exists.")
+			}
+
+			// a second fetch finds it up to date
+			_, err = tc.obj.FetchTool("catalog", "", tc.homedir)
+			assert.Nil(t, err, "Refetch of an up to date tool succeeds.")
+
+			_, err = tc.obj.FetchTool("nonexistent", "", tc.homedir)
+			assert.NotNil(t, err, "Nonexistent tool fails to fetch.")
+		})
+	}
+}
+
+// HELPER_PROCESS_ENV_VAR tells the test binary it's being run as a tool by TestHelperProcess
+const HELPER_PROCESS_ENV_VAR = "GO_WANT_HELPER_PROCESS"
+
+// TestHelperProcess isn't a real test.  It's the tool runExec runs when testExec is set, and exits with the code given as its last argument.
+func TestHelperProcess(t *testing.T) {
+	if os.Getenv(HELPER_PROCESS_ENV_VAR) != "1" {
+		return
+	}
+
+	args := os.Args
+	for len(args) > 0 && args[0] != "--" {
+		args = args[1:]
+	}
+
+	code, err := strconv.Atoi(args[len(args)-1])
+	if err != nil {
+		code = 0
+	}
+
+	fmt.Printf("ran %s", strings.Join(args[2:], " "))
+	os.Exit(code)
+}
+
+func TestRunToolExitCode(t *testing.T) {
+	_, config, _ := newTestSignedRepo(t, "foo", "1.0.0")
+
+	homedir, err := ioutil.TempDir("", "dbt-exitcode")
+	if err != nil {
+		t.Fatalf("Failed creating homedir: %s", err)
+	}
+
+	defer os.RemoveAll(homedir)
+
+	err = os.MkdirAll(fmt.Sprintf("%s/%s", homedir, DbtDir), 0755)
+	if err != nil {
+		t.Fatalf("Failed creating dbt dir: %s", err)
+	}
+
+	err = GenerateDbtDir(homedir, false)
+	if err != nil {
+		t.Fatalf("Failed generating dbt dir: %s", err)
+	}
+
+	obj := &DBT{
+		Config: config,
+		Logger: log.New(ioutil.Discard, "", 0),
+	}
+
+	err = obj.FetchTrustStore(homedir)
+	if err != nil {
+		t.Fatalf("Failed fetching truststore: %s", err)
+	}
+
+	testExec = true
+	defer func() { testExec = false }()
+
+	_ = os.Setenv(HELPER_PROCESS_ENV_VAR, "1")
+	defer os.Unsetenv(HELPER_PROCESS_ENV_VAR)
+
+	inputs := []struct {
+		name    string
+		offline bool
+		code    string
+	}{
+		{"success", false, "0"},
+		{"exit 1", false, "1"},
+		{"exit 3", false, "3"},
+		{"offline exit 42", true, "42"},
+	}
+
+	for _, tc := range inputs {
+		t.Run(tc.name, func(t *testing.T) {
+			err := obj.RunTool("", []string{"foo", "exit", tc.code}, homedir, tc.offline)
+
+			if tc.code == "0" {
+				assert.Nil(t, err, "Successful tool returns no error.")
+				return
+			}
+
+			code, ok := ToolExitCode(err)
+			assert.True(t, ok, "Failed tool returns its exit code.")
+			assert.Equal(t, tc.code, strconv.Itoa(code), "Exit code is the tool's.")
+		})
+	}
+
+	_, ok := ToolExitCode(fmt.Errorf("not a tool"))
+	assert.False(t, ok, "Other errors carry no exit code.")
+}
+
+func TestFetchToolRemoteChecksum(t *testing.T) {
+	_, config, _ := newTestSignedRepo(t, "foo", "1.0.0")
+
+	inputs := []struct {
+		name   string
+		tamper func(localPath string) error
+	}{
+		{
+			"stale local checksum",
+			func(localPath string) error {
+				return ioutil.WriteFile(localPath+".sha256", []byte("0000000000000000000000000000000000000000000000000000000000000000"), 0644)
+			},
+		},
+		{
+			"missing local checksum",
+			func(localPath string) error {
+				return os.Remove(localPath + ".sha256")
+			},
+		},
+		{
+			"tampered binary with matching local checksum",
+			func(localPath string) error {
+				err := ioutil.WriteFile(localPath, []byte("#!/bin/sh\necho evil\n"), 0755)
+				if err != nil {
+					return err
+				}
+
+				checksum, err := FileSha256(localPath)
+				if err != nil {
+					return err
+				}
+
+				return ioutil.WriteFile(localPath+".sha256", []byte(checksum), 0644)
+			},
+		},
+	}
+
+	for _, tc := range inputs {
+		t.Run(tc.name, func(t *testing.T) {
+			homedir, err := ioutil.TempDir("", "dbt-remote-checksum")
+			if err != nil {
+				t.Fatalf("Failed creating homedir: %s", err)
+			}
+
+			defer os.RemoveAll(homedir)
+
+			err = makeStagingDbtDir(homedir, false)
+			if err != nil {
+				t.Fatalf("Failed creating dbt dir: %s", err)
+			}
+
+			obj := &DBT{
+				Config: config,
+				Logger: log.New(ioutil.Discard, "", 0),
+			}
+
+			err = obj.FetchTrustStore(homedir)
+			if err != nil {
+				t.Fatalf("Failed fetching truststore: %s", err)
+			}
+
+			localPath, err := obj.FetchTool("foo", "", homedir)
+			if err != nil {
+				t.Fatalf("Failed fetching tool: %s", err)
+			}
+
+			expected, err := ioutil.ReadFile(localPath + ".sha256")
+			if err != nil {
+				t.Fatalf("Failed reading checksum: %s", err)
+			}
+
+			err = tc.tamper(localPath)
+			if err != nil {
+				t.Fatalf("Failed tampering: %s", err)
+			}
+
+			_, err = obj.FetchTool("foo", "", homedir)
+			assert.Nil(t, err, "Tool verifies against the repo's checksum.")
+
+			content, _ := ioutil.ReadFile(localPath)
+			assert.Equal(t, "#!/bin/sh\necho foo 1.0.0\n", string(content), "Cached tool is the one in the repo.")
+
+			checksum, _ := ioutil.ReadFile(localPath + ".sha256")
+			assert.Equal(t, string(expected), string(checksum), "Local checksum agrees with the repo's.")
+		})
+	}
+}
+
+func TestIncompleteToolCache(t *testing.T) {
+	_, config, _ := newTestSignedRepo(t, "foo", "1.0.0")
+
+	inputs := []struct {
+		name     string
+		missing  []string
+		offline  bool
+		errMatch string
+	}{
+		{"offline no checksum", []string{".sha256"}, true, "Re-run online to repair it"},
+		{"offline no signature", []string{".asc"}, true, "Re-run online to repair it"},
+		{"offline neither", []string{".sha256", ".asc"}, true, "Re-run online to repair it"},
+		{"online no checksum", []string{".sha256"}, false, ""},
+		{"online no signature", []string{".asc"}, false, ""},
+		{"online neither", []string{".sha256", ".asc"}, false, ""},
+	}
+
+	for _, tc := range inputs {
+		t.Run(tc.name, func(t *testing.T) {
+			homedir, err := ioutil.TempDir("", "dbt-incomplete")
+			if err != nil {
+				t.Fatalf("Failed creating homedir: %s", err)
+			}
+
+			defer os.RemoveAll(homedir)
+
+			err = makeStagingDbtDir(homedir, false)
+			if err != nil {
+				t.Fatalf("Failed creating dbt dir: %s", err)
+			}
+
+			obj := &DBT{
+				Config: config,
+				Logger: log.New(ioutil.Discard, "", 0),
+			}
+
+			err = obj.FetchTrustStore(homedir)
+			if err != nil {
+				t.Fatalf("Failed fetching truststore: %s", err)
+			}
+
+			localPath, err := obj.FetchTool("foo", "", homedir)
+			if err != nil {
+				t.Fatalf("Failed fetching tool: %s", err)
+			}
+
+			for _, suffix := range tc.missing {
+				_ = os.Remove(localPath + suffix)
+			}
+
+			if tc.offline {
+				_, err = obj.verifyTool(homedir, "foo", "")
+				if assert.NotNil(t, err, "Incomplete cache fails offline.") {
+					assert.Contains(t, err.Error(), tc.errMatch, "Error says how to fix it.")
+				}
+
+				return
+			}
+
+			_, err = obj.FetchTool("foo", "", homedir)
+			assert.Nil(t, err, "Incomplete cache is repaired online.")
+
+			assert.Empty(t, missingSidecars(localPath), "Sidecars are back.")
+		})
+	}
+}
+
+func TestCorruptToolCacheRepair(t *testing.T) {
+	inputs := []struct {
+		name        string
+		offline     bool
+		corruptRepo bool
+		err         bool
+	}{
+		{"online", false, false, false},
+		{"offline", true, false, true},
+		{"repo copy bad too", false, true, true},
+	}
+
+	for _, tc := range inputs {
+		t.Run(tc.name, func(t *testing.T) {
+			repoRoot, config, _ := newTestSignedRepo(t, "foo", "1.0.0")
+
+			homedir, err := ioutil.TempDir("", "dbt-corrupt")
+			if err != nil {
+				t.Fatalf("Failed creating homedir: %s", err)
+			}
+
+			defer os.RemoveAll(homedir)
+
+			err = makeStagingDbtDir(homedir, false)
+			if err != nil {
+				t.Fatalf("Failed creating dbt dir: %s", err)
+			}
+
+			obj := &DBT{
+				Config: config,
+				Logger: log.New(ioutil.Discard, "", 0),
+			}
+
+			err = obj.FetchTrustStore(homedir)
+			if err != nil {
+				t.Fatalf("Failed fetching truststore: %s", err)
+			}
+
+			localPath, err := obj.FetchTool("foo", "", homedir)
+			if err != nil {
+				t.Fatalf("Failed fetching tool: %s", err)
+			}
+
+			goodSig, _ := ioutil.ReadFile(localPath + ".asc")
+			badSig := []byte("not a signature")
+
+			err = ioutil.WriteFile(localPath+".asc", badSig, 0644)
+			if err != nil {
+				t.Fatalf("Failed corrupting signature: %s", err)
+			}
+
+			if tc.corruptRepo {
+				repoSig := fmt.Sprintf("%s/dbt-tools/foo/1.0.0/%s/%s/%s.asc", repoRoot, runtime.GOOS, runtime.GOARCH, ToolFileName("foo", runtime.GOOS))
+
+				err = ioutil.WriteFile(repoSig, badSig, 0644)
+				if err != nil {
+					t.Fatalf("Failed corrupting repo signature: %s", err)
+				}
+			}
+
+			if tc.offline {
+				_, err = obj.verifyTool(homedir, "foo", "")
+			} else {
+				_, err = obj.FetchTool("foo", "", homedir)
+			}
+
+			if tc.err {
+				if assert.NotNil(t, err, "Cache that can't be repaired fails.") && tc.offline {
+					assert.Contains(t, err.Error(), "Re-run online to repair it", "Offline error says how to fix it.")
+				}
+
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("Failed repairing cache: %s", err)
+			}
+
+			sig, _ := ioutil.ReadFile(localPath + ".asc")
+			assert.Equal(t, string(goodSig), string(sig), "Fresh signature is downloaded.")
+		})
+	}
+}
+
+func TestParseToolSpec(t *testing.T) {
+	inputs := []struct {
+		arg     string
+		name    string
+		version string
+	}{
+		{"foo", "foo", ""},
+		{"foo@1.2.3", "foo", "1.2.3"},
+		{"foo@latest", "foo", ""},
+		{"foo@", "foo@", ""},
+		{"foo@bar", "foo@bar", ""},
+		{"foo@bar@1.2.3", "foo@bar", "1.2.3"},
+		{"@1.2.3", "@1.2.3", ""},
+		{"foo@^1.2", "foo", "^1.2"},
+		{"foo@~1.2.3", "foo", "~1.2.3"},
+		{"foo@>=1.0 <2", "foo", ">=1.0 <2"},
+		{"foo@1.x", "foo", "1.x"},
+		{"foo@bar@^1.2", "foo@bar", "^1.2"},
+	}
+
+	for _, tc := range inputs {
+		t.Run(tc.arg, func(t *testing.T) {
+			name, version := ParseToolSpec(tc.arg)
+
+			assert.Equal(t, tc.name, name, "Tool name meets expectations.")
+			assert.Equal(t, tc.version, version, "Tool version meets expectations.")
+		})
+	}
+}
+
+func TestParseToolDigest(t *testing.T) {
+	digest := strings.Repeat("ab", 32)
+
+	inputs := []struct {
+		arg    string
+		spec   string
+		digest string
+	}{
+		{"foo", "foo", ""},
+		{"foo@1.2.3", "foo@1.2.3", ""},
+		{fmt.Sprintf("foo@sha256:%s", digest), "foo", digest},
+		{fmt.Sprintf("foo@1.2.3@sha256:%s", digest), "foo@1.2.3", digest},
+		{fmt.Sprintf("foo@sha256:%s", strings.ToUpper(digest)), "foo", digest},
+		{"foo@sha256:abc", "foo@sha256:abc", ""},
+		{fmt.Sprintf("@sha256:%s", digest), fmt.Sprintf("@sha256:%s", digest), ""},
+	}
+
+	for _, tc := range inputs {
+		t.Run(tc.arg, func(t *testing.T) {
+			spec, digest := ParseToolDigest(tc.arg)
+
+			assert.Equal(t, tc.spec, spec, "Tool spec meets expectations.")
+			assert.Equal(t, tc.digest, digest, "Tool digest meets expectations.")
+		})
+	}
+}
+
+func TestRunToolDigest(t *testing.T) {
+	repoRoot, config, _ := newTestSignedRepo(t, "foo", "1.0.0", "2.0.0")
+
+	homedir, err := ioutil.TempDir("", "dbt-digest")
+	if err != nil {
+		t.Fatalf("Failed creating homedir: %s", err)
+	}
+
+	defer os.RemoveAll(homedir)
+
+	err = makeStagingDbtDir(homedir, false)
+	if err != nil {
+		t.Fatalf("Failed creating dbt dir: %s", err)
+	}
+
+	digest := func(version string) string {
+		checksum, err := FileSha256(fmt.Sprintf("%s/dbt-tools/foo/%s/%s/%s/%s", repoRoot, version, runtime.GOOS, runtime.GOARCH, ToolFileName("foo", runtime.GOOS)))
+		if err != nil {
+			t.Fatalf("Failed checksumming foo %s: %s", version, err)
+		}
+
+		return checksum
+	}
+
+	testExec = true
+	defer func() { testExec = false }()
+
+	_ = os.Setenv(HELPER_PROCESS_ENV_VAR, "1")
+	defer os.Unsetenv(HELPER_PROCESS_ENV_VAR)
+
+	inputs := []struct {
+		name     string
+		tool     string
+		digests  map[string]string
+		offline  bool
+		expected error
+		err      bool
+	}{
+		{"pinned latest", fmt.Sprintf("foo@sha256:%s", digest("2.0.0")), nil, false, nil, false},
+		{"pinned version", fmt.Sprintf("foo@1.0.0@sha256:%s", digest("1.0.0")), nil, false, nil, false},
+		{"pinned offline", fmt.Sprintf("foo@1.0.0@sha256:%s", digest("1.0.0")), nil, true, nil, false},
+		{"wrong digest", fmt.Sprintf("foo@sha256:%s", digest("1.0.0")), nil, false, ErrChecksumMismatch, true},
+		{"wrong digest offline", fmt.Sprintf("foo@2.0.0@sha256:%s", digest("1.0.0")), nil, true, ErrChecksumMismatch, true},
+		{"config digest", "foo@1.0.0", map[string]string{"foo": fmt.Sprintf("sha256:%s", digest("1.0.0"))}, false, nil, false},
+		{"wrong config digest", "foo", map[string]string{"foo": digest("1.0.0")}, false, ErrChecksumMismatch, true},
+		{"conflicting digests", fmt.Sprintf("foo@sha256:%s", digest("2.0.0")), map[string]string{"foo": digest("1.0.0")}, false, nil, true},
+	}
+
+	for _, tc := range inputs {
+		t.Run(tc.name, func(t *testing.T) {
+			tcConfig := config
+			tcConfig.Tools.Digests = tc.digests
+
+			obj := &DBT{
+				Config: tcConfig,
+				Logger: log.New(ioutil.Discard, "", 0),
+			}
+
+			err := obj.FetchTrustStore(homedir)
+			if err != nil {
+				t.Fatalf("Failed fetching truststore: %s", err)
+			}
+
+			err = obj.RunTool("", []string{tc.tool}, homedir, tc.offline)
+			if !tc.err {
+				assert.NoError(t, err, "Pinned tool runs.")
+				return
+			}
+
+			assert.Error(t, err, "Tool that isn't what it's pinned to doesn't run.")
+
+			if tc.expected != nil {
+				assert.True(t, errors.Is(err, tc.expected), "Error %q is %q.", err, tc.expected)
+			}
+		})
+	}
+}
+
+func TestRunToolOfflineVersion(t *testing.T) {
+	_, config, _ := newTestSignedRepo(t, "foo", "1.0.0", "2.0.0")
+
+	homedir, err := ioutil.TempDir("", "dbt-offline-version")
+	if err != nil {
+		t.Fatalf("Failed creating homedir: %s", err)
+	}
+
+	defer os.RemoveAll(homedir)
+
+	err = makeStagingDbtDir(homedir, false)
+	if err != nil {
+		t.Fatalf("Failed creating dbt dir: %s", err)
+	}
+
+	obj := &DBT{
+		Config: config,
+		Logger: log.New(ioutil.Discard, "", 0),
+	}
+
+	err = obj.FetchTrustStore(homedir)
+	if err != nil {
+		t.Fatalf("Failed fetching truststore: %s", err)
+	}
+
+	testExec = true
+	defer func() { testExec = false }()
+
+	_ = os.Setenv(HELPER_PROCESS_ENV_VAR, "1")
+	defer os.Unsetenv(HELPER_PROCESS_ENV_VAR)
+
+	// run each version online once, so they're cached
+	for _, version := range []string{"1.0.0", "2.0.0"} {
+		err = obj.RunTool(version, []string{"foo"}, homedir, false)
+		if err != nil {
+			t.Fatalf("Failed running foo %s: %s", version, err)
+		}
+	}
+
+	inputs := []struct {
+		name    string
+		version string
+		tool    string
+		err     bool
+	}{
+		{"old version", "1.0.0", "foo", false},
+		{"new version", "2.0.0", "foo", false},
+		{"latest", "", "foo", false},
+		{"never run", "3.0.0", "foo", true},
+		{"old version spec", "", "foo@1.0.0", false},
+		{"latest spec", "", "foo@latest", false},
+		{"spec and matching flag", "1.0.0", "foo@1.0.0", false},
+		{"spec and conflicting flag", "2.0.0", "foo@1.0.0", true},
+		{"never run spec", "", "foo@3.0.0", true},
+		{"range spec", "", "foo@^1.0", false},
+		{"range flag", "~2.0", "foo", false},
+		{"never run range", "", "foo@^3", true},
+		{"invalid range", "", "foo@^bogus", true},
+	}
+
+	for _, tc := range inputs {
+		t.Run(tc.name, func(t *testing.T) {
+			err := obj.RunTool(tc.version, []string{tc.tool}, homedir, true)
+			if tc.err {
+				assert.NotNil(t, err, "Version that was never run, or conflicting versions, don't run.")
+				return
+			}
+
+			assert.Nil(t, err, "Previously run version runs offline.")
+		})
+	}
+}
 
 func ExampleRunTool() {
 	inputs := []struct {
@@ -401,9 +1775,9 @@ func ExampleRunTool() {
 		{
 			"s3",
 			&DBT{
-				Config:    s3DbtConfig,
-				Verbose:   true,
-				S3Session: s3Session,
+				Config:   s3DbtConfig,
+				Verbose:  true,
+				S3Client: s3Client,
 			},
 			homeDirS3,
 		},
@@ -432,3 +1806,164 @@ func ExampleRunTool() {
 
 	}
 }
+
+func TestVerifyToolFileChecks(t *testing.T) {
+	inputs := []struct {
+		name          string
+		badChecksum   bool
+		badSignature  bool
+		expectedCause error
+	}{
+		{"good", false, false, nil},
+		{"bad checksum", true, false, ErrChecksumMismatch},
+		{"bad signature", false, true, ErrSignatureMismatch},
+		{"both bad", true, true, ErrChecksumMismatch},
+	}
+
+	for _, tc := range inputs {
+		t.Run(tc.name, func(t *testing.T) {
+			_, config, signer := newTestSignedRepo(t, "foo", "1.0.0")
+
+			homedir := t.TempDir()
+
+			err := makeStagingDbtDir(homedir, false)
+			if err != nil {
+				t.Fatalf("Failed creating dbt dir: %s", err)
+			}
+
+			obj := &DBT{
+				Config: config,
+				Logger: log.New(ioutil.Discard, "", 0),
+			}
+
+			err = obj.FetchTrustStore(homedir)
+			if err != nil {
+				t.Fatalf("Failed fetching truststore: %s", err)
+			}
+
+			localPath, err := obj.FetchTool("foo", "", homedir)
+			if err != nil {
+				t.Fatalf("Failed fetching tool: %s", err)
+			}
+
+			if tc.badChecksum {
+				err = ioutil.WriteFile(localPath+".sha256", []byte(strings.Repeat("0", 64)), 0644)
+				if err != nil {
+					t.Fatalf("Failed corrupting checksum: %s", err)
+				}
+			}
+
+			if tc.badSignature {
+				// a good signature, but of something else
+				otherPath := filepath.Join(t.TempDir(), "other")
+				writeTestSignedTool(t, signer, otherPath, "#!/bin/sh\necho other\n")
+
+				otherSig, err := ioutil.ReadFile(otherPath + ".asc")
+				if err != nil {
+					t.Fatalf("Failed reading other signature: %s", err)
+				}
+
+				err = ioutil.WriteFile(localPath+".asc", otherSig, 0644)
+				if err != nil {
+					t.Fatalf("Failed corrupting signature: %s", err)
+				}
+			}
+
+			err = obj.verifyToolFile(homedir, localPath)
+			if tc.expectedCause == nil {
+				assert.Nil(t, err, "Tool verifies.")
+				return
+			}
+
+			if assert.NotNil(t, err, "Tool fails verification.") {
+				assert.Equal(t, tc.expectedCause, errors.Cause(err), "Failure is reported.")
+			}
+		})
+	}
+}
+
+func TestToolsTrustStore(t *testing.T) {
+	inputs := []struct {
+		name            string
+		toolsSigned     bool
+		toolsTrustStore bool
+		err             bool
+	}{
+		{"one truststore", false, false, false},
+		{"tools truststore", true, true, false},
+		{"tool signed with dbt key", false, true, true},
+		{"tool signed with tools key", true, false, true},
+	}
+
+	for _, tc := range inputs {
+		t.Run(tc.name, func(t *testing.T) {
+			repoRoot, config, _ := newTestSignedRepo(t, "foo", "1.0.0")
+
+			toolsSigner := newTestSigner(t, "toolsigner")
+			writeTestTrustStore(t, filepath.Join(repoRoot, "tools-truststore"), toolsSigner)
+
+			if tc.toolsSigned {
+				toolPath := fmt.Sprintf("%s/dbt-tools/foo/1.0.0/%s/%s/%s", repoRoot, runtime.GOOS, runtime.GOARCH, ToolFileName("foo", runtime.GOOS))
+				writeTestSignedTool(t, toolsSigner, toolPath, "#!/bin/sh\necho foo 1.0.0\n")
+			}
+
+			if tc.toolsTrustStore {
+				config.Dbt.ToolsTrustStore = strings.TrimSuffix(config.Dbt.TrustStore, "truststore") + "tools-truststore"
+			}
+
+			homedir := t.TempDir()
+
+			err := makeStagingDbtDir(homedir, false)
+			if err != nil {
+				t.Fatalf("Failed creating dbt dir: %s", err)
+			}
+
+			obj := &DBT{
+				Config: config,
+				Logger: log.New(ioutil.Discard, "", 0),
+			}
+
+			err = obj.FetchTrustStore(homedir)
+			if err != nil {
+				t.Fatalf("Failed fetching truststore: %s", err)
+			}
+
+			_, statErr := os.Stat(ToolsTruststorePath(homedir))
+			assert.Equal(t, tc.toolsTrustStore, statErr == nil, "Tools truststore is only downloaded if it's configured.")
+
+			localPath, err := obj.FetchTool("foo", "", homedir)
+			if tc.err {
+				if assert.NotNil(t, err, "Tool fails verification.") {
+					assert.Equal(t, ErrSignatureMismatch, errors.Cause(err), "Tool's signature doesn't verify.")
+				}
+
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("Failed fetching tool: %s", err)
+			}
+
+			ok, _ := obj.VerifyFileSignature(homedir, localPath)
+			assert.Equal(t, !tc.toolsSigned, ok, "dbt truststore only verifies tools signed with the dbt key.")
+
+			if !tc.toolsTrustStore {
+				return
+			}
+
+			// without the tools truststore in the config, it's removed, and it's back to the one truststore
+			obj.Config.Dbt.ToolsTrustStore = ""
+
+			err = obj.FetchTrustStore(homedir)
+			if err != nil {
+				t.Fatalf("Failed fetching truststore: %s", err)
+			}
+
+			_, statErr = os.Stat(ToolsTruststorePath(homedir))
+			assert.True(t, os.IsNotExist(statErr), "Tools truststore is removed.")
+
+			_, err = obj.verifyTool(homedir, "foo", "1.0.0")
+			assert.Equal(t, ErrSignatureMismatch, errors.Cause(err), "Tool signed with the tools key no longer verifies.")
+		})
+	}
+}
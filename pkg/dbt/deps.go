@@ -0,0 +1,223 @@
+// Copyright © 2019 Nik Ogura <nik.ogura@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package dbt
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/pkg/errors"
+	"sort"
+	"strings"
+)
+
+// TOOL_DEPS_FILE File next to a tool version's description.txt declaring the other tools it needs.
+const TOOL_DEPS_FILE = "deps.json"
+
+// ToolDeps is the content of a deps.json.  Dependencies maps tool names to versions.  An empty version, or 'latest', means the latest.
+type ToolDeps struct {
+	Dependencies map[string]string `json:"dependencies"`
+}
+
+// FetchToolDeps fetches the dependencies a version of a tool declares in its deps.json, verifying its signature against the truststore under homedir, just like the tool itself.  A semver range like '^1.2' means the newest version in it.  A version without a deps.json, i.e. a 404, has no dependencies, so long as the version itself is in the repo.  Any other error from the repo is returned.
+func (dbt *DBT) FetchToolDeps(toolName string, version string, homedir string) (deps map[string]string, err error) {
+	deps = make(map[string]string)
+
+	if IsVersionRange(version) {
+		version, err = dbt.resolveVersionRange(homedir, toolName, version, false)
+		if err != nil {
+			return deps, err
+		}
+	}
+
+	uri := fmt.Sprintf("%s/%s/%s/%s", dbt.Config.Tools.Repo, toolName, version, TOOL_DEPS_FILE)
+
+	// a repo that can't say whether there's a deps.json is an error, not a tool without dependencies
+	found, err := dbt.FileExists(uri)
+	if err != nil {
+		err = errors.Wrapf(err, "failed to check for dependencies of %s", toolName)
+		return deps, err
+	}
+
+	// a 404 for a version that isn't there isn't a version without dependencies
+	if !found {
+		versionInRepo, err := dbt.ToolVersionExists(toolName, version)
+		if err != nil {
+			err = errors.Wrapf(err, "failed to check for %s version %s", toolName, version)
+			return deps, err
+		}
+
+		if !versionInRepo {
+			err = errors.Wrapf(ErrVersionNotFound, "%s version %s is not in repo", toolName, version)
+			return deps, err
+		}
+
+		return deps, err
+	}
+
+	dbt.VerboseOutput("Fetching dependencies from %s", uri)
+
+	content, err := dbt.fetchDescriptionFile(uri)
+	if err != nil {
+		err = errors.Wrapf(err, "failed to fetch dependencies of %s", toolName)
+		return deps, err
+	}
+
+	signature, err := dbt.fetchDescriptionFile(fmt.Sprintf("%s.asc", uri))
+	if err != nil {
+		err = errors.Wrapf(err, "failed to fetch signature for dependencies of %s", toolName)
+		return deps, err
+	}
+
+	err = dbt.verifyDescription(homedir, content, signature)
+	if err != nil {
+		err = errors.Wrapf(err, "dependencies of %s failed to verify", toolName)
+		return deps, err
+	}
+
+	var toolDeps ToolDeps
+
+	err = json.Unmarshal([]byte(content), &toolDeps)
+	if err != nil {
+		err = errors.Wrapf(err, "failed to parse %s", uri)
+		return deps, err
+	}
+
+	for name, depVersion := range toolDeps.Dependencies {
+		if depVersion == TOOL_SPEC_LATEST {
+			depVersion = ""
+		}
+
+		deps[name] = depVersion
+	}
+
+	return deps, err
+}
+
+// ResolveDependencies fetches and verifies the tools a version of a tool depends on into the cache, and the tools they depend on in turn, so a tool that runs others finds them ready.  An empty version means the latest, and a semver range the newest version in it, here and in deps.json.  A dependency that isn't in the repo, or that leads back to a tool that needs it, is an error.  A tool that isn't in the repo itself has no deps.json there, so nothing to fetch.
+func (dbt *DBT) ResolveDependencies(toolName string, version string, homedir string) (err error) {
+	if version == "" {
+		version, err = dbt.FindLatestVersion(toolName)
+		if err != nil {
+			err = errors.Wrapf(err, "failed to find latest version of %s", toolName)
+			return err
+		}
+
+		if version == "" {
+			dbt.VerboseOutput("%s is not in the repo, so it has no dependencies to fetch", toolName)
+			return err
+		}
+	}
+
+	if IsVersionRange(version) {
+		version, err = dbt.resolveVersionRange(homedir, toolName, version, false)
+		if err != nil {
+			return err
+		}
+	}
+
+	resolved := make(map[string]bool)
+
+	return dbt.resolveDependencies(toolName, version, homedir, []string{}, resolved)
+}
+
+// resolveDependencies walks the dependency graph depth first.  path is the chain of tools that led here, for spotting cycles.  resolved holds what's been fetched already, so tools that several others need are only fetched once.
+func (dbt *DBT) resolveDependencies(toolName string, version string, homedir string, path []string, resolved map[string]bool) (err error) {
+	spec := fmt.Sprintf("%s@%s", toolName, version)
+
+	for _, p := range path {
+		if p == spec {
+			err = errors.Wrapf(ErrDependencyCycle, "%s", strings.Join(append(path, spec), " -> "))
+			return err
+		}
+	}
+
+	if resolved[spec] {
+		return err
+	}
+
+	deps, err := dbt.FetchToolDeps(toolName, version, homedir)
+	if err != nil {
+		return err
+	}
+
+	path = append(path, spec)
+
+	// in order, so what's fetched, and any error, is the same from run to run
+	names := make([]string, 0, len(deps))
+	for name := range deps {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	for _, name := range names {
+		depVersion := deps[name]
+
+		if depVersion == "" {
+			depVersion, err = dbt.FindLatestVersion(name)
+			if err != nil {
+				err = errors.Wrapf(err, "failed to find latest version of %s, needed by %s", name, spec)
+				return err
+			}
+
+			if depVersion == "" {
+				err = errors.Wrapf(ErrToolNotFound, "tool %s, needed by %s, is not in repo", name, spec)
+				return err
+			}
+		}
+
+		// resolved here, so the deps.json fetched, and the cycle check, are for the version that's actually used
+		if IsVersionRange(depVersion) {
+			depVersion, err = dbt.resolveVersionRange(homedir, name, depVersion, false)
+			if err != nil {
+				err = errors.Wrapf(err, "failed to resolve %s@%s, needed by %s", name, deps[name], spec)
+				return err
+			}
+		}
+
+		depSpec := fmt.Sprintf("%s@%s", name, depVersion)
+
+		if !resolved[depSpec] {
+			dbt.VerboseOutput("Fetching %s, needed by %s", depSpec, spec)
+
+			localPath, err := dbt.FetchTool(name, depVersion, homedir)
+			if err != nil {
+				err = errors.Wrapf(err, "failed to fetch %s, needed by %s", depSpec, spec)
+				return err
+			}
+
+			// a dependency pinned in tools.digests is held to it, same as a tool run directly
+			digest, err := dbt.ToolDigest(name, "")
+			if err != nil {
+				return err
+			}
+
+			err = VerifyToolDigest(localPath, name, digest)
+			if err != nil {
+				err = errors.Wrapf(err, "%s, needed by %s, failed verification", depSpec, spec)
+				return err
+			}
+		}
+
+		err = dbt.resolveDependencies(name, depVersion, homedir, path, resolved)
+		if err != nil {
+			return err
+		}
+	}
+
+	resolved[spec] = true
+
+	return err
+}
@@ -0,0 +1,170 @@
+package dbt
+
+import (
+	"fmt"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+func TestResolveDependencies(t *testing.T) {
+	repoRoot, config, signer := newTestSignedRepo(t, "app", "1.0.0")
+
+	tools := map[string][]string{
+		"lib":      {"1.0.0"},
+		"util":     {"1.0.0", "2.0.0"},
+		"cyc-a":    {"1.0.0"},
+		"cyc-b":    {"1.0.0"},
+		"broken":   {"1.0.0"},
+		"badver":   {"1.0.0"},
+		"tampered": {"1.0.0"},
+		"plain":    {"1.0.0"},
+		"ranged":   {"1.0.0"},
+		"deep":     {"1.0.0", "1.2.0", "1.3.0", "2.0.0"},
+		"leaf":     {"1.0.0"},
+		"badrange": {"1.0.0"},
+	}
+
+	for name, versions := range tools {
+		for _, version := range versions {
+			writeTestSignedTool(t, signer, fmt.Sprintf("%s/dbt-tools/%s/%s/%s/%s/%s", repoRoot, name, version, runtime.GOOS, runtime.GOARCH, name), fmt.Sprintf("#!/bin/sh\necho %s %s\n", name, version))
+		}
+	}
+
+	deps := map[string]string{
+		"app":      `{"dependencies": {"lib": "1.0.0", "util": ""}}`,
+		"lib":      `{"dependencies": {"util": "latest"}}`,
+		"cyc-a":    `{"dependencies": {"cyc-b": "1.0.0"}}`,
+		"cyc-b":    `{"dependencies": {"cyc-a": "1.0.0"}}`,
+		"broken":   `{"dependencies": {"missing": ""}}`,
+		"badver":   `{"dependencies": {"util": "9.9.9"}}`,
+		"ranged":   `{"dependencies": {"deep": "^1.2"}}`,
+		"badrange": `{"dependencies": {"deep": "^3"}}`,
+	}
+
+	// only the version the range resolves to has deps of its own
+	writeTestSignedTool(t, signer, fmt.Sprintf("%s/dbt-tools/deep/1.3.0/%s", repoRoot, TOOL_DEPS_FILE), `{"dependencies": {"leaf": "1.0.0"}}`)
+
+	for name, content := range deps {
+		writeTestSignedTool(t, signer, fmt.Sprintf("%s/dbt-tools/%s/1.0.0/%s", repoRoot, name, TOOL_DEPS_FILE), content)
+	}
+
+	// a deps.json changed after it was signed
+	writeTestSignedTool(t, signer, fmt.Sprintf("%s/dbt-tools/tampered/1.0.0/%s", repoRoot, TOOL_DEPS_FILE), `{"dependencies": {}}`)
+
+	err := ioutil.WriteFile(fmt.Sprintf("%s/dbt-tools/tampered/1.0.0/%s", repoRoot, TOOL_DEPS_FILE), []byte(`{"dependencies": {"util": ""}}`), 0644)
+	if err != nil {
+		t.Fatalf("Failed writing deps: %s", err)
+	}
+
+	inputs := []struct {
+		name     string
+		tool     string
+		version  string
+		cached   [][2]string
+		expected error
+	}{
+		{"transitive", "app", "", [][2]string{{"lib", "1.0.0"}, {"util", "2.0.0"}}, nil},
+		{"no deps", "plain", "1.0.0", nil, nil},
+		{"cycle", "cyc-a", "1.0.0", nil, ErrDependencyCycle},
+		{"missing tool", "broken", "1.0.0", nil, ErrToolNotFound},
+		{"missing version", "badver", "1.0.0", nil, ErrVersionNotFound},
+		{"tampered deps", "tampered", "1.0.0", nil, ErrSignatureMismatch},
+		{"range dependency", "ranged", "1.0.0", [][2]string{{"deep", "1.3.0"}, {"leaf", "1.0.0"}}, nil},
+		{"range resolved first", "deep", "~1.3", [][2]string{{"leaf", "1.0.0"}}, nil},
+		{"unsatisfiable range", "badrange", "1.0.0", nil, ErrVersionNotFound},
+		{"missing top version", "plain", "9.9.9", nil, ErrVersionNotFound},
+	}
+
+	for _, tc := range inputs {
+		t.Run(tc.name, func(t *testing.T) {
+			homedir, err := ioutil.TempDir("", "dbt-deps")
+			if err != nil {
+				t.Fatalf("Error creating temp dir: %s", err)
+			}
+
+			defer os.RemoveAll(homedir)
+
+			err = makeStagingDbtDir(homedir, false)
+			if err != nil {
+				t.Fatalf("Error creating dbt dir: %s", err)
+			}
+
+			obj := &DBT{Config: config, Logger: log.New(ioutil.Discard, "", 0)}
+
+			err = obj.FetchTrustStore(homedir)
+			if err != nil {
+				t.Fatalf("Error fetching truststore: %s", err)
+			}
+
+			err = obj.ResolveDependencies(tc.tool, tc.version, homedir)
+			if tc.expected != nil {
+				assert.True(t, errors.Is(err, tc.expected), "Error %q is %q.", err, tc.expected)
+				return
+			}
+
+			assert.NoError(t, err, "Dependencies resolve.")
+
+			for _, cached := range tc.cached {
+				_, err = obj.verifyTool(homedir, cached[0], cached[1])
+				assert.NoError(t, err, "%s version %s is cached and verifies.", cached[0], cached[1])
+			}
+		})
+	}
+}
+
+func TestFetchToolDepsStatus(t *testing.T) {
+	inputs := []struct {
+		name    string
+		status  int
+		version bool
+		err     bool
+	}{
+		{"not found", http.StatusNotFound, true, false},
+		{"version not found", http.StatusNotFound, false, true},
+		{"unauthorized", http.StatusUnauthorized, true, true},
+		{"forbidden", http.StatusForbidden, true, true},
+		{"server error", http.StatusInternalServerError, true, true},
+	}
+
+	for _, tc := range inputs {
+		t.Run(tc.name, func(t *testing.T) {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if strings.HasSuffix(r.URL.Path, TOOL_DEPS_FILE) {
+					w.WriteHeader(tc.status)
+					return
+				}
+
+				// the version dir
+				if !tc.version {
+					w.WriteHeader(http.StatusNotFound)
+				}
+			}))
+			defer server.Close()
+
+			obj := &DBT{
+				Config: Config{Tools: ToolsConfig{Repo: fmt.Sprintf("%s/dbt-tools", server.URL)}},
+				Logger: log.New(ioutil.Discard, "", 0),
+			}
+
+			deps, err := obj.FetchToolDeps("foo", "1.0.0", t.TempDir())
+			if tc.err {
+				assert.NotNil(t, err, "Repo error isn't read as no dependencies.")
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("Failed fetching deps: %s", err)
+			}
+
+			assert.Empty(t, deps, "Tool without a deps.json has no dependencies.")
+		})
+	}
+}
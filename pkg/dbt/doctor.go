@@ -0,0 +1,244 @@
+// Copyright © 2019 Nik Ogura <nik.ogura@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package dbt
+
+import (
+	"fmt"
+	"github.com/keybase/go-crypto/openpgp"
+	"os"
+	"strings"
+)
+
+// DiagnosticResult is the outcome of a single check run by Doctor.
+type DiagnosticResult struct {
+	Name        string
+	Passed      bool
+	Message     string
+	Remediation string
+}
+
+// String returns a human readable form of the result
+func (r DiagnosticResult) String() string {
+	status := "PASS"
+	if !r.Passed {
+		status = "FAIL"
+	}
+
+	return fmt.Sprintf("[%s] %s: %s", status, r.Name, r.Message)
+}
+
+// Doctor checks the dbt install end to end: config file, local directories, repository, truststore, and tools.  Every check is run regardless of whether earlier ones failed, so the full picture is available at once.
+func (dbt *DBT) Doctor(homedir string) (results []DiagnosticResult) {
+	results = make([]DiagnosticResult, 0)
+
+	if homedir == "" {
+		dir, err := GetHomeDir()
+		if err != nil {
+			results = append(results, DiagnosticResult{
+				Name:        "homedir",
+				Message:     fmt.Sprintf("failed to discover user homedir: %s", err),
+				Remediation: fmt.Sprintf("Make sure $HOME is set, or set %s to the directory dbt should use.", DBT_HOME_ENV_VAR),
+			})
+			return results
+		}
+
+		homedir = dir
+	}
+
+	results = append(results, dbt.doctorConfigFile(homedir))
+	results = append(results, dbt.doctorConfig())
+	results = append(results, dbt.doctorDirs(homedir))
+	results = append(results, dbt.doctorRepository())
+	results = append(results, dbt.doctorTrustStore(homedir))
+	results = append(results, dbt.doctorTools())
+
+	return results
+}
+
+func (dbt *DBT) doctorConfigFile(homedir string) (result DiagnosticResult) {
+	result.Name = "config file"
+	filePath := ConfigFilePath(homedir)
+
+	_, err := ParseDbtConfigFile(filePath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			if EnvConfigComplete() {
+				result.Passed = true
+				result.Message = fmt.Sprintf("no config file at %s, using config from the environment", filePath)
+				return result
+			}
+
+			result.Message = fmt.Sprintf("no config file at %s", filePath)
+			result.Remediation = fmt.Sprintf("Run the dbt installer, write a config file to %s, or set %s, %s, and %s.", filePath, DBT_REPO_ENV_VAR, DBT_TRUSTSTORE_ENV_VAR, DBT_TOOLS_REPO_ENV_VAR)
+			return result
+		}
+
+		result.Message = err.Error()
+		result.Remediation = fmt.Sprintf("Fix the syntax of %s.", filePath)
+		return result
+	}
+
+	result.Passed = true
+	result.Message = fmt.Sprintf("%s parses", filePath)
+
+	return result
+}
+
+func (dbt *DBT) doctorConfig() (result DiagnosticResult) {
+	result.Name = "config"
+
+	problems := ValidateConfig(dbt.Config)
+	if len(problems) > 0 {
+		messages := make([]string, 0)
+		for _, p := range problems {
+			messages = append(messages, p.String())
+		}
+
+		result.Message = strings.Join(messages, "; ")
+		result.Remediation = "Correct the listed fields in your config file or environment."
+		return result
+	}
+
+	result.Passed = true
+	result.Message = "repository urls look sane"
+
+	return result
+}
+
+func (dbt *DBT) doctorDirs(homedir string) (result DiagnosticResult) {
+	result.Name = "directories"
+
+	for _, dir := range []string{ConfigDir(homedir), TrustDir(homedir), ToolDir(homedir)} {
+		info, err := os.Stat(dir)
+		if err != nil {
+			result.Message = fmt.Sprintf("failed to stat %s: %s", dir, err)
+			result.Remediation = "Run dbt once to create its directories, or create them by hand."
+			return result
+		}
+
+		if !info.IsDir() {
+			result.Message = fmt.Sprintf("%s is not a directory", dir)
+			result.Remediation = fmt.Sprintf("Move %s out of the way so dbt can create its directory there.", dir)
+			return result
+		}
+
+		if info.Mode().Perm()&0700 != 0700 {
+			result.Message = fmt.Sprintf("%s has mode %s", dir, info.Mode().Perm())
+			result.Remediation = fmt.Sprintf("Run 'chmod 0755 %s' so dbt can read and write it.", dir)
+			return result
+		}
+	}
+
+	result.Passed = true
+	result.Message = "dbt directories exist and are writable"
+
+	return result
+}
+
+func (dbt *DBT) doctorRepository() (result DiagnosticResult) {
+	result.Name = "repository"
+
+	if dbt.Config.Dbt.Repo == "" {
+		return unsetUrlResult(result, "dbt.repository")
+	}
+
+	ok, err := dbt.ToolExists("")
+	if err != nil {
+		result.Message = err.Error()
+		result.Remediation = fmt.Sprintf("Check your network connection and credentials for %s.", dbt.Config.Dbt.Repo)
+		return result
+	}
+
+	if !ok {
+		result.Message = fmt.Sprintf("%s is not reachable", dbt.Config.Dbt.Repo)
+		result.Remediation = "Check the dbt.repository url in your config, and your credentials."
+		return result
+	}
+
+	result.Passed = true
+	result.Message = fmt.Sprintf("%s is reachable", dbt.Config.Dbt.Repo)
+
+	return result
+}
+
+func (dbt *DBT) doctorTrustStore(homedir string) (result DiagnosticResult) {
+	result.Name = "truststore"
+
+	if dbt.Config.Dbt.TrustStore == "" {
+		return unsetUrlResult(result, "dbt.truststore")
+	}
+
+	err := dbt.FetchTrustStore(homedir)
+	if err != nil {
+		result.Message = err.Error()
+		result.Remediation = fmt.Sprintf("Check the dbt.truststore url %s in your config.", dbt.Config.Dbt.TrustStore)
+		return result
+	}
+
+	truststore, err := os.Open(TruststorePath(homedir))
+	if err != nil {
+		result.Message = fmt.Sprintf("failed to open truststore: %s", err)
+		result.Remediation = fmt.Sprintf("Make sure %s is readable.", TrustDir(homedir))
+		return result
+	}
+
+	defer truststore.Close()
+
+	entities, err := openpgp.ReadArmoredKeyRing(truststore)
+	if err != nil || len(entities) == 0 {
+		result.Message = fmt.Sprintf("truststore from %s contains no usable public keys", dbt.Config.Dbt.TrustStore)
+		result.Remediation = "Publish the armored public keys of your signers to the truststore."
+		return result
+	}
+
+	result.Passed = true
+	result.Message = fmt.Sprintf("fetched %s", dbt.Config.Dbt.TrustStore)
+
+	return result
+}
+
+func (dbt *DBT) doctorTools() (result DiagnosticResult) {
+	result.Name = "tools"
+
+	if dbt.Config.Tools.Repo == "" {
+		return unsetUrlResult(result, "tools.repository")
+	}
+
+	tools, err := dbt.FetchToolNames()
+	if err != nil {
+		result.Message = err.Error()
+		result.Remediation = fmt.Sprintf("Check the tools.repository url %s in your config, and your credentials.", dbt.Config.Tools.Repo)
+		return result
+	}
+
+	if len(tools) == 0 {
+		result.Message = fmt.Sprintf("no tools found in %s", dbt.Config.Tools.Repo)
+		result.Remediation = "Publish at least one tool to the tools repository."
+		return result
+	}
+
+	result.Passed = true
+	result.Message = fmt.Sprintf("%d tools available", len(tools))
+
+	return result
+}
+
+// unsetUrlResult fails a network check that can't even be attempted without a url
+func unsetUrlResult(result DiagnosticResult, field string) DiagnosticResult {
+	result.Message = fmt.Sprintf("%s is not set", field)
+	result.Remediation = fmt.Sprintf("Set %s in your config file or environment.", field)
+
+	return result
+}
@@ -0,0 +1,66 @@
+package dbt
+
+import (
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestDoctor(t *testing.T) {
+	inputs := []struct {
+		name    string
+		obj     *DBT
+		homedir string
+		passed  map[string]bool
+	}{
+		{
+			"reposerver",
+			&DBT{
+				Config:  dbtConfig,
+				Verbose: true,
+			},
+			homeDirRepoServer,
+			map[string]bool{
+				"config file": true,
+				"config":      true,
+				"directories": true,
+				"repository":  true,
+				"truststore":  true,
+				"tools":       true,
+			},
+		},
+		{
+			"empty",
+			&DBT{
+				Config:  Config{},
+				Verbose: true,
+			},
+			fmt.Sprintf("%s/homeDirDoctor", tmpDir),
+			map[string]bool{
+				"config file": false,
+				"config":      false,
+				"directories": false,
+				"repository":  false,
+				"truststore":  false,
+				"tools":       false,
+			},
+		},
+	}
+
+	for _, tc := range inputs {
+		t.Run(tc.name, func(t *testing.T) {
+			results := tc.obj.Doctor(tc.homedir)
+
+			actual := make(map[string]bool)
+			for _, result := range results {
+				actual[result.Name] = result.Passed
+
+				if !result.Passed {
+					assert.NotEmpty(t, result.Remediation, "Failed check %q has a remediation hint.", result.Name)
+				}
+			}
+
+			assert.Equal(t, tc.passed, actual, "Diagnostic results meet expectations.")
+		})
+	}
+}
@@ -0,0 +1,48 @@
+// Copyright © 2019 Nik Ogura <nik.ogura@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package dbt
+
+import (
+	"github.com/pkg/errors"
+)
+
+// The errors below are at the root of the errors dbt returns for the failures callers most often want to handle.  Check for them with errors.Is(), or errors.Cause() from github.com/pkg/errors.
+
+// ErrToolNotFound the tool isn't in the repo, or when offline, hasn't been downloaded
+var ErrToolNotFound = errors.New("tool not found")
+
+// ErrVersionNotFound the tool is there, but not the version asked for
+var ErrVersionNotFound = errors.New("version not found")
+
+// ErrChecksumMismatch a file doesn't match its checksum
+var ErrChecksumMismatch = errors.New("checksum mismatch")
+
+// ErrSignatureMismatch a file's signature doesn't verify against the truststore
+var ErrSignatureMismatch = errors.New("signature mismatch")
+
+// ErrRepoUnreachable the repo couldn't be reached at all.  A repo that answers with an error status is reachable.
+var ErrRepoUnreachable = errors.New("repo unreachable")
+
+// ErrDependencyCycle a tool's dependencies lead back to itself
+var ErrDependencyCycle = errors.New("dependency cycle")
+
+// ErrVersionBelowMinimum the tool's policy in the repo says the version is too old to run
+var ErrVersionBelowMinimum = errors.New("version below minimum")
+
+// ErrRunVetoed the pre-run hook failed, so the tool wasn't run
+var ErrRunVetoed = errors.New("run vetoed by pre-run hook")
+
+// ErrFuncTimeout a getter function, such as a usernamefunc, didn't finish in time
+var ErrFuncTimeout = errors.New("getter function timed out")
@@ -0,0 +1,135 @@
+// Copyright © 2019 Nik Ogura <nik.ogura@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package dbt
+
+import (
+	"fmt"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"runtime"
+	"testing"
+)
+
+func TestErrorTypes(t *testing.T) {
+	repoRoot, config, _ := newTestSignedRepo(t, "foo", "1.0.0", "2.0.0")
+
+	homedir, err := ioutil.TempDir("", "dbt-errors")
+	if err != nil {
+		t.Fatalf("Error creating temp dir: %s", err)
+	}
+
+	defer os.RemoveAll(homedir)
+
+	err = makeStagingDbtDir(homedir, false)
+	if err != nil {
+		t.Fatalf("Error creating dbt dir: %s", err)
+	}
+
+	obj := &DBT{Config: config, Logger: log.New(ioutil.Discard, "", 0)}
+
+	err = obj.FetchTrustStore(homedir)
+	if err != nil {
+		t.Fatalf("Error fetching truststore: %s", err)
+	}
+
+	_, err = obj.FetchTool("foo", "1.0.0", homedir)
+	if err != nil {
+		t.Fatalf("Error fetching tool: %s", err)
+	}
+
+	// the cached 1.0.0 no longer matches its checksum
+	err = ioutil.WriteFile(CachedToolPath(homedir, "foo", "1.0.0"), []byte("#!/bin/sh\necho pwned\n"), 0755)
+	if err != nil {
+		t.Fatalf("Error tampering with cached tool: %s", err)
+	}
+
+	// 2.0.0 in the repo has been replaced, checksum and all, but not the signature
+	repoTool := fmt.Sprintf("%s/dbt-tools/foo/2.0.0/%s/%s/%s", repoRoot, runtime.GOOS, runtime.GOARCH, ToolFileName("foo", runtime.GOOS))
+	err = ioutil.WriteFile(repoTool, []byte("#!/bin/sh\necho pwned\n"), 0755)
+	if err != nil {
+		t.Fatalf("Error tampering with repo tool: %s", err)
+	}
+
+	checksum, err := FileSha256(repoTool)
+	if err != nil {
+		t.Fatalf("Error checksumming repo tool: %s", err)
+	}
+
+	err = ioutil.WriteFile(fmt.Sprintf("%s.sha256", repoTool), []byte(checksum), 0644)
+	if err != nil {
+		t.Fatalf("Error writing repo checksum: %s", err)
+	}
+
+	gone := httptest.NewServer(http.NotFoundHandler())
+	gone.Close()
+
+	unreachable := &DBT{Config: Config{Tools: ToolsConfig{Repo: fmt.Sprintf("%s/dbt-tools", gone.URL)}}}
+
+	inputs := []struct {
+		name     string
+		run      func() error
+		expected error
+	}{
+		{
+			"tool not in repo",
+			func() error { _, err := obj.FetchTool("bar", "", homedir); return err },
+			ErrToolNotFound,
+		},
+		{
+			"version not in repo",
+			func() error { _, err := obj.FetchTool("foo", "9.9.9", homedir); return err },
+			ErrVersionNotFound,
+		},
+		{
+			"tool not downloaded",
+			func() error { return obj.RunTool("", []string{"bar"}, homedir, true) },
+			ErrToolNotFound,
+		},
+		{
+			"version not downloaded",
+			func() error { return obj.RunTool("9.9.9", []string{"foo"}, homedir, true) },
+			ErrVersionNotFound,
+		},
+		{
+			"checksum mismatch",
+			func() error { return obj.RunTool("1.0.0", []string{"foo"}, homedir, true) },
+			ErrChecksumMismatch,
+		},
+		{
+			"signature mismatch",
+			func() error { _, err := obj.FetchTool("foo", "2.0.0", homedir); return err },
+			ErrSignatureMismatch,
+		},
+		{
+			"repo unreachable",
+			func() error { _, err := unreachable.FindLatestVersion("foo"); return err },
+			ErrRepoUnreachable,
+		},
+	}
+
+	for _, tc := range inputs {
+		t.Run(tc.name, func(t *testing.T) {
+			err := tc.run()
+
+			assert.True(t, errors.Is(err, tc.expected), "Error %q is %q.", err, tc.expected)
+			assert.Equal(t, tc.expected, errors.Cause(err), "Error's cause is %q.", tc.expected)
+		})
+	}
+}
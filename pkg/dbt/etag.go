@@ -0,0 +1,95 @@
+// Copyright © 2019 Nik Ogura <nik.ogura@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package dbt
+
+import (
+	"fmt"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/pkg/errors"
+	"io/ioutil"
+	"os"
+	"strings"
+)
+
+// ETAG_SUFFIX the suffix of the sidecar file holding the ETag a fetched file was served with.  The next fetch of the same file sends it back as If-None-Match, and keeps the file if the server says it's unchanged.
+const ETAG_SUFFIX = ".etag"
+
+// storedETag returns the ETag destPath was last fetched with, or "" if there isn't one.  The sidecar also records the file's size and modification time, so a file changed since it was fetched is never mistaken for what the server sent, without reading it all to tell.
+func storedETag(destPath string) (etag string) {
+	content, err := ioutil.ReadFile(fmt.Sprintf("%s%s", destPath, ETAG_SUFFIX))
+	if err != nil {
+		return etag
+	}
+
+	lines := strings.Split(strings.TrimSpace(string(content)), "\n")
+	if len(lines) != 2 {
+		return etag
+	}
+
+	info, err := os.Stat(destPath)
+	if err != nil || etagFileStamp(info) != lines[1] {
+		return etag
+	}
+
+	return lines[0]
+}
+
+// storeETag records the ETag destPath was fetched with.  An empty ETag removes any stale sidecar instead.
+func storeETag(destPath string, etag string) (err error) {
+	sidecar := fmt.Sprintf("%s%s", destPath, ETAG_SUFFIX)
+
+	if etag == "" {
+		err = os.Remove(sidecar)
+		if os.IsNotExist(err) {
+			err = nil
+		}
+
+		return err
+	}
+
+	info, err := os.Stat(destPath)
+	if err != nil {
+		err = errors.Wrapf(err, "failed to stat %s", destPath)
+		return err
+	}
+
+	err = ioutil.WriteFile(sidecar, []byte(fmt.Sprintf("%s\n%s\n", etag, etagFileStamp(info))), 0644)
+	if err != nil {
+		err = errors.Wrapf(err, "failed to write %s", sidecar)
+		return err
+	}
+
+	return err
+}
+
+// etagFileStamp the size and modification time of a file, which is what ties an ETag sidecar to the file it was fetched as
+func etagFileStamp(info os.FileInfo) (stamp string) {
+	return fmt.Sprintf("%d %d", info.Size(), info.ModTime().UnixNano())
+}
+
+// S3FileETag returns the ETag of an object in S3.
+func (dbt *DBT) S3FileETag(meta S3Meta) (etag string, err error) {
+	fileMeta, err := dbt.S3Client.HeadObject(dbt.RequestContext(), &s3.HeadObjectInput{
+		Bucket: aws.String(meta.Bucket),
+		Key:    aws.String(meta.Key),
+	}, dbt.s3Options(meta)...)
+	if err != nil {
+		err = errors.Wrapf(err, "failed to get metadata for %s", meta.Key)
+		return etag, err
+	}
+
+	return aws.ToString(fileMeta.ETag), err
+}
@@ -0,0 +1,147 @@
+// Copyright © 2019 Nik Ogura <nik.ogura@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package dbt
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+)
+
+func TestFetchFileETag(t *testing.T) {
+	content := "version one"
+	etag := `"1"`
+	downloads := 0
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("ETag", etag)
+
+		if r.Header.Get("If-None-Match") == etag {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+
+		if r.Method == http.MethodGet {
+			downloads++
+		}
+
+		_, _ = w.Write([]byte(content))
+	}))
+
+	defer server.Close()
+
+	bucket := "dbt-tools"
+	s3Server, fakeClient := newFakeS3(t, bucket)
+	defer s3Server.Close()
+
+	putObject := func(body string) {
+		_, err := fakeClient.PutObject(context.Background(), &s3.PutObjectInput{
+			Bucket: aws.String(bucket),
+			Key:    aws.String("foo/description.txt"),
+			Body:   bytes.NewReader([]byte(body)),
+		})
+		if err != nil {
+			t.Fatalf("Failed putting object: %s", err)
+		}
+	}
+
+	putObject(content)
+
+	tmpDir, err := ioutil.TempDir("", "dbt-etag")
+	if err != nil {
+		t.Fatalf("Failed creating temp dir: %s", err)
+	}
+
+	defer os.RemoveAll(tmpDir)
+
+	httpPath := fmt.Sprintf("%s/http.txt", tmpDir)
+	s3Path := fmt.Sprintf("%s/s3.txt", tmpDir)
+
+	inputs := []struct {
+		name      string
+		before    func()
+		downloads int
+		content   string
+	}{
+		{
+			"first fetch",
+			nil,
+			1,
+			"version one",
+		},
+		{
+			"unchanged",
+			nil,
+			1,
+			"version one",
+		},
+		{
+			"local file changed",
+			func() {
+				_ = ioutil.WriteFile(httpPath, []byte("tampered"), 0644)
+				_ = ioutil.WriteFile(s3Path, []byte("tampered"), 0644)
+			},
+			2,
+			"version one",
+		},
+		{
+			"remote file changed",
+			func() {
+				content = "version two"
+				etag = `"2"`
+				putObject(content)
+			},
+			3,
+			"version two",
+		},
+	}
+
+	for _, tc := range inputs {
+		t.Run(tc.name, func(t *testing.T) {
+			if tc.before != nil {
+				tc.before()
+			}
+
+			dbtObj := &DBT{S3Client: fakeClient}
+
+			err := dbtObj.FetchFile(fmt.Sprintf("%s/file.txt", server.URL), httpPath)
+			if err != nil {
+				t.Fatalf("Failed fetching file: %s", err)
+			}
+
+			actual, _ := ioutil.ReadFile(httpPath)
+
+			assert.Equal(t, tc.downloads, downloads, "File is only downloaded when it changes.")
+			assert.Equal(t, tc.content, string(actual), "Fetched file is current.")
+
+			err = dbtObj.FetchFile(fmt.Sprintf("https://%s.s3.us-east-1.amazonaws.com/foo/description.txt", bucket), s3Path)
+			if err != nil {
+				t.Fatalf("Failed fetching file from s3: %s", err)
+			}
+
+			actual, _ = ioutil.ReadFile(s3Path)
+
+			assert.Equal(t, tc.content, string(actual), "File fetched from S3 is current.")
+		})
+	}
+}
@@ -0,0 +1,66 @@
+// Copyright © 2019 Nik Ogura <nik.ogura@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !windows
+// +build !windows
+
+package dbt
+
+import (
+	"os"
+	"os/exec"
+	"os/signal"
+	"syscall"
+)
+
+// execTool replaces the running process with the tool, so signals and exit codes belong to the tool alone.
+func execTool(localPath string, args []string, env []string) (err error) {
+	return syscall.Exec(localPath, args, env)
+}
+
+// spawnTool runs the tool as a child process and waits for it, for when dbt has more to do once it's done.  The tool shares dbt's process group, so a Ctrl-C or Ctrl-\ at the terminal already reaches it.  dbt just swallows those while it waits, rather than passing on a second copy.  SIGTERM and SIGHUP are usually sent to dbt alone, so they're passed on to the tool, which can stop in its own way, as it would if it had been exec'd.  A non-zero exit comes back as an *exec.ExitError.
+func spawnTool(localPath string, args []string, env []string) (err error) {
+	cmd := exec.Command(localPath)
+	cmd.Args = args
+	cmd.Env = env
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+
+	// Caught rather than ignored, since an ignored signal stays ignored in the child.
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM, syscall.SIGHUP, syscall.SIGQUIT)
+
+	defer func() {
+		signal.Stop(signals)
+		close(signals)
+	}()
+
+	err = cmd.Start()
+	if err != nil {
+		return err
+	}
+
+	go func() {
+		for sig := range signals {
+			if sig == syscall.SIGTERM || sig == syscall.SIGHUP {
+				_ = cmd.Process.Signal(sig)
+			}
+		}
+	}()
+
+	err = cmd.Wait()
+
+	return err
+}
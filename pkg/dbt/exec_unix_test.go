@@ -0,0 +1,83 @@
+// Copyright © 2019 Nik Ogura <nik.ogura@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !windows
+// +build !windows
+
+package dbt
+
+import (
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestSpawnToolSignals(t *testing.T) {
+	dir := t.TempDir()
+	toolPath := filepath.Join(dir, "foo")
+	logPath := filepath.Join(dir, "signals.log")
+	readyPath := filepath.Join(dir, "ready")
+
+	// logs every signal it gets, and stops on SIGTERM
+	script := `#!/bin/sh
+trap 'echo INT >> "$1"' INT
+trap 'echo QUIT >> "$1"' QUIT
+trap 'echo TERM >> "$1"; exit 0' TERM
+touch "$2"
+while true; do sleep 0.05; done
+`
+
+	err := ioutil.WriteFile(toolPath, []byte(script), 0755)
+	if err != nil {
+		t.Fatalf("Failed writing tool: %s", err)
+	}
+
+	done := make(chan error, 1)
+
+	go func() {
+		done <- spawnTool(toolPath, []string{"foo", logPath, readyPath}, os.Environ())
+	}()
+
+	for i := 0; i < 100; i++ {
+		if _, err := os.Stat(readyPath); err == nil {
+			break
+		}
+
+		time.Sleep(50 * time.Millisecond)
+	}
+
+	// Sent to dbt alone, as a terminal would send them to the whole process group, so the tool has them already.
+	_ = syscall.Kill(os.Getpid(), syscall.SIGINT)
+	_ = syscall.Kill(os.Getpid(), syscall.SIGQUIT)
+
+	time.Sleep(200 * time.Millisecond)
+
+	_ = syscall.Kill(os.Getpid(), syscall.SIGTERM)
+
+	select {
+	case err = <-done:
+		assert.NoError(t, err, "Tool stops cleanly on SIGTERM.")
+	case <-time.After(10 * time.Second):
+		t.Fatalf("Tool never got SIGTERM")
+	}
+
+	signalLog, _ := ioutil.ReadFile(logPath)
+
+	assert.Equal(t, "TERM", strings.TrimSpace(string(signalLog)), "Only SIGTERM is passed on to the tool.")
+}
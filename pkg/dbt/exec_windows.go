@@ -0,0 +1,41 @@
+// Copyright © 2019 Nik Ogura <nik.ogura@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build windows
+// +build windows
+
+package dbt
+
+import (
+	"os"
+	"os/exec"
+)
+
+// execTool runs the tool as a child process and waits for it.  Windows has no exec(), so this is as close as we can get.  A non-zero exit comes back as an *exec.ExitError, for the caller to exit with the same code.
+func execTool(localPath string, args []string, env []string) (err error) {
+	cmd := exec.Command(localPath, args[1:]...)
+	cmd.Env = env
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+
+	err = cmd.Run()
+
+	return err
+}
+
+// spawnTool runs the tool as a child process, same as execTool.
+func spawnTool(localPath string, args []string, env []string) (err error) {
+	return execTool(localPath, args, env)
+}
@@ -0,0 +1,88 @@
+// Copyright © 2019 Nik Ogura <nik.ogura@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package dbt
+
+import (
+	"fmt"
+	"github.com/pkg/errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+)
+
+// HOOK_PRE_RUN the phase a pre-run hook is told it's in
+const HOOK_PRE_RUN = "pre-run"
+
+// HOOK_POST_RUN the phase a post-run hook is told it's in
+const HOOK_POST_RUN = "post-run"
+
+// DBT_HOOK_ENV_VAR Env var telling a hook which phase it's running in, HOOK_PRE_RUN or HOOK_POST_RUN
+const DBT_HOOK_ENV_VAR = "DBT_HOOK"
+
+// DBT_HOOK_TOOL_ENV_VAR Env var telling a hook the name of the tool being run
+const DBT_HOOK_TOOL_ENV_VAR = "DBT_TOOL"
+
+// DBT_HOOK_VERSION_ENV_VAR Env var telling a hook the version of the tool being run
+const DBT_HOOK_VERSION_ENV_VAR = "DBT_TOOL_VERSION"
+
+// DBT_HOOK_PATH_ENV_VAR Env var telling a hook where the tool being run is cached
+const DBT_HOOK_PATH_ENV_VAR = "DBT_TOOL_PATH"
+
+// DBT_HOOK_EXIT_CODE_ENV_VAR Env var telling a post-run hook the tool's exit code.  -1 if it couldn't be run at all.
+const DBT_HOOK_EXIT_CODE_ENV_VAR = "DBT_TOOL_EXIT_CODE"
+
+// runHook runs a pre or post run hook, if one's configured.  The hook gets the tool's name and args as its own args, and the details of the run in its environment.  Its output goes to stderr, so it doesn't mix with what the tool writes to stdout.
+func (dbt *DBT) runHook(phase string, hook string, localPath string, args []string, exitCode int) (err error) {
+	if hook == "" {
+		return err
+	}
+
+	cmd := exec.Command(hook, args...)
+	cmd.Stdout = os.Stderr
+	cmd.Stderr = os.Stderr
+	cmd.Env = append(os.Environ(),
+		fmt.Sprintf("%s=%s", DBT_HOOK_ENV_VAR, phase),
+		fmt.Sprintf("%s=%s", DBT_HOOK_TOOL_ENV_VAR, args[0]),
+		fmt.Sprintf("%s=%s", DBT_HOOK_VERSION_ENV_VAR, filepath.Base(filepath.Dir(localPath))),
+		fmt.Sprintf("%s=%s", DBT_HOOK_PATH_ENV_VAR, localPath),
+	)
+
+	if phase == HOOK_POST_RUN {
+		cmd.Env = append(cmd.Env, fmt.Sprintf("%s=%d", DBT_HOOK_EXIT_CODE_ENV_VAR, exitCode))
+	}
+
+	dbt.VerboseOutput("Running %s hook %s", phase, hook)
+
+	err = cmd.Run()
+	if err != nil {
+		err = errors.Wrapf(err, "%s hook %s failed", phase, hook)
+		return err
+	}
+
+	return err
+}
+
+// runExitCode works out the exit code to tell a post-run hook from what running the tool returned.
+func runExitCode(err error) (code int) {
+	if err == nil {
+		return 0
+	}
+
+	if code, ok := ToolExitCode(err); ok {
+		return code
+	}
+
+	return -1
+}
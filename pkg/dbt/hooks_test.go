@@ -0,0 +1,143 @@
+package dbt
+
+import (
+	"fmt"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// writeTestHook writes a hook that records its phase, environment and args to logPath, then exits with code
+func writeTestHook(t *testing.T, dir string, name string, logPath string, code int) (hookPath string) {
+	hookPath = filepath.Join(dir, name)
+	script := fmt.Sprintf("#!/bin/sh\necho \"$%s $%s $%s $%s $*\" >> %s\nexit %d\n", DBT_HOOK_ENV_VAR, DBT_HOOK_TOOL_ENV_VAR, DBT_HOOK_VERSION_ENV_VAR, DBT_HOOK_EXIT_CODE_ENV_VAR, logPath, code)
+
+	err := ioutil.WriteFile(hookPath, []byte(script), 0755)
+	if err != nil {
+		t.Fatalf("Failed writing hook: %s", err)
+	}
+
+	return hookPath
+}
+
+func TestRunToolHooks(t *testing.T) {
+	_, config, _ := newTestSignedRepo(t, "foo", "1.0.0")
+
+	homedir := t.TempDir()
+
+	err := makeStagingDbtDir(homedir, false)
+	if err != nil {
+		t.Fatalf("Failed creating dbt dir: %s", err)
+	}
+
+	testExec = true
+	defer func() { testExec = false }()
+
+	_ = os.Setenv(HELPER_PROCESS_ENV_VAR, "1")
+	defer os.Unsetenv(HELPER_PROCESS_ENV_VAR)
+
+	inputs := []struct {
+		name     string
+		pre      int
+		post     int
+		code     string
+		log      []string
+		expected error
+	}{
+		{"success", 0, 0, "0", []string{"pre-run foo 1.0.0  foo exit 0", "post-run foo 1.0.0 0 foo exit 0"}, nil},
+		{"tool fails", 0, 0, "3", []string{"pre-run foo 1.0.0  foo exit 3", "post-run foo 1.0.0 3 foo exit 3"}, nil},
+		{"vetoed", 1, 0, "0", []string{"pre-run foo 1.0.0  foo exit 0"}, ErrRunVetoed},
+		{"post hook fails", 0, 1, "0", []string{"pre-run foo 1.0.0  foo exit 0", "post-run foo 1.0.0 0 foo exit 0"}, nil},
+	}
+
+	for _, tc := range inputs {
+		t.Run(tc.name, func(t *testing.T) {
+			hookDir := t.TempDir()
+			logPath := filepath.Join(hookDir, "hooks.log")
+
+			tcConfig := config
+			tcConfig.PreRunHook = writeTestHook(t, hookDir, "pre", logPath, tc.pre)
+			tcConfig.PostRunHook = writeTestHook(t, hookDir, "post", logPath, tc.post)
+
+			obj := &DBT{
+				Config: tcConfig,
+				Logger: log.New(ioutil.Discard, "", 0),
+			}
+
+			err := obj.FetchTrustStore(homedir)
+			if err != nil {
+				t.Fatalf("Failed fetching truststore: %s", err)
+			}
+
+			err = obj.RunTool("", []string{"foo", "exit", tc.code}, homedir, false)
+
+			switch {
+			case tc.expected != nil:
+				if assert.Error(t, err, "Tool doesn't run.") {
+					assert.True(t, errors.Is(err, tc.expected), "Error %q is %q.", err, tc.expected)
+				}
+			case tc.code != "0":
+				code, ok := ToolExitCode(err)
+				assert.True(t, ok, "Failed tool returns its exit code.")
+				assert.Equal(t, tc.code, fmt.Sprintf("%d", code), "Exit code is the tool's, not the hook's.")
+			default:
+				assert.NoError(t, err, "Tool runs.")
+			}
+
+			hookLog, err := ioutil.ReadFile(logPath)
+			if err != nil {
+				t.Fatalf("Failed reading hook log: %s", err)
+			}
+
+			assert.Equal(t, tc.log, strings.Split(strings.TrimSpace(string(hookLog)), "\n"), "Hooks ran with the details of the run.")
+		})
+	}
+
+	// a pre-run hook that can't be run at all is a veto too
+	obj := &DBT{
+		Config: Config{PreRunHook: filepath.Join(homedir, "nonesuch")},
+		Logger: log.New(ioutil.Discard, "", 0),
+	}
+
+	err = obj.runExec(CachedToolPath(homedir, "foo", "1.0.0"), []string{"foo"}, false)
+	assert.True(t, errors.Is(err, ErrRunVetoed), "Missing pre-run hook stops the run.")
+}
+
+func TestSpawnTool(t *testing.T) {
+	dir := t.TempDir()
+	toolPath := filepath.Join(dir, "foo")
+
+	err := ioutil.WriteFile(toolPath, []byte("#!/bin/sh\nexit $1\n"), 0755)
+	if err != nil {
+		t.Fatalf("Failed writing tool: %s", err)
+	}
+
+	inputs := []struct {
+		name string
+		code int
+	}{
+		{"success", 0},
+		{"exit 3", 3},
+	}
+
+	for _, tc := range inputs {
+		t.Run(tc.name, func(t *testing.T) {
+			err := spawnTool(toolPath, []string{"foo", fmt.Sprintf("%d", tc.code)}, os.Environ())
+			if tc.code == 0 {
+				assert.NoError(t, err, "Tool runs.")
+				return
+			}
+
+			exitErr, ok := err.(*exec.ExitError)
+			if assert.True(t, ok, "Failed tool returns an exit error.") {
+				assert.Equal(t, tc.code, exitErr.ExitCode(), "Exit code is the tool's.")
+			}
+		})
+	}
+}
@@ -0,0 +1,90 @@
+// Copyright © 2019 Nik Ogura <nik.ogura@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package dbt
+
+import (
+	"fmt"
+	"github.com/pkg/errors"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// CACHE_LOCK_FILE the lock file in a tool's cache dir, held while the tool is checked and downloaded
+const CACHE_LOCK_FILE = ".lock"
+
+// CACHE_LOCK_TIMEOUT how long to wait for another dbt to finish with a tool before giving up
+const CACHE_LOCK_TIMEOUT = 5 * time.Minute
+
+// UPGRADE_LOCK_SUFFIX appended to the path of the dbt binary to make the lock file held while it's upgraded
+const UPGRADE_LOCK_SUFFIX = ".lock"
+
+// UPGRADE_LOCK_TIMEOUT how long to wait for another dbt to finish upgrading before giving up
+const UPGRADE_LOCK_TIMEOUT = 5 * time.Minute
+
+// lockPollInterval how often to try for a lock someone else holds
+var lockPollInterval = 100 * time.Millisecond
+
+// lockCachedTool takes the lock on a tool's cache dir, so concurrent runs of dbt don't download the same tool over each other.  Call unlock when done.
+func lockCachedTool(homedir string, toolName string) (unlock func(), err error) {
+	lockPath := filepath.Join(CachedToolDir(homedir, toolName), CACHE_LOCK_FILE)
+
+	return lockFile(lockPath, CACHE_LOCK_TIMEOUT)
+}
+
+// lockFile takes an advisory lock on lockPath, creating it if need be, waiting up to timeout for whoever holds it.  The OS drops the lock if the holder dies, so it's only a live but stuck process that can make this time out.
+func lockFile(lockPath string, timeout time.Duration) (unlock func(), err error) {
+	err = os.MkdirAll(filepath.Dir(lockPath), 0755)
+	if err != nil {
+		err = errors.Wrapf(err, "failed to create dir for %s", lockPath)
+		return unlock, err
+	}
+
+	f, err := os.OpenFile(lockPath, os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil {
+		err = errors.Wrapf(err, "failed to open lock file %s", lockPath)
+		return unlock, err
+	}
+
+	deadline := time.Now().Add(timeout)
+
+	for {
+		locked, err := tryLockFile(f)
+		if err != nil {
+			_ = f.Close()
+			err = errors.Wrapf(err, "failed to lock %s", lockPath)
+			return unlock, err
+		}
+
+		if locked {
+			break
+		}
+
+		if time.Now().After(deadline) {
+			_ = f.Close()
+			err = fmt.Errorf("timed out after %s waiting for another dbt to release %s", timeout, lockPath)
+			return unlock, err
+		}
+
+		time.Sleep(lockPollInterval)
+	}
+
+	unlock = func() {
+		_ = unlockFile(f)
+		_ = f.Close()
+	}
+
+	return unlock, err
+}
@@ -0,0 +1,183 @@
+// Copyright © 2019 Nik Ogura <nik.ogura@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package dbt
+
+import (
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"log"
+	"os"
+	"runtime"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestLockFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "dbt-lock")
+	if err != nil {
+		t.Fatalf("Error creating temp dir: %s", err)
+	}
+
+	defer os.RemoveAll(dir)
+
+	lockPath := fmt.Sprintf("%s/foo/%s", dir, CACHE_LOCK_FILE)
+
+	unlock, err := lockFile(lockPath, time.Second)
+	if err != nil {
+		t.Fatalf("Error taking lock: %s", err)
+	}
+
+	_, err = lockFile(lockPath, 200*time.Millisecond)
+	assert.Error(t, err, "A held lock times out.")
+
+	// a waiter gets the lock as soon as it's released
+	acquired := make(chan error)
+	go func() {
+		unlockAgain, err := lockFile(lockPath, 5*time.Second)
+		if err == nil {
+			unlockAgain()
+		}
+
+		acquired <- err
+	}()
+
+	time.Sleep(200 * time.Millisecond)
+	unlock()
+
+	assert.NoError(t, <-acquired, "Released lock is taken by the waiter.")
+}
+
+func TestFetchToolConcurrently(t *testing.T) {
+	_, config, _ := newTestSignedRepo(t, "foo", "1.0.0")
+
+	homedir, err := ioutil.TempDir("", "dbt-lock")
+	if err != nil {
+		t.Fatalf("Error creating temp dir: %s", err)
+	}
+
+	defer os.RemoveAll(homedir)
+
+	err = makeStagingDbtDir(homedir, false)
+	if err != nil {
+		t.Fatalf("Error creating dbt dir: %s", err)
+	}
+
+	obj := &DBT{Config: config, Logger: log.New(ioutil.Discard, "", 0)}
+
+	err = obj.FetchTrustStore(homedir)
+	if err != nil {
+		t.Fatalf("Error fetching truststore: %s", err)
+	}
+
+	var wg sync.WaitGroup
+	errs := make([]error, 8)
+
+	for i := range errs {
+		wg.Add(1)
+
+		go func(i int) {
+			defer wg.Done()
+			_, errs[i] = obj.FetchTool("foo", "1.0.0", homedir)
+		}(i)
+	}
+
+	wg.Wait()
+
+	for _, err := range errs {
+		assert.NoError(t, err, "Concurrent fetches all succeed.")
+	}
+
+	_, err = obj.verifyTool(homedir, "foo", "1.0.0")
+	assert.NoError(t, err, "Cached tool verifies.")
+}
+
+func TestUpgradeInPlaceLocked(t *testing.T) {
+	repoRoot, config, signer := newTestSignedRepo(t, "foo")
+
+	newDbt := "#!/bin/sh\necho new dbt\n"
+	repoBinary := fmt.Sprintf("%s/dbt/9.9.9/%s/%s/dbt", repoRoot, runtime.GOOS, runtime.GOARCH)
+	writeTestSignedTool(t, signer, repoBinary, newDbt)
+
+	dir, err := ioutil.TempDir("", "dbt-upgrade-lock")
+	if err != nil {
+		t.Fatalf("Error creating temp dir: %s", err)
+	}
+
+	defer os.RemoveAll(dir)
+
+	binaryPath := fmt.Sprintf("%s/dbt", dir)
+
+	err = ioutil.WriteFile(binaryPath, []byte("#!/bin/sh\necho old dbt\n"), 0755)
+	if err != nil {
+		t.Fatalf("Error writing binary: %s", err)
+	}
+
+	obj := &DBT{Config: config, Logger: log.New(ioutil.Discard, "", 0)}
+
+	var wg sync.WaitGroup
+	errs := make([]error, 4)
+
+	for i := range errs {
+		wg.Add(1)
+
+		go func(i int) {
+			defer wg.Done()
+			errs[i] = obj.UpgradeInPlace(binaryPath)
+		}(i)
+	}
+
+	wg.Wait()
+
+	for _, err := range errs {
+		assert.NoError(t, err, "Concurrent upgrades all succeed.")
+	}
+
+	actual, _ := ioutil.ReadFile(binaryPath)
+	assert.Equal(t, newDbt, string(actual), "Binary is upgraded.")
+
+	// whoever waits on the lock finds the binary already upgraded, and has nothing to download
+	err = ioutil.WriteFile(binaryPath, []byte("#!/bin/sh\necho old dbt\n"), 0755)
+	if err != nil {
+		t.Fatalf("Error writing binary: %s", err)
+	}
+
+	unlock, err := lockFile(binaryPath+UPGRADE_LOCK_SUFFIX, time.Second)
+	if err != nil {
+		t.Fatalf("Error taking lock: %s", err)
+	}
+
+	done := make(chan error)
+	go func() {
+		done <- obj.UpgradeInPlace(binaryPath)
+	}()
+
+	time.Sleep(200 * time.Millisecond)
+
+	err = ioutil.WriteFile(binaryPath, []byte(newDbt), 0755)
+	if err != nil {
+		t.Fatalf("Error writing binary: %s", err)
+	}
+
+	err = os.Remove(repoBinary)
+	if err != nil {
+		t.Fatalf("Error removing binary from repo: %s", err)
+	}
+
+	unlock()
+
+	assert.NoError(t, <-done, "Waiting upgrade finds the binary current.")
+}
@@ -0,0 +1,86 @@
+// Copyright © 2019 Nik Ogura <nik.ogura@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package dbt
+
+import (
+	"fmt"
+	"github.com/sirupsen/logrus"
+	"log"
+	"os"
+	"strings"
+)
+
+// DBT_LOG_FORMAT_ENV_VAR Env var that picks the format of dbt's log messages.  LOG_FORMAT_JSON for JSON lines, anything else for plain text.
+const DBT_LOG_FORMAT_ENV_VAR = "DBT_LOG_FORMAT"
+
+// LOG_FORMAT_JSON Log format for JSON lines, one object per message, for log aggregators.
+const LOG_FORMAT_JSON = "json"
+
+// LogFields what a log message is about.  Empty fields are left out.
+type LogFields struct {
+	Operation string
+	Tool      string
+	Version   string
+	Url       string
+}
+
+// NewStructuredLogger returns a logger writing JSON lines to stderr if DBT_LOG_FORMAT asks for them, and nil otherwise.
+func NewStructuredLogger() (logger *logrus.Logger) {
+	if !strings.EqualFold(os.Getenv(DBT_LOG_FORMAT_ENV_VAR), LOG_FORMAT_JSON) {
+		return logger
+	}
+
+	logger = logrus.New()
+	logger.SetOutput(os.Stderr)
+	logger.SetFormatter(&logrus.JSONFormatter{})
+	logger.SetLevel(logrus.DebugLevel)
+
+	return logger
+}
+
+// logEvent logs a message about an operation.  With a StructuredLogger, the fields go along with it, otherwise it's just the message, on Logger.
+func (dbt *DBT) logEvent(fields LogFields, message string, args ...interface{}) {
+	msg := fmt.Sprintf(message, args...)
+
+	if dbt.StructuredLogger != nil {
+		dbt.StructuredLogger.WithFields(fields.logrusFields()).Info(strings.TrimSpace(msg))
+		return
+	}
+
+	logger := dbt.Logger
+	if logger == nil {
+		logger = log.New(os.Stderr, "", 0)
+	}
+
+	logger.Print(msg)
+}
+
+// logrusFields converts LogFields to logrus.Fields, leaving out the empty ones.
+func (f LogFields) logrusFields() (fields logrus.Fields) {
+	fields = logrus.Fields{}
+
+	for key, value := range map[string]string{
+		"operation": f.Operation,
+		"tool":      f.Tool,
+		"version":   f.Version,
+		"url":       f.Url,
+	} {
+		if value != "" {
+			fields[key] = value
+		}
+	}
+
+	return fields
+}
@@ -0,0 +1,123 @@
+// Copyright © 2019 Nik Ogura <nik.ogura@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package dbt
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"log"
+	"os"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+func TestNewStructuredLogger(t *testing.T) {
+	inputs := []struct {
+		name   string
+		format string
+		json   bool
+	}{
+		{"unset", "", false},
+		{"text", "text", false},
+		{"json", "json", true},
+		{"JSON", "JSON", true},
+	}
+
+	for _, tc := range inputs {
+		t.Run(tc.name, func(t *testing.T) {
+			_ = os.Setenv(DBT_LOG_FORMAT_ENV_VAR, tc.format)
+			defer os.Unsetenv(DBT_LOG_FORMAT_ENV_VAR)
+
+			logger := NewStructuredLogger()
+
+			assert.Equal(t, tc.json, logger != nil, "Structured logger only exists for json.")
+		})
+	}
+}
+
+func TestFetchToolStructuredLogging(t *testing.T) {
+	_, config, _ := newTestSignedRepo(t, "foo", "1.0.0")
+
+	_ = os.Setenv(DBT_LOG_FORMAT_ENV_VAR, LOG_FORMAT_JSON)
+	defer os.Unsetenv(DBT_LOG_FORMAT_ENV_VAR)
+
+	inputs := []struct {
+		name string
+		json bool
+	}{
+		{"text", false},
+		{"json", true},
+	}
+
+	for _, tc := range inputs {
+		t.Run(tc.name, func(t *testing.T) {
+			homedir, err := ioutil.TempDir("", "dbt-logging")
+			if err != nil {
+				t.Fatalf("Error creating temp dir: %s", err)
+			}
+
+			defer os.RemoveAll(homedir)
+
+			err = makeStagingDbtDir(homedir, false)
+			if err != nil {
+				t.Fatalf("Error creating dbt dir: %s", err)
+			}
+
+			buf := &bytes.Buffer{}
+
+			obj := &DBT{Config: config, Logger: log.New(buf, "", 0)}
+
+			if tc.json {
+				obj.StructuredLogger = NewStructuredLogger()
+				obj.StructuredLogger.SetOutput(buf)
+			}
+
+			err = obj.FetchTrustStore(homedir)
+			if err != nil {
+				t.Fatalf("Error fetching truststore: %s", err)
+			}
+
+			_, err = obj.FetchTool("foo", "", homedir)
+			if err != nil {
+				t.Fatalf("Error fetching tool: %s", err)
+			}
+
+			if !tc.json {
+				assert.Equal(t, "Downloading binary tool \"foo\" version 1.0.0.\n", buf.String(), "Text log is just the message.")
+				return
+			}
+
+			lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+			assert.Equal(t, 1, len(lines), "One line per message.")
+
+			entry := make(map[string]interface{})
+
+			err = json.Unmarshal([]byte(lines[0]), &entry)
+			if err != nil {
+				t.Fatalf("Log line %q is not json: %s", lines[0], err)
+			}
+
+			assert.Equal(t, "Downloading binary tool \"foo\" version 1.0.0.", entry["msg"], "Message is logged.")
+			assert.Equal(t, "download", entry["operation"], "Operation is logged.")
+			assert.Equal(t, "foo", entry["tool"], "Tool is logged.")
+			assert.Equal(t, "1.0.0", entry["version"], "Version is logged.")
+			assert.True(t, strings.HasSuffix(entry["url"].(string), fmt.Sprintf("/dbt-tools/foo/1.0.0/%s/%s/%s", runtime.GOOS, runtime.GOARCH, ToolFileName("foo", runtime.GOOS))), "Url is logged.")
+		})
+	}
+}
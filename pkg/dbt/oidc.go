@@ -0,0 +1,585 @@
+// Copyright © 2019 Nik Ogura <nik.ogura@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package dbt
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/pkg/errors"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// OIDC_TOKEN_FILE the file OIDC tokens are cached in, by server.  Usually ~/.dbt/token.json
+const OIDC_TOKEN_FILE = "token.json"
+
+// OIDC_DEFAULT_TOKEN_KEY what the token for a config without servers is cached under
+const OIDC_DEFAULT_TOKEN_KEY = "default"
+
+// DEFAULT_OIDC_SCOPES the scopes requested if the config doesn't list any.  offline_access gets a refresh token, so logins last.
+const DEFAULT_OIDC_SCOPES = "openid offline_access"
+
+// OIDC_DEVICE_GRANT_TYPE the OAuth2 device authorization grant, RFC 8628
+const OIDC_DEVICE_GRANT_TYPE = "urn:ietf:params:oauth:grant-type:device_code"
+
+// DEFAULT_OIDC_POLL_INTERVAL_SECONDS how often to poll for the user finishing a device login, if the issuer doesn't say
+const DEFAULT_OIDC_POLL_INTERVAL_SECONDS = 5
+
+// OIDC_EXPIRY_LEEWAY tokens this close to expiring are treated as expired, so they don't run out mid request
+const OIDC_EXPIRY_LEEWAY = 30 * time.Second
+
+// oidcIntervalUnit what device login poll intervals and lifetimes are counted in.  Only ever changed by tests.
+var oidcIntervalUnit = time.Second
+
+// oidcInteractive reports whether someone's there to finish a device login, i.e. stdin is a terminal.  Swapped out by tests.
+var oidcInteractive = func() bool {
+	info, err := os.Stdin.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+// ErrNotLoggedIn is returned when a repo needs an OIDC login, and there isn't a usable one
+var ErrNotLoggedIn = errors.New("not logged in")
+
+// OIDCConfig where to log in, for repos behind OIDC auth.  Hosts are the hosts the login's token is sent to.  Without any, it's the hosts of the truststores, the dbt repo, and the tools repo.
+type OIDCConfig struct {
+	Issuer   string   `json:"issuer,omitempty" yaml:"issuer,omitempty"`
+	ClientId string   `json:"clientid,omitempty" yaml:"clientid,omitempty"`
+	Scopes   []string `json:"scopes,omitempty" yaml:"scopes,omitempty"`
+	Hosts    []string `json:"hosts,omitempty" yaml:"hosts,omitempty"`
+}
+
+// OIDCTokenFile the cached OIDC tokens, by server, so logins to different servers don't trample each other
+type OIDCTokenFile struct {
+	Servers map[string]OIDCToken `json:"servers"`
+}
+
+// OIDCToken a token from an OIDC login, as cached on disk
+type OIDCToken struct {
+	AccessToken  string    `json:"access_token"`
+	RefreshToken string    `json:"refresh_token,omitempty"`
+	IdToken      string    `json:"id_token,omitempty"`
+	TokenType    string    `json:"token_type,omitempty"`
+	Expiry       time.Time `json:"expiry,omitempty"`
+}
+
+// Valid returns true if the token has an access token that isn't about to expire.
+func (t OIDCToken) Valid() bool {
+	if t.AccessToken == "" {
+		return false
+	}
+
+	return t.Expiry.IsZero() || time.Now().Add(OIDC_EXPIRY_LEEWAY).Before(t.Expiry)
+}
+
+// oidcEndpoints the parts of an issuer's discovery document dbt needs
+type oidcEndpoints struct {
+	DeviceAuthorizationEndpoint string `json:"device_authorization_endpoint"`
+	TokenEndpoint               string `json:"token_endpoint"`
+}
+
+// deviceAuthorization an issuer's answer to starting a device login
+type deviceAuthorization struct {
+	DeviceCode              string `json:"device_code"`
+	UserCode                string `json:"user_code"`
+	VerificationUri         string `json:"verification_uri"`
+	VerificationUriComplete string `json:"verification_uri_complete"`
+	ExpiresIn               int    `json:"expires_in"`
+	Interval                int    `json:"interval"`
+}
+
+// tokenResponse an issuer's answer to a token request.  Error is set instead if it didn't work out.
+type tokenResponse struct {
+	AccessToken      string `json:"access_token"`
+	RefreshToken     string `json:"refresh_token"`
+	IdToken          string `json:"id_token"`
+	TokenType        string `json:"token_type"`
+	ExpiresIn        int    `json:"expires_in"`
+	Error            string `json:"error"`
+	ErrorDescription string `json:"error_description"`
+}
+
+// OIDCTokenPath returns the file OIDC tokens are cached in, by server.  Usually ~/.dbt/token.json
+func OIDCTokenPath(homedir string) string {
+	return fmt.Sprintf("%s/%s", dbtBaseDir(homedir, XDG_CONFIG_HOME_ENV_VAR), OIDC_TOKEN_FILE)
+}
+
+// OIDCEnabled returns true if the config says to log in with OIDC.
+func (dbt *DBT) OIDCEnabled() bool {
+	return dbt.Config.OIDC.Issuer != ""
+}
+
+// OIDCHost returns true if requests to the given url get the OIDC login's bearer token.  Hosts match as they do for CredentialsFor, by hostname:port or hostname.  Requests anywhere else, such as S3 or a mirror, never see the token.
+func (dbt *DBT) OIDCHost(u *url.URL) bool {
+	if !dbt.OIDCEnabled() || u == nil {
+		return false
+	}
+
+	hosts := dbt.Config.OIDC.Hosts
+
+	if len(hosts) == 0 {
+		for _, repo := range []string{dbt.Config.Dbt.TrustStore, dbt.Config.Dbt.ToolsTrustStore, dbt.Config.Dbt.Repo, dbt.Config.Tools.Repo} {
+			repoUrl, err := url.Parse(repo)
+			if err == nil && repoUrl.Host != "" {
+				hosts = append(hosts, repoUrl.Host)
+			}
+		}
+	}
+
+	for _, host := range hosts {
+		if strings.EqualFold(host, u.Host) || strings.EqualFold(host, u.Hostname()) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// OIDCTokenKey returns the name this server's token is cached under.  That's the server's name in a multi-server config, otherwise OIDC_DEFAULT_TOKEN_KEY.
+func (dbt *DBT) OIDCTokenKey() string {
+	if dbt.Config.ServerName != "" {
+		return dbt.Config.ServerName
+	}
+
+	return OIDC_DEFAULT_TOKEN_KEY
+}
+
+// LoginOIDC logs in with the OAuth2 device authorization grant against the configured issuer.  The user is shown a url and a code to enter there, and once they have, the token is cached for subsequent requests.  Returns the access token.
+func (dbt *DBT) LoginOIDC(ctx context.Context) (token string, err error) {
+	if !dbt.OIDCEnabled() {
+		err = errors.New("no oidc issuer is configured")
+		return token, err
+	}
+
+	if dbt.Config.OIDC.ClientId == "" {
+		err = errors.New("no oidc clientid is configured")
+		return token, err
+	}
+
+	endpoints, err := dbt.oidcDiscover(ctx)
+	if err != nil {
+		return token, err
+	}
+
+	if endpoints.DeviceAuthorizationEndpoint == "" {
+		err = fmt.Errorf("issuer %s doesn't support device logins", dbt.Config.OIDC.Issuer)
+		return token, err
+	}
+
+	scopes := DEFAULT_OIDC_SCOPES
+	if len(dbt.Config.OIDC.Scopes) > 0 {
+		scopes = strings.Join(dbt.Config.OIDC.Scopes, " ")
+	}
+
+	var device deviceAuthorization
+
+	err = dbt.oidcPost(ctx, endpoints.DeviceAuthorizationEndpoint, url.Values{
+		"client_id": {dbt.Config.OIDC.ClientId},
+		"scope":     {scopes},
+	}, &device)
+	if err != nil {
+		err = errors.Wrapf(err, "failed to start device login")
+		return token, err
+	}
+
+	if device.VerificationUriComplete != "" {
+		dbt.logEvent(LogFields{Operation: "login", Url: device.VerificationUriComplete}, "To log in, visit %s and confirm the code %s\n", device.VerificationUriComplete, device.UserCode)
+	} else {
+		dbt.logEvent(LogFields{Operation: "login", Url: device.VerificationUri}, "To log in, visit %s and enter the code %s\n", device.VerificationUri, device.UserCode)
+	}
+
+	interval := device.Interval
+	if interval <= 0 {
+		interval = DEFAULT_OIDC_POLL_INTERVAL_SECONDS
+	}
+
+	if device.ExpiresIn > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, time.Duration(device.ExpiresIn)*oidcIntervalUnit)
+		defer cancel()
+	}
+
+	form := url.Values{
+		"grant_type":  {OIDC_DEVICE_GRANT_TYPE},
+		"device_code": {device.DeviceCode},
+		"client_id":   {dbt.Config.OIDC.ClientId},
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			err = errors.Wrapf(ctx.Err(), "gave up waiting for the device login to finish")
+			return token, err
+		case <-time.After(time.Duration(interval) * oidcIntervalUnit):
+		}
+
+		var resp tokenResponse
+
+		err = dbt.oidcPost(ctx, endpoints.TokenEndpoint, form, &resp)
+		if err != nil {
+			err = errors.Wrapf(err, "failed polling for device login")
+			return token, err
+		}
+
+		switch resp.Error {
+		case "":
+			oidcToken := resp.token()
+
+			err = SaveOIDCToken(dbt.homedir(), dbt.OIDCTokenKey(), oidcToken)
+			if err != nil {
+				return token, err
+			}
+
+			return oidcToken.AccessToken, err
+		case "authorization_pending":
+			continue
+		case "slow_down":
+			interval += 5
+			continue
+		default:
+			err = fmt.Errorf("device login failed: %s", strings.TrimSpace(resp.Error+" "+resp.ErrorDescription))
+			return token, err
+		}
+	}
+}
+
+// OIDCBearer returns the access token to send with requests.  An expired token is quietly refreshed if it can be.  Failing that, if someone's at the terminal, they're asked to log in again there and then.  Otherwise the error is ErrNotLoggedIn.
+func (dbt *DBT) OIDCBearer() (token string, err error) {
+	homedir := dbt.homedir()
+	key := dbt.OIDCTokenKey()
+
+	cached, err := LoadOIDCToken(homedir, key)
+	if err != nil {
+		return token, err
+	}
+
+	if cached.Valid() {
+		return cached.AccessToken, err
+	}
+
+	reason := "not logged in"
+
+	if cached.RefreshToken != "" {
+		refreshed, refreshErr := dbt.RefreshOIDC(dbt.RequestContext(), cached)
+		if refreshErr == nil {
+			err = SaveOIDCToken(homedir, key, refreshed)
+			if err != nil {
+				return token, err
+			}
+
+			return refreshed.AccessToken, err
+		}
+
+		dbt.VerboseOutput("Couldn't refresh login to %s: %s\n", dbt.Config.OIDC.Issuer, refreshErr)
+		reason = fmt.Sprintf("login expired, and couldn't be refreshed (%s)", refreshErr)
+	} else if cached.AccessToken != "" {
+		reason = "login expired"
+	}
+
+	if !oidcInteractive() {
+		err = errors.Wrapf(ErrNotLoggedIn, "%s.  Run 'dbt login' to log in to %s", reason, dbt.Config.OIDC.Issuer)
+		return token, err
+	}
+
+	dbt.logEvent(LogFields{Operation: "login"}, "Logging in to %s: %s.\n", dbt.Config.OIDC.Issuer, reason)
+
+	token, err = dbt.LoginOIDC(dbt.RequestContext())
+	if err != nil {
+		err = errors.Wrapf(ErrNotLoggedIn, "login failed: %s", err)
+		return token, err
+	}
+
+	return token, err
+}
+
+// RefreshOIDC trades a token's refresh token for a fresh one.  Issuers don't always hand out a new refresh token, in which case the old one is kept.
+func (dbt *DBT) RefreshOIDC(ctx context.Context, old OIDCToken) (token OIDCToken, err error) {
+	endpoints, err := dbt.oidcDiscover(ctx)
+	if err != nil {
+		return token, err
+	}
+
+	var resp tokenResponse
+
+	err = dbt.oidcPost(ctx, endpoints.TokenEndpoint, url.Values{
+		"grant_type":    {"refresh_token"},
+		"refresh_token": {old.RefreshToken},
+		"client_id":     {dbt.Config.OIDC.ClientId},
+	}, &resp)
+	if err != nil {
+		err = errors.Wrapf(err, "failed to refresh token")
+		return token, err
+	}
+
+	if resp.Error != "" {
+		err = fmt.Errorf("refresh failed: %s", strings.TrimSpace(resp.Error+" "+resp.ErrorDescription))
+		return token, err
+	}
+
+	token = resp.token()
+
+	if token.RefreshToken == "" {
+		token.RefreshToken = old.RefreshToken
+	}
+
+	return token, err
+}
+
+// Logout forgets the cached login for a server, or for every server if serverName is empty.  The file goes once nothing's left in it.  Having nothing to forget isn't an error.  Credentials from usernamefunc, passwordfunc and pubkeyfunc are fetched fresh for every request, so there's nothing of theirs to clear.
+func (dbt *DBT) Logout(serverName string) (err error) {
+	homedir := dbt.homedir()
+	tokenPath := OIDCTokenPath(homedir)
+
+	tokens, err := LoadOIDCTokenFile(homedir)
+	if err != nil {
+		return err
+	}
+
+	if serverName != "" {
+		if _, ok := tokens.Servers[serverName]; !ok {
+			dbt.VerboseOutput("No cached login for %s.\n", serverName)
+			return err
+		}
+
+		delete(tokens.Servers, serverName)
+	} else {
+		tokens.Servers = make(map[string]OIDCToken)
+	}
+
+	if len(tokens.Servers) > 0 {
+		return SaveOIDCTokenFile(homedir, tokens)
+	}
+
+	err = os.Remove(tokenPath)
+	if err != nil && !os.IsNotExist(err) {
+		err = errors.Wrapf(err, "failed to remove %s", tokenPath)
+		return err
+	}
+
+	return nil
+}
+
+// LoadOIDCToken reads the token cached for a server.  No cached token isn't an error, just an empty token.
+func LoadOIDCToken(homedir string, server string) (token OIDCToken, err error) {
+	tokens, err := LoadOIDCTokenFile(homedir)
+	if err != nil {
+		return token, err
+	}
+
+	token = tokens.Servers[server]
+
+	return token, err
+}
+
+// LoadOIDCTokenFile reads all the cached OIDC tokens.  No file isn't an error, just no tokens.
+func LoadOIDCTokenFile(homedir string) (tokens OIDCTokenFile, err error) {
+	tokenPath := OIDCTokenPath(homedir)
+	tokens.Servers = make(map[string]OIDCToken)
+
+	b, err := ioutil.ReadFile(tokenPath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return tokens, nil
+		}
+
+		err = errors.Wrapf(err, "failed to read %s", tokenPath)
+		return tokens, err
+	}
+
+	err = json.Unmarshal(b, &tokens)
+	if err != nil {
+		err = errors.Wrapf(err, "failed to parse %s", tokenPath)
+		return tokens, err
+	}
+
+	if tokens.Servers == nil {
+		tokens.Servers = make(map[string]OIDCToken)
+	}
+
+	return tokens, err
+}
+
+// SaveOIDCToken caches a server's OIDC token, leaving other servers' alone.
+func SaveOIDCToken(homedir string, server string, token OIDCToken) (err error) {
+	tokens, err := LoadOIDCTokenFile(homedir)
+	if err != nil {
+		return err
+	}
+
+	tokens.Servers[server] = token
+
+	return SaveOIDCTokenFile(homedir, tokens)
+}
+
+// SaveOIDCTokenFile writes the cached OIDC tokens.  The file is readable only by its owner, since the tokens are as good as passwords, and it's replaced in one go so a crash can't leave half of it behind.
+func SaveOIDCTokenFile(homedir string, tokens OIDCTokenFile) (err error) {
+	tokenPath := OIDCTokenPath(homedir)
+
+	b, err := json.MarshalIndent(tokens, "", "  ")
+	if err != nil {
+		err = errors.Wrapf(err, "failed to marshal tokens")
+		return err
+	}
+
+	err = os.MkdirAll(filepath.Dir(tokenPath), 0755)
+	if err != nil {
+		err = errors.Wrapf(err, "failed to create %s", filepath.Dir(tokenPath))
+		return err
+	}
+
+	// temp files are created 0600, and the rename means an existing file with looser permissions doesn't keep them
+	tmpFile, err := ioutil.TempFile(filepath.Dir(tokenPath), "."+OIDC_TOKEN_FILE)
+	if err != nil {
+		err = errors.Wrapf(err, "failed to create temp file for %s", tokenPath)
+		return err
+	}
+
+	defer os.Remove(tmpFile.Name())
+
+	_, err = tmpFile.Write(b)
+	if err == nil {
+		err = tmpFile.Close()
+	} else {
+		_ = tmpFile.Close()
+	}
+
+	if err != nil {
+		err = errors.Wrapf(err, "failed to write %s", tokenPath)
+		return err
+	}
+
+	err = os.Chmod(tmpFile.Name(), 0600)
+	if err != nil {
+		err = errors.Wrapf(err, "failed to chmod %s", tmpFile.Name())
+		return err
+	}
+
+	err = os.Rename(tmpFile.Name(), tokenPath)
+	if err != nil {
+		err = errors.Wrapf(err, "failed to write %s", tokenPath)
+		return err
+	}
+
+	return err
+}
+
+// homedir returns the homedir dbt was created for, or else the user's.
+func (dbt *DBT) homedir() (homedir string) {
+	if dbt.Homedir != "" {
+		return dbt.Homedir
+	}
+
+	homedir, _ = GetHomeDir()
+
+	return homedir
+}
+
+// oidcDiscover fetches the issuer's discovery document.
+func (dbt *DBT) oidcDiscover(ctx context.Context) (endpoints oidcEndpoints, err error) {
+	uri := fmt.Sprintf("%s/.well-known/openid-configuration", strings.TrimSuffix(dbt.Config.OIDC.Issuer, "/"))
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, uri, nil)
+	if err != nil {
+		err = errors.Wrapf(err, "failed to create request for url: %s", uri)
+		return endpoints, err
+	}
+
+	resp, err := dbt.HttpClient().Do(req)
+	if err != nil {
+		err = errors.Wrapf(err, "failed to fetch %s", uri)
+		return endpoints, err
+	}
+
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		err = fmt.Errorf("failed to fetch %s: %s", uri, resp.Status)
+		return endpoints, err
+	}
+
+	err = json.NewDecoder(resp.Body).Decode(&endpoints)
+	if err != nil {
+		err = errors.Wrapf(err, "failed to parse %s", uri)
+		return endpoints, err
+	}
+
+	if endpoints.TokenEndpoint == "" {
+		err = fmt.Errorf("issuer %s has no token endpoint", dbt.Config.OIDC.Issuer)
+		return endpoints, err
+	}
+
+	return endpoints, err
+}
+
+// oidcPost posts a form to an issuer endpoint and decodes the JSON answer.  OAuth2 errors come back as 400s with an error field, so those are decoded rather than failed on.
+func (dbt *DBT) oidcPost(ctx context.Context, endpoint string, form url.Values, answer interface{}) (err error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		err = errors.Wrapf(err, "failed to create request for url: %s", endpoint)
+		return err
+	}
+
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := dbt.HttpClient().Do(req)
+	if err != nil {
+		err = errors.Wrapf(err, "failed to post to %s", endpoint)
+		return err
+	}
+
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(io.LimitReader(resp.Body, 1024*1024))
+	if err != nil {
+		err = errors.Wrapf(err, "failed to read answer from %s", endpoint)
+		return err
+	}
+
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusBadRequest && resp.StatusCode != http.StatusUnauthorized {
+		err = fmt.Errorf("%s answered %s", endpoint, resp.Status)
+		return err
+	}
+
+	err = json.Unmarshal(body, answer)
+	if err != nil {
+		err = errors.Wrapf(err, "failed to parse answer from %s", endpoint)
+		return err
+	}
+
+	return err
+}
+
+// token converts a successful token response to an OIDCToken.
+func (r tokenResponse) token() (token OIDCToken) {
+	token = OIDCToken{
+		AccessToken:  r.AccessToken,
+		RefreshToken: r.RefreshToken,
+		IdToken:      r.IdToken,
+		TokenType:    r.TokenType,
+	}
+
+	if r.ExpiresIn > 0 {
+		token.Expiry = time.Now().Add(time.Duration(r.ExpiresIn) * time.Second)
+	}
+
+	return token
+}
@@ -0,0 +1,432 @@
+package dbt
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeIssuer an OIDC issuer that approves a device login after a given number of polls
+type fakeIssuer struct {
+	server       *httptest.Server
+	pendingPolls int
+	deny         bool
+	refreshable  bool
+	polls        int
+	refreshes    int
+	mutex        sync.Mutex
+}
+
+func newFakeIssuer(t *testing.T, pendingPolls int) (issuer *fakeIssuer) {
+	issuer = &fakeIssuer{pendingPolls: pendingPolls, refreshable: true}
+
+	mux := http.NewServeMux()
+
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		_ = json.NewEncoder(w).Encode(map[string]string{
+			"device_authorization_endpoint": issuer.server.URL + "/device",
+			"token_endpoint":                issuer.server.URL + "/token",
+		})
+	})
+
+	mux.HandleFunc("/device", func(w http.ResponseWriter, r *http.Request) {
+		_ = r.ParseForm()
+		assert.Equal(t, "dbt", r.PostForm.Get("client_id"), "Client id is sent.")
+
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{
+			"device_code":      "devicecode",
+			"user_code":        "ABCD-EFGH",
+			"verification_uri": issuer.server.URL + "/activate",
+			"expires_in":       600,
+			"interval":         1,
+		})
+	})
+
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		issuer.mutex.Lock()
+		defer issuer.mutex.Unlock()
+
+		_ = r.ParseForm()
+
+		switch r.PostForm.Get("grant_type") {
+		case OIDC_DEVICE_GRANT_TYPE:
+			issuer.polls++
+
+			if issuer.polls <= issuer.pendingPolls {
+				w.WriteHeader(http.StatusBadRequest)
+				_ = json.NewEncoder(w).Encode(map[string]string{"error": "authorization_pending"})
+				return
+			}
+
+			if issuer.deny {
+				w.WriteHeader(http.StatusBadRequest)
+				_ = json.NewEncoder(w).Encode(map[string]string{"error": "access_denied"})
+				return
+			}
+
+			_ = json.NewEncoder(w).Encode(map[string]interface{}{
+				"access_token":  "access-1",
+				"refresh_token": "refresh-1",
+				"token_type":    "Bearer",
+				"expires_in":    3600,
+			})
+		case "refresh_token":
+			issuer.refreshes++
+
+			if !issuer.refreshable || r.PostForm.Get("refresh_token") != "refresh-1" {
+				w.WriteHeader(http.StatusBadRequest)
+				_ = json.NewEncoder(w).Encode(map[string]string{"error": "invalid_grant"})
+				return
+			}
+
+			_ = json.NewEncoder(w).Encode(map[string]interface{}{
+				"access_token": fmt.Sprintf("access-%d", issuer.refreshes+1),
+				"token_type":   "Bearer",
+				"expires_in":   3600,
+			})
+		default:
+			w.WriteHeader(http.StatusBadRequest)
+			_ = json.NewEncoder(w).Encode(map[string]string{"error": "unsupported_grant_type"})
+		}
+	})
+
+	issuer.server = httptest.NewServer(mux)
+	t.Cleanup(issuer.server.Close)
+
+	return issuer
+}
+
+func TestLoginOIDC(t *testing.T) {
+	oidcIntervalUnit = time.Millisecond
+	defer func() { oidcIntervalUnit = time.Second }()
+
+	inputs := []struct {
+		name         string
+		pendingPolls int
+		deny         bool
+		token        string
+		err          bool
+	}{
+		{"approved", 0, false, "access-1", false},
+		{"approved after a wait", 2, false, "access-1", false},
+		{"denied", 1, true, "", true},
+	}
+
+	for _, tc := range inputs {
+		t.Run(tc.name, func(t *testing.T) {
+			issuer := newFakeIssuer(t, tc.pendingPolls)
+			issuer.deny = tc.deny
+
+			homedir := t.TempDir()
+
+			obj := &DBT{
+				Config:  Config{OIDC: OIDCConfig{Issuer: issuer.server.URL, ClientId: "dbt"}},
+				Homedir: homedir,
+			}
+
+			token, err := obj.LoginOIDC(context.Background())
+
+			if tc.err {
+				assert.NotNil(t, err, "Login fails.")
+
+				_, statErr := os.Stat(OIDCTokenPath(homedir))
+				assert.True(t, os.IsNotExist(statErr), "Nothing is cached.")
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("Failed logging in: %s", err)
+			}
+
+			assert.Equal(t, tc.token, token, "Access token meets expectations.")
+			assert.Equal(t, tc.pendingPolls+1, issuer.polls, "Polled until approved.")
+
+			info, err := os.Stat(OIDCTokenPath(homedir))
+			if err != nil {
+				t.Fatalf("Token wasn't cached: %s", err)
+			}
+
+			assert.Equal(t, os.FileMode(0600), info.Mode().Perm(), "Cached token is private.")
+
+			cached, err := LoadOIDCToken(homedir, OIDC_DEFAULT_TOKEN_KEY)
+			if err != nil {
+				t.Fatalf("Failed loading token: %s", err)
+			}
+
+			assert.Equal(t, "refresh-1", cached.RefreshToken, "Refresh token is cached.")
+			assert.True(t, cached.Valid(), "Cached token is valid.")
+		})
+	}
+}
+
+func TestOIDCAuthHeaders(t *testing.T) {
+	oidcIntervalUnit = time.Millisecond
+	defer func() { oidcIntervalUnit = time.Second }()
+
+	defer func(orig func() bool) { oidcInteractive = orig }(oidcInteractive)
+
+	inputs := []struct {
+		name        string
+		cached      *OIDCToken
+		refreshable bool
+		interactive bool
+		header      string
+		refreshed   bool
+		err         bool
+	}{
+		{"valid", &OIDCToken{AccessToken: "access-1", RefreshToken: "refresh-1", Expiry: time.Now().Add(time.Hour)}, true, false, "Bearer access-1", false, false},
+		{"no expiry", &OIDCToken{AccessToken: "access-1"}, true, false, "Bearer access-1", false, false},
+		{"expired", &OIDCToken{AccessToken: "access-1", RefreshToken: "refresh-1", Expiry: time.Now().Add(-time.Hour)}, true, false, "Bearer access-2", true, false},
+		{"about to expire", &OIDCToken{AccessToken: "access-1", RefreshToken: "refresh-1", Expiry: time.Now().Add(time.Second)}, true, false, "Bearer access-2", true, false},
+		{"expired without refresh", &OIDCToken{AccessToken: "access-1", Expiry: time.Now().Add(-time.Hour)}, true, false, "", false, true},
+		{"refresh refused", &OIDCToken{AccessToken: "access-1", RefreshToken: "refresh-1", Expiry: time.Now().Add(-time.Hour)}, false, false, "", false, true},
+		{"not logged in", nil, true, false, "", false, true},
+		{"refresh refused at a terminal", &OIDCToken{AccessToken: "access-0", RefreshToken: "refresh-0", Expiry: time.Now().Add(-time.Hour)}, false, true, "Bearer access-1", false, false},
+		{"not logged in at a terminal", nil, true, true, "Bearer access-1", false, false},
+	}
+
+	for _, tc := range inputs {
+		t.Run(tc.name, func(t *testing.T) {
+			interactive := tc.interactive
+			oidcInteractive = func() bool { return interactive }
+
+			issuer := newFakeIssuer(t, 0)
+			issuer.refreshable = tc.refreshable
+
+			homedir := t.TempDir()
+
+			if tc.cached != nil {
+				err := SaveOIDCToken(homedir, OIDC_DEFAULT_TOKEN_KEY, *tc.cached)
+				if err != nil {
+					t.Fatalf("Failed caching token: %s", err)
+				}
+			}
+
+			obj := &DBT{
+				Config: Config{
+					Dbt:      DbtConfig{Repo: "http://localhost/dbt"},
+					Username: "ignored",
+					Password: "ignored",
+					OIDC:     OIDCConfig{Issuer: issuer.server.URL, ClientId: "dbt"},
+				},
+				Homedir: homedir,
+			}
+
+			req, err := http.NewRequest(http.MethodGet, "http://localhost/foo", nil)
+			if err != nil {
+				t.Fatalf("Failed creating request: %s", err)
+			}
+
+			err = obj.AuthHeaders(req)
+
+			if tc.err {
+				if assert.NotNil(t, err, "No usable login is an error.") {
+					assert.Equal(t, ErrNotLoggedIn, errors.Cause(err), "Error says to log in.")
+				}
+
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("Failed adding headers: %s", err)
+			}
+
+			assert.Equal(t, tc.header, req.Header.Get("Authorization"), "Bearer token is sent, and nothing else.")
+
+			if tc.refreshed {
+				cached, err := LoadOIDCToken(homedir, OIDC_DEFAULT_TOKEN_KEY)
+				if err != nil {
+					t.Fatalf("Failed loading token: %s", err)
+				}
+
+				assert.Equal(t, "access-2", cached.AccessToken, "Refreshed token is cached.")
+				assert.Equal(t, "refresh-1", cached.RefreshToken, "Refresh token is kept.")
+			}
+		})
+	}
+}
+
+func TestOIDCHosts(t *testing.T) {
+	homedir := t.TempDir()
+
+	err := SaveOIDCToken(homedir, OIDC_DEFAULT_TOKEN_KEY, OIDCToken{AccessToken: "access-1"})
+	if err != nil {
+		t.Fatalf("Failed caching token: %s", err)
+	}
+
+	inputs := []struct {
+		name   string
+		hosts  []string
+		url    string
+		header string
+	}{
+		{"dbt repo", nil, "https://dbt.example.com/dbt/1.0.0/linux/amd64/dbt", "Bearer access-1"},
+		{"tools repo", nil, "https://tools.example.com:8443/dbt-tools/foo/", "Bearer access-1"},
+		{"same host, other port", nil, "https://tools.example.com/dbt-tools/foo/", "Basic " + base64.StdEncoding.EncodeToString([]byte("default:secret"))},
+		{"other host", nil, "https://mirror.example.com/dbt-tools/foo/", "Basic " + base64.StdEncoding.EncodeToString([]byte("default:secret"))},
+		{"other host with credentials", nil, "https://bucket.s3.amazonaws.com/foo", "Basic " + base64.StdEncoding.EncodeToString([]byte("s3:secret"))},
+		{"configured host", []string{"mirror.example.com"}, "https://mirror.example.com/dbt-tools/foo/", "Bearer access-1"},
+		{"repo host not configured", []string{"mirror.example.com"}, "https://dbt.example.com/dbt/", "Basic " + base64.StdEncoding.EncodeToString([]byte("default:secret"))},
+	}
+
+	for _, tc := range inputs {
+		t.Run(tc.name, func(t *testing.T) {
+			obj := &DBT{
+				Config: Config{
+					Dbt:      DbtConfig{Repo: "https://dbt.example.com/dbt", TrustStore: "https://dbt.example.com/dbt/truststore"},
+					Tools:    ToolsConfig{Repo: "https://tools.example.com:8443/dbt-tools"},
+					Username: "default",
+					Password: "secret",
+					Credentials: map[string]CredentialSet{
+						"bucket.s3.amazonaws.com": {Username: "s3", Password: "secret"},
+					},
+					OIDC: OIDCConfig{Issuer: "https://login.example.com", ClientId: "dbt", Hosts: tc.hosts},
+				},
+				Homedir: homedir,
+			}
+
+			req, err := http.NewRequest(http.MethodGet, tc.url, nil)
+			if err != nil {
+				t.Fatalf("Failed creating request: %s", err)
+			}
+
+			err = obj.AuthHeaders(req)
+			if err != nil {
+				t.Fatalf("Failed adding headers: %s", err)
+			}
+
+			assert.Equal(t, tc.header, req.Header.Get("Authorization"), "Bearer token only goes to OIDC hosts.")
+		})
+	}
+}
+
+func TestOIDCTokensByServer(t *testing.T) {
+	homedir := t.TempDir()
+
+	inputs := []struct {
+		name  string
+		token OIDCToken
+	}{
+		{"prod", OIDCToken{AccessToken: "access-prod", RefreshToken: "refresh-prod"}},
+		{"dev", OIDCToken{AccessToken: "access-dev", RefreshToken: "refresh-dev"}},
+		{OIDC_DEFAULT_TOKEN_KEY, OIDCToken{AccessToken: "access-default"}},
+	}
+
+	// start from a file with loose permissions, to show saving tightens them
+	err := os.MkdirAll(filepath.Dir(OIDCTokenPath(homedir)), 0755)
+	if err != nil {
+		t.Fatalf("Failed creating dbt dir: %s", err)
+	}
+
+	err = ioutil.WriteFile(OIDCTokenPath(homedir), []byte("{}"), 0644)
+	if err != nil {
+		t.Fatalf("Failed writing token file: %s", err)
+	}
+
+	for _, tc := range inputs {
+		err := SaveOIDCToken(homedir, tc.name, tc.token)
+		if err != nil {
+			t.Fatalf("Failed caching token for %s: %s", tc.name, err)
+		}
+	}
+
+	info, err := os.Stat(OIDCTokenPath(homedir))
+	if err != nil {
+		t.Fatalf("Failed to stat token file: %s", err)
+	}
+
+	assert.Equal(t, os.FileMode(0600), info.Mode().Perm(), "Token file is private.")
+
+	for _, tc := range inputs {
+		t.Run(tc.name, func(t *testing.T) {
+			obj := &DBT{
+				Config:  Config{ServerName: tc.name},
+				Homedir: homedir,
+			}
+
+			if tc.name == OIDC_DEFAULT_TOKEN_KEY {
+				obj.Config.ServerName = ""
+			}
+
+			assert.Equal(t, tc.name, obj.OIDCTokenKey(), "Token key meets expectations.")
+
+			cached, err := LoadOIDCToken(homedir, obj.OIDCTokenKey())
+			if err != nil {
+				t.Fatalf("Failed loading token: %s", err)
+			}
+
+			assert.Equal(t, tc.token, cached, "Each server keeps its own token.")
+		})
+	}
+
+	missing, err := LoadOIDCToken(homedir, "nonesuch")
+	if err != nil {
+		t.Fatalf("Failed loading token: %s", err)
+	}
+
+	assert.Equal(t, OIDCToken{}, missing, "Unknown server has no token.")
+}
+
+func TestLogout(t *testing.T) {
+	inputs := []struct {
+		name      string
+		cached    []string
+		server    string
+		remaining []string
+	}{
+		{"one of several", []string{"prod", "dev"}, "prod", []string{"dev"}},
+		{"the only one", []string{"prod"}, "prod", nil},
+		{"all", []string{"prod", "dev", OIDC_DEFAULT_TOKEN_KEY}, "", nil},
+		{"not logged in there", []string{"dev"}, "prod", []string{"dev"}},
+		{"nothing cached", nil, "prod", nil},
+		{"nothing cached at all", nil, "", nil},
+	}
+
+	for _, tc := range inputs {
+		t.Run(tc.name, func(t *testing.T) {
+			homedir := t.TempDir()
+
+			for _, name := range tc.cached {
+				err := SaveOIDCToken(homedir, name, OIDCToken{AccessToken: "access-" + name})
+				if err != nil {
+					t.Fatalf("Failed caching token for %s: %s", name, err)
+				}
+			}
+
+			obj := &DBT{Homedir: homedir}
+
+			err := obj.Logout(tc.server)
+			if err != nil {
+				t.Fatalf("Failed logging out: %s", err)
+			}
+
+			_, statErr := os.Stat(OIDCTokenPath(homedir))
+			if len(tc.remaining) == 0 {
+				assert.True(t, os.IsNotExist(statErr), "Token file is gone.")
+				return
+			}
+
+			tokens, err := LoadOIDCTokenFile(homedir)
+			if err != nil {
+				t.Fatalf("Failed loading tokens: %s", err)
+			}
+
+			assert.Equal(t, len(tc.remaining), len(tokens.Servers), "Only the named server is forgotten.")
+
+			for _, name := range tc.remaining {
+				assert.Equal(t, "access-"+name, tokens.Servers[name].AccessToken, "Other servers keep their tokens.")
+			}
+		})
+	}
+}
@@ -0,0 +1,169 @@
+// Copyright © 2019 Nik Ogura <nik.ogura@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package dbt
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/pkg/errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+// TOOL_POLICY_FILE File in a tool's directory in the repo, next to its versions, setting the oldest version that may run.
+const TOOL_POLICY_FILE = "policy.json"
+
+// ToolPolicy is the content of a policy.json.  Versions older than MinVersion won't be run, downloaded or not.  An empty MinVersion allows everything.
+type ToolPolicy struct {
+	MinVersion string `json:"minVersion"`
+}
+
+// Allows returns true if the policy lets a version run.
+func (p ToolPolicy) Allows(version string) bool {
+	if p.MinVersion == "" || version == p.MinVersion {
+		return true
+	}
+
+	return VersionAIsNewerThanB(version, p.MinVersion)
+}
+
+// CachedToolPolicyPath returns where a tool's policy is cached, so it's enforced offline too.  Usually ~/.dbt/tools/<tool>/policy.json
+func CachedToolPolicyPath(homedir string, toolName string) string {
+	return fmt.Sprintf("%s/%s", CachedToolDir(homedir, toolName), TOOL_POLICY_FILE)
+}
+
+// FetchToolPolicy fetches a tool's policy.json, verifying its signature against the truststore under homedir, and caches it along with the signature for offline runs.  A tool without a policy.json has no policy, and any policy cached for it before is dropped.  If the repo can't say either way, the error is returned and the cached policy kept.
+func (dbt *DBT) FetchToolPolicy(toolName string, homedir string) (policy ToolPolicy, err error) {
+	uri := fmt.Sprintf("%s/%s/%s", dbt.Config.Tools.Repo, toolName, TOOL_POLICY_FILE)
+	policyPath := CachedToolPolicyPath(homedir, toolName)
+
+	// a repo that can't say whether there's a policy leaves the cached one alone, so it isn't lifted by an outage or an expired credential
+	found, err := dbt.FileExists(uri)
+	if err != nil {
+		err = errors.Wrapf(err, "failed to check for policy of %s", toolName)
+		return policy, err
+	}
+
+	if !found {
+		for _, stale := range []string{policyPath, fmt.Sprintf("%s.asc", policyPath)} {
+			err = os.Remove(stale)
+			if err != nil && !os.IsNotExist(err) {
+				err = errors.Wrapf(err, "failed to remove %s", stale)
+				return policy, err
+			}
+		}
+
+		return policy, nil
+	}
+
+	dbt.VerboseOutput("Fetching policy from %s", uri)
+
+	content, err := dbt.fetchDescriptionFile(uri)
+	if err != nil {
+		err = errors.Wrapf(err, "failed to fetch policy of %s", toolName)
+		return policy, err
+	}
+
+	signature, err := dbt.fetchDescriptionFile(fmt.Sprintf("%s.asc", uri))
+	if err != nil {
+		err = errors.Wrapf(err, "failed to fetch signature for policy of %s", toolName)
+		return policy, err
+	}
+
+	policy, err = dbt.parseToolPolicy(homedir, toolName, content, signature)
+	if err != nil {
+		return policy, err
+	}
+
+	err = os.MkdirAll(filepath.Dir(policyPath), 0755)
+	if err != nil {
+		err = errors.Wrapf(err, "failed to create %s", filepath.Dir(policyPath))
+		return policy, err
+	}
+
+	err = ioutil.WriteFile(policyPath, []byte(content), 0644)
+	if err != nil {
+		err = errors.Wrapf(err, "failed to cache policy of %s", toolName)
+		return policy, err
+	}
+
+	err = ioutil.WriteFile(fmt.Sprintf("%s.asc", policyPath), []byte(signature), 0644)
+	if err != nil {
+		err = errors.Wrapf(err, "failed to cache signature for policy of %s", toolName)
+		return policy, err
+	}
+
+	return policy, err
+}
+
+// CachedToolPolicy reads the policy cached for a tool by FetchToolPolicy, verifying it again against the truststore under homedir.  No cached policy is no policy.
+func (dbt *DBT) CachedToolPolicy(toolName string, homedir string) (policy ToolPolicy, err error) {
+	policyPath := CachedToolPolicyPath(homedir, toolName)
+
+	content, err := ioutil.ReadFile(policyPath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return policy, nil
+		}
+
+		err = errors.Wrapf(err, "failed to read %s", policyPath)
+		return policy, err
+	}
+
+	signature, err := ioutil.ReadFile(fmt.Sprintf("%s.asc", policyPath))
+	if err != nil {
+		err = errors.Wrapf(err, "failed to read signature for cached policy of %s", toolName)
+		return policy, err
+	}
+
+	return dbt.parseToolPolicy(homedir, toolName, string(content), string(signature))
+}
+
+// parseToolPolicy verifies a policy's signature, then parses it.
+func (dbt *DBT) parseToolPolicy(homedir string, toolName string, content string, signature string) (policy ToolPolicy, err error) {
+	err = dbt.verifyDescription(homedir, content, signature)
+	if err != nil {
+		err = errors.Wrapf(err, "policy of %s failed to verify", toolName)
+		return policy, err
+	}
+
+	err = json.Unmarshal([]byte(content), &policy)
+	if err != nil {
+		err = errors.Wrapf(err, "failed to parse policy of %s", toolName)
+		return policy, err
+	}
+
+	if policy.MinVersion != "" {
+		_, err = SemverParse(policy.MinVersion)
+		if err != nil {
+			err = errors.Wrapf(err, "policy of %s has a bad minVersion %q", toolName, policy.MinVersion)
+			return policy, err
+		}
+	}
+
+	return policy, err
+}
+
+// enforceToolPolicy refuses a version of a tool its policy doesn't allow.
+func enforceToolPolicy(policy ToolPolicy, toolName string, version string) (err error) {
+	if policy.Allows(version) {
+		return err
+	}
+
+	err = errors.Wrapf(ErrVersionBelowMinimum, "%s version %s is older than %s, the oldest the repo allows", toolName, version, policy.MinVersion)
+
+	return err
+}
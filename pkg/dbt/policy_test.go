@@ -0,0 +1,187 @@
+package dbt
+
+import (
+	"fmt"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+)
+
+func TestToolPolicyAllows(t *testing.T) {
+	inputs := []struct {
+		name    string
+		min     string
+		version string
+		allowed bool
+	}{
+		{"no policy", "", "1.0.0", true},
+		{"equal", "1.2.3", "1.2.3", true},
+		{"newer patch", "1.2.3", "1.2.4", true},
+		{"newer major", "1.2.3", "2.0.0", true},
+		{"older patch", "1.2.3", "1.2.2", false},
+		{"older minor", "1.2.3", "1.1.9", false},
+	}
+
+	for _, tc := range inputs {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, tc.allowed, ToolPolicy{MinVersion: tc.min}.Allows(tc.version), "Policy meets expectations.")
+		})
+	}
+}
+
+func TestRunToolPolicy(t *testing.T) {
+	repoRoot, config, signer := newTestSignedRepo(t, "foo", "1.0.0", "2.0.0")
+	policyPath := fmt.Sprintf("%s/dbt-tools/foo/%s", repoRoot, TOOL_POLICY_FILE)
+
+	homedir, err := ioutil.TempDir("", "dbt-policy")
+	if err != nil {
+		t.Fatalf("Failed creating homedir: %s", err)
+	}
+
+	defer os.RemoveAll(homedir)
+
+	err = makeStagingDbtDir(homedir, false)
+	if err != nil {
+		t.Fatalf("Failed creating dbt dir: %s", err)
+	}
+
+	obj := &DBT{
+		Config: config,
+		Logger: log.New(ioutil.Discard, "", 0),
+	}
+
+	err = obj.FetchTrustStore(homedir)
+	if err != nil {
+		t.Fatalf("Failed fetching truststore: %s", err)
+	}
+
+	testExec = true
+	defer func() { testExec = false }()
+
+	_ = os.Setenv(HELPER_PROCESS_ENV_VAR, "1")
+	defer os.Unsetenv(HELPER_PROCESS_ENV_VAR)
+
+	// cache both versions before there's any policy
+	for _, version := range []string{"1.0.0", "2.0.0"} {
+		err = obj.RunTool(version, []string{"foo"}, homedir, false)
+		if err != nil {
+			t.Fatalf("Failed running foo %s: %s", version, err)
+		}
+	}
+
+	// each step builds on the cache the ones before it leave behind
+	inputs := []struct {
+		name     string
+		policy   string
+		tampered bool
+		version  string
+		offline  bool
+		expected error
+	}{
+		{"below minimum", `{"minVersion": "2.0.0"}`, false, "1.0.0", false, ErrVersionBelowMinimum},
+		{"at minimum", `{"minVersion": "2.0.0"}`, false, "2.0.0", false, nil},
+		{"latest", `{"minVersion": "2.0.0"}`, false, "", false, nil},
+		{"below minimum offline", `{"minVersion": "2.0.0"}`, false, "1.0.0", true, ErrVersionBelowMinimum},
+		{"latest offline", `{"minVersion": "2.0.0"}`, false, "", true, nil},
+		{"latest below minimum", `{"minVersion": "3.0.0"}`, false, "", false, ErrVersionBelowMinimum},
+		{"latest below cached minimum offline", `{"minVersion": "3.0.0"}`, false, "", true, ErrVersionBelowMinimum},
+		{"tampered", `{"minVersion": "1.0.0"}`, true, "1.0.0", false, ErrSignatureMismatch},
+		{"policy withdrawn", "", false, "1.0.0", false, nil},
+		{"policy withdrawn offline", "", false, "1.0.0", true, nil},
+	}
+
+	for _, tc := range inputs {
+		t.Run(tc.name, func(t *testing.T) {
+			_ = os.Remove(policyPath)
+			_ = os.Remove(fmt.Sprintf("%s.asc", policyPath))
+
+			if tc.policy != "" {
+				writeTestSignedTool(t, signer, policyPath, tc.policy)
+			}
+
+			if tc.tampered {
+				err := ioutil.WriteFile(policyPath, []byte(`{"minVersion": "0.0.1"}`), 0644)
+				if err != nil {
+					t.Fatalf("Failed tampering with policy: %s", err)
+				}
+			}
+
+			err := obj.RunTool(tc.version, []string{"foo"}, homedir, tc.offline)
+			if tc.expected == nil {
+				assert.NoError(t, err, "Tool runs.")
+				return
+			}
+
+			if assert.Error(t, err, "Tool doesn't run.") {
+				assert.True(t, errors.Is(err, tc.expected), "Error %q is %q.", err, tc.expected)
+			}
+		})
+	}
+}
+
+func TestFetchToolPolicyRepoError(t *testing.T) {
+	repoRoot, config, signer := newTestSignedRepo(t, "foo", "1.0.0")
+	writeTestSignedTool(t, signer, fmt.Sprintf("%s/dbt-tools/foo/%s", repoRoot, TOOL_POLICY_FILE), `{"minVersion": "1.0.0"}`)
+
+	homedir := t.TempDir()
+
+	err := makeStagingDbtDir(homedir, false)
+	if err != nil {
+		t.Fatalf("Failed creating dbt dir: %s", err)
+	}
+
+	obj := &DBT{
+		Config: config,
+		Logger: log.New(ioutil.Discard, "", 0),
+	}
+
+	err = obj.FetchTrustStore(homedir)
+	if err != nil {
+		t.Fatalf("Failed fetching truststore: %s", err)
+	}
+
+	_, err = obj.FetchToolPolicy("foo", homedir)
+	if err != nil {
+		t.Fatalf("Failed fetching policy: %s", err)
+	}
+
+	inputs := []struct {
+		name   string
+		status int
+	}{
+		{"unauthorized", http.StatusUnauthorized},
+		{"forbidden", http.StatusForbidden},
+		{"server error", http.StatusInternalServerError},
+		{"bad gateway", http.StatusBadGateway},
+	}
+
+	for _, tc := range inputs {
+		t.Run(tc.name, func(t *testing.T) {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(tc.status)
+			}))
+			defer server.Close()
+
+			failing := &DBT{
+				Config: Config{Tools: ToolsConfig{Repo: fmt.Sprintf("%s/dbt-tools", server.URL)}},
+				Logger: log.New(ioutil.Discard, "", 0),
+			}
+
+			_, err := failing.FetchToolPolicy("foo", homedir)
+			assert.NotNil(t, err, "Repo error is an error.")
+
+			// the policy cached before the repo started failing still holds
+			policy, err := obj.CachedToolPolicy("foo", homedir)
+			if err != nil {
+				t.Fatalf("Failed reading cached policy: %s", err)
+			}
+
+			assert.Equal(t, "1.0.0", policy.MinVersion, "Cached policy is kept.")
+		})
+	}
+}
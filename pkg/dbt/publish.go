@@ -0,0 +1,157 @@
+// Copyright © 2019 Nik Ogura <nik.ogura@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package dbt
+
+import (
+	"encoding/base64"
+	"fmt"
+	"github.com/orion-labs/jwt-ssh-agent-go/pkg/agentjwt"
+	"github.com/pkg/errors"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strings"
+)
+
+// PUBLISH_AUTH_BASIC publish with a username and password
+const PUBLISH_AUTH_BASIC = "basic"
+
+// PUBLISH_AUTH_BEARER publish with a bearer token, sent as Authorization: Bearer
+const PUBLISH_AUTH_BEARER = "bearer"
+
+// PUBLISH_AUTH_SSH_AGENT publish with a JWT signed by the ssh-agent key matching Pubkey, which is what the reposerver's ssh-agent-file and ssh-agent-func auth expect
+const PUBLISH_AUTH_SSH_AGENT = "ssh-agent"
+
+// PublishAuth how to authenticate a publish.  If Type is empty, the credentials in dbt's config are used, same as for fetching.
+type PublishAuth struct {
+	Type     string
+	Username string
+	Password string
+	Token    string
+	Pubkey   string
+}
+
+// PublishFile PUTs a local file to the repo, with an X-Checksum-Sha256 header so the reposerver can check it got what was sent.  Only sha256 is sent, since a reposerver with md5 or sha1 in disabledChecksumAlgos rejects uploads carrying those.  remotePath is relative to the tools repo, e.g. foo/1.2.3/linux/amd64/foo, unless it's a full http(s) url.
+func (dbt *DBT) PublishFile(localPath string, remotePath string, auth PublishAuth) (err error) {
+	uri, err := dbt.publishUrl(remotePath)
+	if err != nil {
+		return err
+	}
+
+	sha256sum, err := FileSha256(localPath)
+	if err != nil {
+		err = errors.Wrapf(err, "failed to checksum %s", localPath)
+		return err
+	}
+
+	file, err := os.Open(localPath)
+	if err != nil {
+		err = errors.Wrapf(err, "failed to open %s", localPath)
+		return err
+	}
+
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		err = errors.Wrapf(err, "failed to stat %s", localPath)
+		return err
+	}
+
+	dbt.VerboseOutput("Publishing %s to %s\n", localPath, uri)
+
+	req, err := http.NewRequestWithContext(dbt.RequestContext(), http.MethodPut, uri, file)
+	if err != nil {
+		err = errors.Wrapf(err, "failed to create request for url: %s", uri)
+		return err
+	}
+
+	req.ContentLength = info.Size()
+	req.Header.Set("X-Checksum-Sha256", sha256sum)
+
+	err = dbt.PublishHeaders(req, auth)
+	if err != nil {
+		return err
+	}
+
+	resp, err := dbt.HttpClient().Do(req)
+	if err != nil {
+		err = errors.Wrapf(ErrRepoUnreachable, "failed to publish %s to %s: %s", localPath, uri, err)
+		return err
+	}
+
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		message, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 1024))
+		err = fmt.Errorf("failed to publish %s to %s: %s %s", localPath, uri, resp.Status, strings.TrimSpace(string(message)))
+		return err
+	}
+
+	return err
+}
+
+// PublishHeaders adds the headers for the given publish auth to a request.
+func (dbt *DBT) PublishHeaders(r *http.Request, auth PublishAuth) (err error) {
+	switch auth.Type {
+	case "":
+		err = dbt.AuthHeaders(r)
+		if err != nil {
+			err = errors.Wrapf(err, "failed adding auth headers")
+			return err
+		}
+	case PUBLISH_AUTH_BASIC:
+		r.Header.Set("Authorization", "Basic "+base64.StdEncoding.EncodeToString([]byte(auth.Username+":"+auth.Password)))
+	case PUBLISH_AUTH_BEARER:
+		r.Header.Set("Authorization", "Bearer "+auth.Token)
+	case PUBLISH_AUTH_SSH_AGENT:
+		token, err := agentjwt.SignedJwtToken(auth.Username, auth.Pubkey)
+		if err != nil {
+			err = errors.Wrapf(err, "failed to sign JWT token")
+			return err
+		}
+
+		r.Header.Set("Token", token)
+	default:
+		err = fmt.Errorf("unsupported publish auth %q.  Use %s, %s, or %s", auth.Type, PUBLISH_AUTH_BASIC, PUBLISH_AUTH_BEARER, PUBLISH_AUTH_SSH_AGENT)
+		return err
+	}
+
+	return err
+}
+
+// publishUrl works out where remotePath goes.  Publishing goes through the reposerver, so S3 repos aren't supported.
+func (dbt *DBT) publishUrl(remotePath string) (uri string, err error) {
+	if strings.HasPrefix(remotePath, "http://") || strings.HasPrefix(remotePath, "https://") {
+		return remotePath, err
+	}
+
+	repo := dbt.Config.Tools.Repo
+
+	if repo == "" {
+		err = errors.New("no tools repo is configured to publish to")
+		return uri, err
+	}
+
+	if isS3, _ := dbt.s3Url(repo); isS3 {
+		err = fmt.Errorf("can't publish to %s, since it's in S3.  Upload with the aws cli instead", repo)
+		return uri, err
+	}
+
+	uri = fmt.Sprintf("%s/%s", strings.TrimSuffix(repo, "/"), strings.TrimPrefix(remotePath, "/"))
+
+	return uri, err
+}
@@ -0,0 +1,174 @@
+package dbt
+
+import (
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestPublishFile(t *testing.T) {
+	serverRoot := t.TempDir()
+
+	repoServer := &DBTRepoServer{
+		ServerRoot:  serverRoot,
+		AuthTypePut: AUTH_BASIC_HTPASSWD,
+		AuthOptsPut: AuthOpts{
+			IdpFile: writeTestHtpasswd(t, t.TempDir(), "uploader", "password"),
+		},
+		GenerateChecksums:     true,
+		DisabledChecksumAlgos: []string{CHECKSUM_MD5, CHECKSUM_SHA1},
+	}
+
+	r, err := repoServer.Router()
+	if err != nil {
+		t.Fatalf("Failed creating router: %s", err)
+	}
+
+	server := httptest.NewServer(r)
+	defer server.Close()
+
+	localPath := filepath.Join(t.TempDir(), "foo")
+	content := "#!/bin/sh\necho foo\n"
+
+	err = os.WriteFile(localPath, []byte(content), 0755)
+	if err != nil {
+		t.Fatalf("Failed writing file to publish: %s", err)
+	}
+
+	checksum, err := FileSha256(localPath)
+	if err != nil {
+		t.Fatalf("Failed checksumming file: %s", err)
+	}
+
+	inputs := []struct {
+		name       string
+		config     Config
+		remotePath string
+		auth       PublishAuth
+		published  string
+		err        bool
+	}{
+		{
+			"basic auth",
+			Config{Tools: ToolsConfig{Repo: fmt.Sprintf("%s/dbt-tools", server.URL)}},
+			"foo/1.0.0/linux/amd64/foo",
+			PublishAuth{Type: PUBLISH_AUTH_BASIC, Username: "uploader", Password: "password"},
+			"dbt-tools/foo/1.0.0/linux/amd64/foo",
+			false,
+		},
+		{
+			"configured auth",
+			Config{Tools: ToolsConfig{Repo: fmt.Sprintf("%s/dbt-tools/", server.URL)}, Username: "uploader", Password: "password"},
+			"/foo/1.1.0/linux/amd64/foo",
+			PublishAuth{},
+			"dbt-tools/foo/1.1.0/linux/amd64/foo",
+			false,
+		},
+		{
+			"full url",
+			Config{},
+			fmt.Sprintf("%s/elsewhere/foo", server.URL),
+			PublishAuth{Type: PUBLISH_AUTH_BASIC, Username: "uploader", Password: "password"},
+			"elsewhere/foo",
+			false,
+		},
+		{
+			"bad password",
+			Config{Tools: ToolsConfig{Repo: fmt.Sprintf("%s/dbt-tools", server.URL)}},
+			"foo/1.2.0/linux/amd64/foo",
+			PublishAuth{Type: PUBLISH_AUTH_BASIC, Username: "uploader", Password: "wrong"},
+			"",
+			true,
+		},
+		{
+			"unknown auth",
+			Config{Tools: ToolsConfig{Repo: fmt.Sprintf("%s/dbt-tools", server.URL)}},
+			"foo/1.2.0/linux/amd64/foo",
+			PublishAuth{Type: "kerberos"},
+			"",
+			true,
+		},
+		{
+			"no repo",
+			Config{},
+			"foo/1.2.0/linux/amd64/foo",
+			PublishAuth{},
+			"",
+			true,
+		},
+		{
+			"s3 repo",
+			Config{Tools: ToolsConfig{Repo: "https://dbt-tools.s3.us-east-1.amazonaws.com"}},
+			"foo/1.2.0/linux/amd64/foo",
+			PublishAuth{},
+			"",
+			true,
+		},
+	}
+
+	for _, tc := range inputs {
+		t.Run(tc.name, func(t *testing.T) {
+			obj := &DBT{Config: tc.config}
+
+			err := obj.PublishFile(localPath, tc.remotePath, tc.auth)
+
+			if tc.err {
+				assert.NotNil(t, err, "Publish fails.")
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("Failed publishing: %s", err)
+			}
+
+			published, err := ioutil.ReadFile(filepath.Join(serverRoot, tc.published))
+			if err != nil {
+				t.Fatalf("Failed reading published file: %s", err)
+			}
+
+			assert.Equal(t, content, string(published), "Published content meets expectations.")
+
+			sidecar, err := ioutil.ReadFile(filepath.Join(serverRoot, tc.published+".sha256"))
+			if err != nil {
+				t.Fatalf("Failed reading checksum file: %s", err)
+			}
+
+			assert.Equal(t, checksum, string(sidecar), "Checksum is generated from what was published.")
+		})
+	}
+}
+
+func TestPublishHeaders(t *testing.T) {
+	inputs := []struct {
+		name   string
+		auth   PublishAuth
+		header string
+		value  string
+	}{
+		{"basic", PublishAuth{Type: PUBLISH_AUTH_BASIC, Username: "foo", Password: "bar"}, "Authorization", "Basic Zm9vOmJhcg=="},
+		{"bearer", PublishAuth{Type: PUBLISH_AUTH_BEARER, Token: "sekrit"}, "Authorization", "Bearer sekrit"},
+	}
+
+	for _, tc := range inputs {
+		t.Run(tc.name, func(t *testing.T) {
+			req, err := http.NewRequest(http.MethodPut, "http://localhost/foo", nil)
+			if err != nil {
+				t.Fatalf("Failed creating request: %s", err)
+			}
+
+			obj := &DBT{}
+
+			err = obj.PublishHeaders(req, tc.auth)
+			if err != nil {
+				t.Fatalf("Failed adding headers: %s", err)
+			}
+
+			assert.Equal(t, tc.value, req.Header.Get(tc.header), "Auth header meets expectations.")
+		})
+	}
+}
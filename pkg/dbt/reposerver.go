@@ -1,20 +1,28 @@
 package dbt
 
 import (
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
 	auth "github.com/abbot/go-http-auth"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
 	"github.com/gorilla/mux"
-	"github.com/nikogura/gomason/pkg/gomason"
 	"github.com/orion-labs/jwt-ssh-agent-go/pkg/agentjwt"
 	"github.com/pkg/errors"
 	log "github.com/sirupsen/logrus"
+	"gopkg.in/yaml.v3"
+	"hash"
 	"io"
 	"io/ioutil"
 	"net/http"
 	"os"
 	"path/filepath"
 	"strconv"
+	"strings"
+	"time"
 )
 
 // AUTH_BASIC_HTPASSWD config flag for basic auth
@@ -32,26 +40,87 @@ const AUTH_BASIC_LDAP = "basic-ldap"
 // AUTH_SSH_AGENT_LDAP flag for configuring ssh-agent auth pulling public key from an LDAP directory
 const AUTH_SSH_AGENT_LDAP = "ssh-agent-ldap"
 
+// DEFAULT_MAX_UPLOAD_BYTES the largest file the reposerver accepts if MaxUploadBytes isn't set.  500MB.
+const DEFAULT_MAX_UPLOAD_BYTES = 500 * 1024 * 1024
+
+// UPLOAD_TEMP_PREFIX prefix of the temp files uploads are streamed into before being moved into place
+const UPLOAD_TEMP_PREFIX = ".dbt-upload-"
+
+// CHECKSUM_MD5 the md5 upload checksum, sent as X-Checksum-Md5
+const CHECKSUM_MD5 = "md5"
+
+// CHECKSUM_SHA1 the sha1 upload checksum, sent as X-Checksum-Sha1
+const CHECKSUM_SHA1 = "sha1"
+
+// CHECKSUM_SHA256 the sha256 upload checksum, sent as X-Checksum-Sha256
+const CHECKSUM_SHA256 = "sha256"
+
+// ErrUploadTooLarge is returned when a PUT is bigger than the reposerver's upload limit
+var ErrUploadTooLarge = errors.New("upload too large")
+
+// ErrChecksumDisabled is returned when a PUT sends a checksum the reposerver has been configured not to compute
+var ErrChecksumDisabled = errors.New("checksum algorithm disabled")
+
 func init() {
 	log.SetFormatter(&log.JSONFormatter{})
 }
 
 // DBTRepoServer The reference 'trusted repository' server for dbt.
 type DBTRepoServer struct {
-	Address     string   `json:"address"`
-	Port        int      `json:"port"`
-	ServerRoot  string   `json:"serverRoot"`
-	AuthTypeGet string   `json:"authTypeGet"`
-	AuthTypePut string   `json:"authTypePut"`
-	AuthGets    bool     `json:"authGets"`
-	AuthOptsGet AuthOpts `json:"authOptsGet"`
-	AuthOptsPut AuthOpts `json:"authOptsPut"`
+	Address     string   `json:"address" yaml:"address"`
+	Port        int      `json:"port" yaml:"port"`
+	ServerRoot  string   `json:"serverRoot" yaml:"serverRoot"`
+	AuthTypeGet string   `json:"authTypeGet" yaml:"authTypeGet"`
+	AuthTypePut string   `json:"authTypePut" yaml:"authTypePut"`
+	AuthGets    bool     `json:"authGets" yaml:"authGets"`
+	AuthOptsGet AuthOpts `json:"authOptsGet" yaml:"authOptsGet"`
+	AuthOptsPut AuthOpts `json:"authOptsPut" yaml:"authOptsPut"`
+	// S3Backend if set, artifacts are stored in and served from this S3 bucket instead of ServerRoot
+	S3Backend *S3BackendOpts `json:"s3Backend,omitempty" yaml:"s3Backend,omitempty"`
+	S3Client  *s3.Client     `json:"-" yaml:"-"`
+	// Files if set, GETs are served from this instead of ServerRoot or S3Backend, e.g. http.FS of an embed.FS for a self contained demo.  It's read only, so PUTs aren't allowed.
+	Files http.FileSystem `json:"-" yaml:"-"`
+	// MetricsEnabled if true, Prometheus metrics are served on /metrics
+	MetricsEnabled bool `json:"metricsEnabled,omitempty" yaml:"metricsEnabled,omitempty"`
+	// RateLimitPerMinute if set, the number of PUTs each user may make per minute
+	RateLimitPerMinute int `json:"rateLimitPerMinute,omitempty" yaml:"rateLimitPerMinute,omitempty"`
+	// ReadRateLimitPerMinute if set, the number of GETs each user, or source address for anonymous GETs, may make per minute
+	ReadRateLimitPerMinute int `json:"readRateLimitPerMinute,omitempty" yaml:"readRateLimitPerMinute,omitempty"`
+
+	// MaxUploadBytes the largest file that can be PUT.  Defaults to DEFAULT_MAX_UPLOAD_BYTES
+	MaxUploadBytes int64 `json:"maxUploadBytes,omitempty" yaml:"maxUploadBytes,omitempty"`
+
+	// GenerateChecksums if true, a .sha256 sidecar file is written for every upload that doesn't already have one
+	GenerateChecksums bool `json:"generateChecksums,omitempty" yaml:"generateChecksums,omitempty"`
+
+	// RequireChecksum if true, PUTs without at least one X-Checksum-* header are rejected
+	RequireChecksum bool `json:"requireChecksum,omitempty" yaml:"requireChecksum,omitempty"`
+
+	// DisabledChecksumAlgos checksums, of CHECKSUM_MD5, CHECKSUM_SHA1, and CHECKSUM_SHA256, that aren't computed for uploads.  PUTs that send one are rejected.
+	DisabledChecksumAlgos []string `json:"disabledChecksumAlgos,omitempty" yaml:"disabledChecksumAlgos,omitempty"`
+
+	// PrettyIndex if true, directories of tools and of versions get an index page showing latest versions and descriptions, rather than a plain file listing
+	PrettyIndex bool `json:"prettyIndex,omitempty" yaml:"prettyIndex,omitempty"`
+
+	// DisableCompression if true, responses are never gzip or deflate encoded
+	DisableCompression bool `json:"disableCompression,omitempty" yaml:"disableCompression,omitempty"`
+
+	// CompressMinBytes the smallest text response that gets compressed.  Defaults to DEFAULT_COMPRESS_MIN_BYTES
+	CompressMinBytes int `json:"compressMinBytes,omitempty" yaml:"compressMinBytes,omitempty"`
+
+	// CORSAllowOrigins if set, the origins whose browser pages may read from the reposerver.  CORS_ALLOW_ANY allows every origin.
+	CORSAllowOrigins []string `json:"corsAllowOrigins,omitempty" yaml:"corsAllowOrigins,omitempty"`
+
+	putLimiter  *RateLimiter
+	readLimiter *RateLimiter
 }
 
 // AuthOpts Struct for holding Auth options
 type AuthOpts struct {
-	IdpFile string `json:"idpFile"`
-	IdpFunc string `json:"idpFunc,omitempty"`
+	IdpFile string `json:"idpFile" yaml:"idpFile"`
+	IdpFunc string `json:"idpFunc,omitempty" yaml:"idpFunc,omitempty"`
+	// FuncTimeout how long IdpFunc may take, such as '30s'.  Empty means DEFAULT_FUNC_TIMEOUT.
+	FuncTimeout string `json:"funcTimeout,omitempty" yaml:"funcTimeout,omitempty"`
 }
 
 // NewRepoServer creates a new DBTRepoServer object from the config file provided.
@@ -64,6 +133,15 @@ func NewRepoServer(configFilePath string) (server *DBTRepoServer, err error) {
 
 	server = &DBTRepoServer{}
 
+	if IsYamlFile(configFilePath) {
+		err = yaml.Unmarshal(c, server)
+		if err != nil {
+			err = errors.Wrapf(err, "failed to unmarshal yaml in %q", configFilePath)
+		}
+
+		return server, err
+	}
+
 	err = json.Unmarshal(c, server)
 	if err != nil {
 		err = errors.Wrapf(err, "failed to unmarshal json in %q", configFilePath)
@@ -72,14 +150,87 @@ func NewRepoServer(configFilePath string) (server *DBTRepoServer, err error) {
 	return server, err
 }
 
-// RunRepoServer Run runs the test repository server.
+// RunRepoServer Run runs the test repository server.  A config that doesn't pass Validate stops it before it starts listening.
 func (d *DBTRepoServer) RunRepoServer() (err error) {
+	err = d.Validate()
+	if err != nil {
+		return err
+	}
 
-	log.Printf("Running dbt artifact server on %s port %d.  Serving tree at: %s", d.Address, d.Port, d.ServerRoot)
+	if d.Files != nil {
+		log.Printf("Running dbt artifact server on %s port %d.  Serving a built in tree.", d.Address, d.Port)
+	} else if d.S3Backend != nil {
+		log.Printf("Running dbt artifact server on %s port %d.  Serving s3 bucket: %s", d.Address, d.Port, d.S3Backend.Bucket)
+	} else {
+		log.Printf("Running dbt artifact server on %s port %d.  Serving tree at: %s", d.Address, d.Port, d.ServerRoot)
+	}
 
 	fullAddress := fmt.Sprintf("%s:%s", d.Address, strconv.Itoa(d.Port))
 
-	r := mux.NewRouter()
+	if d.Files == nil && d.S3Backend == nil {
+		err = CleanUploadTempFiles(d.ServerRoot, time.Hour)
+		if err != nil {
+			log.Errorf("Failed cleaning up after interrupted uploads: %s", err)
+		}
+	}
+
+	r, err := d.Router()
+	if err != nil {
+		return err
+	}
+
+	// run the server
+	err = http.ListenAndServe(fullAddress, r)
+
+	return err
+}
+
+// Router sets up the reposerver's routes, auth, and instrumentation.
+func (d *DBTRepoServer) Router() (r *mux.Router, err error) {
+	fs, err := d.FileSystem()
+	if err != nil {
+		err = errors.Wrapf(err, "failed to set up storage")
+		return r, err
+	}
+
+	r = mux.NewRouter()
+
+	if d.RateLimitPerMinute > 0 {
+		d.putLimiter = NewRateLimiter(d.RateLimitPerMinute)
+	}
+
+	if d.ReadRateLimitPerMinute > 0 {
+		d.readLimiter = NewRateLimiter(d.ReadRateLimitPerMinute)
+	}
+
+	files := VersionIndexHandler(fs, http.FileServer(fs))
+
+	files = TrustStoreFingerprintsHandler(fs, files)
+
+	if d.PrettyIndex {
+		files = PrettyIndexHandler(fs, files)
+	}
+
+	files = ETagHandler(fs, files)
+
+	files = d.LimitReads(files)
+
+	// metrics first, so the catch all file routes below don't swallow them
+	if d.MetricsEnabled {
+		metrics := NewRepoServerMetrics()
+		r.Handle(METRICS_PATH, metrics.Handler()).Methods("GET")
+		r.Use(metrics.Middleware(d))
+	}
+
+	if !d.DisableCompression {
+		r.Use(d.Compress)
+	}
+
+	// preflights before the file routes, which would otherwise answer them with a 405
+	if len(d.CORSAllowOrigins) > 0 {
+		r.PathPrefix("/").HandlerFunc(d.CORSPreflight).Methods("OPTIONS")
+		r.Use(d.CORS)
+	}
 
 	// handle the uploads if enabled
 	if d.AuthTypePut != "" {
@@ -100,7 +251,7 @@ func (d *DBTRepoServer) RunRepoServer() (err error) {
 		//	return err
 		default:
 			err = errors.New(fmt.Sprintf("unsupported auth method: %s", d.AuthTypePut))
-			return err
+			return r, err
 		}
 	}
 
@@ -110,12 +261,12 @@ func (d *DBTRepoServer) RunRepoServer() (err error) {
 		case AUTH_BASIC_HTPASSWD:
 			htpasswd := auth.HtpasswdFileProvider(d.AuthOptsGet.IdpFile)
 			authenticator := auth.NewBasicAuthenticator("DBT Server", htpasswd)
-			r.PathPrefix("/").Handler(auth.JustCheck(authenticator, http.FileServer(http.Dir(d.ServerRoot)).ServeHTTP)).Methods("GET", "HEAD")
+			r.PathPrefix("/").Handler(auth.JustCheck(authenticator, files.ServeHTTP)).Methods("GET", "HEAD")
 		case AUTH_SSH_AGENT_FILE:
-			r.PathPrefix("/").Handler(d.CheckPubkeysGetFile(http.FileServer(http.Dir(d.ServerRoot)).ServeHTTP)).Methods("GET", "HEAD")
+			r.PathPrefix("/").Handler(d.CheckPubkeysGetFile(files.ServeHTTP)).Methods("GET", "HEAD")
 
 		case AUTH_SSH_AGENT_FUNC:
-			r.PathPrefix("/").Handler(d.CheckPubkeysGetFunc(http.FileServer(http.Dir(d.ServerRoot)).ServeHTTP)).Methods("GET", "HEAD")
+			r.PathPrefix("/").Handler(d.CheckPubkeysGetFunc(files.ServeHTTP)).Methods("GET", "HEAD")
 
 		//case AUTH_BASIC_LDAP:
 		//	err = errors.New("basic auth via ldap not yet supported")
@@ -127,41 +278,119 @@ func (d *DBTRepoServer) RunRepoServer() (err error) {
 		//
 		default:
 			err = errors.New(fmt.Sprintf("unsupported auth method: %s", d.AuthTypeGet))
-			return err
+			return r, err
 
 		}
 	} else {
-		r.PathPrefix("/").Handler(http.FileServer(http.Dir(d.ServerRoot))).Methods("GET", "HEAD")
+		r.PathPrefix("/").Handler(files).Methods("GET", "HEAD")
 	}
 
-	// run the server
-	err = http.ListenAndServe(fullAddress, r)
+	return r, err
+}
 
-	return err
+// UploadLimit returns the largest file, in bytes, that can be PUT to the reposerver.
+func (d *DBTRepoServer) UploadLimit() int64 {
+	if d.MaxUploadBytes > 0 {
+		return d.MaxUploadBytes
+	}
+
+	return DEFAULT_MAX_UPLOAD_BYTES
 }
 
+// ChecksumEnabled reports whether uploads are checksummed with the given algorithm, i.e. it isn't in DisabledChecksumAlgos.
+func (d *DBTRepoServer) ChecksumEnabled(algo string) bool {
+	for _, disabled := range d.DisabledChecksumAlgos {
+		if strings.EqualFold(strings.TrimSpace(disabled), algo) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// HandlePut verifies any checksums sent with an upload, and then writes the file to ServerRoot, or the S3 backend if one is configured.  Only the checksums that are enabled are computed.
 func (d *DBTRepoServer) HandlePut(path string, body io.ReadCloser, md5sum string, sha1sum string, sha256sum string) (err error) {
 	filePath := fmt.Sprintf("%s/%s", d.ServerRoot, path)
-	fileDir := filepath.Dir(filePath)
+	tmpDir := filepath.Dir(filePath)
 
-	// create subdirs if they don't exist
-	if _, err := os.Stat(fileDir); os.IsNotExist(err) {
-		err = os.MkdirAll(fileDir, 0755)
-		if err != nil {
-			err = errors.Wrapf(err, "failed to create server path %s", fileDir)
+	sent := map[string]string{
+		CHECKSUM_MD5:    md5sum,
+		CHECKSUM_SHA1:   sha1sum,
+		CHECKSUM_SHA256: sha256sum,
+	}
+
+	for _, algo := range []string{CHECKSUM_MD5, CHECKSUM_SHA1, CHECKSUM_SHA256} {
+		if sent[algo] != "" && !d.ChecksumEnabled(algo) {
+			err = errors.Wrapf(ErrChecksumDisabled, "%s checksums are disabled on this server.  Send X-Checksum-Sha256 instead", algo)
 			return err
 		}
 	}
 
-	fileBytes, err := ioutil.ReadAll(body)
+	if d.S3Backend != nil {
+		filePath = path
+		tmpDir = os.TempDir()
+	} else {
+		// create subdirs if they don't exist
+		if _, err := os.Stat(tmpDir); os.IsNotExist(err) {
+			err = os.MkdirAll(tmpDir, 0755)
+			if err != nil {
+				err = errors.Wrapf(err, "failed to create server path %s", tmpDir)
+				return err
+			}
+		}
+	}
+
+	// Stream the upload into a temp file next to its final home, checksumming as we go, so memory use doesn't depend on the size of the file.
+	tmpFile, err := ioutil.TempFile(tmpDir, UPLOAD_TEMP_PREFIX)
+	if err != nil {
+		err = errors.Wrapf(err, "failed to create temp file for %s", filePath)
+		return err
+	}
+
+	renamed := false
+
+	defer func() {
+		_ = tmpFile.Close()
+
+		if !renamed {
+			_ = os.Remove(tmpFile.Name())
+		}
+	}()
+
+	hashers := map[string]hash.Hash{}
+	writers := []io.Writer{tmpFile}
+
+	for algo, newHasher := range map[string]func() hash.Hash{CHECKSUM_MD5: md5.New, CHECKSUM_SHA1: sha1.New, CHECKSUM_SHA256: sha256.New} {
+		if d.ChecksumEnabled(algo) {
+			hashers[algo] = newHasher()
+			writers = append(writers, hashers[algo])
+		}
+	}
+
+	writer := io.MultiWriter(writers...)
+
+	// Read at most one byte past the limit, so we can tell if there was more.  If the body is an http.MaxBytesReader, it errors out instead once it hits the limit.
+	limit := d.UploadLimit()
+	written, err := io.Copy(writer, io.LimitReader(body, limit+1))
+	if written > limit || (err != nil && written == limit) {
+		err = errors.Wrapf(ErrUploadTooLarge, "%s is larger than %d bytes", filePath, limit)
+		return err
+	}
 
-	// Checksum bytes
-	md5Actual, sha1Actual, sha256Actual, err := gomason.AllChecksumsForBytes(fileBytes)
 	if err != nil {
-		err = errors.Wrapf(err, "failed to derive checksums for file %s", filePath)
+		err = errors.Wrapf(err, "failed to read body for %s", filePath)
 		return err
 	}
 
+	actual := make(map[string]string)
+	for algo, hasher := range hashers {
+		actual[algo] = hex.EncodeToString(hasher.Sum(nil))
+	}
+
+	md5Actual := actual[CHECKSUM_MD5]
+	sha1Actual := actual[CHECKSUM_SHA1]
+	sha256Actual := actual[CHECKSUM_SHA256]
+
 	// verify sent checksums if present.  You don't have to provide checksums, but if you do, they have to match what we received.
 	if md5sum != "" {
 		if md5sum != md5Actual {
@@ -184,10 +413,103 @@ func (d *DBTRepoServer) HandlePut(path string, body io.ReadCloser, md5sum string
 		}
 	}
 
-	// write file to filesystem.
-	err = ioutil.WriteFile(filePath, fileBytes, 0644)
+	// make sure it's all really on disk before it becomes visible, lest a crash leave a truncated file in place
+	err = tmpFile.Sync()
+	if err != nil {
+		err = errors.Wrapf(err, "failed to sync %s", filePath)
+		return err
+	}
+
+	err = tmpFile.Close()
+	if err != nil {
+		err = errors.Wrapf(err, "failed to write %s", filePath)
+		return err
+	}
+
+	if d.S3Backend != nil {
+		err = d.s3BackendPut(filePath, tmpFile.Name())
+		if err != nil {
+			return err
+		}
+
+		return d.GenerateChecksumFile(filePath, sha256Actual)
+	}
+
+	// temp files are created 0600
+	err = os.Chmod(tmpFile.Name(), 0644)
+	if err != nil {
+		err = errors.Wrapf(err, "failed to chmod %s", tmpFile.Name())
+		return err
+	}
+
+	// move it into place in one go.
+	err = os.Rename(tmpFile.Name(), filePath)
 	if err != nil {
 		err = errors.Wrapf(err, "failed to write %s", filePath)
+		return err
+	}
+
+	renamed = true
+
+	return d.GenerateChecksumFile(filePath, sha256Actual)
+}
+
+// GenerateChecksumFile writes the .sha256 sidecar file clients verify downloads against, if GenerateChecksums is set.  Sidecars and signatures don't get sidecars of their own, and a sidecar that has already been uploaded is never overwritten.
+func (d *DBTRepoServer) GenerateChecksumFile(filePath string, sha256sum string) (err error) {
+	// no sha256 means it's disabled, which Validate won't allow alongside GenerateChecksums
+	if !d.GenerateChecksums || sha256sum == "" {
+		return err
+	}
+
+	for _, suffix := range []string{".sha256", ".sha1", ".md5", ".asc"} {
+		if strings.HasSuffix(filePath, suffix) {
+			return err
+		}
+	}
+
+	sidecar := fmt.Sprintf("%s.sha256", filePath)
+
+	if d.S3Backend != nil {
+		return d.s3BackendPutIfAbsent(sidecar, []byte(sha256sum))
+	}
+
+	tmpFile, err := ioutil.TempFile(filepath.Dir(sidecar), UPLOAD_TEMP_PREFIX)
+	if err != nil {
+		err = errors.Wrapf(err, "failed to create temp file for %s", sidecar)
+		return err
+	}
+
+	defer os.Remove(tmpFile.Name())
+
+	_, err = tmpFile.WriteString(sha256sum)
+	if err != nil {
+		_ = tmpFile.Close()
+		err = errors.Wrapf(err, "failed to write %s", sidecar)
+		return err
+	}
+
+	err = tmpFile.Close()
+	if err != nil {
+		err = errors.Wrapf(err, "failed to write %s", sidecar)
+		return err
+	}
+
+	err = os.Chmod(tmpFile.Name(), 0644)
+	if err != nil {
+		err = errors.Wrapf(err, "failed to chmod %s", tmpFile.Name())
+		return err
+	}
+
+	// Unlike a rename, a link won't replace an existing file, so an uploaded sidecar always wins.
+	err = os.Link(tmpFile.Name(), sidecar)
+	if err != nil {
+		if os.IsExist(err) {
+			log.Infof("Not generating %s.  It already exists.", sidecar)
+			return nil
+		}
+
+		err = errors.Wrapf(err, "failed to write %s", sidecar)
+		return err
 	}
 
 	return err
@@ -195,12 +517,43 @@ func (d *DBTRepoServer) HandlePut(path string, body io.ReadCloser, md5sum string
 
 // PutHandlerHtpasswd Handles puts with htpasswd auth
 func (d *DBTRepoServer) PutHandlerHtpasswd(w http.ResponseWriter, r *auth.AuthenticatedRequest) {
+	if rateLimited(d.putLimiter, w, r.Username) {
+		return
+	}
 
-	err := d.HandlePut(r.URL.Path, r.Body, r.Header.Get("X-Checksum-Md5"), r.Header.Get("X-Checksum-Sha1"), r.Header.Get("X-Checksum-Sha256"))
+	d.PutFile(w, &r.Request)
+}
+
+// PutFile writes the body of an already authenticated PUT to the repository, and sends the response.
+func (d *DBTRepoServer) PutFile(w http.ResponseWriter, r *http.Request) {
+	md5sum := r.Header.Get("X-Checksum-Md5")
+	sha1sum := r.Header.Get("X-Checksum-Sha1")
+	sha256sum := r.Header.Get("X-Checksum-Sha256")
+
+	if d.RequireChecksum && md5sum == "" && sha1sum == "" && sha256sum == "" {
+		log.Errorf("Rejecting PUT of %s with no checksum headers", r.URL.Path)
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	body := http.MaxBytesReader(w, r.Body, d.UploadLimit())
+
+	err := d.HandlePut(r.URL.Path, body, md5sum, sha1sum, sha256sum)
 	if err != nil {
 		err = errors.Wrapf(err, "failed writing file %s", r.URL.Path)
-		w.WriteHeader(http.StatusInternalServerError)
 		log.Error(err)
+
+		if errors.Cause(err) == ErrUploadTooLarge {
+			w.WriteHeader(http.StatusRequestEntityTooLarge)
+			return
+		}
+
+		if errors.Cause(err) == ErrChecksumDisabled {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		w.WriteHeader(http.StatusInternalServerError)
 		return
 	}
 
@@ -280,7 +633,12 @@ func (d *DBTRepoServer) PubkeyFromFileGet(subject string) (pubkeys string, err e
 // PubkeyFromFuncPut takes a subject name, and runs the configured function to return the corresponding public key
 func (d *DBTRepoServer) PubkeysFromFuncPut(subject string) (pubkey string, err error) {
 
-	pubkey, err = GetFuncUsername(d.AuthOptsPut.IdpFunc, subject)
+	timeout, err := ParseFuncTimeout(d.AuthOptsPut.FuncTimeout)
+	if err != nil {
+		return pubkey, err
+	}
+
+	pubkey, err = GetFuncUsernameTimeout(d.AuthOptsPut.IdpFunc, subject, timeout)
 	if err != nil {
 		err = errors.Wrapf(err, "failed to get password from shell function %q", d.AuthOptsPut.IdpFunc)
 		return pubkey, err
@@ -292,7 +650,12 @@ func (d *DBTRepoServer) PubkeysFromFuncPut(subject string) (pubkey string, err e
 // PubkeyFromFuncGet takes a subject name, and runs the configured function to return the corresponding public key
 func (d *DBTRepoServer) PubkeysFromFuncGet(subject string) (pubkey string, err error) {
 
-	pubkey, err = GetFuncUsername(d.AuthOptsGet.IdpFunc, subject)
+	timeout, err := ParseFuncTimeout(d.AuthOptsGet.FuncTimeout)
+	if err != nil {
+		return pubkey, err
+	}
+
+	pubkey, err = GetFuncUsernameTimeout(d.AuthOptsGet.IdpFunc, subject, timeout)
 	if err != nil {
 		err = errors.Wrapf(err, "failed to get password from shell function %q", d.AuthOptsGet.IdpFunc)
 		return pubkey, err
@@ -402,15 +765,11 @@ func (d *DBTRepoServer) PutHandlerPubkeyFile(w http.ResponseWriter, r *http.Requ
 
 	log.Infof("Subject %s successfully authenticated", subject)
 
-	err = d.HandlePut(r.URL.Path, r.Body, r.Header.Get("X-Checksum-Md5"), r.Header.Get("X-Checksum-Sha1"), r.Header.Get("X-Checksum-Sha256"))
-	if err != nil {
-		err = errors.Wrapf(err, "failed writing file %s", r.URL.Path)
-		w.WriteHeader(http.StatusInternalServerError)
-		log.Error(err)
+	if rateLimited(d.putLimiter, w, subject) {
 		return
 	}
 
-	w.WriteHeader(http.StatusCreated)
+	d.PutFile(w, r)
 }
 
 // PutHandlerPubkeyFunc
@@ -440,15 +799,11 @@ func (d *DBTRepoServer) PutHandlerPubkeyFunc(w http.ResponseWriter, r *http.Requ
 
 	log.Infof("Subject %s successfully authenticated", subject)
 
-	err = d.HandlePut(r.URL.Path, r.Body, r.Header.Get("X-Checksum-Md5"), r.Header.Get("X-Checksum-Sha1"), r.Header.Get("X-Checksum-Sha256"))
-	if err != nil {
-		err = errors.Wrapf(err, "failed writing file %s", r.URL.Path)
-		w.WriteHeader(http.StatusInternalServerError)
-		log.Error(err)
+	if rateLimited(d.putLimiter, w, subject) {
 		return
 	}
 
-	w.WriteHeader(http.StatusCreated)
+	d.PutFile(w, r)
 }
 
 // Auth Methods
@@ -0,0 +1,234 @@
+// Copyright © 2019 Nik Ogura <nik.ogura@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package dbt
+
+import (
+	"compress/flate"
+	"compress/gzip"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// DEFAULT_COMPRESS_MIN_BYTES responses smaller than this aren't worth compressing
+const DEFAULT_COMPRESS_MIN_BYTES = 1024
+
+// ENCODING_GZIP the gzip content encoding
+const ENCODING_GZIP = "gzip"
+
+// ENCODING_DEFLATE the deflate content encoding
+const ENCODING_DEFLATE = "deflate"
+
+// CompressMin returns the smallest response, in bytes, that gets compressed.
+func (d *DBTRepoServer) CompressMin() int {
+	if d.CompressMinBytes > 0 {
+		return d.CompressMinBytes
+	}
+
+	return DEFAULT_COMPRESS_MIN_BYTES
+}
+
+// Compress gzip or deflate encodes text responses for clients that accept it.  Tool binaries and anything already compressed go out untouched, as do HEADs and range requests, so sizes and offsets always refer to the file itself.
+func (d *DBTRepoServer) Compress(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Add("Vary", "Accept-Encoding")
+
+		encoding := acceptedEncoding(r.Header.Get("Accept-Encoding"))
+
+		if encoding == "" || r.Method != http.MethodGet || r.Header.Get("Range") != "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		cw := &compressWriter{ResponseWriter: w, encoding: encoding, minBytes: d.CompressMin()}
+		defer cw.Close()
+
+		next.ServeHTTP(cw, r)
+	})
+}
+
+// acceptedEncoding picks gzip, or failing that deflate, from an Accept-Encoding header.  Returns "" if the client takes neither.
+func acceptedEncoding(header string) (encoding string) {
+	accepted := make(map[string]bool)
+
+	for _, part := range strings.Split(header, ",") {
+		fields := strings.Split(part, ";")
+		name := strings.ToLower(strings.TrimSpace(fields[0]))
+
+		// q=0 means the client specifically doesn't want it
+		refused := false
+		for _, param := range fields[1:] {
+			param = strings.TrimSpace(param)
+			if strings.HasPrefix(param, "q=") {
+				q, err := strconv.ParseFloat(strings.TrimPrefix(param, "q="), 64)
+				refused = err == nil && q == 0
+			}
+		}
+
+		if !refused {
+			accepted[name] = true
+		}
+	}
+
+	switch {
+	case accepted[ENCODING_GZIP]:
+		return ENCODING_GZIP
+	case accepted[ENCODING_DEFLATE]:
+		return ENCODING_DEFLATE
+	}
+
+	return ""
+}
+
+// compressible reports whether a content type is text, which compresses well.  Binaries, archives, and images are left alone.
+func compressible(contentType string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+
+	if strings.HasPrefix(mediaType, "text/") {
+		return true
+	}
+
+	switch mediaType {
+	case "application/json", "application/javascript", "application/xml", "application/pgp-signature", "application/pgp-keys", "image/svg+xml":
+		return true
+	}
+
+	return strings.HasSuffix(mediaType, "+json") || strings.HasSuffix(mediaType, "+xml")
+}
+
+// compressWriter holds back the start of a response until it knows whether it's worth compressing, i.e. it's a 200, it's text that isn't encoded already, and there's enough of it.
+type compressWriter struct {
+	http.ResponseWriter
+	encoding string
+	minBytes int
+	status   int
+	buf      []byte
+	decided  bool
+	encoder  io.WriteCloser
+}
+
+// WriteHeader records the status, which is sent once it's decided whether to compress.
+func (c *compressWriter) WriteHeader(status int) {
+	if c.status == 0 {
+		c.status = status
+	}
+}
+
+// Write buffers until there's enough to decide on, then passes everything on, compressed or not.
+func (c *compressWriter) Write(b []byte) (int, error) {
+	if c.status == 0 {
+		c.status = http.StatusOK
+	}
+
+	if !c.decided {
+		if c.status != http.StatusOK || c.Header().Get("Content-Encoding") != "" || !compressible(c.contentType(b)) {
+			c.start(false)
+			return c.ResponseWriter.Write(b)
+		}
+
+		c.buf = append(c.buf, b...)
+
+		if len(c.buf) < c.minBytes {
+			return len(b), nil
+		}
+
+		err := c.start(true)
+		return len(b), err
+	}
+
+	if c.encoder != nil {
+		return c.encoder.Write(b)
+	}
+
+	return c.ResponseWriter.Write(b)
+}
+
+// Close sends whatever's still held back, and finishes off the compressed stream.
+func (c *compressWriter) Close() error {
+	if !c.decided {
+		// it all fit in the buffer, so it's only worth compressing if the buffer filled up
+		err := c.start(len(c.buf) >= c.minBytes)
+		if err != nil {
+			return err
+		}
+	}
+
+	if c.encoder != nil {
+		return c.encoder.Close()
+	}
+
+	return nil
+}
+
+// contentType returns the response's content type, sniffing it the way the file server would if it hasn't been set.
+func (c *compressWriter) contentType(b []byte) string {
+	contentType := c.Header().Get("Content-Type")
+	if contentType == "" {
+		contentType = http.DetectContentType(b)
+		c.Header().Set("Content-Type", contentType)
+	}
+
+	return contentType
+}
+
+// start sends the headers, and anything buffered, either through an encoder or as is.
+func (c *compressWriter) start(compress bool) (err error) {
+	c.decided = true
+
+	if c.status == 0 {
+		c.status = http.StatusOK
+	}
+
+	if compress {
+		c.Header().Del("Content-Length")
+		c.Header().Set("Content-Encoding", c.encoding)
+
+		// the encoded bytes differ from the file's, so the tag can only be a weak one
+		if etag := c.Header().Get("ETag"); etag != "" && !strings.HasPrefix(etag, "W/") {
+			c.Header().Set("ETag", "W/"+etag)
+		}
+
+		if c.encoding == ENCODING_GZIP {
+			c.encoder = gzip.NewWriter(c.ResponseWriter)
+		} else {
+			c.encoder, err = flate.NewWriter(c.ResponseWriter, flate.DefaultCompression)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	c.ResponseWriter.WriteHeader(c.status)
+
+	if len(c.buf) == 0 {
+		return nil
+	}
+
+	buffered := c.buf
+	c.buf = nil
+
+	if c.encoder != nil {
+		_, err = c.encoder.Write(buffered)
+		return err
+	}
+
+	_, err = c.ResponseWriter.Write(buffered)
+	return err
+}
@@ -0,0 +1,196 @@
+package dbt
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestAcceptedEncoding(t *testing.T) {
+	inputs := []struct {
+		header   string
+		encoding string
+	}{
+		{"", ""},
+		{"gzip", ENCODING_GZIP},
+		{"deflate", ENCODING_DEFLATE},
+		{"deflate, gzip", ENCODING_GZIP},
+		{"GZIP;q=0.5, deflate", ENCODING_GZIP},
+		{"gzip;q=0, deflate", ENCODING_DEFLATE},
+		{"gzip; q=0.0", ""},
+		{"br", ""},
+	}
+
+	for _, tc := range inputs {
+		t.Run(tc.header, func(t *testing.T) {
+			assert.Equal(t, tc.encoding, acceptedEncoding(tc.header), "Chosen encoding meets expectations.")
+		})
+	}
+}
+
+func TestRepoServerCompression(t *testing.T) {
+	serverRoot := t.TempDir()
+
+	text := strings.Repeat("foo does things, and does them well.\n", 100)
+	binary := append([]byte{0x7f, 'E', 'L', 'F'}, bytes.Repeat([]byte{0}, 4096)...)
+
+	files := map[string][]byte{
+		"dbt-tools/foo/description.txt":          []byte(text),
+		"dbt-tools/foo/tiny.txt":                 []byte("tiny"),
+		"dbt-tools/foo/deps.json":                []byte(fmt.Sprintf(`{"dependencies": {}, "padding": %q}`, text)),
+		"dbt-tools/foo/1.0.0/linux/amd64/foo":    binary,
+		"dbt-tools/foo/1.0.0/linux/amd64/foo.gz": []byte(text), // served as application/gzip whatever it holds
+	}
+
+	for path, content := range files {
+		fullPath := fmt.Sprintf("%s/%s", serverRoot, path)
+
+		err := os.MkdirAll(fullPath[:strings.LastIndex(fullPath, "/")], 0755)
+		if err != nil {
+			t.Fatalf("Failed creating dir: %s", err)
+		}
+
+		err = os.WriteFile(fullPath, content, 0644)
+		if err != nil {
+			t.Fatalf("Failed writing %s: %s", path, err)
+		}
+	}
+
+	inputs := []struct {
+		name     string
+		disable  bool
+		method   string
+		path     string
+		accept   string
+		rangeHdr string
+		encoding string
+	}{
+		{"text gzip", false, http.MethodGet, "/dbt-tools/foo/description.txt", "gzip", "", ENCODING_GZIP},
+		{"text deflate", false, http.MethodGet, "/dbt-tools/foo/description.txt", "deflate", "", ENCODING_DEFLATE},
+		{"json", false, http.MethodGet, "/dbt-tools/foo/deps.json", "gzip", "", ENCODING_GZIP},
+		{"directory listing", false, http.MethodGet, "/dbt-tools/foo/", "gzip", "", ""},
+		{"not accepted", false, http.MethodGet, "/dbt-tools/foo/description.txt", "", "", ""},
+		{"too small", false, http.MethodGet, "/dbt-tools/foo/tiny.txt", "gzip", "", ""},
+		{"binary", false, http.MethodGet, "/dbt-tools/foo/1.0.0/linux/amd64/foo", "gzip", "", ""},
+		{"already compressed", false, http.MethodGet, "/dbt-tools/foo/1.0.0/linux/amd64/foo.gz", "gzip", "", ""},
+		{"head", false, http.MethodHead, "/dbt-tools/foo/description.txt", "gzip", "", ""},
+		{"range", false, http.MethodGet, "/dbt-tools/foo/description.txt", "gzip", "bytes=0-99", ""},
+		{"missing", false, http.MethodGet, "/dbt-tools/foo/nope.txt", "gzip", "", ""},
+		{"disabled", true, http.MethodGet, "/dbt-tools/foo/description.txt", "gzip", "", ""},
+	}
+
+	for _, tc := range inputs {
+		t.Run(tc.name, func(t *testing.T) {
+			repoServer := &DBTRepoServer{
+				ServerRoot:         serverRoot,
+				DisableCompression: tc.disable,
+			}
+
+			r, err := repoServer.Router()
+			if err != nil {
+				t.Fatalf("Failed creating router: %s", err)
+			}
+
+			req := httptest.NewRequest(tc.method, tc.path, nil)
+			if tc.accept != "" {
+				req.Header.Set("Accept-Encoding", tc.accept)
+			}
+
+			if tc.rangeHdr != "" {
+				req.Header.Set("Range", tc.rangeHdr)
+			}
+
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, req)
+
+			assert.Equal(t, tc.encoding, w.Header().Get("Content-Encoding"), "Content encoding meets expectations.")
+
+			if tc.encoding == "" {
+				return
+			}
+
+			assert.Equal(t, "", w.Header().Get("Content-Length"), "Compressed responses don't claim the file's length.")
+
+			var reader io.Reader
+			if tc.encoding == ENCODING_GZIP {
+				reader, err = gzip.NewReader(w.Body)
+				if err != nil {
+					t.Fatalf("Failed reading gzip: %s", err)
+				}
+			} else {
+				reader = flate.NewReader(w.Body)
+			}
+
+			body, err := ioutil.ReadAll(reader)
+			if err != nil {
+				t.Fatalf("Failed decompressing: %s", err)
+			}
+
+			expected := files[strings.TrimPrefix(tc.path, "/")]
+			assert.Equal(t, string(expected), string(body), "Decompressed body is the file.")
+		})
+	}
+}
+
+func TestFetchFromCompressingRepoServer(t *testing.T) {
+	repoRoot, config, _ := newTestSignedRepo(t, "foo", "1.0.0", "1.1.0")
+
+	// small enough that everything text gets compressed
+	repoServer := &DBTRepoServer{
+		ServerRoot:       repoRoot,
+		CompressMinBytes: 1,
+	}
+
+	r, err := repoServer.Router()
+	if err != nil {
+		t.Fatalf("Failed creating router: %s", err)
+	}
+
+	server := httptest.NewServer(r)
+	defer server.Close()
+
+	config.Dbt.TrustStore = fmt.Sprintf("%s/truststore", server.URL)
+	config.Tools.Repo = fmt.Sprintf("%s/dbt-tools", server.URL)
+
+	homedir := t.TempDir()
+
+	err = GenerateDbtDir(homedir, false)
+	if err != nil {
+		t.Fatalf("Failed creating dbt dir: %s", err)
+	}
+
+	obj := &DBT{Config: config}
+
+	err = obj.FetchTrustStore(homedir)
+	if err != nil {
+		t.Fatalf("Failed fetching truststore: %s", err)
+	}
+
+	expected, err := ioutil.ReadFile(fmt.Sprintf("%s/truststore", repoRoot))
+	if err != nil {
+		t.Fatalf("Failed reading truststore: %s", err)
+	}
+
+	actual, err := ioutil.ReadFile(TruststorePath(homedir))
+	if err != nil {
+		t.Fatalf("Failed reading fetched truststore: %s", err)
+	}
+
+	assert.Equal(t, string(expected), string(actual), "Compressed truststore is fetched as is.")
+
+	versions, err := obj.FetchToolVersions("foo")
+	if err != nil {
+		t.Fatalf("Failed listing versions: %s", err)
+	}
+
+	assert.Equal(t, []string{"1.0.0", "1.1.0"}, versions, "Versions are listed from a compressed index.")
+}
@@ -0,0 +1,103 @@
+// Copyright © 2019 Nik Ogura <nik.ogura@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package dbt
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// CORS_ALLOW_ANY an entry in CORSAllowOrigins that allows requests from any origin
+const CORS_ALLOW_ANY = "*"
+
+// CORS_ALLOW_METHODS the methods browsers may use cross origin.  Only reads are allowed, uploads stay same origin.
+const CORS_ALLOW_METHODS = "GET, HEAD, OPTIONS"
+
+// CORS_ALLOW_HEADERS the request headers browsers may send cross origin, so authenticated GETs still work
+const CORS_ALLOW_HEADERS = "Authorization, Token, X-Authenticated-Username"
+
+// CORS_MAX_AGE how long, in seconds, browsers may cache a preflight response
+const CORS_MAX_AGE = "600"
+
+// CORS adds CORS headers to GET and HEAD responses for requests from an allowed origin.  Requests from any other origin are served as usual, just without the headers, so the browser blocks them.
+func (d *DBTRepoServer) CORS(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodGet || r.Method == http.MethodHead {
+			d.setCORSHeaders(w, r)
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// CORSPreflight answers preflight OPTIONS requests.  Preflights aren't authenticated, since browsers never send credentials with them.
+func (d *DBTRepoServer) CORSPreflight(w http.ResponseWriter, r *http.Request) {
+	if !d.setCORSHeaders(w, r) {
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+
+	w.Header().Set("Access-Control-Allow-Methods", CORS_ALLOW_METHODS)
+	w.Header().Set("Access-Control-Allow-Headers", CORS_ALLOW_HEADERS)
+	w.Header().Set("Access-Control-Max-Age", CORS_MAX_AGE)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// setCORSHeaders sets the Access-Control-Allow-Origin header if the request's Origin is allowed, and reports whether it was.
+func (d *DBTRepoServer) setCORSHeaders(w http.ResponseWriter, r *http.Request) (allowed bool) {
+	// the answer depends on the Origin, so caches mustn't hand one origin's response to another
+	w.Header().Add("Vary", "Origin")
+
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return false
+	}
+
+	for _, allowedOrigin := range d.CORSAllowOrigins {
+		if strings.EqualFold(strings.TrimSuffix(allowedOrigin, "/"), origin) {
+			w.Header().Set("Access-Control-Allow-Origin", origin)
+			w.Header().Set("Access-Control-Allow-Credentials", "true")
+			return true
+		}
+	}
+
+	// any origin can read, but only anonymously.  Credentials go only to origins that are named.
+	for _, allowedOrigin := range d.CORSAllowOrigins {
+		if allowedOrigin == CORS_ALLOW_ANY {
+			w.Header().Set("Access-Control-Allow-Origin", CORS_ALLOW_ANY)
+			return true
+		}
+	}
+
+	return false
+}
+
+// validateCORSOrigins checks that each allowed origin looks like an origin, i.e. a scheme and host with no path, since those are all a browser ever sends.
+func validateCORSOrigins(origins []string) (problems []string) {
+	for _, origin := range origins {
+		if origin == CORS_ALLOW_ANY {
+			continue
+		}
+
+		u, err := url.Parse(origin)
+		if err != nil || u.Scheme == "" || u.Host == "" || strings.TrimSuffix(u.Path, "/") != "" || u.RawQuery != "" {
+			problems = append(problems, fmt.Sprintf("corsAllowOrigins entry %q isn't an origin.  Use a scheme and host like https://dbt.example.com, or %s for any origin.", origin, CORS_ALLOW_ANY))
+		}
+	}
+
+	return problems
+}
@@ -0,0 +1,79 @@
+package dbt
+
+import (
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+)
+
+func TestRepoServerCORS(t *testing.T) {
+	serverRoot := t.TempDir()
+
+	err := os.MkdirAll(fmt.Sprintf("%s/dbt-tools/foo", serverRoot), 0755)
+	if err != nil {
+		t.Fatalf("Failed creating tool dir: %s", err)
+	}
+
+	err = os.WriteFile(fmt.Sprintf("%s/dbt-tools/foo/description.txt", serverRoot), []byte("foo does things"), 0644)
+	if err != nil {
+		t.Fatalf("Failed writing test file: %s", err)
+	}
+
+	inputs := []struct {
+		name        string
+		origins     []string
+		method      string
+		origin      string
+		status      int
+		allowOrigin string
+		credentials bool
+	}{
+		{"off", nil, http.MethodGet, "https://ui.example.com", http.StatusOK, "", false},
+		{"off preflight", nil, http.MethodOptions, "https://ui.example.com", http.StatusMethodNotAllowed, "", false},
+		{"allowed get", []string{"https://ui.example.com"}, http.MethodGet, "https://ui.example.com", http.StatusOK, "https://ui.example.com", true},
+		{"allowed head", []string{"https://ui.example.com/"}, http.MethodHead, "https://ui.example.com", http.StatusOK, "https://ui.example.com", true},
+		{"other origin", []string{"https://ui.example.com"}, http.MethodGet, "https://evil.example.com", http.StatusOK, "", false},
+		{"no origin", []string{"https://ui.example.com"}, http.MethodGet, "", http.StatusOK, "", false},
+		{"allowed preflight", []string{"https://ui.example.com"}, http.MethodOptions, "https://ui.example.com", http.StatusNoContent, "https://ui.example.com", true},
+		{"other origin preflight", []string{"https://ui.example.com"}, http.MethodOptions, "https://evil.example.com", http.StatusForbidden, "", false},
+		{"any origin", []string{CORS_ALLOW_ANY}, http.MethodGet, "https://whoever.example.com", http.StatusOK, CORS_ALLOW_ANY, false},
+	}
+
+	for _, tc := range inputs {
+		t.Run(tc.name, func(t *testing.T) {
+			repoServer := &DBTRepoServer{
+				ServerRoot:       serverRoot,
+				CORSAllowOrigins: tc.origins,
+			}
+
+			r, err := repoServer.Router()
+			if err != nil {
+				t.Fatalf("Failed creating router: %s", err)
+			}
+
+			req := httptest.NewRequest(tc.method, "/dbt-tools/foo/description.txt", nil)
+			if tc.origin != "" {
+				req.Header.Set("Origin", tc.origin)
+			}
+
+			if tc.method == http.MethodOptions {
+				req.Header.Set("Access-Control-Request-Method", http.MethodGet)
+			}
+
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, req)
+
+			assert.Equal(t, tc.status, w.Code, "Status meets expectations.")
+			assert.Equal(t, tc.allowOrigin, w.Header().Get("Access-Control-Allow-Origin"), "Allowed origin meets expectations.")
+			assert.Equal(t, tc.credentials, w.Header().Get("Access-Control-Allow-Credentials") == "true", "Credentials are only allowed for named origins.")
+
+			if tc.method == http.MethodOptions && tc.status == http.StatusNoContent {
+				assert.Equal(t, CORS_ALLOW_METHODS, w.Header().Get("Access-Control-Allow-Methods"), "Preflight lists the allowed methods.")
+				assert.Equal(t, CORS_ALLOW_HEADERS, w.Header().Get("Access-Control-Allow-Headers"), "Preflight lists the allowed headers.")
+			}
+		})
+	}
+}
@@ -0,0 +1,154 @@
+// Copyright © 2019 Nik Ogura <nik.ogura@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package dbt
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"net/http"
+	"path"
+	"regexp"
+	"strings"
+)
+
+var sha256Regex = regexp.MustCompile(`^[0-9a-f]{64}$`)
+
+// ETagHandler gives every GET and HEAD a strong ETag, and answers If-None-Match with a 304 when it matches.  Files are tagged with the checksum in their .sha256 sidecar if there is one, or else their size and modification time, and the file server does the rest.  Anything generated, such as directory listings and indices, is tagged with a hash of what would be sent.
+func ETagHandler(fs http.FileSystem, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		if etag := fileETag(fs, path.Clean(r.URL.Path)); etag != "" {
+			w.Header().Set("ETag", etag)
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		buffered := &bufferedResponse{ResponseWriter: w}
+		next.ServeHTTP(buffered, r)
+
+		status := buffered.Status()
+
+		if status == http.StatusOK && w.Header().Get("ETag") == "" {
+			sum := sha256.Sum256(buffered.body.Bytes())
+			etag := fmt.Sprintf(`"sha256-%x"`, sum)
+			w.Header().Set("ETag", etag)
+
+			if etagMatches(r.Header.Get("If-None-Match"), etag) {
+				w.Header().Del("Content-Type")
+				w.Header().Del("Content-Length")
+				w.WriteHeader(http.StatusNotModified)
+				return
+			}
+		}
+
+		w.WriteHeader(status)
+		_, _ = w.Write(buffered.body.Bytes())
+	})
+}
+
+// fileETag returns the ETag for a regular file in fs, or "" if name isn't one.
+func fileETag(fs http.FileSystem, name string) (etag string) {
+	file, err := fs.Open(name)
+	if err != nil {
+		return etag
+	}
+
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil || info.IsDir() {
+		return etag
+	}
+
+	if checksum := sidecarSha256(fs, name); checksum != "" {
+		return fmt.Sprintf(`"sha256-%s"`, checksum)
+	}
+
+	return fmt.Sprintf(`"%x-%x"`, info.ModTime().UnixNano(), info.Size())
+}
+
+// sidecarSha256 returns the checksum from name's .sha256 sidecar, or "" if there isn't a usable one.
+func sidecarSha256(fs http.FileSystem, name string) (checksum string) {
+	file, err := fs.Open(fmt.Sprintf("%s.sha256", name))
+	if err != nil {
+		return checksum
+	}
+
+	defer file.Close()
+
+	// the checksum is first, possibly followed by a file name like sha256sum writes
+	content, err := io.ReadAll(io.LimitReader(file, 1024))
+	if err != nil {
+		return checksum
+	}
+
+	fields := strings.Fields(string(content))
+	if len(fields) == 0 || !sha256Regex.MatchString(strings.ToLower(fields[0])) {
+		return checksum
+	}
+
+	return strings.ToLower(fields[0])
+}
+
+// etagMatches reports whether an If-None-Match header matches etag.  Like the file server, it uses the weak comparison, so a compressed copy still matches the file it came from.
+func etagMatches(ifNoneMatch string, etag string) bool {
+	for _, candidate := range strings.Split(ifNoneMatch, ",") {
+		candidate = strings.TrimSpace(candidate)
+
+		if candidate == "*" || strings.TrimPrefix(candidate, "W/") == strings.TrimPrefix(etag, "W/") {
+			return true
+		}
+	}
+
+	return false
+}
+
+// bufferedResponse holds on to a response, so it can be tagged before it's sent.
+type bufferedResponse struct {
+	http.ResponseWriter
+	status int
+	body   bytes.Buffer
+}
+
+// WriteHeader records the status, rather than sending it.
+func (b *bufferedResponse) WriteHeader(status int) {
+	if b.status == 0 {
+		b.status = status
+	}
+}
+
+// Write holds on to the body, rather than sending it.
+func (b *bufferedResponse) Write(p []byte) (int, error) {
+	if b.status == 0 {
+		b.status = http.StatusOK
+	}
+
+	return b.body.Write(p)
+}
+
+// Status returns the status code, which is 200 if nothing set one.
+func (b *bufferedResponse) Status() int {
+	if b.status == 0 {
+		return http.StatusOK
+	}
+
+	return b.status
+}
@@ -0,0 +1,119 @@
+package dbt
+
+import (
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestRepoServerETags(t *testing.T) {
+	serverRoot := t.TempDir()
+
+	checksum := strings.Repeat("ab", 32)
+
+	files := map[string]string{
+		"dbt-tools/foo/1.0.0/linux/amd64/foo":        "#!/bin/sh\necho foo\n",
+		"dbt-tools/foo/1.0.0/linux/amd64/foo.sha256": checksum + "  foo\n",
+		"dbt-tools/foo/description.txt":              strings.Repeat("foo does things.\n", 100),
+	}
+
+	for path, content := range files {
+		fullPath := fmt.Sprintf("%s/%s", serverRoot, path)
+
+		err := os.MkdirAll(fullPath[:strings.LastIndex(fullPath, "/")], 0755)
+		if err != nil {
+			t.Fatalf("Failed creating dir: %s", err)
+		}
+
+		err = os.WriteFile(fullPath, []byte(content), 0644)
+		if err != nil {
+			t.Fatalf("Failed writing %s: %s", path, err)
+		}
+	}
+
+	repoServer := &DBTRepoServer{
+		ServerRoot:  serverRoot,
+		PrettyIndex: true,
+	}
+
+	r, err := repoServer.Router()
+	if err != nil {
+		t.Fatalf("Failed creating router: %s", err)
+	}
+
+	get := func(path string, accept string, ifNoneMatch string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		if accept != "" {
+			req.Header.Set("Accept-Encoding", accept)
+		}
+
+		if ifNoneMatch != "" {
+			req.Header.Set("If-None-Match", ifNoneMatch)
+		}
+
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+
+		return w
+	}
+
+	inputs := []struct {
+		name   string
+		path   string
+		accept string
+		etag   string
+	}{
+		{"sidecar checksum", "/dbt-tools/foo/1.0.0/linux/amd64/foo", "", fmt.Sprintf(`"sha256-%s"`, checksum)},
+		{"size and mtime", "/dbt-tools/foo/1.0.0/linux/amd64/foo.sha256", "", ""},
+		{"compressed", "/dbt-tools/foo/description.txt", "gzip", ""},
+		{"version index", "/dbt-tools/foo/" + VERSION_INDEX_FILE, "", ""},
+		{"pretty index", "/dbt-tools/", "", ""},
+		{"directory listing", "/dbt-tools/foo/1.0.0/", "", ""},
+	}
+
+	for _, tc := range inputs {
+		t.Run(tc.name, func(t *testing.T) {
+			first := get(tc.path, tc.accept, "")
+
+			assert.Equal(t, http.StatusOK, first.Code, "First fetch succeeds.")
+
+			etag := first.Header().Get("ETag")
+			assert.NotEqual(t, "", etag, "Response is tagged.")
+
+			if tc.etag != "" {
+				assert.Equal(t, tc.etag, etag, "ETag meets expectations.")
+			}
+
+			if tc.accept != "" {
+				assert.True(t, strings.HasPrefix(etag, "W/"), "Compressed responses get a weak tag.")
+			}
+
+			second := get(tc.path, tc.accept, etag)
+
+			assert.Equal(t, http.StatusNotModified, second.Code, "Unchanged content isn't sent again.")
+			assert.Equal(t, 0, second.Body.Len(), "Not modified has no body.")
+
+			third := get(tc.path, tc.accept, `"something-else"`)
+
+			assert.Equal(t, http.StatusOK, third.Code, "A stale tag gets the content.")
+			assert.Equal(t, first.Body.String(), third.Body.String(), "Content is the same either way.")
+		})
+	}
+
+	// changing the content changes the tag
+	before := get("/dbt-tools/foo/"+VERSION_INDEX_FILE, "", "").Header().Get("ETag")
+
+	err = os.MkdirAll(fmt.Sprintf("%s/dbt-tools/foo/1.1.0", serverRoot), 0755)
+	if err != nil {
+		t.Fatalf("Failed creating version dir: %s", err)
+	}
+
+	after := get("/dbt-tools/foo/"+VERSION_INDEX_FILE, "", before)
+
+	assert.Equal(t, http.StatusOK, after.Code, "Changed index is sent again.")
+	assert.NotEqual(t, before, after.Header().Get("ETag"), "Changed index gets a new tag.")
+}
@@ -0,0 +1,103 @@
+package dbt
+
+import (
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"testing/fstest"
+)
+
+func TestRepoServerFiles(t *testing.T) {
+	repoServer := &DBTRepoServer{
+		Port: 9999,
+		Files: http.FS(fstest.MapFS{
+			"dbt-tools/foo/description.txt":       {Data: []byte("foo does things")},
+			"dbt-tools/foo/1.0.0/linux/amd64/foo": {Data: []byte("#!/bin/sh\necho foo\n")},
+		}),
+	}
+
+	err := repoServer.Validate()
+	assert.Nil(t, err, "A built in tree needs no server root.")
+
+	r, err := repoServer.Router()
+	if err != nil {
+		t.Fatalf("Failed creating router: %s", err)
+	}
+
+	server := httptest.NewServer(r)
+	defer server.Close()
+
+	inputs := []struct {
+		name   string
+		path   string
+		status int
+		body   string
+	}{
+		{"file", "/dbt-tools/foo/description.txt", http.StatusOK, "foo does things"},
+		{"version index", "/dbt-tools/foo/" + VERSION_INDEX_FILE, http.StatusOK, `{"versions":["1.0.0"],"latest":"1.0.0"}` + "\n"},
+		{"missing", "/dbt-tools/bar/description.txt", http.StatusNotFound, ""},
+	}
+
+	for _, tc := range inputs {
+		t.Run(tc.name, func(t *testing.T) {
+			resp, err := http.Get(server.URL + tc.path)
+			if err != nil {
+				t.Fatalf("Failed fetching: %s", err)
+			}
+
+			defer resp.Body.Close()
+
+			body, _ := ioutil.ReadAll(resp.Body)
+
+			assert.Equal(t, tc.status, resp.StatusCode, "Status meets expectations.")
+
+			if tc.body != "" {
+				assert.Equal(t, tc.body, string(body), "Body meets expectations.")
+			}
+		})
+	}
+
+	repoServer.AuthTypePut = AUTH_BASIC_HTPASSWD
+
+	err = repoServer.Validate()
+	assert.NotNil(t, err, "Uploads to a built in tree are refused.")
+}
+
+func TestFetchToolFromFiles(t *testing.T) {
+	repoRoot, config, _ := newTestSignedRepo(t, "foo", "1.0.0")
+
+	// os.DirFS is an fs.FS, same as an embed.FS would be
+	repoServer := &DBTRepoServer{Files: http.FS(os.DirFS(repoRoot))}
+
+	r, err := repoServer.Router()
+	if err != nil {
+		t.Fatalf("Failed creating router: %s", err)
+	}
+
+	server := httptest.NewServer(r)
+	defer server.Close()
+
+	config.Dbt.TrustStore = fmt.Sprintf("%s/truststore", server.URL)
+	config.Tools.Repo = fmt.Sprintf("%s/dbt-tools", server.URL)
+
+	homedir := t.TempDir()
+
+	err = GenerateDbtDir(homedir, false)
+	if err != nil {
+		t.Fatalf("Failed creating dbt dir: %s", err)
+	}
+
+	obj := &DBT{Config: config}
+
+	err = obj.FetchTrustStore(homedir)
+	if err != nil {
+		t.Fatalf("Failed fetching truststore: %s", err)
+	}
+
+	_, err = obj.FetchTool("foo", "1.0.0", homedir)
+	assert.Nil(t, err, "Signed tool is fetched from a built in tree.")
+}
@@ -0,0 +1,105 @@
+// Copyright © 2019 Nik Ogura <nik.ogura@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package dbt
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/keybase/go-crypto/openpgp"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+	"io"
+	"net/http"
+	"path"
+	"sort"
+)
+
+// TRUSTSTORE_SERVER_PATH where the reposerver serves the truststore from, relative to its root
+const TRUSTSTORE_SERVER_PATH = "/dbt/truststore"
+
+// TRUSTSTORE_FINGERPRINTS_PATH where the reposerver reports the fingerprints of the keys in its truststore
+const TRUSTSTORE_FINGERPRINTS_PATH = TRUSTSTORE_SERVER_PATH + "/fingerprints"
+
+// TrustStoreKey a key in a truststore, as reported by the reposerver.  KeyId and Fingerprint are upper case hex, the way gpg shows them.
+type TrustStoreKey struct {
+	KeyId       string   `json:"keyid"`
+	Fingerprint string   `json:"fingerprint"`
+	Identities  []string `json:"identities,omitempty"`
+}
+
+// TrustStoreFingerprints the keys in a truststore, in the order they appear in it
+type TrustStoreFingerprints struct {
+	Keys []TrustStoreKey `json:"keys"`
+}
+
+// ReadTrustStoreFingerprints parses an armored truststore, and returns the id and fingerprint of every key in it.
+func ReadTrustStoreFingerprints(truststore io.Reader) (fingerprints TrustStoreFingerprints, err error) {
+	entities, err := openpgp.ReadArmoredKeyRing(truststore)
+	if err != nil {
+		err = errors.Wrapf(err, "failed to parse truststore")
+		return fingerprints, err
+	}
+
+	fingerprints.Keys = make([]TrustStoreKey, 0, len(entities))
+
+	for _, entity := range entities {
+		key := TrustStoreKey{
+			KeyId:       fmt.Sprintf("%016X", entity.PrimaryKey.KeyId),
+			Fingerprint: fmt.Sprintf("%X", entity.PrimaryKey.Fingerprint),
+		}
+
+		for name := range entity.Identities {
+			key.Identities = append(key.Identities, name)
+		}
+
+		sort.Strings(key.Identities)
+
+		fingerprints.Keys = append(fingerprints.Keys, key)
+	}
+
+	return fingerprints, err
+}
+
+// TrustStoreFingerprintsHandler wraps a file server so that a GET of TRUSTSTORE_FINGERPRINTS_PATH returns the fingerprints of the keys in the served truststore, for clients to check the keys they pin against.
+func TrustStoreFingerprintsHandler(fs http.FileSystem, files http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if path.Clean(r.URL.Path) != TRUSTSTORE_FINGERPRINTS_PATH || (r.Method != http.MethodGet && r.Method != http.MethodHead) {
+			files.ServeHTTP(w, r)
+			return
+		}
+
+		file, err := fs.Open(TRUSTSTORE_SERVER_PATH)
+		if err != nil {
+			http.NotFound(w, r)
+			return
+		}
+
+		defer file.Close()
+
+		fingerprints, err := ReadTrustStoreFingerprints(file)
+		if err != nil {
+			log.Errorf("failed reading fingerprints of %s: %s", TRUSTSTORE_SERVER_PATH, err)
+			http.Error(w, "failed reading truststore", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+
+		err = json.NewEncoder(w).Encode(fingerprints)
+		if err != nil {
+			log.Errorf("failed writing fingerprints of %s: %s", TRUSTSTORE_SERVER_PATH, err)
+		}
+	})
+}
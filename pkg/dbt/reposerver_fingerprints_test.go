@@ -0,0 +1,100 @@
+// Copyright © 2019 Nik Ogura <nik.ogura@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package dbt
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"testing/fstest"
+)
+
+func TestTrustStoreFingerprints(t *testing.T) {
+	repoRoot, _, signer := newTestSignedRepo(t, "foo")
+
+	truststore, err := ioutil.ReadFile(fmt.Sprintf("%s/truststore", repoRoot))
+	if err != nil {
+		t.Fatalf("Failed reading truststore: %s", err)
+	}
+
+	expected := TrustStoreFingerprints{
+		Keys: []TrustStoreKey{
+			{
+				KeyId:       fmt.Sprintf("%016X", signer.PrimaryKey.KeyId),
+				Fingerprint: fmt.Sprintf("%X", signer.PrimaryKey.Fingerprint),
+				Identities:  []string{"tester <tester@nikogura.com>"},
+			},
+		},
+	}
+
+	inputs := []struct {
+		name   string
+		files  fstest.MapFS
+		method string
+		status int
+	}{
+		{"truststore", fstest.MapFS{"dbt/truststore": {Data: truststore}}, http.MethodGet, http.StatusOK},
+		{"head", fstest.MapFS{"dbt/truststore": {Data: truststore}}, http.MethodHead, http.StatusOK},
+		{"no truststore", fstest.MapFS{"dbt/other": {Data: []byte("foo")}}, http.MethodGet, http.StatusNotFound},
+		{"not a truststore", fstest.MapFS{"dbt/truststore": {Data: []byte("foo")}}, http.MethodGet, http.StatusInternalServerError},
+	}
+
+	for _, tc := range inputs {
+		t.Run(tc.name, func(t *testing.T) {
+			repoServer := &DBTRepoServer{Files: http.FS(tc.files)}
+
+			r, err := repoServer.Router()
+			if err != nil {
+				t.Fatalf("Failed creating router: %s", err)
+			}
+
+			server := httptest.NewServer(r)
+			defer server.Close()
+
+			req, err := http.NewRequest(tc.method, server.URL+TRUSTSTORE_FINGERPRINTS_PATH, nil)
+			if err != nil {
+				t.Fatalf("Failed creating request: %s", err)
+			}
+
+			resp, err := http.DefaultClient.Do(req)
+			if err != nil {
+				t.Fatalf("Failed fetching fingerprints: %s", err)
+			}
+
+			defer resp.Body.Close()
+
+			assert.Equal(t, tc.status, resp.StatusCode, "Status meets expectations.")
+
+			if tc.status != http.StatusOK || tc.method != http.MethodGet {
+				return
+			}
+
+			assert.Equal(t, "application/json", resp.Header.Get("Content-Type"), "Fingerprints are JSON.")
+
+			var actual TrustStoreFingerprints
+
+			err = json.NewDecoder(resp.Body).Decode(&actual)
+			if err != nil {
+				t.Fatalf("Failed decoding fingerprints: %s", err)
+			}
+
+			assert.Equal(t, expected, actual, "Fingerprints match the truststore's keys.")
+		})
+	}
+}
@@ -0,0 +1,311 @@
+// Copyright © 2019 Nik Ogura <nik.ogura@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package dbt
+
+import (
+	"encoding/json"
+	log "github.com/sirupsen/logrus"
+	"html/template"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// INDEX_DESCRIPTION_MAX_BYTES the most of a tool's description shown on an index page
+const INDEX_DESCRIPTION_MAX_BYTES = 1024
+
+// indexSemver matches the names of version directories
+var indexSemver = regexp.MustCompile(`^\d+\.\d+\.\d+$`)
+
+// indexTemplate renders the pretty index.  Each entry gets exactly one anchor, linking to it by name like http.FileServer does, so the client's listing parsers read it the same way.
+var indexTemplate = template.Must(template.New("index").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>{{.Path}}</title>
+<style>
+body { font-family: sans-serif; margin: 2em; }
+table { border-collapse: collapse; }
+th, td { text-align: left; padding: 0.25em 1em; border-bottom: 1px solid #ddd; vertical-align: top; }
+td.description { white-space: pre-wrap; }
+</style>
+</head>
+<body>
+<h1>{{.Path}}</h1>
+<table>
+{{if .Tools}}<tr><th>Tool</th><th>Latest</th><th>Description</th></tr>
+{{range .Entries}}<tr><td><a href="{{.Href}}">{{.Name}}</a></td><td>{{.Latest}}</td><td class="description">{{.Description}}</td></tr>
+{{end}}{{else}}<tr><th>Version</th></tr>
+{{range .Entries}}<tr><td><a href="{{.Href}}">{{.Name}}</a></td></tr>
+{{end}}{{end}}</table>
+</body>
+</html>
+`))
+
+// indexPage is what the index template renders.  Tools is true for a directory of tools, and false for a directory of versions.
+type indexPage struct {
+	Path    string
+	Tools   bool
+	Entries []indexEntry
+}
+
+// indexEntry is one line of an index page.  Latest and Description are only set for tools.
+type indexEntry struct {
+	Name        string
+	Href        string
+	Latest      string
+	Description string
+}
+
+// PrettyIndexHandler wraps a file server so that directories of tools list each tool's latest version and description, and directories of versions list the versions newest first.  Any other directory, or one with an index.html, is left to the file server.
+func PrettyIndexHandler(fs http.FileSystem, files http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet || !strings.HasSuffix(r.URL.Path, "/") {
+			files.ServeHTTP(w, r)
+			return
+		}
+
+		page, ok := buildIndexPage(fs, path.Clean(r.URL.Path))
+		if !ok {
+			files.ServeHTTP(w, r)
+			return
+		}
+
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+
+		err := indexTemplate.Execute(w, page)
+		if err != nil {
+			log.Errorf("failed rendering index of %s: %s", r.URL.Path, err)
+		}
+	})
+}
+
+// buildIndexPage works out what kind of directory dirPath is, and what goes on its index page.  ok is false if it's neither a directory of tools nor of versions.
+func buildIndexPage(fs http.FileSystem, dirPath string) (page indexPage, ok bool) {
+	entries, ok := readIndexDir(fs, dirPath)
+	if !ok {
+		return page, false
+	}
+
+	page.Path = dirPath
+
+	versions := dirVersions(entries)
+	others := make([]indexEntry, 0)
+
+	for _, entry := range entries {
+		if entry.IsDir() && indexSemver.MatchString(entry.Name()) {
+			continue
+		}
+
+		others = append(others, newIndexEntry(entry.Name(), entry.IsDir()))
+	}
+
+	// a directory of versions is a tool
+	if len(versions) > 0 {
+		sort.Slice(versions, func(i, j int) bool {
+			return VersionAIsNewerThanB(versions[i], versions[j])
+		})
+
+		for _, version := range versions {
+			page.Entries = append(page.Entries, newIndexEntry(version, true))
+		}
+
+		page.Entries = append(page.Entries, others...)
+
+		return page, true
+	}
+
+	// a directory of tools has at least one subdirectory that's a tool
+	for i, entry := range others {
+		if !strings.HasSuffix(entry.Href, "/") {
+			continue
+		}
+
+		latest := latestIndexVersion(fs, path.Join(dirPath, entry.Name))
+		if latest == "" {
+			continue
+		}
+
+		page.Tools = true
+		others[i].Latest = latest
+		others[i].Description = indexDescription(fs, path.Join(dirPath, entry.Name, latest, SYNC_DESCRIPTION_FILE))
+	}
+
+	page.Entries = others
+
+	return page, page.Tools
+}
+
+// newIndexEntry makes an index entry linking to name, with a trailing slash for directories like http.FileServer.
+func newIndexEntry(name string, dir bool) (entry indexEntry) {
+	entry = indexEntry{Name: name, Href: (&url.URL{Path: name}).String()}
+
+	if dir {
+		entry.Name += "/"
+		entry.Href += "/"
+	}
+
+	return entry
+}
+
+// readIndexDir lists a directory for an index page.  ok is false if it's not a directory, or has an index.html of its own.
+func readIndexDir(fs http.FileSystem, dirPath string) (entries []os.FileInfo, ok bool) {
+	entries, ok = readDir(fs, dirPath)
+	if !ok {
+		return entries, ok
+	}
+
+	for _, entry := range entries {
+		if entry.Name() == "index.html" {
+			return entries, false
+		}
+	}
+
+	return entries, ok
+}
+
+// readDir lists a directory, sorted by name.  ok is false if it's not a directory.
+func readDir(fs http.FileSystem, dirPath string) (entries []os.FileInfo, ok bool) {
+	dir, err := fs.Open(dirPath)
+	if err != nil {
+		return entries, false
+	}
+
+	defer dir.Close()
+
+	info, err := dir.Stat()
+	if err != nil || !info.IsDir() {
+		return entries, false
+	}
+
+	entries, err = dir.Readdir(-1)
+	if err != nil {
+		return entries, false
+	}
+
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].Name() < entries[j].Name()
+	})
+
+	return entries, true
+}
+
+// dirVersions returns the names of the version directories among entries.
+func dirVersions(entries []os.FileInfo) (versions []string) {
+	versions = make([]string, 0)
+
+	for _, entry := range entries {
+		if entry.IsDir() && indexSemver.MatchString(entry.Name()) {
+			versions = append(versions, entry.Name())
+		}
+	}
+
+	return versions
+}
+
+// latestIndexVersion returns the newest version directory in a tool's directory, or "" if it has none.
+func latestIndexVersion(fs http.FileSystem, toolPath string) (latest string) {
+	entries, ok := readDir(fs, toolPath)
+	if !ok {
+		return latest
+	}
+
+	return LatestVersion(dirVersions(entries))
+}
+
+// indexDescription reads the start of a tool's description, or returns "" if there isn't one.
+func indexDescription(fs http.FileSystem, descriptionPath string) (description string) {
+	file, err := fs.Open(descriptionPath)
+	if err != nil {
+		return description
+	}
+
+	defer file.Close()
+
+	content, err := ioutil.ReadAll(io.LimitReader(file, INDEX_DESCRIPTION_MAX_BYTES))
+	if err != nil {
+		return description
+	}
+
+	return strings.TrimSpace(string(content))
+}
+
+// VersionIndexHandler wraps a file server so that a GET of <tool>/VERSION_INDEX_FILE returns a VersionIndex generated from the tool's directory.  An index file that's actually in the repo is served as is.
+func VersionIndexHandler(fs http.FileSystem, files http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if path.Base(r.URL.Path) != VERSION_INDEX_FILE || (r.Method != http.MethodGet && r.Method != http.MethodHead) {
+			files.ServeHTTP(w, r)
+			return
+		}
+
+		if file, err := fs.Open(path.Clean(r.URL.Path)); err == nil {
+			_ = file.Close()
+			files.ServeHTTP(w, r)
+			return
+		}
+
+		index, ok := buildVersionIndex(fs, path.Dir(path.Clean(r.URL.Path)))
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+
+		err := json.NewEncoder(w).Encode(index)
+		if err != nil {
+			log.Errorf("failed writing version index of %s: %s", r.URL.Path, err)
+		}
+	})
+}
+
+// buildVersionIndex lists the versions in a tool's directory, oldest first.  The latest version is whatever the tool's LATEST_FILE says, or else the newest version.  ok is false if the directory has no versions.
+func buildVersionIndex(fs http.FileSystem, toolPath string) (index VersionIndex, ok bool) {
+	entries, ok := readDir(fs, toolPath)
+	if !ok {
+		return index, ok
+	}
+
+	index.Versions = dirVersions(entries)
+	if len(index.Versions) == 0 {
+		return index, false
+	}
+
+	sort.Slice(index.Versions, func(i, j int) bool {
+		return VersionAIsNewerThanB(index.Versions[j], index.Versions[i])
+	})
+
+	index.Latest = LatestVersion(index.Versions)
+
+	file, err := fs.Open(path.Join(toolPath, LATEST_FILE))
+	if err != nil {
+		return index, ok
+	}
+
+	defer file.Close()
+
+	content, err := ioutil.ReadAll(io.LimitReader(file, 1024))
+	if err == nil && indexSemver.MatchString(strings.TrimSpace(string(content))) {
+		index.Latest = strings.TrimSpace(string(content))
+	}
+
+	return index, ok
+}
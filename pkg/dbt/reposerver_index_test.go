@@ -0,0 +1,292 @@
+// Copyright © 2019 Nik Ogura <nik.ogura@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package dbt
+
+import (
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestPrettyIndex(t *testing.T) {
+	serverRoot, err := ioutil.TempDir("", "dbt-index")
+	if err != nil {
+		t.Fatalf("Failed creating server root: %s", err)
+	}
+
+	defer os.RemoveAll(serverRoot)
+
+	files := map[string]string{
+		"dbt-tools/foo/1.2.3/description.txt":  "foo, but old",
+		"dbt-tools/foo/1.9.0/description.txt":  "foo, but older than 1.10.0",
+		"dbt-tools/foo/1.10.0/description.txt": "foo <does> things",
+		"dbt-tools/foo/latest":                 "1.10.0",
+		"dbt-tools/bar/0.1.0/linux/amd64/bar":  "bar",
+		"dbt-tools/docs/index.html":            "<html>docs</html>",
+	}
+
+	for name, content := range files {
+		filePath := fmt.Sprintf("%s/%s", serverRoot, name)
+
+		err = os.MkdirAll(filePath[:strings.LastIndex(filePath, "/")], 0755)
+		if err != nil {
+			t.Fatalf("Failed creating dir for %s: %s", name, err)
+		}
+
+		err = os.WriteFile(filePath, []byte(content), 0644)
+		if err != nil {
+			t.Fatalf("Failed writing %s: %s", name, err)
+		}
+	}
+
+	repoServer := &DBTRepoServer{
+		ServerRoot:  serverRoot,
+		PrettyIndex: true,
+	}
+
+	r, err := repoServer.Router()
+	if err != nil {
+		t.Fatalf("Failed creating router: %s", err)
+	}
+
+	server := httptest.NewServer(r)
+	defer server.Close()
+
+	inputs := []struct {
+		name     string
+		path     string
+		contains []string
+		absent   []string
+	}{
+		{
+			"tools",
+			"/dbt-tools/",
+			[]string{`<a href="foo/">foo/</a></td><td>1.10.0</td><td class="description">foo &lt;does&gt; things</td>`, `<a href="bar/">bar/</a></td><td>0.1.0</td>`, `<a href="docs/">docs/</a>`},
+			[]string{"foo, but old"},
+		},
+		{
+			"versions",
+			"/dbt-tools/foo/",
+			[]string{`<a href="1.10.0/">1.10.0/</a></td></tr>
+<tr><td><a href="1.9.0/">1.9.0/</a></td></tr>
+<tr><td><a href="1.2.3/">1.2.3/</a></td></tr>
+<tr><td><a href="latest">latest</a>`},
+			[]string{},
+		},
+		{
+			"plain directory",
+			"/dbt-tools/bar/0.1.0/",
+			[]string{`<a href="linux/">linux/</a>`},
+			[]string{"<table>"},
+		},
+		{
+			"own index",
+			"/dbt-tools/docs/",
+			[]string{"<html>docs</html>"},
+			[]string{"<table>"},
+		},
+	}
+
+	for _, tc := range inputs {
+		t.Run(tc.name, func(t *testing.T) {
+			resp, err := http.Get(fmt.Sprintf("%s%s", server.URL, tc.path))
+			if err != nil {
+				t.Fatalf("Failed making request: %s", err)
+			}
+
+			defer resp.Body.Close()
+
+			body, err := ioutil.ReadAll(resp.Body)
+			if err != nil {
+				t.Fatalf("Failed reading index: %s", err)
+			}
+
+			assert.Equal(t, http.StatusOK, resp.StatusCode, "Index is served.")
+
+			for _, s := range tc.contains {
+				assert.Contains(t, string(body), s, "Index has what it should.")
+			}
+
+			for _, s := range tc.absent {
+				assert.NotContains(t, string(body), s, "Index lacks what it should.")
+			}
+		})
+	}
+
+	// the client has to read the pretty index just like the plain one
+	obj := &DBT{Config: Config{Tools: ToolsConfig{Repo: fmt.Sprintf("%s/dbt-tools", server.URL)}}}
+
+	tools, err := obj.FetchToolNames()
+	if err != nil {
+		t.Fatalf("Failed fetching tool names: %s", err)
+	}
+
+	assert.Equal(t, []Tool{{Name: "bar"}, {Name: "docs"}, {Name: "foo"}}, tools, "Tool names are parsed from the index.")
+
+	versions, err := obj.FetchToolVersions("foo")
+	if err != nil {
+		t.Fatalf("Failed fetching versions: %s", err)
+	}
+
+	assert.ElementsMatch(t, []string{"1.2.3", "1.9.0", "1.10.0"}, versions, "Versions are parsed from the index.")
+}
+
+func TestVersionIndex(t *testing.T) {
+	serverRoot, err := ioutil.TempDir("", "dbt-version-index")
+	if err != nil {
+		t.Fatalf("Failed creating server root: %s", err)
+	}
+
+	defer os.RemoveAll(serverRoot)
+
+	files := map[string]string{
+		"dbt-tools/foo/1.10.0/linux/amd64/foo": "foo",
+		"dbt-tools/foo/1.9.0/linux/amd64/foo":  "foo",
+		"dbt-tools/foo/1.2.3/linux/amd64/foo":  "foo",
+		"dbt-tools/foo/latest":                 "1.9.0\n",
+		"dbt-tools/bar/0.1.0/linux/amd64/bar":  "bar",
+		"dbt-tools/bar/0.2.0/linux/amd64/bar":  "bar",
+		"dbt-tools/baz/index.json":             `{"versions":["9.9.9"],"latest":"9.9.9"}`,
+	}
+
+	for name, content := range files {
+		filePath := fmt.Sprintf("%s/%s", serverRoot, name)
+
+		err = os.MkdirAll(filePath[:strings.LastIndex(filePath, "/")], 0755)
+		if err != nil {
+			t.Fatalf("Failed creating dir for %s: %s", name, err)
+		}
+
+		err = os.WriteFile(filePath, []byte(content), 0644)
+		if err != nil {
+			t.Fatalf("Failed writing %s: %s", name, err)
+		}
+	}
+
+	repoServer := &DBTRepoServer{
+		ServerRoot: serverRoot,
+	}
+
+	r, err := repoServer.Router()
+	if err != nil {
+		t.Fatalf("Failed creating router: %s", err)
+	}
+
+	// like a CDN with autoindex turned off, directory listings are forbidden
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if strings.HasSuffix(req.URL.Path, "/") {
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+
+		r.ServeHTTP(w, req)
+	}))
+	defer server.Close()
+
+	inputs := []struct {
+		name   string
+		tool   string
+		status int
+		index  VersionIndex
+	}{
+		{
+			"latest file",
+			"foo",
+			http.StatusOK,
+			VersionIndex{Versions: []string{"1.2.3", "1.9.0", "1.10.0"}, Latest: "1.9.0"},
+		},
+		{
+			"newest version",
+			"bar",
+			http.StatusOK,
+			VersionIndex{Versions: []string{"0.1.0", "0.2.0"}, Latest: "0.2.0"},
+		},
+		{
+			"index in repo",
+			"baz",
+			http.StatusOK,
+			VersionIndex{Versions: []string{"9.9.9"}, Latest: "9.9.9"},
+		},
+		{
+			"no such tool",
+			"frobnitz",
+			http.StatusNotFound,
+			VersionIndex{},
+		},
+	}
+
+	obj := &DBT{Config: Config{Tools: ToolsConfig{Repo: fmt.Sprintf("%s/dbt-tools", server.URL)}}}
+
+	for _, tc := range inputs {
+		t.Run(tc.name, func(t *testing.T) {
+			resp, err := http.Get(fmt.Sprintf("%s/dbt-tools/%s/%s", server.URL, tc.tool, VERSION_INDEX_FILE))
+			if err != nil {
+				t.Fatalf("Failed making request: %s", err)
+			}
+
+			defer resp.Body.Close()
+
+			assert.Equal(t, tc.status, resp.StatusCode, "Response status meets expectations.")
+
+			index, found, err := obj.FetchVersionIndex(tc.tool)
+			if err != nil {
+				t.Fatalf("Failed fetching version index: %s", err)
+			}
+
+			assert.Equal(t, tc.status == http.StatusOK, found, "Index found or not as expected.")
+			assert.Equal(t, tc.index, index, "Index meets expectations.")
+
+			if !found {
+				return
+			}
+
+			versions, err := obj.FetchToolVersions(tc.tool)
+			if err != nil {
+				t.Fatalf("Failed fetching versions: %s", err)
+			}
+
+			assert.Equal(t, tc.index.Versions, versions, "Versions come from the index, even without a directory listing.")
+		})
+	}
+}
+
+func TestFetchVersionIndexFallback(t *testing.T) {
+	// a server that answers everything with a web page, as some do
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		_, _ = fmt.Fprint(w, `<html><body><a href="1.2.3/">1.2.3/</a><a href="1.3.0/">1.3.0/</a></body></html>`)
+	}))
+	defer server.Close()
+
+	obj := &DBT{Config: Config{Tools: ToolsConfig{Repo: server.URL}}}
+
+	_, found, err := obj.FetchVersionIndex("foo")
+	if err != nil {
+		t.Fatalf("Failed fetching version index: %s", err)
+	}
+
+	assert.False(t, found, "Html isn't mistaken for a version index.")
+
+	versions, err := obj.FetchToolVersions("foo")
+	if err != nil {
+		t.Fatalf("Failed fetching versions: %s", err)
+	}
+
+	assert.Equal(t, []string{"1.2.3", "1.3.0"}, versions, "Versions are scraped from the listing instead.")
+}
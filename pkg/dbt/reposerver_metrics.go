@@ -0,0 +1,167 @@
+// Copyright © 2019 Nik Ogura <nik.ogura@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package dbt
+
+import (
+	"github.com/gorilla/mux"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// METRICS_PATH the path on which the reposerver serves Prometheus metrics
+const METRICS_PATH = "/metrics"
+
+// METRICS_PATH_OTHER the path label for requests that aren't under one of metricsPathPrefixes, or that 404
+const METRICS_PATH_OTHER = "other"
+
+// metricsPathPrefixes the first elements of paths that get a path label of their own.  Anything else goes under METRICS_PATH_OTHER, so whatever gets asked for, the number of label values stays fixed.
+var metricsPathPrefixes = map[string]bool{
+	"/":         true,
+	"dbt":       true,
+	"dbt-tools": true,
+	"metrics":   true,
+}
+
+// RepoServerMetrics Prometheus metrics for the reposerver.  Each set of metrics has its own registry, so several servers can live in one process.
+type RepoServerMetrics struct {
+	Registry     *prometheus.Registry
+	Requests     *prometheus.CounterVec
+	Duration     *prometheus.HistogramVec
+	AuthFailures *prometheus.CounterVec
+	BytesServed  prometheus.Gauge
+}
+
+// NewRepoServerMetrics creates and registers the reposerver's metrics.
+func NewRepoServerMetrics() (metrics *RepoServerMetrics) {
+	metrics = &RepoServerMetrics{
+		Registry: prometheus.NewRegistry(),
+		Requests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "dbt_reposerver_requests_total",
+			Help: "Requests handled, by method, status code, and the first element of the path, for the usual repo paths.",
+		}, []string{"method", "status", "path"}),
+		Duration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "dbt_reposerver_request_duration_seconds",
+			Help:    "Time taken to handle requests, by method.",
+			Buckets: prometheus.DefBuckets,
+		}, []string{"method"}),
+		AuthFailures: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "dbt_reposerver_auth_failures_total",
+			Help: "Requests rejected as unauthenticated or unauthorized, by auth type.",
+		}, []string{"type"}),
+		BytesServed: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "dbt_reposerver_bytes_served",
+			Help: "Total bytes written in response bodies to GET requests.",
+		}),
+	}
+
+	metrics.Registry.MustRegister(metrics.Requests, metrics.Duration, metrics.AuthFailures, metrics.BytesServed)
+
+	return metrics
+}
+
+// Handler returns an http.Handler that serves the metrics to Prometheus.
+func (m *RepoServerMetrics) Handler() http.Handler {
+	return promhttp.HandlerFor(m.Registry, promhttp.HandlerOpts{})
+}
+
+// Middleware returns mux middleware that records metrics for every request the given server handles.
+func (m *RepoServerMetrics) Middleware(d *DBTRepoServer) mux.MiddlewareFunc {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			start := time.Now()
+			recorder := &statusRecorder{ResponseWriter: w}
+
+			next.ServeHTTP(recorder, r)
+
+			status := recorder.Status()
+
+			path := pathPrefix(r.URL.Path)
+			if status == http.StatusNotFound {
+				path = METRICS_PATH_OTHER
+			}
+
+			m.Requests.WithLabelValues(r.Method, strconv.Itoa(status), path).Inc()
+			m.Duration.WithLabelValues(r.Method).Observe(time.Since(start).Seconds())
+
+			if status == http.StatusUnauthorized || status == http.StatusForbidden {
+				authType := d.AuthTypeGet
+				if r.Method == http.MethodPut {
+					authType = d.AuthTypePut
+				}
+
+				m.AuthFailures.WithLabelValues(authType).Inc()
+			}
+
+			if r.Method == http.MethodGet {
+				m.BytesServed.Add(float64(recorder.bytes))
+			}
+		})
+	}
+}
+
+// pathPrefix returns the first element of a request path, if it's one of metricsPathPrefixes, and METRICS_PATH_OTHER if it isn't.
+func pathPrefix(urlPath string) string {
+	prefix := strings.SplitN(strings.TrimPrefix(urlPath, "/"), "/", 2)[0]
+	if prefix == "" {
+		prefix = "/"
+	}
+
+	if !metricsPathPrefixes[prefix] {
+		return METRICS_PATH_OTHER
+	}
+
+	return prefix
+}
+
+// statusRecorder wraps an http.ResponseWriter to keep track of the status code and number of bytes written.
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+	bytes  int
+}
+
+// WriteHeader records the status code before passing it on.
+func (s *statusRecorder) WriteHeader(status int) {
+	if s.status == 0 {
+		s.status = status
+	}
+
+	s.ResponseWriter.WriteHeader(status)
+}
+
+// Write counts the bytes written before passing them on.
+func (s *statusRecorder) Write(b []byte) (int, error) {
+	if s.status == 0 {
+		s.status = http.StatusOK
+	}
+
+	n, err := s.ResponseWriter.Write(b)
+	s.bytes += n
+
+	return n, err
+}
+
+// Status returns the status code sent, which is 200 if nothing was ever written.
+func (s *statusRecorder) Status() int {
+	if s.status == 0 {
+		return http.StatusOK
+	}
+
+	return s.status
+}
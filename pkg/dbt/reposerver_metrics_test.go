@@ -0,0 +1,160 @@
+package dbt
+
+import (
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestRepoServerMetrics(t *testing.T) {
+	serverRoot, err := ioutil.TempDir("", "dbt-metrics")
+	if err != nil {
+		t.Fatalf("Failed creating server root: %s", err)
+	}
+
+	defer os.RemoveAll(serverRoot)
+
+	err = os.MkdirAll(fmt.Sprintf("%s/dbt-tools/foo", serverRoot), 0755)
+	if err != nil {
+		t.Fatalf("Failed creating tool dir: %s", err)
+	}
+
+	err = os.WriteFile(fmt.Sprintf("%s/dbt-tools/foo/description.txt", serverRoot), []byte("foo does things"), 0644)
+	if err != nil {
+		t.Fatalf("Failed writing test file: %s", err)
+	}
+
+	htpasswdFile := fmt.Sprintf("%s/htpasswd", serverRoot)
+	err = os.WriteFile(htpasswdFile, []byte(""), 0644)
+	if err != nil {
+		t.Fatalf("Failed writing htpasswd file: %s", err)
+	}
+
+	repoServer := &DBTRepoServer{
+		ServerRoot:  serverRoot,
+		AuthTypePut: AUTH_BASIC_HTPASSWD,
+		AuthOptsPut: AuthOpts{
+			IdpFile: htpasswdFile,
+		},
+		MetricsEnabled: true,
+	}
+
+	r, err := repoServer.Router()
+	if err != nil {
+		t.Fatalf("Failed creating router: %s", err)
+	}
+
+	server := httptest.NewServer(r)
+	defer server.Close()
+
+	inputs := []struct {
+		name   string
+		method string
+		path   string
+		status int
+	}{
+		{
+			"get",
+			http.MethodGet,
+			"/dbt-tools/foo/description.txt",
+			http.StatusOK,
+		},
+		{
+			"missing",
+			http.MethodGet,
+			"/dbt-tools/bar/description.txt",
+			http.StatusNotFound,
+		},
+		{
+			"unknown path",
+			http.MethodGet,
+			"/wp-admin/install.php",
+			http.StatusNotFound,
+		},
+		{
+			"unknown path that exists",
+			http.MethodGet,
+			"/htpasswd",
+			http.StatusOK,
+		},
+		{
+			"unauthenticated put",
+			http.MethodPut,
+			"/dbt-tools/foo/description.txt",
+			http.StatusUnauthorized,
+		},
+	}
+
+	for _, tc := range inputs {
+		t.Run(tc.name, func(t *testing.T) {
+			req, err := http.NewRequest(tc.method, fmt.Sprintf("%s%s", server.URL, tc.path), strings.NewReader("x"))
+			if err != nil {
+				t.Fatalf("Failed creating request: %s", err)
+			}
+
+			resp, err := http.DefaultClient.Do(req)
+			if err != nil {
+				t.Fatalf("Failed making request: %s", err)
+			}
+
+			resp.Body.Close()
+
+			assert.Equal(t, tc.status, resp.StatusCode, "Response status meets expectations.")
+		})
+	}
+
+	resp, err := http.Get(fmt.Sprintf("%s%s", server.URL, METRICS_PATH))
+	if err != nil {
+		t.Fatalf("Failed fetching metrics: %s", err)
+	}
+
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatalf("Failed reading metrics: %s", err)
+	}
+
+	metrics := string(body)
+
+	expected := []string{
+		`dbt_reposerver_requests_total{method="GET",path="dbt-tools",status="200"} 1`,
+		`dbt_reposerver_requests_total{method="GET",path="other",status="404"} 2`,
+		`dbt_reposerver_requests_total{method="GET",path="other",status="200"} 1`,
+		`dbt_reposerver_requests_total{method="PUT",path="dbt-tools",status="401"} 1`,
+		`dbt_reposerver_request_duration_seconds_count{method="GET"} 4`,
+		`dbt_reposerver_auth_failures_total{type="basic-htpasswd"} 1`,
+	}
+
+	for _, e := range expected {
+		assert.Contains(t, metrics, e, "Metrics include expected values.")
+	}
+
+	assert.NotContains(t, metrics, `path="wp-admin"`, "Unknown paths don't get labels of their own.")
+	assert.NotContains(t, metrics, `path="dbt-tools",status="404"`, "404s don't get labels of their own.")
+
+	// the 404 page counts too
+	assert.Regexp(t, `dbt_reposerver_bytes_served [1-9]\d*`, metrics, "Bytes served are counted.")
+}
+
+func TestRepoServerMetricsDisabled(t *testing.T) {
+	repoServer := &DBTRepoServer{
+		ServerRoot: tmpDir,
+	}
+
+	r, err := repoServer.Router()
+	if err != nil {
+		t.Fatalf("Failed creating router: %s", err)
+	}
+
+	req := httptest.NewRequest(http.MethodGet, METRICS_PATH, nil)
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusNotFound, w.Code, "No metrics unless enabled.")
+}
@@ -0,0 +1,150 @@
+// Copyright © 2019 Nik Ogura <nik.ogura@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package dbt
+
+import (
+	log "github.com/sirupsen/logrus"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// RateLimiter A token bucket rate limiter keyed by client.  Each client can make up to a minute's worth of requests in a burst, after which they're refilled at the steady rate.  Buckets that sit idle are swept away periodically so the map doesn't grow forever.
+type RateLimiter struct {
+	perMinute   int
+	idle        time.Duration
+	buckets     map[string]*tokenBucket
+	lastCleanup time.Time
+	mutex       sync.Mutex
+	now         func() time.Time
+}
+
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// NewRateLimiter creates a RateLimiter allowing perMinute requests per minute per key.
+func NewRateLimiter(perMinute int) (limiter *RateLimiter) {
+	limiter = &RateLimiter{
+		perMinute: perMinute,
+		idle:      10 * time.Minute,
+		buckets:   make(map[string]*tokenBucket),
+		now:       time.Now,
+	}
+
+	limiter.lastCleanup = limiter.now()
+
+	return limiter
+}
+
+// Allow takes a token from the key's bucket if there is one.  If not, it returns how long until there will be.
+func (l *RateLimiter) Allow(key string) (ok bool, retryAfter time.Duration) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	now := l.now()
+	capacity := float64(l.perMinute)
+	perSecond := capacity / 60
+
+	if now.Sub(l.lastCleanup) > l.idle {
+		for k, b := range l.buckets {
+			if now.Sub(b.last) > l.idle {
+				delete(l.buckets, k)
+			}
+		}
+
+		l.lastCleanup = now
+	}
+
+	bucket, exists := l.buckets[key]
+	if !exists {
+		bucket = &tokenBucket{tokens: capacity, last: now}
+		l.buckets[key] = bucket
+	}
+
+	bucket.tokens = math.Min(capacity, bucket.tokens+now.Sub(bucket.last).Seconds()*perSecond)
+	bucket.last = now
+
+	if bucket.tokens >= 1 {
+		bucket.tokens--
+		ok = true
+		return ok, retryAfter
+	}
+
+	retryAfter = time.Duration((1 - bucket.tokens) / perSecond * float64(time.Second))
+
+	return ok, retryAfter
+}
+
+// rateLimited returns true, after sending a 429 with a Retry-After header, if the key has run out of requests.  A nil limiter never limits.
+func rateLimited(limiter *RateLimiter, w http.ResponseWriter, key string) bool {
+	if limiter == nil {
+		return false
+	}
+
+	ok, retryAfter := limiter.Allow(key)
+	if ok {
+		return false
+	}
+
+	log.Infof("Rate limit exceeded for %s", key)
+	w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+	w.WriteHeader(http.StatusTooManyRequests)
+
+	return true
+}
+
+// LimitReads wraps a GET handler in the read rate limit, keyed by authenticated username, or source address for anonymous requests.
+func (d *DBTRepoServer) LimitReads(wrapped http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if rateLimited(d.readLimiter, w, d.readLimitKey(r)) {
+			return
+		}
+
+		wrapped.ServeHTTP(w, r)
+	})
+}
+
+// readLimitKey figures out who's asking.  Usernames are only trusted if GETs are actually authenticated, otherwise anyone could dodge the limit by making one up.
+func (d *DBTRepoServer) readLimitKey(r *http.Request) string {
+	if d.AuthGets {
+		switch d.AuthTypeGet {
+		case AUTH_BASIC_HTPASSWD:
+			if username, _, ok := r.BasicAuth(); ok {
+				return username
+			}
+		case AUTH_SSH_AGENT_FILE, AUTH_SSH_AGENT_FUNC:
+			if username := r.Header.Get("X-Authenticated-Username"); username != "" {
+				return username
+			}
+		}
+	}
+
+	return clientAddress(r)
+}
+
+// clientAddress returns the source address of a request, minus the port
+func clientAddress(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+
+	return host
+}
@@ -0,0 +1,118 @@
+package dbt
+
+import (
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRateLimiter(t *testing.T) {
+	start := time.Now()
+
+	inputs := []struct {
+		name    string
+		key     string
+		elapsed time.Duration
+		ok      bool
+	}{
+		{"first", "alice", 0, true},
+		{"second", "alice", 0, true},
+		{"over the limit", "alice", 0, false},
+		{"someone else", "bob", 0, true},
+		{"refilled", "alice", 30 * time.Second, true},
+		{"empty again", "alice", 30 * time.Second, false},
+	}
+
+	limiter := NewRateLimiter(2)
+
+	for _, tc := range inputs {
+		t.Run(tc.name, func(t *testing.T) {
+			limiter.now = func() time.Time { return start.Add(tc.elapsed) }
+
+			ok, retryAfter := limiter.Allow(tc.key)
+
+			assert.Equal(t, tc.ok, ok, "Rate limit decision meets expectations.")
+
+			if !ok {
+				assert.True(t, retryAfter > 0, "Limited requests are told when to retry.")
+			}
+		})
+	}
+
+	// idle buckets get swept
+	limiter.now = func() time.Time { return start.Add(time.Hour) }
+	_, _ = limiter.Allow("carol")
+
+	assert.Equal(t, 1, len(limiter.buckets), "Idle buckets are cleaned up.")
+}
+
+func TestRepoServerPutRateLimit(t *testing.T) {
+	serverRoot, err := ioutil.TempDir("", "dbt-ratelimit")
+	if err != nil {
+		t.Fatalf("Failed creating server root: %s", err)
+	}
+
+	defer os.RemoveAll(serverRoot)
+
+	htpasswdFile := writeTestHtpasswd(t, serverRoot, "uploader", "password")
+
+	repoServer := &DBTRepoServer{
+		ServerRoot:  serverRoot,
+		AuthTypePut: AUTH_BASIC_HTPASSWD,
+		AuthOptsPut: AuthOpts{
+			IdpFile: htpasswdFile,
+		},
+		RateLimitPerMinute: 2,
+	}
+
+	r, err := repoServer.Router()
+	if err != nil {
+		t.Fatalf("Failed creating router: %s", err)
+	}
+
+	server := httptest.NewServer(r)
+	defer server.Close()
+
+	inputs := []struct {
+		name   string
+		method string
+		status int
+	}{
+		{"put", http.MethodPut, http.StatusCreated},
+		{"put again", http.MethodPut, http.StatusCreated},
+		{"put too many", http.MethodPut, http.StatusTooManyRequests},
+		{"get", http.MethodGet, http.StatusOK},
+		{"get again", http.MethodGet, http.StatusOK},
+		{"get more", http.MethodGet, http.StatusOK},
+	}
+
+	for _, tc := range inputs {
+		t.Run(tc.name, func(t *testing.T) {
+			req, err := http.NewRequest(tc.method, fmt.Sprintf("%s/dbt-tools/foo/description.txt", server.URL), strings.NewReader("foo does things"))
+			if err != nil {
+				t.Fatalf("Failed creating request: %s", err)
+			}
+
+			req.SetBasicAuth("uploader", "password")
+
+			resp, err := http.DefaultClient.Do(req)
+			if err != nil {
+				t.Fatalf("Failed making request: %s", err)
+			}
+
+			resp.Body.Close()
+
+			assert.Equal(t, tc.status, resp.StatusCode, "Response status meets expectations.")
+
+			if tc.status == http.StatusTooManyRequests {
+				assert.NotEmpty(t, resp.Header.Get("Retry-After"), "Retry-After header is set.")
+			}
+		})
+	}
+}
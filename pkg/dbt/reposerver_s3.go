@@ -0,0 +1,508 @@
+// Copyright © 2019 Nik Ogura <nik.ogura@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package dbt
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/s3/manager"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/smithy-go"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path"
+	"strings"
+	"time"
+)
+
+// DEFAULT_S3_BUCKET_REGION the region of buckets that report no location constraint.  S3 does that for us-east-1, and only us-east-1.
+const DEFAULT_S3_BUCKET_REGION = "us-east-1"
+
+// S3_LEGACY_EU_REGION the region of buckets whose location constraint is the legacy "EU"
+const S3_LEGACY_EU_REGION = "eu-west-1"
+
+// S3_ACCESS_DENIED_CODE the error code S3 answers with when the caller lacks permission for a request
+const S3_ACCESS_DENIED_CODE = "AccessDenied"
+
+// S3_SNIFF_LEN how much of a file http.ServeContent reads to sniff its content type
+const S3_SNIFF_LEN = 512
+
+// S3BucketRegionAPI the parts of the S3 API used to find out which region a bucket is in
+type S3BucketRegionAPI interface {
+	GetBucketLocation(ctx context.Context, params *s3.GetBucketLocationInput, optFns ...func(*s3.Options)) (*s3.GetBucketLocationOutput, error)
+	manager.HeadBucketAPIClient
+}
+
+// S3BucketRegion finds out which region a bucket is in.  It asks GetBucketLocation first.  If the caller isn't allowed s3:GetBucketLocation, it reads the x-amz-bucket-region header from a HeadBucket instead.  A bucket that can't be located is an error rather than a guess, so a wrong region doesn't turn up later as a cryptic failure.  Transient failures are retried by the client.
+func S3BucketRegion(ctx context.Context, client S3BucketRegionAPI, bucket string) (region string, err error) {
+	// us-east-1 answers GetBucketLocation for buckets in any region
+	loc, err := client.GetBucketLocation(ctx, &s3.GetBucketLocationInput{Bucket: &bucket}, func(o *s3.Options) {
+		o.Region = DEFAULT_S3_BUCKET_REGION
+	})
+	if err == nil {
+		switch constraint := string(loc.LocationConstraint); constraint {
+		case "":
+			region = DEFAULT_S3_BUCKET_REGION
+		case "EU":
+			region = S3_LEGACY_EU_REGION
+		default:
+			region = constraint
+		}
+
+		return region, err
+	}
+
+	var apiErr smithy.APIError
+	if !errors.As(err, &apiErr) || apiErr.ErrorCode() != S3_ACCESS_DENIED_CODE {
+		err = errors.Wrapf(err, "unable to locate s3 bucket %s", bucket)
+		return region, err
+	}
+
+	log.Debugf("Not allowed to get the location of s3 bucket %s.  Reading its region from HeadBucket instead.", bucket)
+
+	region, err = manager.GetBucketRegion(ctx, client, bucket)
+	if err != nil {
+		err = errors.Wrapf(err, "unable to locate s3 bucket %s: not allowed s3:GetBucketLocation, and HeadBucket didn't say", bucket)
+		return region, err
+	}
+
+	if region == "" {
+		err = errors.New(fmt.Sprintf("unable to locate s3 bucket %s: not allowed s3:GetBucketLocation, and HeadBucket didn't say", bucket))
+		return region, err
+	}
+
+	return region, err
+}
+
+// S3BackendOpts Struct for holding the location of an S3 bucket the reposerver stores artifacts in instead of ServerRoot.
+type S3BackendOpts struct {
+	Bucket string `json:"bucket" yaml:"bucket"`
+	Region string `json:"region" yaml:"region"`
+	Prefix string `json:"prefix,omitempty" yaml:"prefix,omitempty"`
+}
+
+// FileSystem returns the tree the reposerver serves GETs from.  That's Files if it was given, the S3 backend if one is configured, otherwise ServerRoot on local disk.
+func (d *DBTRepoServer) FileSystem() (fs http.FileSystem, err error) {
+	if d.Files != nil {
+		fs = d.Files
+		return fs, err
+	}
+
+	if d.S3Backend == nil {
+		fs = uploadHidingFileSystem{http.Dir(d.ServerRoot)}
+		return fs, err
+	}
+
+	client, err := d.s3BackendClient()
+	if err != nil {
+		return fs, err
+	}
+
+	fs = &S3FileSystem{
+		Client: client,
+		Bucket: d.S3Backend.Bucket,
+		Prefix: d.S3Backend.Prefix,
+	}
+
+	return fs, err
+}
+
+// s3BackendClient returns the reposerver's S3 client, creating it from the default credential chain if need be.
+func (d *DBTRepoServer) s3BackendClient() (client *s3.Client, err error) {
+	if d.S3Client != nil {
+		client = d.S3Client
+		return client, err
+	}
+
+	awsConfig, err := DefaultAwsConfig(&S3Meta{Region: d.S3Backend.Region})
+	if err != nil {
+		err = errors.Wrapf(err, "failed to create s3 client")
+		return client, err
+	}
+
+	// The region from the environment is only a guess at where the bucket is, so without one in the config, ask S3.
+	if d.S3Backend.Region == "" {
+		if awsConfig.Region == "" {
+			awsConfig.Region = DEFAULT_S3_BUCKET_REGION
+		}
+
+		region, err := S3BucketRegion(context.Background(), s3.NewFromConfig(awsConfig), d.S3Backend.Bucket)
+		if err != nil {
+			return client, err
+		}
+
+		log.Infof("S3 bucket %s is in region %s", d.S3Backend.Bucket, region)
+		awsConfig.Region = region
+	}
+
+	client = s3.NewFromConfig(awsConfig)
+	d.S3Client = client
+
+	return client, err
+}
+
+// s3BackendPut uploads an already verified file from local disk to the S3 backend.
+func (d *DBTRepoServer) s3BackendPut(filePath string, localFile string) (err error) {
+	client, err := d.s3BackendClient()
+	if err != nil {
+		return err
+	}
+
+	key := s3Key(d.S3Backend.Prefix, filePath)
+
+	f, err := os.Open(localFile)
+	if err != nil {
+		err = errors.Wrapf(err, "failed to open %s", localFile)
+		return err
+	}
+
+	defer f.Close()
+
+	_, err = manager.NewUploader(client).Upload(context.Background(), &s3.PutObjectInput{
+		Bucket: aws.String(d.S3Backend.Bucket),
+		Key:    aws.String(key),
+		Body:   f,
+	})
+	if err != nil {
+		err = errors.Wrapf(err, "failed to upload %s to s3 bucket %s", key, d.S3Backend.Bucket)
+	}
+
+	return err
+}
+
+// s3BackendPutIfAbsent uploads content to the S3 backend, unless there's already something there.
+func (d *DBTRepoServer) s3BackendPutIfAbsent(filePath string, content []byte) (err error) {
+	client, err := d.s3BackendClient()
+	if err != nil {
+		return err
+	}
+
+	key := s3Key(d.S3Backend.Prefix, filePath)
+
+	_, err = client.HeadObject(context.Background(), &s3.HeadObjectInput{
+		Bucket: aws.String(d.S3Backend.Bucket),
+		Key:    aws.String(key),
+	})
+	if err == nil {
+		log.Infof("Not generating %s.  It already exists.", key)
+		return err
+	}
+
+	if !IsS3NotFound(err) {
+		err = errors.Wrapf(err, "failed checking for %s in s3 bucket %s", key, d.S3Backend.Bucket)
+		return err
+	}
+
+	_, err = client.PutObject(context.Background(), &s3.PutObjectInput{
+		Bucket: aws.String(d.S3Backend.Bucket),
+		Key:    aws.String(key),
+		Body:   bytes.NewReader(content),
+	})
+	if err != nil {
+		err = errors.Wrapf(err, "failed to upload %s to s3 bucket %s", key, d.S3Backend.Bucket)
+	}
+
+	return err
+}
+
+// s3Key turns a request path into an S3 key under the given prefix.
+func s3Key(prefix string, name string) string {
+	return strings.TrimPrefix(path.Join("/", prefix, name), "/")
+}
+
+// S3FileSystem An http.FileSystem that serves the objects in an S3 bucket.  'Directories' are the common prefixes of the keys, so http.FileServer can produce the same index pages dbt clients parse from a reposerver on local disk.
+type S3FileSystem struct {
+	Client *s3.Client
+	Bucket string
+	Prefix string
+}
+
+// Open opens the object or 'directory' for the given path.  Objects are only HEADed here.  Their content is streamed from S3 when it's read, so stat'ing one, say for an ETag, doesn't download it, and serving one doesn't hold it in memory.
+func (s *S3FileSystem) Open(name string) (file http.File, err error) {
+	key := s3Key(s.Prefix, name)
+	root := key == s3Key(s.Prefix, "/")
+
+	if !root {
+		resp, err := s.Client.HeadObject(context.Background(), &s3.HeadObjectInput{
+			Bucket: aws.String(s.Bucket),
+			Key:    aws.String(key),
+		})
+
+		if err == nil {
+			file = &s3Object{
+				client: s.Client,
+				bucket: s.Bucket,
+				key:    key,
+				info: s3FileInfo{
+					name:    path.Base(key),
+					size:    resp.ContentLength,
+					modTime: aws.ToTime(resp.LastModified),
+				},
+			}
+
+			return file, nil
+		}
+
+		if !IsS3NotFound(err) {
+			err = errors.Wrapf(err, "failed to fetch %s from s3", key)
+			return file, err
+		}
+	}
+
+	return s.openDir(key, root)
+}
+
+// openDir lists the objects and common prefixes directly under the given key.  Only the root is allowed to be empty.
+func (s *S3FileSystem) openDir(key string, root bool) (file http.File, err error) {
+	prefix := key
+	if prefix != "" {
+		prefix = prefix + "/"
+	}
+
+	entries := make([]os.FileInfo, 0)
+
+	paginator := s3.NewListObjectsV2Paginator(s.Client, &s3.ListObjectsV2Input{
+		Bucket:    aws.String(s.Bucket),
+		Prefix:    aws.String(prefix),
+		Delimiter: aws.String("/"),
+	})
+
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(context.Background())
+		if err != nil {
+			err = errors.Wrapf(err, "failed to list %s in s3", prefix)
+			return file, err
+		}
+
+		for _, p := range page.CommonPrefixes {
+			entries = append(entries, s3FileInfo{
+				name: path.Base(aws.ToString(p.Prefix)),
+				dir:  true,
+			})
+		}
+
+		for _, o := range page.Contents {
+			// skip the zero byte 'folder' object some tools create for the directory itself
+			if aws.ToString(o.Key) == prefix {
+				continue
+			}
+
+			entries = append(entries, s3FileInfo{
+				name:    path.Base(aws.ToString(o.Key)),
+				size:    o.Size,
+				modTime: aws.ToTime(o.LastModified),
+			})
+		}
+	}
+
+	if len(entries) == 0 && !root {
+		return file, os.ErrNotExist
+	}
+
+	file = &s3File{
+		Reader: bytes.NewReader(nil),
+		info: s3FileInfo{
+			name: path.Base("/" + key),
+			dir:  true,
+		},
+		entries: entries,
+	}
+
+	return file, err
+}
+
+// s3Object An http.File streaming the content of an S3 object.  Nothing is fetched until it's read, and the first read after a seek fetches from there on with a ranged GetObject, which is all http.ServeContent needs for sizing and range requests.  The bytes it reads to sniff the content type are fetched on their own and kept, so a HEAD only fetches those, and a GET doesn't fetch them twice.
+type s3Object struct {
+	client *s3.Client
+	bucket string
+	key    string
+	info   s3FileInfo
+	offset int64
+	body   io.ReadCloser
+	sniff  []byte
+}
+
+// Read reads the object from the current offset, fetching it from S3 if it isn't already streaming.
+func (o *s3Object) Read(p []byte) (n int, err error) {
+	if o.offset >= o.info.size {
+		return n, io.EOF
+	}
+
+	if o.offset == 0 && o.sniff == nil && o.body == nil && len(p) <= S3_SNIFF_LEN {
+		body, err := o.get(fmt.Sprintf("bytes=0-%d", len(p)-1))
+		if err != nil {
+			return n, err
+		}
+
+		defer body.Close()
+
+		o.sniff, err = ioutil.ReadAll(body)
+		if err != nil {
+			err = errors.Wrapf(err, "failed to read %s from s3", o.key)
+			return n, err
+		}
+	}
+
+	if o.offset < int64(len(o.sniff)) {
+		n = copy(p, o.sniff[o.offset:])
+		o.offset += int64(n)
+
+		return n, err
+	}
+
+	if o.body == nil {
+		o.body, err = o.get(fmt.Sprintf("bytes=%d-", o.offset))
+		if err != nil {
+			return n, err
+		}
+	}
+
+	n, err = o.body.Read(p)
+	o.offset += int64(n)
+
+	return n, err
+}
+
+// get fetches the given range of the object.
+func (o *s3Object) get(byteRange string) (body io.ReadCloser, err error) {
+	resp, err := o.client.GetObject(context.Background(), &s3.GetObjectInput{
+		Bucket: aws.String(o.bucket),
+		Key:    aws.String(o.key),
+		Range:  aws.String(byteRange),
+	})
+	if err != nil {
+		err = errors.Wrapf(err, "failed to fetch %s from s3", o.key)
+		return body, err
+	}
+
+	return resp.Body, err
+}
+
+// Seek moves the offset the next read starts from.  Moving it drops the stream, so the next read fetches from the new offset.
+func (o *s3Object) Seek(offset int64, whence int) (position int64, err error) {
+	switch whence {
+	case io.SeekStart:
+		position = offset
+	case io.SeekCurrent:
+		position = o.offset + offset
+	case io.SeekEnd:
+		position = o.info.size + offset
+	default:
+		err = fmt.Errorf("invalid whence %d seeking %s", whence, o.key)
+		return o.offset, err
+	}
+
+	if position < 0 {
+		err = fmt.Errorf("negative position seeking %s", o.key)
+		return o.offset, err
+	}
+
+	if position != o.offset && o.body != nil {
+		_ = o.body.Close()
+		o.body = nil
+	}
+
+	o.offset = position
+
+	return position, err
+}
+
+// Close closes the stream from S3, if there is one.
+func (o *s3Object) Close() (err error) {
+	if o.body != nil {
+		err = o.body.Close()
+		o.body = nil
+	}
+
+	return err
+}
+
+// Readdir fails, since an object isn't a 'directory'.
+func (o *s3Object) Readdir(count int) (entries []os.FileInfo, err error) {
+	err = fmt.Errorf("%s is not a directory", o.key)
+	return entries, err
+}
+
+// Stat returns the object's FileInfo, from when it was opened.
+func (o *s3Object) Stat() (os.FileInfo, error) {
+	return o.info, nil
+}
+
+// s3File An http.File holding the listing of an S3 'directory'.
+type s3File struct {
+	*bytes.Reader
+	info    s3FileInfo
+	entries []os.FileInfo
+}
+
+// Close is a no-op.  The listing is read into memory when the 'directory' is opened.
+func (f *s3File) Close() error {
+	return nil
+}
+
+// Readdir returns the entries of a 'directory', following the semantics of os.File.Readdir.
+func (f *s3File) Readdir(count int) (entries []os.FileInfo, err error) {
+	if count <= 0 {
+		entries = f.entries
+		f.entries = nil
+		return entries, err
+	}
+
+	if len(f.entries) == 0 {
+		return entries, io.EOF
+	}
+
+	if count > len(f.entries) {
+		count = len(f.entries)
+	}
+
+	entries = f.entries[:count]
+	f.entries = f.entries[count:]
+
+	return entries, err
+}
+
+// Stat returns the file's FileInfo
+func (f *s3File) Stat() (os.FileInfo, error) {
+	return f.info, nil
+}
+
+// s3FileInfo os.FileInfo for an S3 object or common prefix.
+type s3FileInfo struct {
+	name    string
+	size    int64
+	modTime time.Time
+	dir     bool
+}
+
+func (i s3FileInfo) Name() string       { return i.name }
+func (i s3FileInfo) Size() int64        { return i.size }
+func (i s3FileInfo) ModTime() time.Time { return i.modTime }
+func (i s3FileInfo) IsDir() bool        { return i.dir }
+func (i s3FileInfo) Sys() interface{}   { return nil }
+
+func (i s3FileInfo) Mode() os.FileMode {
+	if i.dir {
+		return os.ModeDir | 0755
+	}
+
+	return 0644
+}
@@ -0,0 +1,868 @@
+package dbt
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/sha1"
+	"encoding/base64"
+	"fmt"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/johannesboyne/gofakes3"
+	"github.com/johannesboyne/gofakes3/backend/s3mem"
+	"github.com/nikogura/gomason/pkg/gomason"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestNewRepoServerFormats(t *testing.T) {
+	expected := &DBTRepoServer{
+		Address:     "127.0.0.1",
+		Port:        9999,
+		ServerRoot:  "/var/dbt",
+		AuthTypeGet: AUTH_BASIC_HTPASSWD,
+		AuthTypePut: AUTH_SSH_AGENT_FILE,
+		AuthGets:    true,
+		AuthOptsGet: AuthOpts{
+			IdpFile: "/etc/dbt/htpasswd",
+		},
+		AuthOptsPut: AuthOpts{
+			IdpFile: "/etc/dbt/pubkeys.json",
+		},
+	}
+
+	inputs := []struct {
+		name     string
+		fileName string
+		contents string
+	}{
+		{
+			"json",
+			"reposerver.json",
+			`{
+  "address": "127.0.0.1",
+  "port": 9999,
+  "serverRoot": "/var/dbt",
+  "authTypeGet": "basic-htpasswd",
+  "authTypePut": "ssh-agent-file",
+  "authGets": true,
+  "authOptsGet": {
+    "idpFile": "/etc/dbt/htpasswd"
+  },
+  "authOptsPut": {
+    "idpFile": "/etc/dbt/pubkeys.json"
+  }
+}`,
+		},
+		{
+			"yaml",
+			"reposerver.yaml",
+			`address: 127.0.0.1
+port: 9999
+serverRoot: /var/dbt
+authTypeGet: basic-htpasswd
+authTypePut: ssh-agent-file
+authGets: true
+authOptsGet:
+  idpFile: /etc/dbt/htpasswd
+authOptsPut:
+  idpFile: /etc/dbt/pubkeys.json
+`,
+		},
+	}
+
+	for _, tc := range inputs {
+		t.Run(tc.name, func(t *testing.T) {
+			configFile := fmt.Sprintf("%s/%s", tmpDir, tc.fileName)
+
+			err := os.WriteFile(configFile, []byte(tc.contents), 0644)
+			if err != nil {
+				t.Fatalf("Failed to write config file %s: %s", configFile, err)
+			}
+
+			actual, err := NewRepoServer(configFile)
+			if err != nil {
+				t.Errorf("Failed creating reposerver: %s", err)
+			}
+
+			assert.Equal(t, expected, actual, "Parsed reposerver config meets expectations.")
+		})
+	}
+}
+
+func TestRepoServerS3Backend(t *testing.T) {
+	bucket := "dbt-repo"
+
+	fakeS3, fakeClient := newFakeS3(t, bucket)
+	defer fakeS3.Close()
+
+	repoServer := &DBTRepoServer{
+		S3Backend: &S3BackendOpts{
+			Bucket: bucket,
+			Region: "us-east-1",
+			Prefix: "repo",
+		},
+		S3Client: fakeClient,
+	}
+
+	content := "#!/bin/sh\necho foo\n"
+	_, _, sha256sum, err := gomason.AllChecksumsForBytes([]byte(content))
+	if err != nil {
+		t.Fatalf("Failed to checksum test content: %s", err)
+	}
+
+	inputs := []struct {
+		name      string
+		path      string
+		sha256sum string
+		err       bool
+	}{
+		{
+			"good checksum",
+			"/dbt-tools/foo/1.2.3/linux/amd64/foo",
+			sha256sum,
+			false,
+		},
+		{
+			"no checksum",
+			"/dbt-tools/foo/1.2.4/linux/amd64/foo",
+			"",
+			false,
+		},
+		{
+			"bad checksum",
+			"/dbt-tools/foo/1.2.5/linux/amd64/foo",
+			"0000",
+			true,
+		},
+	}
+
+	for _, tc := range inputs {
+		t.Run(tc.name, func(t *testing.T) {
+			err := repoServer.HandlePut(tc.path, ioutil.NopCloser(strings.NewReader(content)), "", "", tc.sha256sum)
+			_, headErr := fakeClient.HeadObject(context.Background(), &s3.HeadObjectInput{
+				Bucket: aws.String(bucket),
+				Key:    aws.String(fmt.Sprintf("repo%s", tc.path)),
+			})
+
+			if tc.err {
+				assert.NotNil(t, err, "Bad checksum is rejected.")
+				assert.True(t, IsS3NotFound(headErr), "Rejected file is not uploaded.")
+				return
+			}
+
+			if err != nil {
+				t.Errorf("Failed putting file: %s", err)
+			}
+
+			assert.Nil(t, headErr, "File is uploaded under the prefix.")
+		})
+	}
+
+	// Serve it back the way the reposerver does, and read it with a dbt client.
+	fs, err := repoServer.FileSystem()
+	if err != nil {
+		t.Fatalf("Failed to get reposerver filesystem: %s", err)
+	}
+
+	server := httptest.NewServer(http.FileServer(fs))
+	defer server.Close()
+
+	client := &DBT{
+		Config: Config{
+			Tools: ToolsConfig{
+				Repo: fmt.Sprintf("%s/dbt-tools", server.URL),
+			},
+		},
+		Verbose: true,
+	}
+
+	versions, err := client.FetchToolVersions("foo")
+	if err != nil {
+		t.Errorf("Failed fetching versions: %s", err)
+	}
+
+	assert.ElementsMatch(t, []string{"1.2.3", "1.2.4"}, versions, "Versions served from S3 meet expectations.")
+
+	resp, err := http.Get(fmt.Sprintf("%s/dbt-tools/foo/1.2.3/linux/amd64/foo", server.URL))
+	if err != nil {
+		t.Fatalf("Failed fetching file: %s", err)
+	}
+
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatalf("Failed reading file: %s", err)
+	}
+
+	assert.Equal(t, content, string(body), "File served from S3 meets expectations.")
+
+	resp, err = http.Get(fmt.Sprintf("%s/dbt-tools/bar/", server.URL))
+	if err != nil {
+		t.Fatalf("Failed fetching missing dir: %s", err)
+	}
+
+	resp.Body.Close()
+
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode, "Missing paths are not found.")
+}
+
+func TestS3FileSystemStreaming(t *testing.T) {
+	bucket := "dbt-repo"
+	key := "repo/dbt-tools/foo/1.2.3/linux/amd64/foo"
+
+	// note the GetObject calls for the object, and the range each asked for
+	var lock sync.Mutex
+	var gets []string
+
+	fake := gofakes3.New(s3mem.New()).Server()
+	fakeS3 := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodGet && strings.HasSuffix(r.URL.Path, key) {
+			lock.Lock()
+			gets = append(gets, r.Header.Get("Range"))
+			lock.Unlock()
+		}
+
+		fake.ServeHTTP(w, r)
+	}))
+	defer fakeS3.Close()
+
+	fakeClient := newFakeS3Client(fakeS3.URL)
+
+	_, err := fakeClient.CreateBucket(context.Background(), &s3.CreateBucketInput{Bucket: aws.String(bucket)})
+	if err != nil {
+		t.Fatalf("Failed to create bucket %s: %s", bucket, err)
+	}
+
+	content := bytes.Repeat([]byte("0123456789abcdef"), 64*1024)
+
+	_, err = fakeClient.PutObject(context.Background(), &s3.PutObjectInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(key),
+		Body:   bytes.NewReader(content),
+	})
+	if err != nil {
+		t.Fatalf("Failed to put %s into fake s3: %s", key, err)
+	}
+
+	repoServer := &DBTRepoServer{
+		S3Backend: &S3BackendOpts{
+			Bucket: bucket,
+			Region: "us-east-1",
+			Prefix: "repo",
+		},
+		S3Client: fakeClient,
+	}
+
+	fs, err := repoServer.FileSystem()
+	if err != nil {
+		t.Fatalf("Failed to get reposerver filesystem: %s", err)
+	}
+
+	server := httptest.NewServer(ETagHandler(fs, http.FileServer(fs)))
+	defer server.Close()
+
+	inputs := []struct {
+		name      string
+		method    string
+		byteRange string
+		status    int
+		body      []byte
+		gets      []string
+	}{
+		{"head", http.MethodHead, "", http.StatusOK, []byte{}, []string{"bytes=0-511"}},
+		{"get", http.MethodGet, "", http.StatusOK, content, []string{"bytes=0-511", "bytes=512-"}},
+		{"range in sniffed bytes", http.MethodGet, "bytes=100-199", http.StatusPartialContent, content[100:200], []string{"bytes=0-511"}},
+		{"range", http.MethodGet, "bytes=1000-1999", http.StatusPartialContent, content[1000:2000], []string{"bytes=0-511", "bytes=1000-"}},
+	}
+
+	for _, tc := range inputs {
+		t.Run(tc.name, func(t *testing.T) {
+			lock.Lock()
+			gets = nil
+			lock.Unlock()
+
+			req, err := http.NewRequest(tc.method, fmt.Sprintf("%s/dbt-tools/foo/1.2.3/linux/amd64/foo", server.URL), nil)
+			if err != nil {
+				t.Fatalf("Failed creating request: %s", err)
+			}
+
+			if tc.byteRange != "" {
+				req.Header.Set("Range", tc.byteRange)
+			}
+
+			resp, err := http.DefaultClient.Do(req)
+			if err != nil {
+				t.Fatalf("Failed fetching file: %s", err)
+			}
+
+			defer resp.Body.Close()
+
+			body, err := ioutil.ReadAll(resp.Body)
+			if err != nil {
+				t.Fatalf("Failed reading file: %s", err)
+			}
+
+			assert.Equal(t, tc.status, resp.StatusCode, "Status meets expectations.")
+			assert.True(t, bytes.Equal(tc.body, body), "Body meets expectations.")
+			assert.NotEmpty(t, resp.Header.Get("ETag"), "Object is tagged.")
+
+			lock.Lock()
+			defer lock.Unlock()
+
+			// the ETag comes from a HEAD, and content type from the first few bytes, so the rest is only fetched to send it, from where it's sent from
+			assert.Equal(t, tc.gets, gets, "Object is only fetched as needed.")
+		})
+	}
+}
+
+// writeTestHtpasswd writes an htpasswd file holding a single user in the {SHA} format go-http-auth understands, and returns its path.
+func writeTestHtpasswd(t *testing.T, dir string, username string, password string) (htpasswdFile string) {
+	hash := sha1.Sum([]byte(password))
+	htpasswdFile = fmt.Sprintf("%s/htpasswd", dir)
+
+	err := os.WriteFile(htpasswdFile, []byte(fmt.Sprintf("%s:{SHA}%s\n", username, base64.StdEncoding.EncodeToString(hash[:]))), 0644)
+	if err != nil {
+		t.Fatalf("Failed writing htpasswd file: %s", err)
+	}
+
+	return htpasswdFile
+}
+
+func TestRepoServerMaxUpload(t *testing.T) {
+	serverRoot, err := ioutil.TempDir("", "dbt-maxupload")
+	if err != nil {
+		t.Fatalf("Failed creating server root: %s", err)
+	}
+
+	defer os.RemoveAll(serverRoot)
+
+	repoServer := &DBTRepoServer{
+		ServerRoot:  serverRoot,
+		AuthTypePut: AUTH_BASIC_HTPASSWD,
+		AuthOptsPut: AuthOpts{
+			IdpFile: writeTestHtpasswd(t, serverRoot, "uploader", "password"),
+		},
+		MaxUploadBytes: 10,
+	}
+
+	r, err := repoServer.Router()
+	if err != nil {
+		t.Fatalf("Failed creating router: %s", err)
+	}
+
+	server := httptest.NewServer(r)
+	defer server.Close()
+
+	inputs := []struct {
+		name    string
+		content string
+		status  int
+	}{
+		{
+			"under",
+			"123456789",
+			http.StatusCreated,
+		},
+		{
+			"at",
+			"1234567890",
+			http.StatusCreated,
+		},
+		{
+			"over",
+			"12345678901",
+			http.StatusRequestEntityTooLarge,
+		},
+	}
+
+	for _, tc := range inputs {
+		t.Run(tc.name, func(t *testing.T) {
+			path := fmt.Sprintf("/dbt-tools/foo/%s.txt", tc.name)
+
+			req, err := http.NewRequest(http.MethodPut, fmt.Sprintf("%s%s", server.URL, path), strings.NewReader(tc.content))
+			if err != nil {
+				t.Fatalf("Failed creating request: %s", err)
+			}
+
+			req.SetBasicAuth("uploader", "password")
+
+			resp, err := http.DefaultClient.Do(req)
+			if err != nil {
+				t.Fatalf("Failed making request: %s", err)
+			}
+
+			resp.Body.Close()
+
+			assert.Equal(t, tc.status, resp.StatusCode, "Response status meets expectations.")
+
+			_, err = os.Stat(fmt.Sprintf("%s%s", serverRoot, path))
+			assert.Equal(t, tc.status == http.StatusCreated, err == nil, "Only uploads within the limit are written.")
+		})
+	}
+
+	assert.Equal(t, int64(DEFAULT_MAX_UPLOAD_BYTES), (&DBTRepoServer{}).UploadLimit(), "Default upload limit meets expectations.")
+}
+
+func TestRepoServerRequireChecksum(t *testing.T) {
+	serverRoot, err := ioutil.TempDir("", "dbt-requirechecksum")
+	if err != nil {
+		t.Fatalf("Failed creating server root: %s", err)
+	}
+
+	defer os.RemoveAll(serverRoot)
+
+	repoServer := &DBTRepoServer{
+		ServerRoot:  serverRoot,
+		AuthTypePut: AUTH_BASIC_HTPASSWD,
+		AuthOptsPut: AuthOpts{
+			IdpFile: writeTestHtpasswd(t, serverRoot, "uploader", "password"),
+		},
+		RequireChecksum: true,
+	}
+
+	r, err := repoServer.Router()
+	if err != nil {
+		t.Fatalf("Failed creating router: %s", err)
+	}
+
+	server := httptest.NewServer(r)
+	defer server.Close()
+
+	content := "frobnitz ene woo"
+	md5sum, sha1sum, sha256sum, err := gomason.AllChecksumsForBytes([]byte(content))
+	if err != nil {
+		t.Fatalf("Failed to checksum test content: %s", err)
+	}
+
+	inputs := []struct {
+		name   string
+		header string
+		value  string
+		status int
+	}{
+		{"none", "", "", http.StatusBadRequest},
+		{"md5", "X-Checksum-Md5", md5sum, http.StatusCreated},
+		{"sha1", "X-Checksum-Sha1", sha1sum, http.StatusCreated},
+		{"sha256", "X-Checksum-Sha256", sha256sum, http.StatusCreated},
+	}
+
+	for _, tc := range inputs {
+		t.Run(tc.name, func(t *testing.T) {
+			path := fmt.Sprintf("/dbt-tools/foo/%s.txt", tc.name)
+
+			req, err := http.NewRequest(http.MethodPut, fmt.Sprintf("%s%s", server.URL, path), strings.NewReader(content))
+			if err != nil {
+				t.Fatalf("Failed creating request: %s", err)
+			}
+
+			req.SetBasicAuth("uploader", "password")
+
+			if tc.header != "" {
+				req.Header.Set(tc.header, tc.value)
+			}
+
+			resp, err := http.DefaultClient.Do(req)
+			if err != nil {
+				t.Fatalf("Failed making request: %s", err)
+			}
+
+			resp.Body.Close()
+
+			assert.Equal(t, tc.status, resp.StatusCode, "Response status meets expectations.")
+
+			_, err = os.Stat(fmt.Sprintf("%s%s", serverRoot, path))
+			assert.Equal(t, tc.status == http.StatusCreated, err == nil, "Only uploads with checksums are written.")
+		})
+	}
+}
+
+func TestRepoServerCompressedTool(t *testing.T) {
+	serverRoot, err := ioutil.TempDir("", "dbt-compressed")
+	if err != nil {
+		t.Fatalf("Failed creating server root: %s", err)
+	}
+
+	defer os.RemoveAll(serverRoot)
+
+	repoServer := &DBTRepoServer{
+		ServerRoot:  serverRoot,
+		AuthTypePut: AUTH_BASIC_HTPASSWD,
+		AuthOptsPut: AuthOpts{
+			IdpFile: writeTestHtpasswd(t, serverRoot, "uploader", "password"),
+		},
+	}
+
+	r, err := repoServer.Router()
+	if err != nil {
+		t.Fatalf("Failed creating router: %s", err)
+	}
+
+	server := httptest.NewServer(r)
+	defer server.Close()
+
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	_, _ = gz.Write([]byte("#!/bin/sh\necho foo\n"))
+	_ = gz.Close()
+
+	compressed := buf.Bytes()
+	fileUrl := fmt.Sprintf("%s/dbt-tools/foo/1.0.0/linux/amd64/foo%s", server.URL, COMPRESSED_SUFFIX)
+
+	req, err := http.NewRequest(http.MethodPut, fileUrl, bytes.NewReader(compressed))
+	if err != nil {
+		t.Fatalf("Failed creating request: %s", err)
+	}
+
+	req.SetBasicAuth("uploader", "password")
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("Failed uploading: %s", err)
+	}
+
+	resp.Body.Close()
+
+	assert.Equal(t, http.StatusCreated, resp.StatusCode, "Compressed tool uploads.")
+
+	// the gzipped bytes have to come back exactly as uploaded, not decoded along the way
+	resp, err = http.Get(fileUrl)
+	if err != nil {
+		t.Fatalf("Failed fetching: %s", err)
+	}
+
+	defer resp.Body.Close()
+
+	body, _ := ioutil.ReadAll(resp.Body)
+
+	assert.Equal(t, compressed, body, "Compressed tool is served as uploaded.")
+	assert.Equal(t, "", resp.Header.Get("Content-Encoding"), "Compressed tool isn't served with a content encoding.")
+}
+
+func TestHandlePut(t *testing.T) {
+	serverRoot, err := ioutil.TempDir("", "dbt-handleput")
+	if err != nil {
+		t.Fatalf("Failed creating server root: %s", err)
+	}
+
+	defer os.RemoveAll(serverRoot)
+
+	repoServer := &DBTRepoServer{
+		ServerRoot: serverRoot,
+	}
+
+	// big enough to take several reads, so the checksums are computed over a stream rather than one buffer
+	content := strings.Repeat("frobnitz ene woo\n", 10000)
+	md5sum, sha1sum, sha256sum, err := gomason.AllChecksumsForBytes([]byte(content))
+	if err != nil {
+		t.Fatalf("Failed to checksum test content: %s", err)
+	}
+
+	inputs := []struct {
+		name      string
+		md5sum    string
+		sha1sum   string
+		sha256sum string
+		err       string
+	}{
+		{"no checksums", "", "", "", ""},
+		{"all checksums", md5sum, sha1sum, sha256sum, ""},
+		{"md5 only", md5sum, "", "", ""},
+		{"sha1 only", "", sha1sum, "", ""},
+		{"sha256 only", "", "", sha256sum, ""},
+		{"bad md5", "0000", sha1sum, sha256sum, "Md5 sum"},
+		{"bad sha1", md5sum, "0000", sha256sum, "Sha1 sum"},
+		{"bad sha256", md5sum, sha1sum, "0000", "Sha256 sum"},
+		{"bad md5 only", "0000", "", "", "Md5 sum"},
+		{"bad sha1 only", "", "0000", "", "Sha1 sum"},
+		{"bad sha256 only", "", "", "0000", "Sha256 sum"},
+		{"swapped", sha256sum, sha1sum, md5sum, "Md5 sum"},
+	}
+
+	for _, tc := range inputs {
+		t.Run(tc.name, func(t *testing.T) {
+			dir := fmt.Sprintf("/dbt-tools/%s", strings.ReplaceAll(tc.name, " ", "-"))
+			path := fmt.Sprintf("%s/foo", dir)
+
+			err := repoServer.HandlePut(path, ioutil.NopCloser(strings.NewReader(content)), tc.md5sum, tc.sha1sum, tc.sha256sum)
+
+			entries, readErr := ioutil.ReadDir(fmt.Sprintf("%s%s", serverRoot, dir))
+			if readErr != nil {
+				t.Fatalf("Failed reading upload dir: %s", readErr)
+			}
+
+			names := make([]string, 0)
+			for _, e := range entries {
+				names = append(names, e.Name())
+			}
+
+			if tc.err != "" {
+				if assert.NotNil(t, err, "Checksum mismatch is rejected.") {
+					assert.Contains(t, err.Error(), tc.err, "Mismatched checksum is named.")
+				}
+
+				assert.Empty(t, names, "Rejected upload leaves nothing behind.")
+				return
+			}
+
+			if err != nil {
+				t.Errorf("Failed putting file: %s", err)
+			}
+
+			assert.Equal(t, []string{"foo"}, names, "Only the uploaded file is left behind.")
+
+			written, err := ioutil.ReadFile(fmt.Sprintf("%s%s", serverRoot, path))
+			if err != nil {
+				t.Fatalf("Failed reading uploaded file: %s", err)
+			}
+
+			assert.Equal(t, content, string(written), "Uploaded content meets expectations.")
+		})
+	}
+}
+
+func TestDisabledChecksumAlgos(t *testing.T) {
+	serverRoot, err := ioutil.TempDir("", "dbt-disabledchecksums")
+	if err != nil {
+		t.Fatalf("Failed creating server root: %s", err)
+	}
+
+	defer os.RemoveAll(serverRoot)
+
+	content := "frobnitz ene woo"
+	md5sum, sha1sum, sha256sum, err := gomason.AllChecksumsForBytes([]byte(content))
+	if err != nil {
+		t.Fatalf("Failed to checksum test content: %s", err)
+	}
+
+	inputs := []struct {
+		name     string
+		disabled []string
+		headers  map[string]string
+		status   int
+		body     string
+	}{
+		{"all enabled", nil, map[string]string{"X-Checksum-Md5": md5sum, "X-Checksum-Sha1": sha1sum, "X-Checksum-Sha256": sha256sum}, http.StatusCreated, ""},
+		{"sha256 with md5 disabled", []string{CHECKSUM_MD5}, map[string]string{"X-Checksum-Sha256": sha256sum}, http.StatusCreated, ""},
+		{"bad sha256 with md5 disabled", []string{CHECKSUM_MD5}, map[string]string{"X-Checksum-Sha256": "0000"}, http.StatusInternalServerError, ""},
+		{"md5 sent but disabled", []string{CHECKSUM_MD5}, map[string]string{"X-Checksum-Md5": md5sum, "X-Checksum-Sha256": sha256sum}, http.StatusBadRequest, "md5 checksums are disabled"},
+		{"sha1 sent but disabled", []string{"SHA1"}, map[string]string{"X-Checksum-Sha1": sha1sum}, http.StatusBadRequest, "sha1 checksums are disabled"},
+		{"no checksums sent", []string{CHECKSUM_MD5, CHECKSUM_SHA1}, map[string]string{}, http.StatusCreated, ""},
+	}
+
+	for i, tc := range inputs {
+		t.Run(tc.name, func(t *testing.T) {
+			repoServer := &DBTRepoServer{
+				ServerRoot:            serverRoot,
+				DisabledChecksumAlgos: tc.disabled,
+			}
+
+			path := fmt.Sprintf("/dbt-tools/foo/%d/foo", i)
+
+			req := httptest.NewRequest(http.MethodPut, path, strings.NewReader(content))
+			for k, v := range tc.headers {
+				req.Header.Set(k, v)
+			}
+
+			w := httptest.NewRecorder()
+			repoServer.PutFile(w, req)
+
+			assert.Equal(t, tc.status, w.Code, "Status meets expectations.")
+			assert.Contains(t, w.Body.String(), tc.body, "Response explains itself.")
+
+			_, statErr := os.Stat(fmt.Sprintf("%s%s", serverRoot, path))
+			assert.Equal(t, tc.status == http.StatusCreated, statErr == nil, "Only accepted uploads are written.")
+		})
+	}
+}
+
+func TestGenerateChecksums(t *testing.T) {
+	serverRoot, err := ioutil.TempDir("", "dbt-checksums")
+	if err != nil {
+		t.Fatalf("Failed creating server root: %s", err)
+	}
+
+	defer os.RemoveAll(serverRoot)
+
+	content := "frobnitz ene woo"
+	_, _, sha256sum, err := gomason.AllChecksumsForBytes([]byte(content))
+	if err != nil {
+		t.Fatalf("Failed to checksum test content: %s", err)
+	}
+
+	inputs := []struct {
+		name     string
+		generate bool
+		path     string
+		existing string
+		sidecar  string
+	}{
+		{"off", false, "/dbt-tools/foo/1.2.3/foo", "", ""},
+		{"on", true, "/dbt-tools/foo/1.2.4/foo", "", sha256sum},
+		{"explicit sidecar kept", true, "/dbt-tools/foo/1.2.5/foo", "uploaded", "uploaded"},
+		{"no sidecar for sidecar", true, "/dbt-tools/foo/1.2.6/foo.sha256", "", ""},
+		{"no sidecar for signature", true, "/dbt-tools/foo/1.2.7/foo.asc", "", ""},
+	}
+
+	for _, tc := range inputs {
+		t.Run(tc.name, func(t *testing.T) {
+			repoServer := &DBTRepoServer{
+				ServerRoot:        serverRoot,
+				GenerateChecksums: tc.generate,
+			}
+
+			sidecarPath := fmt.Sprintf("%s%s.sha256", serverRoot, tc.path)
+
+			if tc.existing != "" {
+				err := repoServer.HandlePut(fmt.Sprintf("%s.sha256", tc.path), ioutil.NopCloser(strings.NewReader(tc.existing)), "", "", "")
+				if err != nil {
+					t.Fatalf("Failed putting sidecar: %s", err)
+				}
+			}
+
+			err := repoServer.HandlePut(tc.path, ioutil.NopCloser(strings.NewReader(content)), "", "", "")
+			if err != nil {
+				t.Errorf("Failed putting file: %s", err)
+			}
+
+			sidecar, err := ioutil.ReadFile(sidecarPath)
+			if tc.sidecar == "" {
+				assert.True(t, os.IsNotExist(err), "No sidecar is written.")
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("Failed reading sidecar: %s", err)
+			}
+
+			assert.Equal(t, tc.sidecar, string(sidecar), "Sidecar meets expectations.")
+
+			entries, err := ioutil.ReadDir(filepath.Dir(sidecarPath))
+			if err != nil {
+				t.Fatalf("Failed reading upload dir: %s", err)
+			}
+
+			assert.Equal(t, 2, len(entries), "Only the file and its sidecar are left behind.")
+		})
+	}
+
+	t.Run("s3", func(t *testing.T) {
+		bucket := "dbt-repo"
+
+		fakeS3, fakeClient := newFakeS3(t, bucket)
+		defer fakeS3.Close()
+
+		repoServer := &DBTRepoServer{
+			S3Backend: &S3BackendOpts{
+				Bucket: bucket,
+				Region: "us-east-1",
+			},
+			S3Client:          fakeClient,
+			GenerateChecksums: true,
+		}
+
+		client := fakeClient
+
+		err := repoServer.HandlePut("/dbt-tools/foo/1.2.4/foo.sha256", ioutil.NopCloser(strings.NewReader("uploaded")), "", "", "")
+		if err != nil {
+			t.Fatalf("Failed putting sidecar: %s", err)
+		}
+
+		for _, p := range []string{"/dbt-tools/foo/1.2.3/foo", "/dbt-tools/foo/1.2.4/foo"} {
+			err := repoServer.HandlePut(p, ioutil.NopCloser(strings.NewReader(content)), "", "", "")
+			if err != nil {
+				t.Errorf("Failed putting file: %s", err)
+			}
+		}
+
+		expected := map[string]string{
+			"dbt-tools/foo/1.2.3/foo.sha256": sha256sum,
+			"dbt-tools/foo/1.2.4/foo.sha256": "uploaded",
+		}
+
+		for key, want := range expected {
+			resp, err := client.GetObject(context.Background(), &s3.GetObjectInput{
+				Bucket: aws.String(bucket),
+				Key:    aws.String(key),
+			})
+			if err != nil {
+				t.Fatalf("Failed fetching %s: %s", key, err)
+			}
+
+			got, err := ioutil.ReadAll(resp.Body)
+			resp.Body.Close()
+			if err != nil {
+				t.Fatalf("Failed reading %s: %s", key, err)
+			}
+
+			assert.Equal(t, want, string(got), "Sidecar in s3 meets expectations.")
+		}
+	})
+}
+
+func TestS3BucketRegion(t *testing.T) {
+	locationXml := `<?xml version="1.0" encoding="UTF-8"?><LocationConstraint xmlns="http://s3.amazonaws.com/doc/2006-03-01/">%s</LocationConstraint>`
+	errorXml := `<?xml version="1.0" encoding="UTF-8"?><Error><Code>%s</Code><Message>%s</Message></Error>`
+
+	inputs := []struct {
+		name           string
+		locationStatus int
+		locationBody   string
+		headStatus     int
+		headRegion     string
+		expected       string
+		err            bool
+	}{
+		{"us-east-1", http.StatusOK, fmt.Sprintf(locationXml, ""), 0, "", "us-east-1", false},
+		{"other region", http.StatusOK, fmt.Sprintf(locationXml, "eu-west-2"), 0, "", "eu-west-2", false},
+		{"legacy eu", http.StatusOK, fmt.Sprintf(locationXml, "EU"), 0, "", "eu-west-1", false},
+		{"access denied", http.StatusForbidden, fmt.Sprintf(errorXml, "AccessDenied", "Access Denied"), http.StatusOK, "ap-southeast-2", "ap-southeast-2", false},
+		{"access denied head forbidden", http.StatusForbidden, fmt.Sprintf(errorXml, "AccessDenied", "Access Denied"), http.StatusForbidden, "us-west-2", "us-west-2", false},
+		{"access denied head says nothing", http.StatusForbidden, fmt.Sprintf(errorXml, "AccessDenied", "Access Denied"), http.StatusForbidden, "", "", true},
+		{"access denied no such bucket", http.StatusForbidden, fmt.Sprintf(errorXml, "AccessDenied", "Access Denied"), http.StatusNotFound, "", "", true},
+		{"no such bucket", http.StatusNotFound, fmt.Sprintf(errorXml, "NoSuchBucket", "The specified bucket does not exist"), 0, "", "", true},
+	}
+
+	for _, tc := range inputs {
+		t.Run(tc.name, func(t *testing.T) {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.Method == http.MethodHead {
+					if tc.headRegion != "" {
+						w.Header().Set("x-amz-bucket-region", tc.headRegion)
+					}
+
+					w.WriteHeader(tc.headStatus)
+					return
+				}
+
+				w.Header().Set("Content-Type", "application/xml")
+				w.WriteHeader(tc.locationStatus)
+				_, _ = w.Write([]byte(tc.locationBody))
+			}))
+
+			defer server.Close()
+
+			region, err := S3BucketRegion(context.Background(), newFakeS3Client(server.URL), "dbt-tools")
+			if tc.err {
+				assert.Error(t, err, "Bucket can't be located.")
+				assert.Contains(t, fmt.Sprintf("%s", err), "unable to locate s3 bucket dbt-tools", "Error names the bucket.")
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("Failed locating bucket: %s", err)
+			}
+
+			assert.Equal(t, tc.expected, region, "Bucket region is detected.")
+		})
+	}
+}
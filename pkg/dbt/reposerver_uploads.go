@@ -0,0 +1,95 @@
+// Copyright © 2019 Nik Ogura <nik.ogura@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package dbt
+
+import (
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// isUploadTempFile returns true if the file name is that of an upload in progress
+func isUploadTempFile(name string) bool {
+	return strings.HasPrefix(path.Base(name), UPLOAD_TEMP_PREFIX)
+}
+
+// uploadHidingFileSystem An http.FileSystem that won't serve or list uploads in progress, so readers only ever see complete files.
+type uploadHidingFileSystem struct {
+	http.FileSystem
+}
+
+// Open opens the named file, unless it's an upload in progress.
+func (fs uploadHidingFileSystem) Open(name string) (http.File, error) {
+	if isUploadTempFile(name) {
+		return nil, os.ErrNotExist
+	}
+
+	file, err := fs.FileSystem.Open(name)
+	if err != nil {
+		return nil, err
+	}
+
+	return uploadHidingFile{file}, nil
+}
+
+// uploadHidingFile An http.File whose directory listings leave out uploads in progress.
+type uploadHidingFile struct {
+	http.File
+}
+
+// Readdir lists the directory, minus any uploads in progress.
+func (f uploadHidingFile) Readdir(count int) (entries []os.FileInfo, err error) {
+	all, err := f.File.Readdir(count)
+
+	entries = make([]os.FileInfo, 0, len(all))
+	for _, e := range all {
+		if !isUploadTempFile(e.Name()) {
+			entries = append(entries, e)
+		}
+	}
+
+	return entries, err
+}
+
+// CleanUploadTempFiles removes the temp files left under root by uploads that were interrupted, e.g. by the server dying.  Only files older than maxAge are removed, so as not to disturb uploads that are actually in progress.
+func CleanUploadTempFiles(root string, maxAge time.Duration) (err error) {
+	cutoff := time.Now().Add(-maxAge)
+
+	err = filepath.Walk(root, func(filePath string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if info.IsDir() || !isUploadTempFile(filePath) || info.ModTime().After(cutoff) {
+			return nil
+		}
+
+		log.Infof("Removing abandoned upload %s", filePath)
+
+		err = os.Remove(filePath)
+		if err != nil {
+			err = errors.Wrapf(err, "failed to remove %s", filePath)
+		}
+
+		return err
+	})
+
+	return err
+}
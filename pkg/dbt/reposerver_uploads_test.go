@@ -0,0 +1,135 @@
+package dbt
+
+import (
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestUploadsInProgressHidden(t *testing.T) {
+	serverRoot, err := ioutil.TempDir("", "dbt-uploads")
+	if err != nil {
+		t.Fatalf("Failed creating server root: %s", err)
+	}
+
+	defer os.RemoveAll(serverRoot)
+
+	toolDir := fmt.Sprintf("%s/dbt-tools/foo", serverRoot)
+	err = os.MkdirAll(toolDir, 0755)
+	if err != nil {
+		t.Fatalf("Failed creating tool dir: %s", err)
+	}
+
+	for _, name := range []string{"1.2.3", "description.txt", fmt.Sprintf("%s123", UPLOAD_TEMP_PREFIX)} {
+		err = os.WriteFile(fmt.Sprintf("%s/%s", toolDir, name), []byte("frobnitz ene woo"), 0644)
+		if err != nil {
+			t.Fatalf("Failed writing test file: %s", err)
+		}
+	}
+
+	repoServer := &DBTRepoServer{
+		ServerRoot: serverRoot,
+	}
+
+	r, err := repoServer.Router()
+	if err != nil {
+		t.Fatalf("Failed creating router: %s", err)
+	}
+
+	server := httptest.NewServer(r)
+	defer server.Close()
+
+	inputs := []struct {
+		name   string
+		path   string
+		status int
+	}{
+		{"complete file", "/dbt-tools/foo/description.txt", http.StatusOK},
+		{"upload in progress", fmt.Sprintf("/dbt-tools/foo/%s123", UPLOAD_TEMP_PREFIX), http.StatusNotFound},
+	}
+
+	for _, tc := range inputs {
+		t.Run(tc.name, func(t *testing.T) {
+			resp, err := http.Get(fmt.Sprintf("%s%s", server.URL, tc.path))
+			if err != nil {
+				t.Fatalf("Failed making request: %s", err)
+			}
+
+			resp.Body.Close()
+
+			assert.Equal(t, tc.status, resp.StatusCode, "Response status meets expectations.")
+		})
+	}
+
+	resp, err := http.Get(fmt.Sprintf("%s/dbt-tools/foo/", server.URL))
+	if err != nil {
+		t.Fatalf("Failed fetching listing: %s", err)
+	}
+
+	defer resp.Body.Close()
+
+	listing, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatalf("Failed reading listing: %s", err)
+	}
+
+	assert.Contains(t, string(listing), "description.txt", "Complete files are listed.")
+	assert.NotContains(t, string(listing), UPLOAD_TEMP_PREFIX, "Uploads in progress are not listed.")
+}
+
+func TestCleanUploadTempFiles(t *testing.T) {
+	serverRoot, err := ioutil.TempDir("", "dbt-uploads")
+	if err != nil {
+		t.Fatalf("Failed creating server root: %s", err)
+	}
+
+	defer os.RemoveAll(serverRoot)
+
+	toolDir := fmt.Sprintf("%s/dbt-tools/foo/1.2.3", serverRoot)
+	err = os.MkdirAll(toolDir, 0755)
+	if err != nil {
+		t.Fatalf("Failed creating tool dir: %s", err)
+	}
+
+	inputs := []struct {
+		name string
+		age  time.Duration
+		kept bool
+	}{
+		{"foo", 2 * time.Hour, true},
+		{fmt.Sprintf("%sabandoned", UPLOAD_TEMP_PREFIX), 2 * time.Hour, false},
+		{fmt.Sprintf("%sinprogress", UPLOAD_TEMP_PREFIX), 0, true},
+	}
+
+	for _, tc := range inputs {
+		filePath := fmt.Sprintf("%s/%s", toolDir, tc.name)
+
+		err = os.WriteFile(filePath, []byte("frobnitz ene woo"), 0644)
+		if err != nil {
+			t.Fatalf("Failed writing test file: %s", err)
+		}
+
+		modTime := time.Now().Add(-tc.age)
+		err = os.Chtimes(filePath, modTime, modTime)
+		if err != nil {
+			t.Fatalf("Failed setting file times: %s", err)
+		}
+	}
+
+	err = CleanUploadTempFiles(serverRoot, time.Hour)
+	if err != nil {
+		t.Errorf("Failed cleaning temp files: %s", err)
+	}
+
+	for _, tc := range inputs {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := os.Stat(fmt.Sprintf("%s/%s", toolDir, tc.name))
+			assert.Equal(t, tc.kept, err == nil, "File kept or removed as expected.")
+		})
+	}
+}
@@ -0,0 +1,166 @@
+// Copyright © 2019 Nik Ogura <nik.ogura@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package dbt
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// Validate checks the reposerver's config for the mistakes that otherwise only show up once it's serving requests, such as an unknown auth type, a missing identity file or function, a server root that isn't there, or a port out of range.  Every problem found is listed in the error, with what to do about it.
+func (d *DBTRepoServer) Validate() (err error) {
+	problems := make([]string, 0)
+
+	if d.Port < 1 || d.Port > 65535 {
+		problems = append(problems, fmt.Sprintf("port %d is out of range.  Use a port from 1 to 65535.", d.Port))
+	}
+
+	if d.Files != nil {
+		if d.AuthTypePut != "" {
+			problems = append(problems, "authTypePut is set, but the tree is built in, so there's nowhere to put uploads.  Unset authTypePut.")
+		}
+	} else if d.S3Backend != nil {
+		if d.S3Backend.Bucket == "" {
+			problems = append(problems, "s3Backend is set, but has no bucket.  Set s3Backend.bucket to the bucket to serve.")
+		}
+	} else {
+		problems = append(problems, validateServerRoot(d.ServerRoot)...)
+	}
+
+	if d.AuthTypePut != "" && d.Files == nil {
+		problems = append(problems, validateAuth("authTypePut", d.AuthTypePut, "authOptsPut", d.AuthOptsPut)...)
+	}
+
+	if d.AuthGets {
+		if d.AuthTypeGet == "" {
+			problems = append(problems, "authGets is set, but authTypeGet isn't, so GETs wouldn't be authenticated at all.  Set authTypeGet.")
+		} else {
+			problems = append(problems, validateAuth("authTypeGet", d.AuthTypeGet, "authOptsGet", d.AuthOptsGet)...)
+		}
+	}
+
+	problems = append(problems, validateCORSOrigins(d.CORSAllowOrigins)...)
+
+	for _, algo := range d.DisabledChecksumAlgos {
+		switch strings.ToLower(strings.TrimSpace(algo)) {
+		case CHECKSUM_MD5, CHECKSUM_SHA1, CHECKSUM_SHA256:
+		default:
+			problems = append(problems, fmt.Sprintf("disabledChecksumAlgos entry %q isn't a checksum algorithm.  Use %s, %s, or %s.", algo, CHECKSUM_MD5, CHECKSUM_SHA1, CHECKSUM_SHA256))
+		}
+	}
+
+	if d.GenerateChecksums && !d.ChecksumEnabled(CHECKSUM_SHA256) {
+		problems = append(problems, "generateChecksums is set, but sha256 is in disabledChecksumAlgos, so there's nothing to generate them from.  Enable sha256, or unset generateChecksums.")
+	}
+
+	if len(problems) > 0 {
+		err = fmt.Errorf("invalid reposerver config:\n\t%s", strings.Join(problems, "\n\t"))
+	}
+
+	return err
+}
+
+// validateServerRoot checks that the tree to serve is a directory the reposerver can read.
+func validateServerRoot(serverRoot string) (problems []string) {
+	if serverRoot == "" {
+		problems = append(problems, "serverRoot isn't set.  Point it at the tree to serve, or configure s3Backend.")
+		return problems
+	}
+
+	info, err := os.Stat(serverRoot)
+	if err != nil {
+		if os.IsNotExist(err) {
+			problems = append(problems, fmt.Sprintf("serverRoot %s doesn't exist.  Create it, or point serverRoot at the tree to serve.", serverRoot))
+			return problems
+		}
+
+		problems = append(problems, fmt.Sprintf("serverRoot %s can't be read: %s", serverRoot, err))
+		return problems
+	}
+
+	if !info.IsDir() {
+		problems = append(problems, fmt.Sprintf("serverRoot %s isn't a directory.  Point serverRoot at the tree to serve.", serverRoot))
+		return problems
+	}
+
+	dir, err := os.Open(serverRoot)
+	if err == nil {
+		_, err = dir.Readdirnames(1)
+		dir.Close()
+	}
+
+	if err != nil && err != io.EOF {
+		problems = append(problems, fmt.Sprintf("serverRoot %s can't be read by the reposerver: %s.  Check its permissions.", serverRoot, err))
+	}
+
+	return problems
+}
+
+// validateAuth checks that an auth type is one the reposerver supports, and that its options are there.  typeField and optsField name the config fields, so problems can point at them.
+func validateAuth(typeField string, authType string, optsField string, opts AuthOpts) (problems []string) {
+	switch authType {
+	case AUTH_BASIC_HTPASSWD:
+		problems = append(problems, validateIdpFile(optsField, opts.IdpFile, authType)...)
+	case AUTH_SSH_AGENT_FILE:
+		problems = append(problems, validateIdpFile(optsField, opts.IdpFile, authType)...)
+
+		if len(problems) == 0 {
+			_, err := LoadPubkeyIdpFile(opts.IdpFile)
+			if err != nil {
+				problems = append(problems, fmt.Sprintf("%s.idpFile %s isn't a valid public key identity file: %s", optsField, opts.IdpFile, err))
+			}
+		}
+	case AUTH_SSH_AGENT_FUNC:
+		if strings.TrimSpace(opts.IdpFunc) == "" {
+			problems = append(problems, fmt.Sprintf("%s.idpFunc isn't set.  %s %s needs a shell command that prints a user's public keys.", optsField, typeField, authType))
+		}
+
+		_, err := ParseFuncTimeout(opts.FuncTimeout)
+		if err != nil {
+			problems = append(problems, fmt.Sprintf("%s.funcTimeout %q isn't a duration more than 0, such as '30s'.", optsField, opts.FuncTimeout))
+		}
+	case AUTH_BASIC_LDAP, AUTH_SSH_AGENT_LDAP:
+		problems = append(problems, fmt.Sprintf("%s %s isn't supported yet.  Use %s, %s, or %s.", typeField, authType, AUTH_BASIC_HTPASSWD, AUTH_SSH_AGENT_FILE, AUTH_SSH_AGENT_FUNC))
+	default:
+		problems = append(problems, fmt.Sprintf("%s %q isn't a recognized auth type.  Use %s, %s, or %s.", typeField, authType, AUTH_BASIC_HTPASSWD, AUTH_SSH_AGENT_FILE, AUTH_SSH_AGENT_FUNC))
+	}
+
+	return problems
+}
+
+// validateIdpFile checks that an auth type's identity file is set, and there to be read.
+func validateIdpFile(optsField string, idpFile string, authType string) (problems []string) {
+	if idpFile == "" {
+		problems = append(problems, fmt.Sprintf("%s.idpFile isn't set.  %s needs an identity file.", optsField, authType))
+		return problems
+	}
+
+	f, err := os.Open(idpFile)
+	if err != nil {
+		if os.IsNotExist(err) {
+			problems = append(problems, fmt.Sprintf("%s.idpFile %s doesn't exist.  Create it, or fix the path.", optsField, idpFile))
+			return problems
+		}
+
+		problems = append(problems, fmt.Sprintf("%s.idpFile %s can't be read by the reposerver: %s.  Check its permissions.", optsField, idpFile, err))
+		return problems
+	}
+
+	f.Close()
+
+	return problems
+}
@@ -0,0 +1,187 @@
+package dbt
+
+import (
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestRepoServerValidate(t *testing.T) {
+	tmpDir := t.TempDir()
+
+	serverRoot := filepath.Join(tmpDir, "repo")
+	err := os.Mkdir(serverRoot, 0755)
+	if err != nil {
+		t.Fatalf("Failed creating server root: %s", err)
+	}
+
+	notADir := filepath.Join(tmpDir, "file")
+	htpasswd := filepath.Join(tmpDir, "htpasswd")
+	pubkeys := filepath.Join(tmpDir, "pubkeys.json")
+	badPubkeys := filepath.Join(tmpDir, "bad.json")
+
+	files := map[string]string{
+		notADir:    "not a directory",
+		htpasswd:   "nik:$apr1$ytmDEY.X$LJt5T3fWtswK3KF5iINxT1\n",
+		pubkeys:    `{"getUsers": [], "putUsers": []}`,
+		badPubkeys: "not json",
+	}
+
+	for path, content := range files {
+		err = os.WriteFile(path, []byte(content), 0644)
+		if err != nil {
+			t.Fatalf("Failed writing %s: %s", path, err)
+		}
+	}
+
+	missing := filepath.Join(tmpDir, "missing")
+
+	inputs := []struct {
+		name     string
+		server   DBTRepoServer
+		problems []string
+	}{
+		{
+			"minimal",
+			DBTRepoServer{Port: 9999, ServerRoot: serverRoot},
+			[]string{},
+		},
+		{
+			"htpasswd puts, pubkey gets",
+			DBTRepoServer{
+				Port:        9999,
+				ServerRoot:  serverRoot,
+				AuthTypePut: AUTH_BASIC_HTPASSWD,
+				AuthOptsPut: AuthOpts{IdpFile: htpasswd},
+				AuthTypeGet: AUTH_SSH_AGENT_FILE,
+				AuthGets:    true,
+				AuthOptsGet: AuthOpts{IdpFile: pubkeys},
+			},
+			[]string{},
+		},
+		{
+			"s3 backend",
+			DBTRepoServer{Port: 9999, S3Backend: &S3BackendOpts{Bucket: "dbt", Region: "us-east-1"}},
+			[]string{},
+		},
+		{
+			"get auth unused",
+			DBTRepoServer{Port: 9999, ServerRoot: serverRoot, AuthTypeGet: "bogus"},
+			[]string{},
+		},
+		{
+			"bad port",
+			DBTRepoServer{Port: 70000, ServerRoot: serverRoot},
+			[]string{"port 70000 is out of range"},
+		},
+		{
+			"no server root",
+			DBTRepoServer{Port: 9999},
+			[]string{"serverRoot isn't set"},
+		},
+		{
+			"missing server root",
+			DBTRepoServer{Port: 9999, ServerRoot: missing},
+			[]string{fmt.Sprintf("serverRoot %s doesn't exist", missing)},
+		},
+		{
+			"server root not a dir",
+			DBTRepoServer{Port: 9999, ServerRoot: notADir},
+			[]string{"isn't a directory"},
+		},
+		{
+			"s3 without bucket",
+			DBTRepoServer{Port: 9999, S3Backend: &S3BackendOpts{Region: "us-east-1"}},
+			[]string{"s3Backend is set, but has no bucket"},
+		},
+		{
+			"unknown auth type",
+			DBTRepoServer{Port: 9999, ServerRoot: serverRoot, AuthTypePut: "bogus"},
+			[]string{`authTypePut "bogus" isn't a recognized auth type`},
+		},
+		{
+			"ldap",
+			DBTRepoServer{Port: 9999, ServerRoot: serverRoot, AuthTypePut: AUTH_BASIC_LDAP},
+			[]string{"isn't supported yet"},
+		},
+		{
+			"missing idp file",
+			DBTRepoServer{Port: 9999, ServerRoot: serverRoot, AuthTypePut: AUTH_BASIC_HTPASSWD, AuthOptsPut: AuthOpts{IdpFile: missing}},
+			[]string{fmt.Sprintf("authOptsPut.idpFile %s doesn't exist", missing)},
+		},
+		{
+			"unset idp file",
+			DBTRepoServer{Port: 9999, ServerRoot: serverRoot, AuthTypePut: AUTH_SSH_AGENT_FILE},
+			[]string{"authOptsPut.idpFile isn't set"},
+		},
+		{
+			"unparseable idp file",
+			DBTRepoServer{Port: 9999, ServerRoot: serverRoot, AuthTypePut: AUTH_SSH_AGENT_FILE, AuthOptsPut: AuthOpts{IdpFile: badPubkeys}},
+			[]string{"isn't a valid public key identity file"},
+		},
+		{
+			"missing idp func",
+			DBTRepoServer{Port: 9999, ServerRoot: serverRoot, AuthTypeGet: AUTH_SSH_AGENT_FUNC, AuthGets: true},
+			[]string{"authOptsGet.idpFunc isn't set"},
+		},
+		{
+			"bad func timeout",
+			DBTRepoServer{Port: 9999, ServerRoot: serverRoot, AuthTypeGet: AUTH_SSH_AGENT_FUNC, AuthGets: true, AuthOptsGet: AuthOpts{IdpFunc: "echo", FuncTimeout: "soon"}},
+			[]string{`authOptsGet.funcTimeout "soon" isn't a duration`},
+		},
+		{
+			"auth gets without a type",
+			DBTRepoServer{Port: 9999, ServerRoot: serverRoot, AuthGets: true},
+			[]string{"authGets is set, but authTypeGet isn't"},
+		},
+		{
+			"cors origins",
+			DBTRepoServer{Port: 9999, ServerRoot: serverRoot, CORSAllowOrigins: []string{"https://ui.example.com", CORS_ALLOW_ANY}},
+			[]string{},
+		},
+		{
+			"bad cors origin",
+			DBTRepoServer{Port: 9999, ServerRoot: serverRoot, CORSAllowOrigins: []string{"ui.example.com/catalog"}},
+			[]string{`corsAllowOrigins entry "ui.example.com/catalog" isn't an origin`},
+		},
+		{
+			"disabled checksums",
+			DBTRepoServer{Port: 9999, ServerRoot: serverRoot, DisabledChecksumAlgos: []string{"MD5", CHECKSUM_SHA1}, GenerateChecksums: true},
+			[]string{},
+		},
+		{
+			"unknown checksum",
+			DBTRepoServer{Port: 9999, ServerRoot: serverRoot, DisabledChecksumAlgos: []string{"crc32"}},
+			[]string{`disabledChecksumAlgos entry "crc32" isn't a checksum algorithm`},
+		},
+		{
+			"generating checksums without sha256",
+			DBTRepoServer{Port: 9999, ServerRoot: serverRoot, DisabledChecksumAlgos: []string{CHECKSUM_SHA256}, GenerateChecksums: true},
+			[]string{"generateChecksums is set, but sha256 is in disabledChecksumAlgos"},
+		},
+		{
+			"everything wrong",
+			DBTRepoServer{Port: 0, ServerRoot: missing, AuthTypePut: "bogus", AuthGets: true},
+			[]string{"port 0 is out of range", "doesn't exist", "isn't a recognized auth type", "authGets is set"},
+		},
+	}
+
+	for _, tc := range inputs {
+		t.Run(tc.name, func(t *testing.T) {
+			err := tc.server.Validate()
+
+			if len(tc.problems) == 0 {
+				assert.Nil(t, err, "Valid config passes.")
+				return
+			}
+
+			if assert.NotNil(t, err, "Invalid config is caught.") {
+				for _, problem := range tc.problems {
+					assert.Contains(t, err.Error(), problem, "Error names the problem.")
+				}
+			}
+		})
+	}
+}
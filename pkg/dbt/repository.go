@@ -16,12 +16,16 @@ package dbt
 
 import (
 	"bufio"
-	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
 	"fmt"
-	"github.com/aws/aws-sdk-go/aws"
-	"github.com/aws/aws-sdk-go/aws/session"
-	"github.com/aws/aws-sdk-go/service/s3"
-	"github.com/aws/aws-sdk-go/service/s3/s3manager"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awshttp "github.com/aws/aws-sdk-go-v2/aws/transport/http"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/feature/s3/manager"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
 	"github.com/keybase/go-crypto/openpgp"
 	"github.com/pkg/errors"
 	"golang.org/x/net/html"
@@ -36,7 +40,6 @@ import (
 	"regexp"
 	"strconv"
 	"strings"
-	"time"
 )
 
 // AWS_ID_ENV_VAR Default env var for AWS access key
@@ -51,7 +54,25 @@ const AWS_REGION_ENV_VAR = "AWS_DEFAULT_REGION"
 // NOPROGRESS turns off the progress bar on file fetches.  Primarily used for testing to avoid cluttering up the output and confusing the test harness.
 var NOPROGRESS = false
 
-// ToolExists Returns true if a tool of the name input exists in the repository given.
+// LATEST_FILE the name of the optional file in a tool's directory, or the root of the dbt repo, holding the latest version.  It lets dbt find versions on repos that can't list directories, such as S3 static websites and CDNs.
+const LATEST_FILE = "latest"
+
+// VERSION_INDEX_FILE the name of the machine readable index of a tool's versions.  The reposerver generates one for every tool, and dbt prefers it to scraping directory listings.
+const VERSION_INDEX_FILE = "index.json"
+
+// VersionIndex the contents of a VERSION_INDEX_FILE
+type VersionIndex struct {
+	Versions []string `json:"versions"`
+	Latest   string   `json:"latest"`
+}
+
+// COMPRESSED_SUFFIX the suffix of a gzipped tool binary in the repo.  Publishing <tool>.gz alongside, or instead of, <tool> is all it takes to have dbt fetch the compressed copy.
+const COMPRESSED_SUFFIX = ".gz"
+
+// DEFAULT_S3_ENDPOINT_REGION the region used with a custom S3 endpoint when none is configured.
+const DEFAULT_S3_ENDPOINT_REGION = "us-east-1"
+
+// ToolExists Returns true if a tool of the name input exists in the repository given.  Only a 404 means it doesn't.  Any other error status is returned as an error.
 func (dbt *DBT) ToolExists(toolName string) (found bool, err error) {
 	var uri string
 	var repoUrl string
@@ -64,15 +85,15 @@ func (dbt *DBT) ToolExists(toolName string) (found bool, err error) {
 		uri = fmt.Sprintf("%s/%s/", repoUrl, toolName)
 	}
 
-	isS3, s3Meta := S3Url(uri)
+	isS3, s3Meta := dbt.s3Url(uri)
 
 	if isS3 {
 		return dbt.S3ToolExists(s3Meta)
 	}
 
-	client := &http.Client{}
+	client := dbt.HttpClient()
 
-	req, err := http.NewRequest("GET", uri, nil)
+	req, err := http.NewRequestWithContext(dbt.RequestContext(), "GET", uri, nil)
 	if err != nil {
 		err = errors.Wrapf(err, "failed to create request for url: %s", uri)
 		return found, err
@@ -87,22 +108,26 @@ func (dbt *DBT) ToolExists(toolName string) (found bool, err error) {
 	resp, err := client.Do(req)
 
 	if err != nil {
-		err = errors.Wrap(err, fmt.Sprintf("Failed to find tool in repo %q: %s", repoUrl, err))
+		err = errors.Wrapf(ErrRepoUnreachable, "Failed to find tool in repo %q: %s", repoUrl, err)
 		return false, err
 	}
 
-	if resp != nil {
-		if resp.StatusCode != 200 {
-			return false, err
-		}
-	} else {
-		return false, err
+	defer resp.Body.Close()
+
+	// only a 404 says the tool isn't there.  An auth failure or a server error says nothing either way.
+	switch {
+	case resp.StatusCode >= 200 && resp.StatusCode <= 299:
+		found = true
+	case resp.StatusCode == http.StatusNotFound:
+		found = false
+	default:
+		err = fmt.Errorf("unexpected status %d looking for tool %q in repo %q", resp.StatusCode, toolName, repoUrl)
 	}
 
-	return true, err
+	return found, err
 }
 
-// ToolVersionExists returns true if the specified version of a tool is in the repo
+// ToolVersionExists returns true if the specified version of a tool is in the repo.  Only a 404 means it isn't.  Any other error status is returned as an error.
 func (dbt *DBT) ToolVersionExists(tool string, version string) (ok bool, err error) {
 	var uri string
 
@@ -115,15 +140,15 @@ func (dbt *DBT) ToolVersionExists(tool string, version string) (ok bool, err err
 		uri = fmt.Sprintf("%s/%s/%s/", repoUrl, tool, version)
 	}
 
-	isS3, s3Meta := S3Url(uri)
+	isS3, s3Meta := dbt.s3Url(uri)
 
 	if isS3 {
 		return dbt.S3ToolVersionExists(s3Meta)
 	}
 
-	client := &http.Client{}
+	client := dbt.HttpClient()
 
-	req, err := http.NewRequest("GET", uri, nil)
+	req, err := http.NewRequestWithContext(dbt.RequestContext(), "GET", uri, nil)
 	if err != nil {
 		err = errors.Wrapf(err, "failed to create request for url: %s", uri)
 		return ok, err
@@ -137,15 +162,21 @@ func (dbt *DBT) ToolVersionExists(tool string, version string) (ok bool, err err
 
 	resp, err := client.Do(req)
 	if err != nil {
-		err = errors.Wrapf(err, "Error looking for tool %q version %q in repo %q", tool, version, uri)
+		err = errors.Wrapf(ErrRepoUnreachable, "Error looking for tool %q version %q in repo %q: %s", tool, version, uri, err)
 		return ok, err
 	}
 
-	if resp.StatusCode != 200 {
-		return ok, err
-	}
+	defer resp.Body.Close()
 
-	ok = true
+	// only a 404 says the version isn't there.  An auth failure or a server error says nothing either way.
+	switch {
+	case resp.StatusCode >= 200 && resp.StatusCode <= 299:
+		ok = true
+	case resp.StatusCode == http.StatusNotFound:
+		ok = false
+	default:
+		err = fmt.Errorf("unexpected status %d looking for tool %q version %q in repo %q", resp.StatusCode, tool, version, uri)
+	}
 
 	return ok, err
 }
@@ -163,15 +194,24 @@ func (dbt *DBT) FetchToolVersions(toolName string) (versions []string, err error
 		uri = fmt.Sprintf("%s/%s/", repoUrl, toolName)
 	}
 
-	isS3, s3Meta := S3Url(uri)
+	isS3, s3Meta := dbt.s3Url(uri)
 
 	if isS3 {
 		return dbt.S3FetchToolVersions(s3Meta)
 	}
 
-	client := &http.Client{}
+	index, found, err := dbt.FetchVersionIndex(toolName)
+	if err != nil {
+		return versions, err
+	}
+
+	if found {
+		return index.Versions, err
+	}
+
+	client := dbt.HttpClient()
 
-	req, err := http.NewRequest("GET", uri, nil)
+	req, err := http.NewRequestWithContext(dbt.RequestContext(), "GET", uri, nil)
 	if err != nil {
 		err = errors.Wrapf(err, "failed to create request for url: %s", uri)
 		return versions, err
@@ -185,24 +225,184 @@ func (dbt *DBT) FetchToolVersions(toolName string) (versions []string, err error
 
 	resp, err := client.Do(req)
 	if err != nil {
-		err = errors.Wrapf(err, "Error looking for versions of tool %q in repo %q", toolName, uri)
+		err = errors.Wrapf(ErrRepoUnreachable, "Error looking for versions of tool %q in repo %q: %s", toolName, uri, err)
 		return versions, err
 	}
 
 	if resp != nil {
-		versions = dbt.ParseVersionResponse(resp)
-
 		defer resp.Body.Close()
 
+		// can't list?  Maybe there's a latest file.
+		if resp.StatusCode != http.StatusOK {
+			latest, found, err := dbt.FetchLatestFile(toolName)
+			if err != nil {
+				return versions, err
+			}
+
+			if found {
+				versions = []string{latest}
+				return versions, err
+			}
+		}
+
+		switch resp.StatusCode {
+		case http.StatusOK:
+			versions = dbt.ParseVersionResponse(resp)
+		case http.StatusNotFound:
+			// not an error, there just isn't any such tool
+			versions = make([]string, 0)
+		default:
+			err = fmt.Errorf("unexpected status %d looking for versions of tool %q in repo %q", resp.StatusCode, toolName, uri)
+			return versions, err
+		}
 	}
 
 	return versions, err
 }
 
+// FetchVersionIndex fetches the VERSION_INDEX_FILE for a tool, or for dbt itself if toolName is "".  found is false if there isn't one, or it can't be read as a VersionIndex, in which case versions have to be found by listing.  S3 repos can always be listed, so they're not checked.
+func (dbt *DBT) FetchVersionIndex(toolName string) (index VersionIndex, found bool, err error) {
+	var uri string
+
+	if toolName == "" {
+		uri = fmt.Sprintf("%s/%s", dbt.Config.Dbt.Repo, VERSION_INDEX_FILE)
+	} else {
+		uri = fmt.Sprintf("%s/%s/%s", dbt.Config.Tools.Repo, toolName, VERSION_INDEX_FILE)
+	}
+
+	if isS3, _ := dbt.s3Url(uri); isS3 {
+		return index, found, err
+	}
+
+	client := dbt.HttpClient()
+
+	req, err := http.NewRequestWithContext(dbt.RequestContext(), "GET", uri, nil)
+	if err != nil {
+		err = errors.Wrapf(err, "failed to create request for url: %s", uri)
+		return index, found, err
+	}
+
+	err = dbt.AuthHeaders(req)
+	if err != nil {
+		err = errors.Wrapf(err, "failed adding auth headers")
+		return index, found, err
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		err = errors.Wrapf(ErrRepoUnreachable, "failed to fetch %s: %s", uri, err)
+		return index, found, err
+	}
+
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		dbt.VerboseOutput("No version index at %s", uri)
+		return index, found, err
+	}
+
+	// some servers answer anything at all with a page of html, so anything that isn't a proper index is just ignored
+	err = json.NewDecoder(io.LimitReader(resp.Body, 1024*1024)).Decode(&index)
+	if err != nil {
+		dbt.VerboseOutput("Ignoring unreadable version index at %s: %s", uri, err)
+		return VersionIndex{}, found, nil
+	}
+
+	semverMatch := regexp.MustCompile(`^\d+\.\d+\.\d+$`)
+
+	versions := make([]string, 0)
+	for _, version := range index.Versions {
+		if semverMatch.MatchString(version) {
+			versions = append(versions, version)
+		}
+	}
+
+	// the reposerver doesn't serve indices of things without versions, so an empty one came from somewhere else
+	if len(versions) == 0 {
+		dbt.VerboseOutput("Ignoring version index at %s with no versions in it", uri)
+		return VersionIndex{}, found, err
+	}
+
+	index.Versions = versions
+
+	dbt.VerboseOutput("Versions per %s: %s", uri, strings.Join(index.Versions, ", "))
+
+	found = true
+
+	return index, found, err
+}
+
+// FetchLatestFile fetches the version named in the LATEST_FILE for a tool, or for dbt itself if toolName is "".  found is false if there's no such file, or it doesn't hold a version, in which case versions have to be found by listing.  S3 repos can always be listed, so they're not checked.
+func (dbt *DBT) FetchLatestFile(toolName string) (version string, found bool, err error) {
+	var uri string
+
+	if toolName == "" {
+		uri = fmt.Sprintf("%s/%s", dbt.Config.Dbt.Repo, LATEST_FILE)
+	} else {
+		uri = fmt.Sprintf("%s/%s/%s", dbt.Config.Tools.Repo, toolName, LATEST_FILE)
+	}
+
+	if isS3, _ := dbt.s3Url(uri); isS3 {
+		return version, found, err
+	}
+
+	client := dbt.HttpClient()
+
+	req, err := http.NewRequestWithContext(dbt.RequestContext(), "GET", uri, nil)
+	if err != nil {
+		err = errors.Wrapf(err, "failed to create request for url: %s", uri)
+		return version, found, err
+	}
+
+	err = dbt.AuthHeaders(req)
+	if err != nil {
+		err = errors.Wrapf(err, "failed adding auth headers")
+		return version, found, err
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		err = errors.Wrapf(ErrRepoUnreachable, "failed to fetch %s: %s", uri, err)
+		return version, found, err
+	}
+
+	defer resp.Body.Close()
+
+	// Static hosts that can't list often say 403 rather than 404 for things that aren't there.
+	if resp.StatusCode != http.StatusOK {
+		dbt.VerboseOutput("No latest file at %s", uri)
+		return version, found, err
+	}
+
+	body, err := ioutil.ReadAll(io.LimitReader(resp.Body, 1024))
+	if err != nil {
+		err = errors.Wrapf(err, "failed to read %s", uri)
+		return version, found, err
+	}
+
+	version = strings.TrimSpace(string(body))
+
+	// like version indices, anything that isn't a version, say a catch-all page or a proxy's login page, is just ignored
+	semverMatch := regexp.MustCompile(`^\d+\.\d+\.\d+$`)
+	if !semverMatch.MatchString(version) {
+		dbt.VerboseOutput("Ignoring %s, which holds no semantic version", uri)
+		return "", found, nil
+	}
+
+	dbt.VerboseOutput("Latest version per %s: %s", uri, version)
+
+	found = true
+
+	return version, found, err
+}
+
 // ParseVersionResponse does an http get of an url and returns a list of semantic version links found at that place
 func (dbt *DBT) ParseVersionResponse(resp *http.Response) (versions []string) {
 	parser := html.NewTokenizer(resp.Body)
 
+	// there could be other files, we only want things that look like semantic versions
+	semverMatch := regexp.MustCompile(`^\d+\.\d+\.\d+$`)
+
 	for {
 		tt := parser.Next()
 
@@ -215,17 +415,10 @@ func (dbt *DBT) ParseVersionResponse(resp *http.Response) (versions []string) {
 			if isAnchor {
 				for _, a := range t.Attr {
 					if a.Key == "href" {
-						if a.Val != "../" {
-							// trim the trailing slash so we get actual semantic versions
-							version := strings.TrimRight(a.Val, "/")
-
-							// there could be other files, we only want things that look like semantic versions
-							semverMatch := regexp.MustCompile(`^\d+\.\d+\.\d+$`)
-
-							if semverMatch.MatchString(version) {
-								versions = append(versions, version)
+						version := HrefName(a.Val)
 
-							}
+						if semverMatch.MatchString(version) {
+							versions = append(versions, version)
 						}
 					}
 				}
@@ -234,33 +427,162 @@ func (dbt *DBT) ParseVersionResponse(resp *http.Response) (versions []string) {
 	}
 }
 
-// FetchFile Fetches a file and places it on the filesystem.
-// Does not validate the signature.  That's a different step.
-func (dbt *DBT) FetchFile(fileUrl string, destPath string) (err error) {
-	out, err := os.Create(destPath)
+// HrefName returns the name of the file or directory a link in a directory listing points to.  Servers variously link to 'x/', './x/', '/path/to/x/', or 'https://host/path/to/x/', so all that's wanted is the last element of the path.
+func HrefName(href string) (name string) {
+	u, err := url.Parse(href)
 	if err != nil {
-		return err
+		return name
 	}
 
-	err = os.Chmod(destPath, 0755)
+	name = path.Base(strings.TrimRight(u.Path, "/"))
+
+	// path.Base says '.' for empty paths, '/' for the root, and we don't want '..' either
+	if name == "." || name == "/" || name == ".." {
+		name = ""
+	}
+
+	return name
+}
+
+// ListRepoFiles lists every file under a directory in the repo, recursively, as paths relative to the directory.
+func (dbt *DBT) ListRepoFiles(dirUrl string) (files []string, err error) {
+	dirUrl = strings.TrimSuffix(dirUrl, "/")
+
+	isS3, s3Meta := dbt.s3Url(dirUrl)
+
+	if isS3 {
+		return dbt.S3ListRepoFiles(s3Meta)
+	}
+
+	files = make([]string, 0)
+
+	dirFiles, dirs, err := dbt.listHttpDir(dirUrl)
 	if err != nil {
-		return err
+		return files, err
 	}
 
-	defer out.Close()
+	files = append(files, dirFiles...)
+
+	for _, dir := range dirs {
+		subFiles, err := dbt.ListRepoFiles(fmt.Sprintf("%s/%s", dirUrl, dir))
+		if err != nil {
+			return files, err
+		}
+
+		for _, f := range subFiles {
+			files = append(files, fmt.Sprintf("%s/%s", dir, f))
+		}
+	}
+
+	return files, err
+}
+
+// listHttpDir reads the directory listing at dirUrl, and returns the names of the files and directories in it.  Links that lead anywhere other than straight into the directory, like the parent directory, or sorting links, are ignored.
+func (dbt *DBT) listHttpDir(dirUrl string) (files []string, dirs []string, err error) {
+	uri := fmt.Sprintf("%s/", dirUrl)
+
+	dbt.VerboseOutput("Listing %s", uri)
+
+	base, err := url.Parse(uri)
+	if err != nil {
+		err = errors.Wrapf(err, "failed to parse %s", uri)
+		return files, dirs, err
+	}
+
+	client := dbt.HttpClient()
+
+	req, err := http.NewRequestWithContext(dbt.RequestContext(), "GET", uri, nil)
+	if err != nil {
+		err = errors.Wrapf(err, "failed to create request for url: %s", uri)
+		return files, dirs, err
+	}
+
+	err = dbt.AuthHeaders(req)
+	if err != nil {
+		err = errors.Wrapf(err, "failed adding auth headers")
+		return files, dirs, err
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		err = errors.Wrapf(ErrRepoUnreachable, "failed to list %s: %s", uri, err)
+		return files, dirs, err
+	}
+
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		err = fmt.Errorf("unexpected status %d listing %s", resp.StatusCode, uri)
+		return files, dirs, err
+	}
+
+	parser := html.NewTokenizer(resp.Body)
+
+	for {
+		tt := parser.Next()
+
+		switch {
+		case tt == html.ErrorToken:
+			return files, dirs, err
+		case tt == html.StartTagToken:
+			t := parser.Token()
+			if t.Data != "a" {
+				continue
+			}
+
+			for _, a := range t.Attr {
+				if a.Key != "href" {
+					continue
+				}
+
+				name := HrefName(a.Val)
+				if name == "" {
+					continue
+				}
+
+				href, err := url.Parse(a.Val)
+				if err != nil {
+					continue
+				}
+
+				target := base.ResolveReference(href)
+				if target.Path != base.Path+name && target.Path != base.Path+name+"/" {
+					continue
+				}
+
+				if strings.HasSuffix(target.Path, "/") {
+					dirs = append(dirs, name)
+				} else {
+					files = append(files, name)
+				}
+			}
+		}
+	}
+}
+
+// FetchFile Fetches a file and places it on the filesystem.
+// Does not validate the signature.  That's a different step.
+// If destPath was fetched before, and the server says it hasn't changed since, it's left as it is.
+func (dbt *DBT) FetchFile(fileUrl string, destPath string) (err error) {
+	return dbt.fetchFile(fileUrl, destPath, !NOPROGRESS)
+}
+
+// fetchFile does the work of FetchFile.  A progress bar is shown if progress is true, and the file is fetched over HTTP.
+func (dbt *DBT) fetchFile(fileUrl string, destPath string, progress bool) (err error) {
+	etag := storedETag(destPath)
+
+	dbt.VerboseOutput("Fetching %s to %s", fileUrl, destPath)
 
 	// Check to see if this is an S3 URL
-	isS3, s3Meta := S3Url(fileUrl)
+	isS3, s3Meta := dbt.s3Url(fileUrl)
 
 	if isS3 {
-		return dbt.S3FetchFile(fileUrl, s3Meta, out)
+		return dbt.s3FetchFileIfChanged(fileUrl, s3Meta, destPath, etag)
 	}
 
-	client := &http.Client{
-		Timeout: 300 * time.Second,
-	}
+	client := dbt.HttpClient()
 
-	req, err := http.NewRequest("HEAD", fileUrl, nil)
+	req, err := http.NewRequestWithContext(dbt.RequestContext(), "HEAD", fileUrl, nil)
 	if err != nil {
 		err = errors.Wrapf(err, "failed to create request for url: %s", fileUrl)
 		return err
@@ -272,23 +594,44 @@ func (dbt *DBT) FetchFile(fileUrl string, destPath string) (err error) {
 		return err
 	}
 
+	if etag != "" {
+		req.Header.Set("If-None-Match", etag)
+	}
+
 	var bar *pb.ProgressBar
 
-	if !NOPROGRESS {
+	if progress {
 		headResp, err := client.Do(req)
-		defer headResp.Body.Close()
-
 		if err != nil {
 			err = errors.Wrapf(err, "error making request to %s", fileUrl)
 			return err
 		}
 
-		if headResp.StatusCode > 399 {
-			err = errors.New(fmt.Sprintf("unable to request headers for %s: %d %s", fileUrl, headResp.StatusCode, headResp.Status))
+		if headResp == nil {
+			err = errors.New(fmt.Sprintf("no response to HEAD request for %s", fileUrl))
+			return err
+		}
+
+		if headResp.Body != nil {
+			defer headResp.Body.Close()
+		}
+
+		if headResp.StatusCode == http.StatusNotModified {
+			dbt.VerboseOutput("%s is unchanged since it was last fetched", fileUrl)
 			return err
 		}
 
 		sizeHeader := headResp.Header.Get("Content-Length")
+
+		// Servers that don't allow HEAD get a plain GET, with a progress bar that doesn't know the size up front.
+		if headResp.StatusCode == http.StatusMethodNotAllowed {
+			dbt.VerboseOutput("%s does not allow HEAD requests.  Download size is unknown.", fileUrl)
+			sizeHeader = ""
+		} else if headResp.StatusCode > 399 {
+			err = errors.New(fmt.Sprintf("unable to request headers for %s: %d %s", fileUrl, headResp.StatusCode, headResp.Status))
+			return err
+		}
+
 		if sizeHeader == "" {
 			sizeHeader = "0"
 		}
@@ -306,7 +649,7 @@ func (dbt *DBT) FetchFile(fileUrl string, destPath string) (err error) {
 
 	}
 
-	req, err = http.NewRequest("GET", fileUrl, nil)
+	req, err = http.NewRequestWithContext(dbt.RequestContext(), "GET", fileUrl, nil)
 	if err != nil {
 		err = errors.Wrapf(err, "failed to create request for url: %s", fileUrl)
 		return err
@@ -318,9 +661,24 @@ func (dbt *DBT) FetchFile(fileUrl string, destPath string) (err error) {
 		return err
 	}
 
+	if etag != "" {
+		req.Header.Set("If-None-Match", etag)
+	}
+
 	resp, err := client.Do(req)
 	if err != nil {
-		err = errors.Wrap(err, fmt.Sprintf("Error fetching file from %q", fileUrl))
+		err = errors.Wrapf(ErrRepoUnreachable, "Error fetching file from %q: %s", fileUrl, err)
+		return err
+	}
+
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotModified {
+		if bar != nil {
+			bar.Finish()
+		}
+
+		dbt.VerboseOutput("%s is unchanged since it was last fetched", fileUrl)
 		return err
 	}
 
@@ -329,25 +687,240 @@ func (dbt *DBT) FetchFile(fileUrl string, destPath string) (err error) {
 		return err
 	}
 
-	if resp != nil {
-		defer resp.Body.Close()
+	mode, err := ParseToolFileMode(dbt.Config.ToolFileMode)
+	if err != nil {
+		return err
+	}
 
-		if bar != nil {
-			// create proxy reader
-			reader := bar.NewProxyReader(resp.Body)
+	// only now that there's something to replace it with is the old file truncated
+	out, err := createFetchDest(destPath, mode)
+	if err != nil {
+		return err
+	}
+
+	defer out.Close()
+
+	var body io.Reader = resp.Body
+
+	// with a progress bar, the body's read through it, so the bar counts the bytes as they're written
+	if bar != nil {
+		body = bar.NewProxyReader(resp.Body)
+	}
+
+	_, err = io.Copy(out, body)
+
+	if bar != nil {
+		bar.Finish()
+	}
+
+	if err != nil {
+		err = errors.Wrapf(err, "failed to download %s", fileUrl)
+		return err
+	}
+
+	return storeETag(destPath, resp.Header.Get("ETag"))
+}
+
+// DEFAULT_TOOL_FILE_MODE the mode downloaded files get, unless the config's toolfilemode says otherwise
+const DEFAULT_TOOL_FILE_MODE os.FileMode = 0755
+
+// ParseToolFileMode parses an octal file mode such as '0750' for downloaded files.  Empty means DEFAULT_TOOL_FILE_MODE.  The owner has to keep read, write, and execute, or cached tools couldn't be run or updated.
+func ParseToolFileMode(mode string) (fileMode os.FileMode, err error) {
+	if mode == "" {
+		return DEFAULT_TOOL_FILE_MODE, err
+	}
+
+	parsed, err := strconv.ParseUint(mode, 8, 32)
+	if err != nil || parsed > 0777 {
+		err = fmt.Errorf("%q is not an octal file mode such as 0750", mode)
+		return fileMode, err
+	}
+
+	fileMode = os.FileMode(parsed)
+
+	if fileMode&0700 != 0700 {
+		err = fmt.Errorf("%q has to leave the owner read, write, and execute, such as 0700 or 0750", mode)
+		return fileMode, err
+	}
+
+	return fileMode, err
+}
+
+// createFetchDest creates, or truncates, the file a fetch writes to.  A new file is created with mode, less the umask, so it's never more open than asked for.  The mode's then set exactly, on the open file and before anything's written, so neither the umask nor an existing file's old mode is left to decide it.
+func createFetchDest(destPath string, mode os.FileMode) (out *os.File, err error) {
+	out, err = os.OpenFile(destPath, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, mode)
+	if err != nil {
+		return out, err
+	}
+
+	err = out.Chmod(mode)
+	if err != nil {
+		_ = out.Close()
+		return out, err
+	}
+
+	return out, err
+}
+
+// s3FetchFileIfChanged fetches a file from S3, unless the object's ETag matches the one destPath was last fetched with.
+func (dbt *DBT) s3FetchFileIfChanged(fileUrl string, meta S3Meta, destPath string, etag string) (err error) {
+	// the one HEAD gives both the ETag to compare, and the size for the download
+	fileMeta, err := dbt.S3Client.HeadObject(dbt.RequestContext(), &s3.HeadObjectInput{
+		Bucket: aws.String(meta.Bucket),
+		Key:    aws.String(meta.Key),
+	}, dbt.s3Options(meta)...)
+	if err != nil {
+		err = errors.Wrapf(err, "failed to get metadata for %s", fileUrl)
+		return err
+	}
+
+	current := aws.ToString(fileMeta.ETag)
+
+	if etag != "" && current == etag {
+		dbt.VerboseOutput("%s is unchanged since it was last fetched", fileUrl)
+		return err
+	}
+
+	mode, err := ParseToolFileMode(dbt.Config.ToolFileMode)
+	if err != nil {
+		return err
+	}
+
+	out, err := createFetchDest(destPath, mode)
+	if err != nil {
+		return err
+	}
+
+	defer out.Close()
+
+	err = dbt.s3Download(fileUrl, meta, out, fileMeta.ContentLength)
+	if err != nil {
+		return err
+	}
+
+	return storeETag(destPath, current)
+}
+
+// FileExists checks whether a file is in the repo without downloading it.  Only a 404, or NotFound from S3, means it isn't there.  Any other error status is returned as an error, so callers don't mistake a server that's failing for one without the file.
+func (dbt *DBT) FileExists(fileUrl string) (found bool, err error) {
+	isS3, s3Meta := dbt.s3Url(fileUrl)
+
+	if isS3 {
+		_, err = dbt.S3Client.HeadObject(dbt.RequestContext(), &s3.HeadObjectInput{
+			Bucket: aws.String(s3Meta.Bucket),
+			Key:    aws.String(s3Meta.Key),
+		}, dbt.s3Options(s3Meta)...)
+		if err != nil {
+			if IsS3NotFound(err) {
+				return false, nil
+			}
+
+			err = errors.Wrapf(err, "failed checking for %s", fileUrl)
+			return found, err
+		}
+
+		return true, err
+	}
+
+	req, err := http.NewRequestWithContext(dbt.RequestContext(), "HEAD", fileUrl, nil)
+	if err != nil {
+		err = errors.Wrapf(err, "failed to create request for url: %s", fileUrl)
+		return found, err
+	}
+
+	err = dbt.AuthHeaders(req)
+	if err != nil {
+		err = errors.Wrapf(err, "failed adding auth headers")
+		return found, err
+	}
+
+	resp, err := dbt.HttpClient().Do(req)
+	if err != nil {
+		err = errors.Wrapf(err, "error making request to %s", fileUrl)
+		return found, err
+	}
+
+	defer resp.Body.Close()
+
+	// only a 404 says the file isn't there.  An auth failure, server error, or anything else says nothing either way.
+	switch {
+	case resp.StatusCode >= 200 && resp.StatusCode <= 299:
+		found = true
+	case resp.StatusCode == http.StatusNotFound:
+		found = false
+	default:
+		err = fmt.Errorf("unexpected status %d checking for %s", resp.StatusCode, fileUrl)
+	}
+
+	return found, err
+}
+
+// FetchToolBinary downloads a tool binary to destPath.  If the repo has a gzipped copy at <toolUrl>.gz, that's fetched instead, and decompressed into destPath.  Checksums and signatures are always those of the uncompressed binary, so verification is the same either way.
+func (dbt *DBT) FetchToolBinary(toolUrl string, destPath string) (err error) {
+	compressedUrl := fmt.Sprintf("%s%s", toolUrl, COMPRESSED_SUFFIX)
+
+	compressed, err := dbt.FileExists(compressedUrl)
+	if err != nil {
+		return err
+	}
+
+	if !compressed {
+		return dbt.FetchFile(toolUrl, destPath)
+	}
+
+	compressedPath := fmt.Sprintf("%s%s", destPath, COMPRESSED_SUFFIX)
+	defer os.Remove(compressedPath)
+
+	// the compressed copy is gone after this, so its ETag is no use next time
+	defer os.Remove(fmt.Sprintf("%s%s", compressedPath, ETAG_SUFFIX))
+
+	err = dbt.FetchFile(compressedUrl, compressedPath)
+	if err != nil {
+		return err
+	}
+
+	mode, err := ParseToolFileMode(dbt.Config.ToolFileMode)
+	if err != nil {
+		return err
+	}
+
+	err = gunzipFile(compressedPath, destPath, mode)
+	if err != nil {
+		err = errors.Wrapf(err, "failed to decompress %s", compressedUrl)
+		return err
+	}
+
+	return err
+}
+
+// gunzipFile decompresses src into dest, which ends up with the given mode.
+func gunzipFile(src string, dest string, mode os.FileMode) (err error) {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+
+	defer in.Close()
+
+	gz, err := gzip.NewReader(in)
+	if err != nil {
+		return err
+	}
 
-			// and copy from pb reader
-			_, _ = io.Copy(out, reader)
-		}
+	defer gz.Close()
 
-		_, err = io.Copy(out, resp.Body)
+	out, err := createFetchDest(dest, mode)
+	if err != nil {
+		return err
+	}
 
-		if err != nil {
-			return err
-		}
+	_, err = io.Copy(out, gz)
+	closeErr := out.Close()
+	if err != nil {
+		return err
 	}
 
-	return err
+	return closeErr
 }
 
 // VerifyFileChecksum Verifies the sha256 checksum of a given file against an expected value
@@ -372,67 +945,90 @@ func (dbt *DBT) VerifyFileChecksum(filePath string, expected string) (success bo
 
 // VerifyFileVersion verifies the version by matching it's Sha256 checksum against what the repo says it should be
 func (dbt *DBT) VerifyFileVersion(fileUrl string, filePath string) (success bool, err error) {
+	expected, err := dbt.FetchRemoteChecksum(fileUrl)
+	if err != nil {
+		return success, err
+	}
+
+	actual, err := FileSha256(filePath)
+	if err != nil {
+		success = false
+		return success, err
+	}
+
+	dbt.VerboseOutput("Verifying checksum of %q against content of %q", filePath, fmt.Sprintf("%s.sha256", fileUrl))
+	dbt.VerboseOutput("  Expected: %s", expected)
+	dbt.VerboseOutput("  Actual:   %s", actual)
+
+	success = actual == expected
+
+	return success, err
+}
+
+// FetchRemoteChecksum fetches the sha256 checksum the repo publishes alongside a file.
+func (dbt *DBT) FetchRemoteChecksum(fileUrl string) (checksum string, err error) {
 	uri := fmt.Sprintf("%s.sha256", fileUrl)
 
 	// Check to see if this is an S3 URL
-	isS3, s3Meta := S3Url(uri)
+	isS3, s3Meta := dbt.s3Url(uri)
 
 	if isS3 {
-		return dbt.S3VerifyFileVersion(filePath, s3Meta)
+		return dbt.S3FetchChecksum(s3Meta)
 	}
 
-	client := &http.Client{}
+	client := dbt.HttpClient()
 
-	req, err := http.NewRequest("GET", uri, nil)
+	req, err := http.NewRequestWithContext(dbt.RequestContext(), "GET", uri, nil)
 	if err != nil {
 		err = errors.Wrapf(err, "failed to create request for url: %s", uri)
-		return success, err
+		return checksum, err
 	}
 
 	err = dbt.AuthHeaders(req)
 	if err != nil {
 		err = errors.Wrapf(err, "failed adding auth headers")
-		return success, err
+		return checksum, err
 	}
 
 	resp, err := client.Do(req)
 	if err != nil {
-		err = errors.Wrapf(err, "Error fetching checksum from %q", uri)
-		return success, err
+		err = errors.Wrapf(ErrRepoUnreachable, "Error fetching checksum from %q: %s", uri, err)
+		return checksum, err
 	}
 
-	if resp != nil {
-		defer resp.Body.Close()
+	defer resp.Body.Close()
 
-		checksumBytes, err := ioutil.ReadAll(resp.Body)
+	if resp.StatusCode != http.StatusOK {
+		err = fmt.Errorf("unable to fetch checksum from %s: %d %s", uri, resp.StatusCode, http.StatusText(resp.StatusCode))
+		return checksum, err
+	}
 
-		if err != nil {
-			success = false
-			return success, err
-		}
+	checksumBytes, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		err = errors.Wrapf(err, "failed reading checksum from %q", uri)
+		return checksum, err
+	}
 
-		expected := string(checksumBytes)
-		actual, err := FileSha256(filePath)
+	checksum = string(checksumBytes)
 
-		if err != nil {
-			success = false
-			return success, err
-		}
+	return checksum, err
+}
 
-		if actual == expected {
-			success = true
+// VerifyFileSignature verifies the signature on the given file against the dbt truststore.  Tools, and files published with them, are verified with VerifyToolFileSignature instead.
+func (dbt *DBT) VerifyFileSignature(homedir string, filePath string) (success bool, err error) {
+	if homedir == "" {
+		homedir, err = GetHomeDir()
+		if err != nil {
+			err = errors.Wrapf(err, "failed to get homedir")
 			return success, err
 		}
-
-		success = false
-		return success, err
 	}
 
-	return success, err
+	return dbt.verifyFileSignature(TruststorePath(homedir), filePath)
 }
 
-// VerifyFileSignature verifies the signature on the given file
-func (dbt *DBT) VerifyFileSignature(homedir string, filePath string) (success bool, err error) {
+// VerifyToolFileSignature verifies the signature on a tool, or a file published with one, against the tools truststore.  Without a tools truststore, that's the dbt truststore, same as VerifyFileSignature.
+func (dbt *DBT) VerifyToolFileSignature(homedir string, filePath string) (success bool, err error) {
 	if homedir == "" {
 		homedir, err = GetHomeDir()
 		if err != nil {
@@ -441,9 +1037,12 @@ func (dbt *DBT) VerifyFileSignature(homedir string, filePath string) (success bo
 		}
 	}
 
-	sigFile := fmt.Sprintf("%s.asc", filePath)
+	return dbt.verifyFileSignature(toolsTruststorePath(homedir), filePath)
+}
 
-	truststoreFileName := fmt.Sprintf("%s/%s", homedir, TruststorePath)
+// verifyFileSignature verifies the signature on the given file against the keys in the given truststore file.
+func (dbt *DBT) verifyFileSignature(truststoreFileName string, filePath string) (success bool, err error) {
+	sigFile := fmt.Sprintf("%s.asc", filePath)
 
 	truststore, err := os.Open(truststoreFileName)
 	if err != nil {
@@ -502,17 +1101,30 @@ func (dbt *DBT) VerifyFileSignature(homedir string, filePath string) (success bo
 
 		entity, _ := openpgp.CheckArmoredDetachedSignature(entities, target, signature)
 		if entity != nil {
+			for name := range entity.Identities {
+				dbt.VerboseOutput("  Signed by %s (key %X)", name, entity.PrimaryKey.KeyId)
+			}
 			dbt.VerboseOutput("  Pass!")
 			return true, nil
 		}
 	}
 
-	err = fmt.Errorf("signing entity not in truststore")
+	err = errors.Wrap(ErrSignatureMismatch, "signing entity not in truststore")
 	return false, err
 }
 
 // FindLatestVersion finds the latest version of the tool available in the tool repo.  If the tool name is "", it is expecting to parse versions of dbt itself.
 func (dbt *DBT) FindLatestVersion(toolName string) (latest string, err error) {
+	latest, found, err := dbt.FetchLatestFile(toolName)
+	if err != nil {
+		err = errors.Wrapf(err, "error checking latest file for tool %s", toolName)
+		return latest, err
+	}
+
+	if found {
+		return latest, err
+	}
+
 	toolInRepo, err := dbt.ToolExists(toolName)
 	if err != nil {
 		err = errors.Wrap(err, fmt.Sprintf("error checking repo for tool %s", toolName))
@@ -530,28 +1142,65 @@ func (dbt *DBT) FindLatestVersion(toolName string) (latest string, err error) {
 		return latest, err
 	}
 
-	err = errors.New("tool not in repo")
+	err = errors.Wrapf(ErrToolNotFound, "tool %s not in repo", toolName)
 
 	return latest, err
 }
 
-// DefaultSession creates a default AWS session from local config path.  Hooks directly into credentials if present, or Credentials Provider if configured.
-func DefaultSession(s3meta *S3Meta) (awssession *session.Session, err error) {
-	if os.Getenv(AWS_ID_ENV_VAR) == "" && os.Getenv(AWS_SECRET_ENV_VAR) == "" {
-		_ = os.Setenv("AWS_SDK_LOAD_CONFIG", "true")
+// FindLatestVersionSatisfying returns the highest version of a tool in the repo within a semver range, such as '^1.2' for the newest 1.x at or after 1.2.0, or '~1.2.3' for the newest 1.2.x at or after 1.2.3.  An invalid range is an error, as is a range no version is in.
+func (dbt *DBT) FindLatestVersionSatisfying(toolName string, constraint string) (latest string, err error) {
+	// check the range first, so a typo doesn't cost a trip to the repo
+	_, err = LatestVersionSatisfying(nil, constraint)
+	if err != nil {
+		return latest, err
+	}
+
+	toolInRepo, err := dbt.ToolExists(toolName)
+	if err != nil {
+		err = errors.Wrapf(err, "error checking repo for tool %s", toolName)
+		return latest, err
+	}
+
+	if !toolInRepo {
+		err = errors.Wrapf(ErrToolNotFound, "tool %s not in repo", toolName)
+		return latest, err
+	}
+
+	versions, err := dbt.FetchToolVersions(toolName)
+	if err != nil {
+		err = errors.Wrapf(err, "error getting versions for tool %s", toolName)
+		return latest, err
 	}
 
-	awssession, err = session.NewSession()
+	latest, err = LatestVersionSatisfying(versions, constraint)
 	if err != nil {
-		log.Fatalf("Failed to create aws session")
+		return latest, err
+	}
+
+	if latest == "" {
+		err = errors.Wrapf(ErrVersionNotFound, "no version of %s in the repo satisfies %s", toolName, constraint)
+		return latest, err
 	}
 
+	return latest, err
+}
+
+// DefaultAwsConfig loads the default AWS config, from the environment and ~/.aws.  Hooks directly into credentials if present, or Credentials Provider if configured.
+func DefaultAwsConfig(s3meta *S3Meta) (awsConfig aws.Config, err error) {
+	options := make([]func(*config.LoadOptions) error, 0)
+
 	// Set the s3 region based on the s3metadata derived from the dbt config.
-	if s3meta != nil {
-		awssession.Config.Region = &s3meta.Region
+	if s3meta != nil && s3meta.Region != "" {
+		options = append(options, config.WithRegion(s3meta.Region))
+	}
+
+	awsConfig, err = config.LoadDefaultConfig(context.Background(), options...)
+	if err != nil {
+		err = errors.Wrapf(err, "failed to load aws config")
+		return awsConfig, err
 	}
 
-	return awssession, err
+	return awsConfig, err
 }
 
 // DirsForURL given a URL, return a list of path elements suitable for creating directories/ folders
@@ -628,6 +1277,149 @@ func S3Url(url string) (ok bool, meta S3Meta) {
 	return ok, meta
 }
 
+// S3UrlForEndpoint is S3Url for S3 compatible stores such as MinIO or Ceph.  If endpoint is set, urls on the endpoint's host are taken to be path style, e.g. https://minio.example.com/<bucket>/<key>, and urls on a subdomain of it virtual host style, e.g. https://<bucket>.minio.example.com/<key>.  Anything else is handed to S3Url.
+func S3UrlForEndpoint(uri string, endpoint string) (ok bool, meta S3Meta) {
+	if endpoint == "" {
+		return S3Url(uri)
+	}
+
+	e, err := url.Parse(endpoint)
+	if err != nil || e.Host == "" {
+		return S3Url(uri)
+	}
+
+	u, err := url.Parse(uri)
+	if err != nil {
+		return ok, meta
+	}
+
+	keyPath := strings.TrimPrefix(u.Path, "/")
+
+	switch {
+	case u.Host == e.Host:
+		parts := strings.SplitN(keyPath, "/", 2)
+		if parts[0] == "" {
+			return ok, meta
+		}
+
+		meta = S3Meta{Bucket: parts[0], Url: uri}
+		if len(parts) == 2 {
+			meta.Key = parts[1]
+		}
+
+	case strings.HasSuffix(u.Host, "."+e.Host):
+		meta = S3Meta{Bucket: strings.TrimSuffix(u.Host, "."+e.Host), Key: keyPath, Url: uri}
+
+	default:
+		return S3Url(uri)
+	}
+
+	ok = true
+	return ok, meta
+}
+
+// s3Url is S3Url, taking the configured S3 endpoint, if any, into account.
+func (dbt *DBT) s3Url(uri string) (ok bool, meta S3Meta) {
+	return S3UrlForEndpoint(uri, dbt.Config.Dbt.S3Endpoint)
+}
+
+// S3BucketRegion returns the region of the bucket in s3meta, working it out the first time it's asked for.  S3 is asked with GetBucketLocation, and then HeadBucket's x-amz-bucket-region header.  If neither says, it's the region in the bucket's url, and then the region from the AWS config.  With an S3 endpoint configured, the bucket isn't in AWS, so there's nothing to work out, and the region is left to the client.
+func (dbt *DBT) S3BucketRegion(s3meta S3Meta) (region string) {
+	if dbt.Config.Dbt.S3Endpoint != "" || dbt.S3Client == nil || s3meta.Bucket == "" {
+		return region
+	}
+
+	// The lock only covers the cache, not the lookups, so one slow bucket doesn't hold up the others.  Two lookups of the same bucket at once just both ask.
+	dbt.s3RegionsLock.Lock()
+	region, ok := dbt.s3Regions[s3meta.Bucket]
+	dbt.s3RegionsLock.Unlock()
+
+	if ok {
+		return region
+	}
+
+	region, err := S3BucketRegion(dbt.RequestContext(), dbt.S3Client, s3meta.Bucket)
+	if err != nil {
+		dbt.VerboseOutput("Couldn't ask S3 for the region of bucket %s: %s", s3meta.Bucket, err)
+		region = s3meta.Region
+	}
+
+	if region == "" {
+		awsConfig, err := DefaultAwsConfig(nil)
+		if err == nil {
+			region = awsConfig.Region
+		}
+	}
+
+	if s3meta.Region != "" && region != s3meta.Region {
+		dbt.VerboseOutput("The url %s says bucket %s is in %s, but it's in %s.  Using %s.", s3meta.Url, s3meta.Bucket, s3meta.Region, region, region)
+	}
+
+	dbt.s3RegionsLock.Lock()
+	defer dbt.s3RegionsLock.Unlock()
+
+	if dbt.s3Regions == nil {
+		dbt.s3Regions = make(map[string]string)
+	}
+
+	dbt.s3Regions[s3meta.Bucket] = region
+
+	return region
+}
+
+// s3Options returns the options for requests to the bucket in s3meta, so they go to the bucket's region whatever region the client was made for.
+func (dbt *DBT) s3Options(s3meta S3Meta) (optFns []func(*s3.Options)) {
+	optFns = make([]func(*s3.Options), 0)
+
+	region := dbt.S3BucketRegion(s3meta)
+	if region != "" {
+		optFns = append(optFns, func(o *s3.Options) {
+			o.Region = region
+		})
+	}
+
+	return optFns
+}
+
+// s3Downloader returns a downloader for the bucket in s3meta.
+func (dbt *DBT) s3Downloader(s3meta S3Meta) (downloader *manager.Downloader) {
+	optFns := dbt.s3Options(s3meta)
+
+	return manager.NewDownloader(dbt.S3Client, func(d *manager.Downloader) {
+		d.ClientOptions = append(d.ClientOptions, optFns...)
+	})
+}
+
+// NewS3Client creates an S3 client for the bucket in s3meta that sends its requests through httpClient.  If the config names an S3 endpoint, requests go there instead of to AWS.
+func NewS3Client(dbtConfig Config, s3meta S3Meta, httpClient *http.Client) (client *s3.Client, err error) {
+	awsConfig, err := DefaultAwsConfig(&s3meta)
+	if err != nil {
+		err = errors.Wrapf(err, "failed to create s3 client")
+		return client, err
+	}
+
+	if httpClient != nil {
+		awsConfig.HTTPClient = httpClient
+	}
+
+	endpoint := dbtConfig.Dbt.S3Endpoint
+
+	// S3 compatible stores mostly don't care about the region, but the SDK refuses to sign requests without one
+	if endpoint != "" && awsConfig.Region == "" {
+		awsConfig.Region = DEFAULT_S3_ENDPOINT_REGION
+	}
+
+	client = s3.NewFromConfig(awsConfig, func(o *s3.Options) {
+		if endpoint != "" {
+			o.EndpointResolver = s3.EndpointResolverFromURL(endpoint)
+		}
+
+		o.UsePathStyle = dbtConfig.Dbt.S3ForcePathStyle
+	})
+
+	return client, err
+}
+
 // S3FetchFile fetches a file out of S3 instead of using a normal HTTP GET
 func (dbt *DBT) S3FetchFile(fileUrl string, meta S3Meta, outFile *os.File) (err error) {
 	headOptions := &s3.HeadObjectInput{
@@ -635,69 +1427,81 @@ func (dbt *DBT) S3FetchFile(fileUrl string, meta S3Meta, outFile *os.File) (err
 		Key:    aws.String(meta.Key),
 	}
 
-	headSvc := s3.New(dbt.S3Session)
-
-	fileMeta, err := headSvc.HeadObject(headOptions)
+	fileMeta, err := dbt.S3Client.HeadObject(dbt.RequestContext(), headOptions, dbt.s3Options(meta)...)
 	if err != nil {
 		err = errors.Wrapf(err, "failed to get metadata for %s", fileUrl)
 		return err
 	}
 
-	downloader := s3manager.NewDownloader(dbt.S3Session)
+	return dbt.s3Download(fileUrl, meta, outFile, fileMeta.ContentLength)
+}
+
+// s3Download downloads an object of the given size, as found by a HEAD already made, into outFile.
+func (dbt *DBT) s3Download(fileUrl string, meta S3Meta, outFile *os.File, size int64) (err error) {
+	downloader := dbt.s3Downloader(meta)
 	downloadOptions := &s3.GetObjectInput{
 		Bucket: aws.String(meta.Bucket),
 		Key:    aws.String(meta.Key),
 	}
 
+	var dest io.WriterAt = outFile
+
 	if !NOPROGRESS {
 		// create and start progress bar
-		bar := pb.New(int(*fileMeta.ContentLength)).SetUnits(pb.U_BYTES)
+		bar := pb.New(int(size)).SetUnits(pb.U_BYTES)
 		bar.Output = os.Stderr
 		bar.Start()
 
-		buf := &aws.WriteAtBuffer{}
-
-		_, err = downloader.Download(buf, downloadOptions)
-		if err != nil {
-			err = errors.Wrapf(err, "unable to download file from %s", fileUrl)
-			return err
-		}
-
-		// create proxy reader
-		reader := bar.NewProxyReader(bytes.NewBuffer(buf.Bytes()))
+		defer bar.Finish()
 
-		// and copy from pb reader
-		_, _ = io.Copy(outFile, reader)
-
-		return err
+		// the download goes straight to the file, once, with the bar counting along
+		dest = &progressWriterAt{w: outFile, bar: bar}
 	}
 
-	_, err = downloader.Download(outFile, downloadOptions)
+	_, err = downloader.Download(dbt.RequestContext(), dest, downloadOptions)
 	if err != nil {
-		err = errors.Wrapf(err, "download failed")
+		err = errors.Wrapf(err, "unable to download file from %s", fileUrl)
 		return err
 	}
 
 	return err
 }
 
+// progressWriterAt passes writes through to w, adding them to a progress bar as they go.  The downloader writes parts concurrently, which the bar's counter is safe for.
+type progressWriterAt struct {
+	w   io.WriterAt
+	bar *pb.ProgressBar
+}
+
+// WriteAt implements io.WriterAt
+func (p *progressWriterAt) WriteAt(b []byte, off int64) (n int, err error) {
+	n, err = p.w.WriteAt(b, off)
+	p.bar.Add(n)
+
+	return n, err
+}
+
 // S3ToolExists detects whether a tool exists in S3 by looking at the top level folder for the tool
 func (dbt *DBT) S3ToolExists(meta S3Meta) (found bool, err error) {
-	svc := s3.New(dbt.S3Session)
-	options := &s3.ListObjectsInput{
+	options := &s3.ListObjectsV2Input{
 		Bucket:    aws.String(meta.Bucket),
 		Prefix:    aws.String(meta.Key),
 		Delimiter: aws.String("/"),
 	}
 
-	resp, err := svc.ListObjects(options)
-	if err != nil {
-		err = errors.Wrapf(err, "failed to list objects at %s", meta.Key)
-		return found, err
-	}
+	paginator := s3.NewListObjectsV2Paginator(dbt.S3Client, options)
 
-	if len(resp.Contents) > 0 {
-		found = true
+	// no need to look any further once we've found something
+	for paginator.HasMorePages() && !found {
+		page, err := paginator.NextPage(dbt.RequestContext(), dbt.s3Options(meta)...)
+		if err != nil {
+			err = errors.Wrapf(err, "failed to list objects at %s", meta.Key)
+			return found, err
+		}
+
+		if len(page.Contents) > 0 || len(page.CommonPrefixes) > 0 {
+			found = true
+		}
 	}
 
 	return found, err
@@ -705,8 +1509,12 @@ func (dbt *DBT) S3ToolExists(meta S3Meta) (found bool, err error) {
 
 // S3FetchTruststore fetches the truststore out of S3 writing it into the dbt dir on the local disk
 func (dbt *DBT) S3FetchTruststore(homedir string, meta S3Meta) (err error) {
-	downloader := s3manager.NewDownloader(dbt.S3Session)
-	filePath := fmt.Sprintf("%s/%s", homedir, TruststorePath)
+	return dbt.s3FetchTruststoreTo(meta, TruststorePath(homedir))
+}
+
+// s3FetchTruststoreTo fetches a truststore out of S3, writing it to filePath.
+func (dbt *DBT) s3FetchTruststoreTo(meta S3Meta, filePath string) (err error) {
+	downloader := dbt.s3Downloader(meta)
 	dbt.VerboseOutput("Writing truststore to %s", filePath)
 
 	file, err := os.Create(filePath)
@@ -714,7 +1522,10 @@ func (dbt *DBT) S3FetchTruststore(homedir string, meta S3Meta) (err error) {
 		err = errors.Wrapf(err, "Failed opening truststore file %s", filePath)
 		return err
 	}
-	_, err = downloader.Download(file, &s3.GetObjectInput{
+
+	defer file.Close()
+
+	_, err = downloader.Download(dbt.RequestContext(), file, &s3.GetObjectInput{
 		Bucket: aws.String(meta.Bucket),
 		Key:    aws.String(meta.Key),
 	})
@@ -735,11 +1546,15 @@ func (dbt *DBT) S3ToolVersionExists(meta S3Meta) (ok bool, err error) {
 
 	log.Printf("Looking for %q in %s", meta.Key, meta.Bucket)
 
-	headSvc := s3.New(dbt.S3Session)
-
 	// not found is an error, as opposed to a successful request that has a 404 code
-	_, fetchErr := headSvc.HeadObject(headOptions)
-	if fetchErr != nil {
+	_, err = dbt.S3Client.HeadObject(dbt.RequestContext(), headOptions, dbt.s3Options(meta)...)
+	if err != nil {
+		if IsS3NotFound(err) {
+			err = nil
+			return ok, err
+		}
+
+		err = errors.Wrapf(err, "failed checking for %s in %s", meta.Key, meta.Bucket)
 		return ok, err
 	}
 
@@ -748,23 +1563,68 @@ func (dbt *DBT) S3ToolVersionExists(meta S3Meta) (ok bool, err error) {
 	return ok, err
 }
 
-// S3VerifyFileVersion verifies the version of a file on the filesystem matches the sha256 hash stored in the s3 bucket for that file
-func (dbt *DBT) S3VerifyFileVersion(filePath string, meta S3Meta) (success bool, err error) {
-	// get checksum file from s3
-	buff := &aws.WriteAtBuffer{}
-	downloader := s3manager.NewDownloader(dbt.S3Session)
-	_, err = downloader.Download(buff, &s3.GetObjectInput{
+// S3ListRepoFiles lists every object under a 'directory' in S3, as paths relative to it.
+func (dbt *DBT) S3ListRepoFiles(meta S3Meta) (files []string, err error) {
+	files = make([]string, 0)
+
+	prefix := fmt.Sprintf("%s/", strings.TrimSuffix(meta.Key, "/"))
+
+	options := &s3.ListObjectsV2Input{
 		Bucket: aws.String(meta.Bucket),
-		Key:    aws.String(meta.Key),
-	})
+		Prefix: aws.String(prefix),
+	}
 
+	paginator := s3.NewListObjectsV2Paginator(dbt.S3Client, options)
+
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(dbt.RequestContext(), dbt.s3Options(meta)...)
+		if err != nil {
+			err = errors.Wrapf(err, "failed to list objects at %s", prefix)
+			return files, err
+		}
+
+		for _, o := range page.Contents {
+			name := strings.TrimPrefix(aws.ToString(o.Key), prefix)
+
+			// skip the zero byte 'folder' objects some tools create
+			if name != "" && !strings.HasSuffix(name, "/") {
+				files = append(files, name)
+			}
+		}
+	}
+
+	return files, err
+}
+
+// IsS3NotFound returns true if the error from an S3 call means the object simply isn't there, as opposed to a permissions, throttling, or network problem.
+func IsS3NotFound(err error) bool {
+	var noSuchKey *types.NoSuchKey
+	if errors.As(err, &noSuchKey) {
+		return true
+	}
+
+	// HEAD requests have no body to carry an error code, so there's only the status to go on
+	var notFound *types.NotFound
+	if errors.As(err, &notFound) {
+		return true
+	}
+
+	var respErr *awshttp.ResponseError
+	if errors.As(err, &respErr) {
+		return respErr.HTTPStatusCode() == http.StatusNotFound
+	}
+
+	return false
+}
+
+// S3VerifyFileVersion verifies the version of a file on the filesystem matches the sha256 hash stored in the s3 bucket for that file
+func (dbt *DBT) S3VerifyFileVersion(filePath string, meta S3Meta) (success bool, err error) {
+	expected, err := dbt.S3FetchChecksum(meta)
 	if err != nil {
-		err = errors.Wrapf(err, "failed to download checksum for %s", meta.Url)
 		return success, err
 	}
 
 	// compare it to what's on the disk
-	expected := string(buff.Bytes())
 	actual, err := FileSha256(filePath)
 
 	dbt.VerboseOutput("Verifying checksum of %q against content of %q", filePath, meta.Url)
@@ -784,35 +1644,98 @@ func (dbt *DBT) S3VerifyFileVersion(filePath string, meta S3Meta) (success bool,
 	return success, err
 }
 
-// S3FetchToolVersions fetches available versions for a tool from S3
+// S3FetchChecksum fetches a checksum file from S3.
+func (dbt *DBT) S3FetchChecksum(meta S3Meta) (checksum string, err error) {
+	buff := manager.NewWriteAtBuffer([]byte{})
+	downloader := dbt.s3Downloader(meta)
+	_, err = downloader.Download(dbt.RequestContext(), buff, &s3.GetObjectInput{
+		Bucket: aws.String(meta.Bucket),
+		Key:    aws.String(meta.Key),
+	})
+
+	if err != nil {
+		err = errors.Wrapf(err, "failed to download checksum for %s", meta.Url)
+		return checksum, err
+	}
+
+	checksum = string(buff.Bytes())
+
+	return checksum, err
+}
+
+// S3FetchToolVersions fetches available versions for a tool from S3.  Versions are the 'directories' directly under the tool, so they're read from the CommonPrefixes of a delimited listing.  If that turns up nothing, every object under the tool is scanned instead.
 func (dbt *DBT) S3FetchToolVersions(meta S3Meta) (versions []string, err error) {
 	versions = make([]string, 0)
-	uniqueVersions := make(map[string]int)
-	svc := s3.New(dbt.S3Session)
 
-	options := &s3.ListObjectsInput{
-		Bucket: aws.String(meta.Bucket),
-		Prefix: aws.String(meta.Key),
+	options := &s3.ListObjectsV2Input{
+		Bucket:    aws.String(meta.Bucket),
+		Prefix:    aws.String(meta.Key),
+		Delimiter: aws.String("/"),
 	}
 
-	resp, err := svc.ListObjects(options)
-	if err != nil {
-		err = errors.Wrapf(err, "failed to list objects at %s", meta.Key)
+	semver := regexp.MustCompile(`^\d+\.\d+\.\d+$`)
+
+	paginator := s3.NewListObjectsV2Paginator(dbt.S3Client, options)
+
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(dbt.RequestContext(), dbt.s3Options(meta)...)
+		if err != nil {
+			err = errors.Wrapf(err, "failed to list versions at %s", meta.Key)
+			return versions, err
+		}
+
+		for _, p := range page.CommonPrefixes {
+			version := path.Base(strings.TrimPrefix(aws.ToString(p.Prefix), meta.Key))
+			if semver.MatchString(version) {
+				versions = append(versions, version)
+			}
+		}
+	}
+
+	if len(versions) > 0 {
 		return versions, err
 	}
 
+	dbt.VerboseOutput("No version prefixes found under %s.  Scanning all objects.", meta.Url)
+
+	return dbt.S3ScanToolVersions(meta)
+}
+
+// S3ScanToolVersions fetches available versions for a tool from S3 by listing every object under the tool and picking the versions out of the keys.
+func (dbt *DBT) S3ScanToolVersions(meta S3Meta) (versions []string, err error) {
+	versions = make([]string, 0)
+	uniqueVersions := make(map[string]int)
+
+	options := &s3.ListObjectsV2Input{
+		Bucket: aws.String(meta.Bucket),
+		Prefix: aws.String(meta.Key),
+	}
+
 	dir := regexp.MustCompile(`\d+\.\d+\.\d+/`)
 	semver := regexp.MustCompile(`\d+\.\d+\.\d+`)
 
-	for _, k := range resp.Contents {
-		if dir.MatchString(*k.Key) {
-			parts := strings.Split(*k.Key, "/")
-			if len(parts) > 0 {
-				if semver.MatchString(parts[0]) {
-					uniqueVersions[parts[0]] = 1
-				} else if len(parts) > 1 {
-					if semver.MatchString(parts[1]) {
-						uniqueVersions[parts[1]] = 1
+	// S3 returns at most 1000 keys per request, so walk every page
+	paginator := s3.NewListObjectsV2Paginator(dbt.S3Client, options)
+
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(dbt.RequestContext(), dbt.s3Options(meta)...)
+		if err != nil {
+			err = errors.Wrapf(err, "failed to list objects at %s", meta.Key)
+			return versions, err
+		}
+
+		for _, k := range page.Contents {
+			key := aws.ToString(k.Key)
+
+			if dir.MatchString(key) {
+				parts := strings.Split(key, "/")
+				if len(parts) > 0 {
+					if semver.MatchString(parts[0]) {
+						uniqueVersions[parts[0]] = 1
+					} else if len(parts) > 1 {
+						if semver.MatchString(parts[1]) {
+							uniqueVersions[parts[1]] = 1
+						}
 					}
 				}
 			}
@@ -15,11 +15,35 @@
 package dbt
 
 import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
 	"fmt"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awshttp "github.com/aws/aws-sdk-go-v2/aws/transport/http"
+	"github.com/aws/aws-sdk-go-v2/credentials"
+	"github.com/aws/aws-sdk-go-v2/feature/s3/manager"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/aws/smithy-go"
+	smithyhttp "github.com/aws/smithy-go/transport/http"
+	"github.com/johannesboyne/gofakes3"
+	"github.com/johannesboyne/gofakes3/backend/s3mem"
+	"github.com/pkg/errors"
 	"github.com/stretchr/testify/assert"
+	"io/ioutil"
 	"log"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
 	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 )
 
 func TestToolExists(t *testing.T) {
@@ -40,9 +64,9 @@ func TestToolExists(t *testing.T) {
 		{
 			"s3",
 			&DBT{
-				Config:    s3DbtConfig,
-				Verbose:   true,
-				S3Session: s3Session,
+				Config:   s3DbtConfig,
+				Verbose:  true,
+				S3Client: s3Client,
 			},
 			homeDirS3,
 		},
@@ -90,9 +114,9 @@ func TestToolVersionExists(t *testing.T) {
 		{
 			"s3",
 			&DBT{
-				Config:    s3DbtConfig,
-				Verbose:   true,
-				S3Session: s3Session,
+				Config:   s3DbtConfig,
+				Verbose:  true,
+				S3Client: s3Client,
 			},
 			homeDirS3,
 		},
@@ -142,9 +166,9 @@ func TestFetchToolVersions(t *testing.T) {
 		{
 			"s3",
 			&DBT{
-				Config:    s3DbtConfig,
-				Verbose:   true,
-				S3Session: s3Session,
+				Config:   s3DbtConfig,
+				Verbose:  true,
+				S3Client: s3Client,
 			},
 			homeDirS3,
 		},
@@ -184,9 +208,9 @@ func TestFetchFile(t *testing.T) {
 		{
 			"s3",
 			&DBT{
-				Config:    s3DbtConfig,
-				Verbose:   true,
-				S3Session: s3Session,
+				Config:   s3DbtConfig,
+				Verbose:  true,
+				S3Client: s3Client,
 			},
 			homeDirS3,
 		},
@@ -242,7 +266,7 @@ func TestFetchFile(t *testing.T) {
 
 			// download trust store
 			trustStoreUrl := fmt.Sprintf("%s/truststore", testDbtUrl(port))
-			trustStoreFile := fmt.Sprintf("%s/%s", tc.homedir, TruststorePath)
+			trustStoreFile := TruststorePath(tc.homedir)
 
 			t.Logf("Fetching truststore from %s", trustStoreUrl)
 			err = tc.obj.FetchFile(trustStoreUrl, trustStoreFile)
@@ -314,9 +338,9 @@ func TestFindLatestVersion(t *testing.T) {
 		{
 			"s3",
 			&DBT{
-				Config:    s3DbtConfig,
-				Verbose:   true,
-				S3Session: s3Session,
+				Config:   s3DbtConfig,
+				Verbose:  true,
+				S3Client: s3Client,
 			},
 			homeDirS3,
 		},
@@ -337,11 +361,18 @@ func TestFindLatestVersion(t *testing.T) {
 	}
 }
 
-func TestDefaultSession(t *testing.T) {
-	_, err := DefaultSession(nil)
+func TestDefaultAwsConfig(t *testing.T) {
+	_, err := DefaultAwsConfig(nil)
 	if err != nil {
-		t.Errorf("Failed to get an AWS Session")
+		t.Errorf("Failed to get an AWS config")
 	}
+
+	awsConfig, err := DefaultAwsConfig(&S3Meta{Region: "us-west-2"})
+	if err != nil {
+		t.Fatalf("Failed to get an AWS config: %s", err)
+	}
+
+	assert.Equal(t, "us-west-2", awsConfig.Region, "Region comes from the repo url.")
 }
 
 func TestS3Url(t *testing.T) {
@@ -381,6 +412,450 @@ func TestS3Url(t *testing.T) {
 	}
 }
 
+func TestS3UrlForEndpoint(t *testing.T) {
+	inputs := []struct {
+		name     string
+		url      string
+		endpoint string
+		result   bool
+		bucket   string
+		key      string
+	}{
+		{
+			"no endpoint",
+			"https://minio.example.com/dbt-tools/foo/1.2.3/linux/amd64/foo",
+			"",
+			false,
+			"",
+			"",
+		},
+		{
+			"path style",
+			"https://minio.example.com/dbt-tools/foo/1.2.3/linux/amd64/foo",
+			"https://minio.example.com",
+			true,
+			"dbt-tools",
+			"foo/1.2.3/linux/amd64/foo",
+		},
+		{
+			"path style bucket only",
+			"https://minio.example.com/dbt-tools",
+			"https://minio.example.com",
+			true,
+			"dbt-tools",
+			"",
+		},
+		{
+			"virtual host style",
+			"https://dbt-tools.minio.example.com/foo/1.2.3/linux/amd64/foo",
+			"https://minio.example.com",
+			true,
+			"dbt-tools",
+			"foo/1.2.3/linux/amd64/foo",
+		},
+		{
+			"no bucket",
+			"https://minio.example.com/",
+			"https://minio.example.com",
+			false,
+			"",
+			"",
+		},
+		{
+			"other host",
+			"https://www.nikogura.com/dbt-tools/foo",
+			"https://minio.example.com",
+			false,
+			"",
+			"",
+		},
+		{
+			"aws alongside endpoint",
+			"https://dbt-tools.s3.us-east-1.amazonaws.com/foo",
+			"https://minio.example.com",
+			true,
+			"dbt-tools",
+			"foo",
+		},
+	}
+
+	for _, tc := range inputs {
+		t.Run(tc.name, func(t *testing.T) {
+			ok, meta := S3UrlForEndpoint(tc.url, tc.endpoint)
+
+			assert.Equal(t, tc.result, ok, "S3 or not meets expectations.")
+			assert.Equal(t, tc.bucket, meta.Bucket, "Bucket meets expectations.")
+			assert.Equal(t, tc.key, meta.Key, "Key meets expectations.")
+		})
+	}
+}
+
+func TestS3Endpoint(t *testing.T) {
+	bucket := "dbt-tools"
+	content := "#!/bin/sh\necho foo\n"
+
+	server, fakeClient := newFakeS3(t, bucket)
+	defer server.Close()
+
+	_, err := fakeClient.PutObject(context.Background(), &s3.PutObjectInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String("foo/1.2.3/linux/amd64/foo"),
+		Body:   bytes.NewReader([]byte(content)),
+	})
+	if err != nil {
+		t.Fatalf("Failed putting tool: %s", err)
+	}
+
+	for k, v := range map[string]string{AWS_ID_ENV_VAR: "foo", AWS_SECRET_ENV_VAR: "bar"} {
+		orig, set := os.LookupEnv(k)
+		_ = os.Setenv(k, v)
+
+		defer func(k string, orig string, set bool) {
+			if set {
+				_ = os.Setenv(k, orig)
+				return
+			}
+
+			_ = os.Unsetenv(k)
+		}(k, orig, set)
+	}
+
+	config := Config{
+		Dbt: DbtConfig{
+			Repo:             fmt.Sprintf("%s/%s", server.URL, bucket),
+			TrustStore:       fmt.Sprintf("%s/%s/truststore", server.URL, bucket),
+			S3Endpoint:       server.URL,
+			S3ForcePathStyle: true,
+		},
+		Tools: ToolsConfig{
+			Repo: fmt.Sprintf("%s/%s", server.URL, bucket),
+		},
+	}
+
+	assert.Empty(t, ValidateConfig(config), "Config with an S3 endpoint is valid.")
+
+	obj, err := NewDbtFromConfig(config)
+	if err != nil {
+		t.Fatalf("Failed creating dbt: %s", err)
+	}
+
+	if obj.S3Client == nil {
+		t.Fatalf("Repo on the S3 endpoint didn't get an S3 client.")
+	}
+
+	toolUrl := fmt.Sprintf("%s/foo/1.2.3/linux/amd64/foo", config.Tools.Repo)
+
+	exists, err := obj.FileExists(toolUrl)
+	if err != nil {
+		t.Fatalf("Failed checking for file: %s", err)
+	}
+
+	assert.True(t, exists, "File on the S3 endpoint exists.")
+
+	tmpDir, err := ioutil.TempDir("", "dbt-s3-endpoint")
+	if err != nil {
+		t.Fatalf("Failed creating temp dir: %s", err)
+	}
+
+	defer os.RemoveAll(tmpDir)
+
+	destPath := fmt.Sprintf("%s/foo", tmpDir)
+
+	err = obj.FetchFile(toolUrl, destPath)
+	if err != nil {
+		t.Fatalf("Failed fetching file: %s", err)
+	}
+
+	actual, _ := ioutil.ReadFile(destPath)
+	assert.Equal(t, content, string(actual), "File is fetched from the S3 endpoint.")
+}
+
+// newFakeS3 starts a fake S3 server of its own holding the given bucket, so as not to disturb the shared test repo.  Close the returned server when done.
+func newFakeS3(t *testing.T, bucket string) (server *httptest.Server, fakeClient *s3.Client) {
+	server = httptest.NewServer(gofakes3.New(s3mem.New()).Server())
+
+	fakeClient = newFakeS3Client(server.URL)
+
+	_, err := fakeClient.CreateBucket(context.Background(), &s3.CreateBucketInput{Bucket: aws.String(bucket)})
+	if err != nil {
+		t.Fatalf("Failed to create bucket %s: %s", bucket, err)
+	}
+
+	return server, fakeClient
+}
+
+func TestS3ListingPastPageBoundary(t *testing.T) {
+	bucket := "dbt-tools"
+	tool := "manyversions"
+
+	server, fakeClient := newFakeS3(t, bucket)
+	defer server.Close()
+
+	s3Client := fakeClient
+
+	// 300 versions with 4 files apiece is comfortably more than the 1000 keys S3 returns per page
+	expected := make([]string, 0)
+	for i := 1; i <= 300; i++ {
+		version := fmt.Sprintf("1.%d.0", i)
+		expected = append(expected, version)
+
+		for _, file := range []string{"linux/amd64/manyversions", "linux/amd64/manyversions.sha256", "linux/amd64/manyversions.asc", "description.txt"} {
+			key := fmt.Sprintf("%s/%s/%s", tool, version, file)
+			_, err := s3Client.PutObject(context.Background(), &s3.PutObjectInput{
+				Bucket: aws.String(bucket),
+				Key:    aws.String(key),
+				Body:   strings.NewReader(key),
+			})
+			if err != nil {
+				t.Fatalf("Failed to put %s into fake s3: %s", key, err)
+			}
+		}
+	}
+
+	obj := &DBT{
+		Config:   Config{},
+		Verbose:  true,
+		S3Client: fakeClient,
+	}
+
+	inputs := []struct {
+		name string
+		url  string
+	}{
+		{
+			"version prefixes",
+			fmt.Sprintf("https://%s.s3.us-east-1.amazonaws.com/%s/", bucket, tool),
+		},
+		{
+			// without the trailing slash the only common prefix is the tool itself, so versions come from the full scan
+			"full scan",
+			fmt.Sprintf("https://%s.s3.us-east-1.amazonaws.com/%s", bucket, tool),
+		},
+	}
+
+	for _, tc := range inputs {
+		t.Run(tc.name, func(t *testing.T) {
+			ok, meta := S3Url(tc.url)
+			if !ok {
+				t.Fatalf("Test url isn't an S3 url")
+			}
+
+			found, err := obj.S3ToolExists(meta)
+			if err != nil {
+				t.Errorf("Error checking for tool: %s", err)
+			}
+
+			assert.True(t, found, "Tool found in S3.")
+
+			versions, err := obj.S3FetchToolVersions(meta)
+			if err != nil {
+				t.Errorf("Error fetching tool versions: %s", err)
+			}
+
+			assert.ElementsMatch(t, expected, versions, "Every version is found, including those past the first page.")
+			assert.Equal(t, "1.300.0", LatestVersion(versions), "Latest version is found.")
+		})
+	}
+}
+
+func TestS3Operations(t *testing.T) {
+	bucket := "dbt-tools"
+	content := "#!/bin/sh\necho foo\n"
+
+	server, fakeClient := newFakeS3(t, bucket)
+	defer server.Close()
+
+	checksum := fmt.Sprintf("%x", sha256.Sum256([]byte(content)))
+
+	objects := map[string]string{
+		"foo/1.2.3/linux/amd64/foo":        content,
+		"foo/1.2.3/linux/amd64/foo.sha256": checksum,
+		"foo/1.2.3/description.txt":        "foo does things",
+		"truststore":                       "not really a truststore",
+	}
+
+	for key, body := range objects {
+		_, err := fakeClient.PutObject(context.Background(), &s3.PutObjectInput{
+			Bucket: aws.String(bucket),
+			Key:    aws.String(key),
+			Body:   bytes.NewReader([]byte(body)),
+		})
+		if err != nil {
+			t.Fatalf("Failed putting %s: %s", key, err)
+		}
+	}
+
+	homedir, err := ioutil.TempDir("", "dbt-s3-operations")
+	if err != nil {
+		t.Fatalf("Failed creating homedir: %s", err)
+	}
+
+	defer os.RemoveAll(homedir)
+
+	err = makeStagingDbtDir(homedir, false)
+	if err != nil {
+		t.Fatalf("Failed creating dbt dir: %s", err)
+	}
+
+	obj := &DBT{S3Client: fakeClient}
+	baseUrl := fmt.Sprintf("https://%s.s3.us-east-1.amazonaws.com", bucket)
+	toolUrl := fmt.Sprintf("%s/foo/1.2.3/linux/amd64/foo", baseUrl)
+
+	inputs := []struct {
+		name string
+		run  func(t *testing.T)
+	}{
+		{
+			"fetch file",
+			func(t *testing.T) {
+				destPath := fmt.Sprintf("%s/foo", homedir)
+
+				_, meta := S3Url(toolUrl)
+
+				out, err := os.Create(destPath)
+				if err != nil {
+					t.Fatalf("Failed creating %s: %s", destPath, err)
+				}
+
+				err = obj.S3FetchFile(toolUrl, meta, out)
+				_ = out.Close()
+				if err != nil {
+					t.Fatalf("Failed fetching file: %s", err)
+				}
+
+				actual, _ := ioutil.ReadFile(destPath)
+				assert.Equal(t, content, string(actual), "Fetched file matches what's in S3.")
+
+				ok, err := obj.S3VerifyFileVersion(destPath, S3Meta{Bucket: bucket, Key: "foo/1.2.3/linux/amd64/foo.sha256"})
+				if err != nil {
+					t.Fatalf("Failed verifying file: %s", err)
+				}
+
+				assert.True(t, ok, "Fetched file matches the checksum in S3.")
+			},
+		},
+		{
+			"version exists",
+			func(t *testing.T) {
+				ok, err := obj.S3ToolVersionExists(S3Meta{Bucket: bucket, Key: "foo/1.2.3/linux/amd64/foo"})
+				if err != nil {
+					t.Fatalf("Failed checking for version: %s", err)
+				}
+
+				assert.True(t, ok, "Version in S3 exists.")
+
+				ok, err = obj.S3ToolVersionExists(S3Meta{Bucket: bucket, Key: "foo/9.9.9/linux/amd64/foo"})
+				if err != nil {
+					t.Fatalf("Failed checking for version: %s", err)
+				}
+
+				assert.False(t, ok, "Version not in S3 doesn't exist.")
+
+				found, err := obj.FileExists(fmt.Sprintf("%s/foo/9.9.9/linux/amd64/foo", baseUrl))
+				if err != nil {
+					t.Fatalf("Failed checking for file: %s", err)
+				}
+
+				assert.False(t, found, "File not in S3 doesn't exist.")
+			},
+		},
+		{
+			"truststore",
+			func(t *testing.T) {
+				err := obj.S3FetchTruststore(homedir, S3Meta{Bucket: bucket, Key: "truststore"})
+				if err != nil {
+					t.Fatalf("Failed fetching truststore: %s", err)
+				}
+
+				actual, _ := ioutil.ReadFile(TruststorePath(homedir))
+				assert.Equal(t, objects["truststore"], string(actual), "Truststore is fetched from S3.")
+			},
+		},
+		{
+			"catalog",
+			func(t *testing.T) {
+				tools, err := obj.S3FetchToolNames(S3Meta{Bucket: bucket})
+				if err != nil {
+					t.Fatalf("Failed fetching tool names: %s", err)
+				}
+
+				assert.Equal(t, []Tool{{Name: "foo"}}, tools, "Tools are listed from S3.")
+
+				description, err := obj.S3FetchDescription(S3Meta{Bucket: bucket, Key: "foo/1.2.3/description.txt"})
+				if err != nil {
+					t.Fatalf("Failed fetching description: %s", err)
+				}
+
+				assert.Equal(t, objects["foo/1.2.3/description.txt"], description, "Description is fetched from S3.")
+			},
+		},
+	}
+
+	for _, tc := range inputs {
+		t.Run(tc.name, tc.run)
+	}
+}
+
+// testS3ResponseError builds the error the S3 client returns for a failed response with the given status.
+func testS3ResponseError(status int, err error) error {
+	return &awshttp.ResponseError{
+		ResponseError: &smithyhttp.ResponseError{
+			Response: &smithyhttp.Response{Response: &http.Response{StatusCode: status}},
+			Err:      err,
+		},
+	}
+}
+
+func TestIsS3NotFound(t *testing.T) {
+	inputs := []struct {
+		name   string
+		err    error
+		result bool
+	}{
+		{
+			"head not found",
+			&types.NotFound{},
+			true,
+		},
+		{
+			"no such key",
+			&types.NoSuchKey{},
+			true,
+		},
+		{
+			"wrapped not found",
+			errors.Wrapf(&types.NotFound{}, "failed"),
+			true,
+		},
+		{
+			"bare 404",
+			testS3ResponseError(404, &smithy.GenericAPIError{Code: "SomethingElse"}),
+			true,
+		},
+		{
+			"access denied",
+			testS3ResponseError(403, &smithy.GenericAPIError{Code: "AccessDenied"}),
+			false,
+		},
+		{
+			"timeout",
+			&smithy.GenericAPIError{Code: "RequestTimeout", Message: "Your socket connection to the server was not read from or written to within the timeout period."},
+			false,
+		},
+		{
+			"not aws",
+			fmt.Errorf("connection refused"),
+			false,
+		},
+	}
+
+	for _, tc := range inputs {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, tc.result, IsS3NotFound(tc.err), "Not found detection meets expectations.")
+		})
+	}
+}
+
 func TestDirsForPath(t *testing.T) {
 	inputs := []struct {
 		name   string
@@ -421,3 +896,856 @@ func TestDirsForPath(t *testing.T) {
 		})
 	}
 }
+
+func TestLatestFile(t *testing.T) {
+	repoRoot, config, _ := newTestSignedRepo(t, "foo", "1.0.0", "1.1.0")
+
+	// a server that, like an S3 static website, serves files but won't list directories
+	noListing := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasSuffix(r.URL.Path, "/") {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+
+		http.FileServer(http.Dir(repoRoot)).ServeHTTP(w, r)
+	}))
+	defer noListing.Close()
+
+	listingConfig := config
+	noListingConfig := config
+	noListingConfig.Dbt.TrustStore = fmt.Sprintf("%s/truststore", noListing.URL)
+	noListingConfig.Tools.Repo = fmt.Sprintf("%s/dbt-tools", noListing.URL)
+
+	inputs := []struct {
+		name     string
+		config   Config
+		latest   string
+		expected string
+		versions []string
+		err      bool
+	}{
+		{"listing", listingConfig, "", "1.1.0", []string{"1.0.0", "1.1.0"}, false},
+		{"listing with latest file", listingConfig, "1.0.0\n", "1.0.0", []string{"1.0.0", "1.1.0"}, false},
+		{"no listing with latest file", noListingConfig, "1.0.0\n", "1.0.0", []string{"1.0.0"}, false},
+		{"no listing without latest file", noListingConfig, "", "", []string{}, true},
+		{"malformed latest file", noListingConfig, "<html>nope</html>", "", []string{}, true},
+		{"listing with malformed latest file", listingConfig, "<html>nope</html>", "1.1.0", []string{"1.0.0", "1.1.0"}, false},
+	}
+
+	for _, tc := range inputs {
+		t.Run(tc.name, func(t *testing.T) {
+			latestFile := fmt.Sprintf("%s/dbt-tools/foo/%s", repoRoot, LATEST_FILE)
+			_ = os.Remove(latestFile)
+
+			if tc.latest != "" {
+				err := os.WriteFile(latestFile, []byte(tc.latest), 0644)
+				if err != nil {
+					t.Fatalf("Failed writing latest file: %s", err)
+				}
+			}
+
+			obj := &DBT{Config: tc.config}
+
+			latest, err := obj.FindLatestVersion("foo")
+			if tc.err {
+				assert.NotNil(t, err, "Latest version can't be found.")
+				return
+			}
+
+			if err != nil {
+				t.Errorf("Error finding latest version: %s", err)
+			}
+
+			assert.Equal(t, tc.expected, latest, "Latest version meets expectations.")
+
+			versions, err := obj.FetchToolVersions("foo")
+			if err != nil {
+				t.Errorf("Error fetching versions: %s", err)
+			}
+
+			assert.ElementsMatch(t, tc.versions, versions, "Versions meet expectations.")
+		})
+	}
+
+	// and the whole point: tools can be fetched from a repo that can't list
+	err := os.WriteFile(fmt.Sprintf("%s/dbt-tools/foo/%s", repoRoot, LATEST_FILE), []byte("1.1.0"), 0644)
+	if err != nil {
+		t.Fatalf("Failed writing latest file: %s", err)
+	}
+
+	homedir, err := ioutil.TempDir("", "dbt-latest")
+	if err != nil {
+		t.Fatalf("Failed creating homedir: %s", err)
+	}
+
+	defer os.RemoveAll(homedir)
+
+	err = os.MkdirAll(fmt.Sprintf("%s/%s", homedir, DbtDir), 0755)
+	if err != nil {
+		t.Fatalf("Failed creating dbt dir: %s", err)
+	}
+
+	err = GenerateDbtDir(homedir, false)
+	if err != nil {
+		t.Fatalf("Failed generating dbt dir: %s", err)
+	}
+
+	obj := &DBT{Config: noListingConfig, Logger: log.New(ioutil.Discard, "", 0)}
+
+	err = obj.FetchTrustStore(homedir)
+	if err != nil {
+		t.Fatalf("Failed fetching truststore: %s", err)
+	}
+
+	_, err = obj.FetchTool("foo", "", homedir)
+	assert.Nil(t, err, "Tool fetched from a repo that can't list.")
+}
+
+func TestHrefName(t *testing.T) {
+	inputs := []struct {
+		href string
+		name string
+	}{
+		{"3.1.0/", "3.1.0"},
+		{"3.1.0", "3.1.0"},
+		{"./3.1.0/", "3.1.0"},
+		{"/dbt/3.1.0/", "3.1.0"},
+		{"https://repo.example.com/dbt/3.1.0/", "3.1.0"},
+		{"../", ""},
+		{"/", ""},
+		{"?C=N;O=D", ""},
+		{"description.txt", "description.txt"},
+	}
+
+	for _, tc := range inputs {
+		t.Run(tc.href, func(t *testing.T) {
+			assert.Equal(t, tc.name, HrefName(tc.href), "Name from href meets expectations.")
+		})
+	}
+}
+
+func TestParseVersionResponseFixtures(t *testing.T) {
+	inputs := []struct {
+		name    string
+		fixture string
+	}{
+		{"nginx", "testfixtures/listing.nginx.html"},
+		{"apache", "testfixtures/listing.apache.html"},
+	}
+
+	for _, tc := range inputs {
+		t.Run(tc.name, func(t *testing.T) {
+			f, err := os.Open(tc.fixture)
+			if err != nil {
+				t.Fatalf("Failed opening fixture: %s", err)
+			}
+
+			defer f.Close()
+
+			versions := (&DBT{}).ParseVersionResponse(&http.Response{Body: f})
+
+			assert.Equal(t, []string{"3.0.2", "3.1.0", "3.3.4"}, versions, "Versions parsed from listing meet expectations.")
+		})
+	}
+}
+
+func TestFetchToolVersionsStatus(t *testing.T) {
+	inputs := []struct {
+		name     string
+		status   int
+		body     string
+		versions []string
+		err      bool
+	}{
+		{"ok", http.StatusOK, `<a href="1.0.0/">1.0.0/</a><a href="1.1.0/">1.1.0/</a>`, []string{"1.0.0", "1.1.0"}, false},
+		{"not found", http.StatusNotFound, "404 page not found", []string{}, false},
+		{"server error", http.StatusInternalServerError, `<a href="6.6.6/">6.6.6/</a>`, nil, true},
+		{"forbidden", http.StatusForbidden, "denied", nil, true},
+	}
+
+	for _, tc := range inputs {
+		t.Run(tc.name, func(t *testing.T) {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(tc.status)
+				_, _ = w.Write([]byte(tc.body))
+			}))
+			defer server.Close()
+
+			obj := &DBT{Config: Config{Tools: ToolsConfig{Repo: server.URL}}}
+
+			versions, err := obj.FetchToolVersions("foo")
+			if tc.err {
+				assert.NotNil(t, err, "Server error is an error.")
+				assert.Contains(t, err.Error(), fmt.Sprintf("%d", tc.status), "Error includes the status.")
+				return
+			}
+
+			if err != nil {
+				t.Errorf("Error fetching versions: %s", err)
+			}
+
+			assert.Equal(t, tc.versions, versions, "Versions meet expectations.")
+		})
+	}
+}
+
+func TestToolExistsStatus(t *testing.T) {
+	inputs := []struct {
+		name   string
+		status int
+		found  bool
+		err    bool
+	}{
+		{"ok", http.StatusOK, true, false},
+		{"not found", http.StatusNotFound, false, false},
+		{"unauthorized", http.StatusUnauthorized, false, true},
+		{"forbidden", http.StatusForbidden, false, true},
+		{"server error", http.StatusInternalServerError, false, true},
+	}
+
+	for _, tc := range inputs {
+		t.Run(tc.name, func(t *testing.T) {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(tc.status)
+			}))
+			defer server.Close()
+
+			obj := &DBT{Config: Config{Tools: ToolsConfig{Repo: server.URL}}}
+
+			found, err := obj.ToolExists("foo")
+			versionFound, versionErr := obj.ToolVersionExists("foo", "1.0.0")
+
+			if tc.err {
+				for _, e := range []error{err, versionErr} {
+					if assert.NotNil(t, e, "Error status is an error.") {
+						assert.Contains(t, e.Error(), fmt.Sprintf("%d", tc.status), "Error includes the status.")
+						assert.False(t, errors.Is(e, ErrToolNotFound), "Error isn't mistaken for a missing tool.")
+					}
+				}
+				return
+			}
+
+			assert.NoError(t, err, "Tool check succeeds.")
+			assert.NoError(t, versionErr, "Version check succeeds.")
+			assert.Equal(t, tc.found, found, "Tool found meets expectations.")
+			assert.Equal(t, tc.found, versionFound, "Version found meets expectations.")
+		})
+	}
+}
+
+func TestFileExistsStatus(t *testing.T) {
+	inputs := []struct {
+		name   string
+		status int
+		found  bool
+		err    bool
+	}{
+		{"ok", http.StatusOK, true, false},
+		{"not found", http.StatusNotFound, false, false},
+		{"unauthorized", http.StatusUnauthorized, false, true},
+		{"forbidden", http.StatusForbidden, false, true},
+		{"server error", http.StatusInternalServerError, false, true},
+		{"bad gateway", http.StatusBadGateway, false, true},
+	}
+
+	for _, tc := range inputs {
+		t.Run(tc.name, func(t *testing.T) {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(tc.status)
+			}))
+			defer server.Close()
+
+			obj := &DBT{}
+
+			found, err := obj.FileExists(fmt.Sprintf("%s/foo/policy.json", server.URL))
+			if tc.err {
+				if assert.NotNil(t, err, "Error status is an error.") {
+					assert.Contains(t, err.Error(), fmt.Sprintf("%d", tc.status), "Error includes the status.")
+				}
+				return
+			}
+
+			if err != nil {
+				t.Errorf("Error checking for file: %s", err)
+			}
+
+			assert.Equal(t, tc.found, found, "Found meets expectations.")
+		})
+	}
+}
+
+func TestFetchToolCompressed(t *testing.T) {
+	inputs := []struct {
+		name     string
+		compress bool
+		keepRaw  bool
+	}{
+		{"uncompressed", false, true},
+		{"compressed only", true, false},
+		{"compressed alongside", true, true},
+	}
+
+	for _, tc := range inputs {
+		t.Run(tc.name, func(t *testing.T) {
+			repoRoot, config, _ := newTestSignedRepo(t, "foo", "1.0.0")
+			config = withTestETags(t, repoRoot, config)
+			repoPath := fmt.Sprintf("%s/dbt-tools/foo/1.0.0/%s/%s/%s", repoRoot, runtime.GOOS, runtime.GOARCH, ToolFileName("foo", runtime.GOOS))
+
+			expected, err := ioutil.ReadFile(repoPath)
+			if err != nil {
+				t.Fatalf("Failed reading tool: %s", err)
+			}
+
+			if tc.compress {
+				var buf bytes.Buffer
+				gz := gzip.NewWriter(&buf)
+				_, _ = gz.Write(expected)
+				_ = gz.Close()
+
+				err = ioutil.WriteFile(repoPath+COMPRESSED_SUFFIX, buf.Bytes(), 0644)
+				if err != nil {
+					t.Fatalf("Failed writing compressed tool: %s", err)
+				}
+			}
+
+			if !tc.keepRaw {
+				err = os.Remove(repoPath)
+				if err != nil {
+					t.Fatalf("Failed removing tool: %s", err)
+				}
+			} else if tc.compress {
+				// the compressed copy is preferred, so spoiling the raw one shows which was fetched
+				err = ioutil.WriteFile(repoPath, []byte("not fetched"), 0755)
+				if err != nil {
+					t.Fatalf("Failed spoiling tool: %s", err)
+				}
+			}
+
+			homedir, err := ioutil.TempDir("", "dbt-compressed")
+			if err != nil {
+				t.Fatalf("Failed creating homedir: %s", err)
+			}
+
+			defer os.RemoveAll(homedir)
+
+			err = makeStagingDbtDir(homedir, false)
+			if err != nil {
+				t.Fatalf("Failed creating dbt dir: %s", err)
+			}
+
+			config.ToolFileMode = "0750"
+
+			obj := &DBT{Config: config, Logger: log.New(ioutil.Discard, "", 0)}
+
+			err = obj.FetchTrustStore(homedir)
+			if err != nil {
+				t.Fatalf("Failed fetching truststore: %s", err)
+			}
+
+			localPath, err := obj.FetchTool("foo", "", homedir)
+			if err != nil {
+				t.Fatalf("Failed fetching tool: %s", err)
+			}
+
+			actual, err := ioutil.ReadFile(localPath)
+			if err != nil {
+				t.Fatalf("Failed reading fetched tool: %s", err)
+			}
+
+			assert.Equal(t, string(expected), string(actual), "Fetched tool is the uncompressed binary.")
+
+			info, err := os.Stat(localPath)
+			if err != nil {
+				t.Fatalf("Failed to stat fetched tool: %s", err)
+			}
+
+			assert.Equal(t, os.FileMode(0750), info.Mode().Perm(), "Fetched tool has the configured mode.")
+
+			_, err = os.Stat(localPath + COMPRESSED_SUFFIX)
+			assert.True(t, os.IsNotExist(err), "Compressed download is cleaned up.")
+
+			_, err = os.Stat(localPath + COMPRESSED_SUFFIX + ETAG_SUFFIX)
+			assert.True(t, os.IsNotExist(err), "Compressed download's ETag is cleaned up.")
+		})
+	}
+}
+
+func TestFindLatestVersionSatisfying(t *testing.T) {
+	_, config, _ := newTestSignedRepo(t, "foo", "1.0.0", "1.2.0", "1.2.7", "1.3.5", "2.0.0")
+
+	homedir, err := ioutil.TempDir("", "dbt-range")
+	if err != nil {
+		t.Fatalf("Failed creating homedir: %s", err)
+	}
+
+	defer os.RemoveAll(homedir)
+
+	err = makeStagingDbtDir(homedir, false)
+	if err != nil {
+		t.Fatalf("Failed creating dbt dir: %s", err)
+	}
+
+	obj := &DBT{
+		Config: config,
+		Logger: log.New(ioutil.Discard, "", 0),
+	}
+
+	err = obj.FetchTrustStore(homedir)
+	if err != nil {
+		t.Fatalf("Failed fetching truststore: %s", err)
+	}
+
+	// only some versions are downloaded, so offline picks from fewer
+	for _, version := range []string{"1.0.0", "1.2.0"} {
+		_, err = obj.FetchTool("foo", version, homedir)
+		if err != nil {
+			t.Fatalf("Failed fetching foo %s: %s", version, err)
+		}
+	}
+
+	inputs := []struct {
+		name       string
+		tool       string
+		constraint string
+		latest     string
+		cached     string
+		expected   error
+		err        bool
+	}{
+		{"caret", "foo", "^1.2", "1.3.5", "1.2.0", nil, false},
+		{"tilde", "foo", "~1.2.3", "1.2.7", "", ErrVersionNotFound, false},
+		{"wildcard", "foo", "1.x", "1.3.5", "1.2.0", nil, false},
+		{"bounded", "foo", ">=1.0.0 <1.2.5", "1.2.0", "1.2.0", nil, false},
+		{"major", "foo", "^2", "2.0.0", "", ErrVersionNotFound, false},
+		{"nothing in range", "foo", "^3", "", "", ErrVersionNotFound, true},
+		{"invalid range", "foo", "^bogus", "", "", nil, true},
+		{"missing tool", "bar", "^1.2", "", "", ErrToolNotFound, true},
+	}
+
+	for _, tc := range inputs {
+		t.Run(tc.name, func(t *testing.T) {
+			latest, err := obj.FindLatestVersionSatisfying(tc.tool, tc.constraint)
+			if tc.err {
+				if assert.Error(t, err, "Range can't be satisfied.") && tc.expected != nil {
+					assert.True(t, errors.Is(err, tc.expected), "Error %q is %q.", err, tc.expected)
+				}
+
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("Failed finding version: %s", err)
+			}
+
+			assert.Equal(t, tc.latest, latest, "Latest version in range meets expectations.")
+
+			cached, err := LatestCachedVersionSatisfying(homedir, tc.tool, tc.constraint)
+			if tc.cached == "" {
+				if assert.Error(t, err, "No downloaded version is in range.") {
+					assert.True(t, errors.Is(err, tc.expected), "Error %q is %q.", err, tc.expected)
+				}
+
+				return
+			}
+
+			assert.NoError(t, err, "Downloaded version is in range.")
+			assert.Equal(t, tc.cached, cached, "Latest downloaded version in range meets expectations.")
+		})
+	}
+
+	localPath, err := obj.FetchTool("foo", "~1.3", homedir)
+	if err != nil {
+		t.Fatalf("Failed fetching foo ~1.3: %s", err)
+	}
+
+	assert.Equal(t, CachedToolPath(homedir, "foo", "1.3.5"), localPath, "Fetching a range fetches the latest version in it.")
+}
+
+func TestParseToolFileMode(t *testing.T) {
+	inputs := []struct {
+		mode     string
+		expected os.FileMode
+		err      bool
+	}{
+		{"", DEFAULT_TOOL_FILE_MODE, false},
+		{"0755", 0755, false},
+		{"0750", 0750, false},
+		{"700", 0700, false},
+		{"0644", 0, true},
+		{"0500", 0, true},
+		{"04755", 0, true},
+		{"0758", 0, true},
+		{"rwxr-x---", 0, true},
+	}
+
+	for _, tc := range inputs {
+		t.Run(tc.mode, func(t *testing.T) {
+			mode, err := ParseToolFileMode(tc.mode)
+			if tc.err {
+				assert.Error(t, err, "Mode is refused.")
+				return
+			}
+
+			assert.NoError(t, err, "Mode parses.")
+			assert.Equal(t, tc.expected, mode, "Mode meets expectations.")
+		})
+	}
+}
+
+func TestS3FetchFileContent(t *testing.T) {
+	bucket := "dbt-tools"
+
+	server, fakeClient := newFakeS3(t, bucket)
+	defer server.Close()
+
+	// bigger than the downloader's part size, so parts arrive separately and out of order
+	large := make([]byte, manager.DefaultDownloadPartSize*2+12345)
+	for i := range large {
+		large[i] = byte(i % 251)
+	}
+
+	inputs := []struct {
+		name     string
+		content  []byte
+		progress bool
+	}{
+		{"small", []byte("#!/bin/sh\necho foo\n"), false},
+		{"small with progress", []byte("#!/bin/sh\necho foo\n"), true},
+		{"multipart", large, false},
+		{"multipart with progress", large, true},
+	}
+
+	defer func(orig bool) { NOPROGRESS = orig }(NOPROGRESS)
+
+	for _, tc := range inputs {
+		t.Run(tc.name, func(t *testing.T) {
+			key := fmt.Sprintf("foo/%d/foo", len(tc.content))
+
+			_, err := fakeClient.PutObject(context.Background(), &s3.PutObjectInput{
+				Bucket: aws.String(bucket),
+				Key:    aws.String(key),
+				Body:   bytes.NewReader(tc.content),
+			})
+			if err != nil {
+				t.Fatalf("Failed putting %s: %s", key, err)
+			}
+
+			NOPROGRESS = !tc.progress
+
+			fileUrl := fmt.Sprintf("https://%s.s3.us-east-1.amazonaws.com/%s", bucket, key)
+			_, meta := S3Url(fileUrl)
+
+			destPath := filepath.Join(t.TempDir(), "foo")
+
+			out, err := os.Create(destPath)
+			if err != nil {
+				t.Fatalf("Failed creating %s: %s", destPath, err)
+			}
+
+			obj := &DBT{S3Client: fakeClient}
+
+			err = obj.S3FetchFile(fileUrl, meta, out)
+			_ = out.Close()
+			if err != nil {
+				t.Fatalf("Failed fetching file: %s", err)
+			}
+
+			actual, err := ioutil.ReadFile(destPath)
+			if err != nil {
+				t.Fatalf("Failed reading fetched file: %s", err)
+			}
+
+			assert.Equal(t, len(tc.content), len(actual), "Fetched file is the size of the object.")
+			assert.True(t, bytes.Equal(tc.content, actual), "Fetched file is exactly the object.")
+		})
+	}
+}
+
+func TestFetchFileProgress(t *testing.T) {
+	large := make([]byte, 3*1024*1024+17)
+	for i := range large {
+		large[i] = byte(i % 251)
+	}
+
+	inputs := []struct {
+		name    string
+		content []byte
+	}{
+		{"small", []byte("#!/bin/sh\necho foo\n")},
+		{"large", large},
+		{"empty", []byte{}},
+	}
+
+	defer func(orig bool) { NOPROGRESS = orig }(NOPROGRESS)
+
+	NOPROGRESS = false
+
+	for _, tc := range inputs {
+		t.Run(tc.name, func(t *testing.T) {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				http.ServeContent(w, r, "foo", time.Time{}, bytes.NewReader(tc.content))
+			}))
+
+			defer server.Close()
+
+			destPath := filepath.Join(t.TempDir(), "foo")
+
+			obj := &DBT{Logger: log.New(ioutil.Discard, "", 0)}
+
+			err := obj.FetchFile(server.URL+"/foo", destPath)
+			if err != nil {
+				t.Fatalf("Failed fetching file: %s", err)
+			}
+
+			actual, err := ioutil.ReadFile(destPath)
+			if err != nil {
+				t.Fatalf("Failed reading fetched file: %s", err)
+			}
+
+			assert.Equal(t, len(tc.content), len(actual), "Fetched file is the size of the original.")
+			assert.True(t, bytes.Equal(tc.content, actual), "Fetched file is exactly the original.")
+		})
+	}
+}
+
+func TestFetchFileHeadRequest(t *testing.T) {
+	content := []byte("#!/bin/sh\necho foo\n")
+
+	inputs := []struct {
+		name       string
+		headStatus int
+		closed     bool
+		err        bool
+	}{
+		{"head allowed", 0, false, false},
+		{"head not allowed", http.StatusMethodNotAllowed, false, false},
+		{"head not found", http.StatusNotFound, false, true},
+		{"server unreachable", 0, true, true},
+	}
+
+	defer func(orig bool) { NOPROGRESS = orig }(NOPROGRESS)
+
+	NOPROGRESS = false
+
+	for _, tc := range inputs {
+		t.Run(tc.name, func(t *testing.T) {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.Method == http.MethodHead && tc.headStatus != 0 {
+					w.WriteHeader(tc.headStatus)
+					return
+				}
+
+				http.ServeContent(w, r, "foo", time.Time{}, bytes.NewReader(content))
+			}))
+
+			fileUrl := server.URL + "/foo"
+
+			if tc.closed {
+				server.Close()
+			} else {
+				defer server.Close()
+			}
+
+			destPath := filepath.Join(t.TempDir(), "foo")
+
+			obj := &DBT{Logger: log.New(ioutil.Discard, "", 0)}
+
+			err := obj.FetchFile(fileUrl, destPath)
+			if tc.err {
+				assert.Error(t, err, "Fetching the file fails cleanly.")
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("Failed fetching file: %s", err)
+			}
+
+			actual, err := ioutil.ReadFile(destPath)
+			if err != nil {
+				t.Fatalf("Failed reading fetched file: %s", err)
+			}
+
+			assert.Equal(t, content, actual, "Fetched file is exactly the original.")
+		})
+	}
+}
+
+func TestDBTS3BucketRegion(t *testing.T) {
+	locationXml := `<?xml version="1.0" encoding="UTF-8"?><LocationConstraint xmlns="http://s3.amazonaws.com/doc/2006-03-01/">%s</LocationConstraint>`
+	deniedXml := `<?xml version="1.0" encoding="UTF-8"?><Error><Code>AccessDenied</Code><Message>Access Denied</Message></Error>`
+
+	inputs := []struct {
+		name       string
+		location   string
+		denied     bool
+		headRegion string
+		urlRegion  string
+		envRegion  string
+		endpoint   bool
+		expected   string
+	}{
+		{"get bucket location", "eu-west-2", false, "", "us-east-1", "", false, "eu-west-2"},
+		{"head bucket", "", true, "ap-southeast-2", "us-east-1", "", false, "ap-southeast-2"},
+		{"url", "", true, "", "us-west-1", "", false, "us-west-1"},
+		{"ambient config", "", true, "", "", "ca-central-1", false, "ca-central-1"},
+		{"s3 endpoint", "eu-west-2", false, "", "", "", true, ""},
+	}
+
+	for _, tc := range inputs {
+		t.Run(tc.name, func(t *testing.T) {
+			var lock sync.Mutex
+			lookups := 0
+			signedRegion := ""
+
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				lock.Lock()
+				defer lock.Unlock()
+
+				_, location := r.URL.Query()["location"]
+
+				switch {
+				case location:
+					lookups++
+
+					w.Header().Set("Content-Type", "application/xml")
+					if tc.denied {
+						w.WriteHeader(http.StatusForbidden)
+						_, _ = w.Write([]byte(deniedXml))
+						return
+					}
+
+					_, _ = w.Write([]byte(fmt.Sprintf(locationXml, tc.location)))
+
+				case r.Method == http.MethodHead && r.URL.Path == "/dbt-tools":
+					if tc.headRegion != "" {
+						w.Header().Set("x-amz-bucket-region", tc.headRegion)
+					}
+
+					w.WriteHeader(http.StatusForbidden)
+
+				default:
+					// Authorization: AWS4-HMAC-SHA256 Credential=<key>/<date>/<region>/s3/aws4_request, ...
+					scope := strings.Split(strings.SplitN(r.Header.Get("Authorization"), "Credential=", 2)[1], "/")
+					signedRegion = scope[2]
+
+					w.Header().Set("ETag", `"abc123"`)
+				}
+			}))
+
+			defer server.Close()
+
+			t.Setenv("AWS_REGION", tc.envRegion)
+			t.Setenv("AWS_CONFIG_FILE", filepath.Join(t.TempDir(), "config"))
+
+			// s3.EndpointResolverFromURL sticks with the first region it's asked for, so it can't show which region requests are signed for
+			client := s3.New(s3.Options{
+				Credentials: aws.NewCredentialsCache(credentials.NewStaticCredentialsProvider("foo", "bar", "")),
+				EndpointResolver: s3.EndpointResolverFunc(func(region string, options s3.EndpointResolverOptions) (aws.Endpoint, error) {
+					return aws.Endpoint{URL: server.URL, SigningRegion: region, Source: aws.EndpointSourceCustom}, nil
+				}),
+				Region:       "us-east-1",
+				UsePathStyle: true,
+			})
+
+			obj := &DBT{
+				Logger:   log.New(ioutil.Discard, "", 0),
+				S3Client: client,
+			}
+
+			if tc.endpoint {
+				obj.Config.Dbt.S3Endpoint = server.URL
+			}
+
+			meta := S3Meta{Bucket: "dbt-tools", Region: tc.urlRegion, Key: "foo/1.2.3/linux/amd64/foo"}
+
+			assert.Equal(t, tc.expected, obj.S3BucketRegion(meta), "Bucket region is resolved.")
+			assert.Equal(t, tc.expected, obj.S3BucketRegion(meta), "Bucket region is resolved again.")
+
+			_, err := obj.S3FileETag(meta)
+			if err != nil {
+				t.Fatalf("Failed getting ETag: %s", err)
+			}
+
+			lock.Lock()
+			defer lock.Unlock()
+
+			if tc.endpoint {
+				assert.Equal(t, 0, lookups, "Buckets behind an S3 endpoint aren't looked up.")
+				assert.Equal(t, "us-east-1", signedRegion, "Requests use the client's region.")
+				return
+			}
+
+			assert.Equal(t, 1, lookups, "Bucket region is looked up once.")
+			assert.Equal(t, tc.expected, signedRegion, "Requests are signed for the bucket's region.")
+		})
+	}
+}
+
+func TestFetchFilePerHostCredentials(t *testing.T) {
+	newServer := func(username string, password string) *httptest.Server {
+		return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			u, p, ok := r.BasicAuth()
+			if !ok || u != username || p != password {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+
+			_, _ = w.Write([]byte(username))
+		}))
+	}
+
+	dbtServer := newServer("dbtuser", "dbtpass")
+	defer dbtServer.Close()
+
+	toolsServer := newServer("toolsuser", "toolspass")
+	defer toolsServer.Close()
+
+	otherServer := newServer("defaultuser", "defaultpass")
+	defer otherServer.Close()
+
+	// the servers are all on 127.0.0.1, so they're told apart by port
+	hostOf := func(server *httptest.Server) string {
+		u, _ := url.Parse(server.URL)
+		return u.Host
+	}
+
+	obj := &DBT{
+		Config: Config{
+			Username: "defaultuser",
+			Password: "defaultpass",
+			Credentials: map[string]CredentialSet{
+				hostOf(dbtServer):   {Username: "dbtuser", Password: "dbtpass"},
+				hostOf(toolsServer): {Username: "toolsuser", PasswordFunc: "echo toolspass"},
+			},
+		},
+		Logger: log.New(ioutil.Discard, "", 0),
+	}
+
+	inputs := []struct {
+		name     string
+		server   *httptest.Server
+		expected string
+	}{
+		{"dbt repo", dbtServer, "dbtuser"},
+		{"tools repo", toolsServer, "toolsuser"},
+		{"default", otherServer, "defaultuser"},
+	}
+
+	for _, tc := range inputs {
+		t.Run(tc.name, func(t *testing.T) {
+			destPath := filepath.Join(t.TempDir(), "foo")
+
+			err := obj.FetchFile(tc.server.URL+"/foo", destPath)
+			if err != nil {
+				t.Fatalf("Failed fetching file: %s", err)
+			}
+
+			actual, err := ioutil.ReadFile(destPath)
+			if err != nil {
+				t.Fatalf("Failed reading fetched file: %s", err)
+			}
+
+			assert.Equal(t, tc.expected, string(actual), "File is fetched with the host's credentials.")
+		})
+	}
+}
@@ -0,0 +1,96 @@
+// Copyright © 2019 Nik Ogura <nik.ogura@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !windows
+// +build !windows
+
+package dbt
+
+import (
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+)
+
+func TestFetchFileMode(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("#!/bin/sh\necho foo\n"))
+	}))
+
+	defer server.Close()
+
+	inputs := []struct {
+		name     string
+		mode     string
+		umask    int
+		existing os.FileMode
+		expected os.FileMode
+	}{
+		{"default", "", 0022, 0, 0755},
+		{"locked down", "0700", 0022, 0, 0700},
+		{"group", "0750", 0022, 0, 0750},
+		{"strict umask", "0755", 0077, 0, 0755},
+		{"existing file tightened", "0750", 0022, 0777, 0750},
+		{"existing file loosened", "0755", 0022, 0700, 0755},
+	}
+
+	for _, tc := range inputs {
+		t.Run(tc.name, func(t *testing.T) {
+			destPath := filepath.Join(t.TempDir(), "foo")
+
+			if tc.existing != 0 {
+				err := ioutil.WriteFile(destPath, []byte("old"), tc.existing)
+				if err != nil {
+					t.Fatalf("Failed writing existing file: %s", err)
+				}
+
+				err = os.Chmod(destPath, tc.existing)
+				if err != nil {
+					t.Fatalf("Failed to chmod existing file: %s", err)
+				}
+			}
+
+			oldUmask := syscall.Umask(tc.umask)
+			defer syscall.Umask(oldUmask)
+
+			obj := &DBT{
+				Config: Config{ToolFileMode: tc.mode},
+				Logger: log.New(ioutil.Discard, "", 0),
+			}
+
+			err := obj.fetchFile(server.URL+"/foo", destPath, false)
+			if err != nil {
+				t.Fatalf("Failed fetching file: %s", err)
+			}
+
+			info, err := os.Stat(destPath)
+			if err != nil {
+				t.Fatalf("Failed to stat fetched file: %s", err)
+			}
+
+			assert.Equal(t, tc.expected, info.Mode().Perm(), "Fetched file has exactly the configured mode.")
+		})
+	}
+
+	obj := &DBT{Config: Config{ToolFileMode: "0644"}, Logger: log.New(ioutil.Discard, "", 0)}
+
+	err := obj.fetchFile(server.URL+"/foo", filepath.Join(t.TempDir(), "foo"), false)
+	assert.Error(t, err, "Invalid mode fails the fetch rather than falling back.")
+}
@@ -0,0 +1,472 @@
+// Copyright © 2019 Nik Ogura <nik.ogura@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package dbt
+
+import (
+	"context"
+	"fmt"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/pkg/errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// SYNC_DESCRIPTION_FILE the name of the tool description file in each version directory
+const SYNC_DESCRIPTION_FILE = "description.txt"
+
+// RepoTarget one end of a repo sync.  Config is the dbt config for the repo, as it would appear in a dbt config file.  S3Client, if set, is used instead of the default client for repos in S3.
+type RepoTarget struct {
+	Config   Config
+	S3Client *s3.Client
+}
+
+// SyncOptions controls what SyncRepos does.
+type SyncOptions struct {
+	// DryRun if true, report what would be copied without copying anything.
+	DryRun bool
+	// Tools if set, only these tools are synced.  Otherwise every tool in the source is.
+	Tools []string
+	// Homedir where to find the truststore that source files are verified against.  If empty, the source repo's own truststore is fetched, and used.
+	Homedir string
+	// Verbose if true, show the urls being fetched and uploaded.
+	Verbose bool
+	// Context if set, bounds every request to either repo.
+	Context context.Context
+	// Out where to report what's copied.  Defaults to stdout.
+	Out io.Writer
+}
+
+// syncer carries the state for a single SyncRepos run.
+type syncer struct {
+	src  *DBT
+	dst  *DBT
+	opts SyncOptions
+}
+
+// SyncRepos copies every tool version in the src repo that's missing or different in the dst repo.  Binaries and descriptions are verified against the truststore in opts.Homedir before they're uploaded, and then PUT to the dst reposerver along with their checksums and signatures.  The src repo can be HTTP or S3, but the dst has to be a reposerver.
+func SyncRepos(src RepoTarget, dst RepoTarget, opts SyncOptions) (err error) {
+	if opts.Out == nil {
+		opts.Out = os.Stdout
+	}
+
+	if isS3, _ := S3UrlForEndpoint(dst.Config.Tools.Repo, dst.Config.Dbt.S3Endpoint); isS3 {
+		err = fmt.Errorf("cannot sync to %s: the destination must be a reposerver", dst.Config.Tools.Repo)
+		return err
+	}
+
+	s := syncer{opts: opts}
+
+	s.src, err = src.dbt()
+	if err != nil {
+		err = errors.Wrapf(err, "failed to set up source repo")
+		return err
+	}
+
+	s.src.SetVerbose(opts.Verbose)
+	s.src.Context = opts.Context
+
+	s.dst, err = dst.dbt()
+	if err != nil {
+		err = errors.Wrapf(err, "failed to set up destination repo")
+		return err
+	}
+
+	s.dst.SetVerbose(opts.Verbose)
+	s.dst.Context = opts.Context
+
+	if s.opts.Homedir == "" {
+		s.opts.Homedir, err = ioutil.TempDir("", "dbt-sync-home")
+		if err != nil {
+			err = errors.Wrap(err, "failed to create temp dir")
+			return err
+		}
+
+		defer os.RemoveAll(s.opts.Homedir)
+
+		err = makeStagingDbtDir(s.opts.Homedir, opts.Verbose)
+		if err != nil {
+			return err
+		}
+
+		err = s.src.FetchTrustStore(s.opts.Homedir)
+		if err != nil {
+			err = errors.Wrapf(err, "failed to fetch source truststore")
+			return err
+		}
+	}
+
+	toolNames, err := s.toolNames()
+	if err != nil {
+		return err
+	}
+
+	for _, toolName := range toolNames {
+		versions, err := s.src.FetchToolVersions(toolName)
+		if err != nil {
+			err = errors.Wrapf(err, "failed to list versions of %s", toolName)
+			return err
+		}
+
+		for _, version := range versions {
+			err = s.syncVersion(toolName, version)
+			if err != nil {
+				return err
+			}
+		}
+
+		err = s.syncToolFiles(toolName)
+		if err != nil {
+			return err
+		}
+	}
+
+	return err
+}
+
+// dbt creates the dbt object used to talk to the target's repo.
+func (t RepoTarget) dbt() (dbtObj *DBT, err error) {
+	dbtObj, err = NewDbtFromConfig(t.Config)
+	if err != nil {
+		return dbtObj, err
+	}
+
+	if t.S3Client != nil {
+		dbtObj.S3Client = t.S3Client
+		return dbtObj, err
+	}
+
+	// the dbt repo might not be in S3 even if the tools repo is
+	isS3, s3Meta := dbtObj.s3Url(t.Config.Tools.Repo)
+	if isS3 && dbtObj.S3Client == nil {
+		dbtObj.S3Client, err = NewS3Client(t.Config, s3Meta, dbtObj.Client)
+		if err != nil {
+			return dbtObj, err
+		}
+	}
+
+	return dbtObj, err
+}
+
+// toolNames lists the tools to sync, in order.
+func (s *syncer) toolNames() (names []string, err error) {
+	tools, err := s.src.FetchToolNames()
+	if err != nil {
+		err = errors.Wrapf(err, "failed to list tools in %s", s.src.Config.Tools.Repo)
+		return names, err
+	}
+
+	for _, tool := range tools {
+		if len(s.opts.Tools) > 0 && !StringInSlice(tool.Name, s.opts.Tools) {
+			continue
+		}
+
+		names = append(names, tool.Name)
+	}
+
+	sort.Strings(names)
+
+	return names, err
+}
+
+// syncVersion copies whatever has changed in one version of a tool.  Binaries are recognized by their .sha256 files, and carry them and their .asc files along.  Anything else, other than the description and deps.json, is left alone.
+func (s *syncer) syncVersion(toolName string, version string) (err error) {
+	versionPath := fmt.Sprintf("%s/%s", toolName, version)
+
+	files, err := s.src.ListRepoFiles(fmt.Sprintf("%s/%s", s.src.Config.Tools.Repo, versionPath))
+	if err != nil {
+		err = errors.Wrapf(err, "failed to list files in %s", versionPath)
+		return err
+	}
+
+	inSource := make(map[string]bool)
+	for _, f := range files {
+		inSource[f] = true
+	}
+
+	sort.Strings(files)
+
+	for _, f := range files {
+		switch {
+		case strings.HasSuffix(f, ".sha256") || strings.HasSuffix(f, ".asc"):
+			continue
+		case f == SYNC_DESCRIPTION_FILE || f == TOOL_DEPS_FILE:
+			err = s.syncDescription(versionPath, f)
+		case inSource[fmt.Sprintf("%s.sha256", f)]:
+			err = s.syncBinary(versionPath, f, f)
+		case strings.HasSuffix(f, COMPRESSED_SUFFIX) && inSource[fmt.Sprintf("%s.sha256", strings.TrimSuffix(f, COMPRESSED_SUFFIX))]:
+			err = s.syncBinary(versionPath, f, strings.TrimSuffix(f, COMPRESSED_SUFFIX))
+		default:
+			s.src.VerboseOutput("Skipping %s/%s: not a tool binary, description, or deps.json", versionPath, f)
+		}
+
+		if err != nil {
+			return err
+		}
+	}
+
+	return err
+}
+
+// syncToolFiles copies the files that belong to a tool rather than any one version of it, its policy.json and its latest file.  They go after the versions, so the latest file never names a version the destination doesn't have yet.
+func (s *syncer) syncToolFiles(toolName string) (err error) {
+	hasPolicy, err := s.src.FileExists(s.srcUrl(fmt.Sprintf("%s/%s", toolName, TOOL_POLICY_FILE)))
+	if err != nil {
+		err = errors.Wrapf(err, "failed to check for policy of %s", toolName)
+		return err
+	}
+
+	if hasPolicy {
+		err = s.syncDescription(toolName, TOOL_POLICY_FILE)
+		if err != nil {
+			return err
+		}
+	}
+
+	return s.syncLatestFile(toolName)
+}
+
+// syncLatestFile copies a tool's latest file, if the destination doesn't have it, or names another version.  It isn't signed, so one that doesn't hold a version isn't copied.
+func (s *syncer) syncLatestFile(toolName string) (err error) {
+	latestPath := fmt.Sprintf("%s/%s", toolName, LATEST_FILE)
+
+	found, err := s.src.FileExists(s.srcUrl(latestPath))
+	if err != nil {
+		err = errors.Wrapf(err, "failed to check for %s", latestPath)
+		return err
+	}
+
+	if !found {
+		return err
+	}
+
+	latest, err := s.src.fetchDescriptionFile(s.srcUrl(latestPath))
+	if err != nil {
+		return err
+	}
+
+	latest = strings.TrimSpace(latest)
+
+	semverMatch := regexp.MustCompile(`^\d+\.\d+\.\d+$`)
+	if !semverMatch.MatchString(latest) {
+		s.src.VerboseOutput("Skipping %s: it holds no semantic version", latestPath)
+		return err
+	}
+
+	dstLatest, dstErr := s.dst.fetchDescriptionFile(s.dstUrl(latestPath))
+	if dstErr == nil && strings.TrimSpace(dstLatest) == latest {
+		return err
+	}
+
+	if s.opts.DryRun {
+		_, _ = fmt.Fprintf(s.opts.Out, "Would copy %s\n", latestPath)
+		return err
+	}
+
+	_, _ = fmt.Fprintf(s.opts.Out, "Copying %s\n", latestPath)
+
+	tmpDir, err := ioutil.TempDir("", "dbt-sync")
+	if err != nil {
+		err = errors.Wrap(err, "failed to create temp dir")
+		return err
+	}
+
+	defer os.RemoveAll(tmpDir)
+
+	localPath := filepath.Join(tmpDir, LATEST_FILE)
+
+	err = ioutil.WriteFile(localPath, []byte(latest), 0644)
+	if err != nil {
+		err = errors.Wrapf(err, "failed to write %s", localPath)
+		return err
+	}
+
+	return s.put(latestPath, localPath)
+}
+
+// syncBinary copies a binary, its checksum, and its signature, if the destination doesn't have it, or has something else.  fileName is the file in the repo, and binaryName the uncompressed binary the checksum and signature belong to.  They're the same unless the binary is gzipped.
+func (s *syncer) syncBinary(versionPath string, fileName string, binaryName string) (err error) {
+	filePath := fmt.Sprintf("%s/%s", versionPath, fileName)
+	binaryPath := fmt.Sprintf("%s/%s", versionPath, binaryName)
+
+	srcChecksum, err := s.src.FetchRemoteChecksum(s.srcUrl(binaryPath))
+	if err != nil {
+		return err
+	}
+
+	changed, err := s.binaryChanged(filePath, binaryPath, srcChecksum)
+	if err != nil || !changed {
+		return err
+	}
+
+	if s.opts.DryRun {
+		_, _ = fmt.Fprintf(s.opts.Out, "Would copy %s\n", filePath)
+		return err
+	}
+
+	_, _ = fmt.Fprintf(s.opts.Out, "Copying %s\n", filePath)
+
+	tmpDir, err := ioutil.TempDir("", "dbt-sync")
+	if err != nil {
+		err = errors.Wrap(err, "failed to create temp dir")
+		return err
+	}
+
+	defer os.RemoveAll(tmpDir)
+
+	paths := []string{filePath, fmt.Sprintf("%s.sha256", binaryPath), fmt.Sprintf("%s.asc", binaryPath)}
+
+	for _, p := range paths {
+		err = s.src.FetchFile(s.srcUrl(p), filepath.Join(tmpDir, filepath.Base(p)))
+		if err != nil {
+			err = errors.Wrapf(err, "failed to fetch %s", p)
+			return err
+		}
+	}
+
+	localBinary := filepath.Join(tmpDir, filepath.Base(binaryName))
+
+	if fileName != binaryName {
+		err = gunzipFile(filepath.Join(tmpDir, filepath.Base(fileName)), localBinary, DEFAULT_TOOL_FILE_MODE)
+		if err != nil {
+			err = errors.Wrapf(err, "failed to decompress %s", filePath)
+			return err
+		}
+	}
+
+	err = s.src.verifyToolFileChecksum(s.opts.Homedir, localBinary, srcChecksum)
+	if err != nil {
+		err = errors.Wrapf(err, "%s failed to verify", filePath)
+		return err
+	}
+
+	// the binary goes up first, so that its checksum and signature never show up ahead of it
+	for _, p := range paths {
+		err = s.put(p, filepath.Join(tmpDir, filepath.Base(p)))
+		if err != nil {
+			return err
+		}
+	}
+
+	return err
+}
+
+// binaryChanged checks whether the destination is missing a binary, or has one with a different checksum.
+func (s *syncer) binaryChanged(filePath string, binaryPath string, srcChecksum string) (changed bool, err error) {
+	exists, err := s.dst.FileExists(s.dstUrl(filePath))
+	if err != nil {
+		return changed, err
+	}
+
+	if !exists {
+		return true, err
+	}
+
+	dstChecksum, err := s.dst.FetchRemoteChecksum(s.dstUrl(binaryPath))
+	if err != nil {
+		if errors.Is(err, ErrRepoUnreachable) {
+			return changed, err
+		}
+
+		// there's a binary, but no checksum for it.
+		return true, nil
+	}
+
+	return strings.TrimSpace(dstChecksum) != strings.TrimSpace(srcChecksum), err
+}
+
+// syncDescription copies a signed text file, a tool description or deps.json, and its signature, if the destination doesn't have them, or has something else.
+func (s *syncer) syncDescription(versionPath string, fileName string) (err error) {
+	descriptionPath := fmt.Sprintf("%s/%s", versionPath, fileName)
+	signaturePath := fmt.Sprintf("%s.asc", descriptionPath)
+
+	description, err := s.src.fetchDescriptionFile(s.srcUrl(descriptionPath))
+	if err != nil {
+		return err
+	}
+
+	signature, err := s.src.fetchDescriptionFile(s.srcUrl(signaturePath))
+	if err != nil {
+		err = errors.Wrapf(err, "failed to fetch signature for %s", descriptionPath)
+		return err
+	}
+
+	// anything short of an identical description and signature gets replaced
+	dstDescription, descErr := s.dst.fetchDescriptionFile(s.dstUrl(descriptionPath))
+	dstSignature, sigErr := s.dst.fetchDescriptionFile(s.dstUrl(signaturePath))
+
+	if descErr == nil && sigErr == nil && dstDescription == description && dstSignature == signature {
+		return err
+	}
+
+	if s.opts.DryRun {
+		_, _ = fmt.Fprintf(s.opts.Out, "Would copy %s\n", descriptionPath)
+		return err
+	}
+
+	_, _ = fmt.Fprintf(s.opts.Out, "Copying %s\n", descriptionPath)
+
+	err = s.src.verifyDescription(s.opts.Homedir, description, signature)
+	if err != nil {
+		err = errors.Wrapf(err, "%s failed to verify", descriptionPath)
+		return err
+	}
+
+	tmpDir, err := ioutil.TempDir("", "dbt-sync")
+	if err != nil {
+		err = errors.Wrap(err, "failed to create temp dir")
+		return err
+	}
+
+	defer os.RemoveAll(tmpDir)
+
+	contents := map[string]string{descriptionPath: description, signaturePath: signature}
+
+	for _, p := range []string{descriptionPath, signaturePath} {
+		localPath := filepath.Join(tmpDir, filepath.Base(p))
+
+		err = ioutil.WriteFile(localPath, []byte(contents[p]), 0644)
+		if err != nil {
+			err = errors.Wrapf(err, "failed to write %s", localPath)
+			return err
+		}
+
+		err = s.put(p, localPath)
+		if err != nil {
+			return err
+		}
+	}
+
+	return err
+}
+
+// put uploads a local file to the destination repo.  It goes through PublishFile, so it carries the same checksum header and auth as any other publish.
+func (s *syncer) put(repoPath string, localPath string) (err error) {
+	return s.dst.PublishFile(localPath, s.dstUrl(repoPath), PublishAuth{})
+}
+
+// srcUrl the url of a file in the source tools repo
+func (s *syncer) srcUrl(repoPath string) string {
+	return fmt.Sprintf("%s/%s", s.src.Config.Tools.Repo, repoPath)
+}
+
+// dstUrl the url of a file in the destination tools repo
+func (s *syncer) dstUrl(repoPath string) string {
+	return fmt.Sprintf("%s/%s", s.dst.Config.Tools.Repo, repoPath)
+}
@@ -0,0 +1,205 @@
+// Copyright © 2019 Nik Ogura <nik.ogura@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package dbt
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"net/http/httptest"
+	"os"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+func TestSyncRepos(t *testing.T) {
+	srcRoot, srcConfig, signer := newTestSignedRepo(t, "foo", "1.0.0", "1.1.0")
+
+	// descriptions are signed like binaries, but have no checksum
+	descriptionPath := fmt.Sprintf("%s/dbt-tools/foo/1.1.0/%s", srcRoot, SYNC_DESCRIPTION_FILE)
+	writeTestSignedTool(t, signer, descriptionPath, "foo does things")
+	_ = os.Remove(fmt.Sprintf("%s.sha256", descriptionPath))
+
+	// and so are dependencies
+	depsPath := fmt.Sprintf("%s/dbt-tools/foo/1.0.0/%s", srcRoot, TOOL_DEPS_FILE)
+	writeTestSignedTool(t, signer, depsPath, `{"dependencies": {"bar": ""}}`)
+	_ = os.Remove(fmt.Sprintf("%s.sha256", depsPath))
+
+	// and the tool's policy, which isn't tied to a version
+	policyPath := fmt.Sprintf("%s/dbt-tools/foo/%s", srcRoot, TOOL_POLICY_FILE)
+	writeTestSignedTool(t, signer, policyPath, `{"minVersion": "1.0.0"}`)
+	_ = os.Remove(fmt.Sprintf("%s.sha256", policyPath))
+
+	// nor is the latest file, which isn't signed at all
+	latestPath := fmt.Sprintf("%s/dbt-tools/foo/%s", srcRoot, LATEST_FILE)
+
+	err := ioutil.WriteFile(latestPath, []byte("1.1.0\n"), 0644)
+	if err != nil {
+		t.Fatalf("Failed writing latest file: %s", err)
+	}
+
+	dstRoot, err := ioutil.TempDir("", "dbt-sync-dst")
+	if err != nil {
+		t.Fatalf("Failed creating destination root: %s", err)
+	}
+
+	defer os.RemoveAll(dstRoot)
+
+	repoServer := &DBTRepoServer{
+		ServerRoot:  dstRoot,
+		AuthTypePut: AUTH_BASIC_HTPASSWD,
+		AuthOptsPut: AuthOpts{
+			IdpFile: writeTestHtpasswd(t, dstRoot, "uploader", "password"),
+		},
+		DisabledChecksumAlgos: []string{CHECKSUM_MD5, CHECKSUM_SHA1},
+	}
+
+	r, err := repoServer.Router()
+	if err != nil {
+		t.Fatalf("Failed creating router: %s", err)
+	}
+
+	server := httptest.NewServer(r)
+	defer server.Close()
+
+	dstConfig := Config{
+		Tools:    ToolsConfig{Repo: fmt.Sprintf("%s/dbt-tools", server.URL)},
+		Username: "uploader",
+		Password: "password",
+	}
+
+	toolPath := func(version string) string {
+		return fmt.Sprintf("dbt-tools/foo/%s/%s/%s/%s", version, runtime.GOOS, runtime.GOARCH, ToolFileName("foo", runtime.GOOS))
+	}
+
+	inputs := []struct {
+		name   string
+		before func()
+		dryRun bool
+		copied []string
+		err    bool
+	}{
+		{
+			"dry run",
+			nil,
+			true,
+			[]string{"dbt-tools/foo/1.0.0/deps.json", toolPath("1.0.0"), "dbt-tools/foo/1.1.0/description.txt", toolPath("1.1.0"), "dbt-tools/foo/policy.json", "dbt-tools/foo/latest"},
+			false,
+		},
+		{
+			"sync",
+			nil,
+			false,
+			[]string{"dbt-tools/foo/1.0.0/deps.json", toolPath("1.0.0"), "dbt-tools/foo/1.1.0/description.txt", toolPath("1.1.0"), "dbt-tools/foo/policy.json", "dbt-tools/foo/latest"},
+			false,
+		},
+		{
+			"resync",
+			nil,
+			false,
+			[]string{},
+			false,
+		},
+		{
+			"changed tool",
+			func() {
+				writeTestSignedTool(t, signer, fmt.Sprintf("%s/%s", srcRoot, toolPath("1.1.0")), "#!/bin/sh\necho foo 1.1.0 rebuilt\n")
+			},
+			false,
+			[]string{toolPath("1.1.0")},
+			false,
+		},
+		{
+			"changed latest",
+			func() {
+				_ = ioutil.WriteFile(latestPath, []byte("1.0.0"), 0644)
+			},
+			false,
+			[]string{"dbt-tools/foo/latest"},
+			false,
+		},
+		{
+			"changed policy",
+			func() {
+				writeTestSignedTool(t, signer, policyPath, `{"minVersion": "1.1.0"}`)
+				_ = os.Remove(fmt.Sprintf("%s.sha256", policyPath))
+			},
+			false,
+			[]string{"dbt-tools/foo/policy.json"},
+			false,
+		},
+		{
+			"tampered tool",
+			func() {
+				tampered := fmt.Sprintf("%s/%s", srcRoot, toolPath("1.0.0"))
+				_ = ioutil.WriteFile(tampered, []byte("#!/bin/sh\nrm -rf /\n"), 0755)
+				checksum, _ := FileSha256(tampered)
+				_ = ioutil.WriteFile(fmt.Sprintf("%s.sha256", tampered), []byte(checksum), 0644)
+			},
+			false,
+			[]string{toolPath("1.0.0")},
+			true,
+		},
+	}
+
+	for _, tc := range inputs {
+		t.Run(tc.name, func(t *testing.T) {
+			if tc.before != nil {
+				tc.before()
+			}
+
+			out := &bytes.Buffer{}
+
+			err := SyncRepos(RepoTarget{Config: srcConfig}, RepoTarget{Config: dstConfig}, SyncOptions{DryRun: tc.dryRun, Out: out})
+			if tc.err {
+				assert.NotNil(t, err, "Sync fails.")
+			} else if err != nil {
+				t.Fatalf("Sync failed: %s", err)
+			}
+
+			verb := "Copying"
+			if tc.dryRun {
+				verb = "Would copy"
+			}
+
+			expected := ""
+			for _, p := range tc.copied {
+				expected += fmt.Sprintf("%s %s\n", verb, strings.TrimPrefix(p, "dbt-tools/"))
+			}
+
+			assert.Equal(t, expected, out.String(), "Sync reports what it copies.")
+
+			for _, p := range []string{toolPath("1.0.0"), toolPath("1.1.0"), "dbt-tools/foo/1.1.0/description.txt", "dbt-tools/foo/1.0.0/deps.json", "dbt-tools/foo/policy.json", "dbt-tools/foo/policy.json.asc", "dbt-tools/foo/latest"} {
+				srcContent, _ := ioutil.ReadFile(fmt.Sprintf("%s/%s", srcRoot, p))
+				dstContent, dstErr := ioutil.ReadFile(fmt.Sprintf("%s/%s", dstRoot, p))
+
+				if p == "dbt-tools/foo/latest" {
+					srcContent = bytes.TrimSpace(srcContent)
+				}
+
+				switch {
+				case tc.dryRun:
+					assert.True(t, os.IsNotExist(dstErr), "Dry run copies nothing.")
+				case tc.err && p == toolPath("1.0.0"):
+					assert.Equal(t, "#!/bin/sh\necho foo 1.0.0\n", string(dstContent), "Tampered tool isn't copied.")
+				default:
+					assert.Equal(t, string(srcContent), string(dstContent), "Destination matches source.")
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,118 @@
+// Copyright © 2019 Nik Ogura <nik.ogura@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package dbt
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/pkg/errors"
+	"net/http"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"time"
+)
+
+// DBT_TELEMETRY_DISABLE_ENV_VAR Env var that, if set to anything but 0 or false, turns telemetry off whatever the config says
+const DBT_TELEMETRY_DISABLE_ENV_VAR = "DBT_TELEMETRY_DISABLE"
+
+// TELEMETRY_TIMEOUT the longest dbt waits to report a run before giving up on it
+const TELEMETRY_TIMEOUT = 2 * time.Second
+
+// TELEMETRY_EXIT_WAIT the longest a finished run waits on its report.  Plenty for a nearby collector, and short enough that nobody notices.  A report that takes longer is dropped when dbt exits.
+const TELEMETRY_EXIT_WAIT = 50 * time.Millisecond
+
+// TelemetryEvent what's reported about a tool run.  There's nothing in it about who ran the tool, or where, or with what args.
+type TelemetryEvent struct {
+	Tool       string `json:"tool"`
+	Version    string `json:"version"`
+	Os         string `json:"os"`
+	Arch       string `json:"arch"`
+	DbtVersion string `json:"dbtversion"`
+	Timestamp  string `json:"timestamp"`
+}
+
+// TelemetryEnabled returns true if the config has a telemetry url, and DBT_TELEMETRY_DISABLE_ENV_VAR doesn't turn it off.
+func (dbt *DBT) TelemetryEnabled() bool {
+	if dbt.Config.TelemetryUrl == "" {
+		return false
+	}
+
+	disable := os.Getenv(DBT_TELEMETRY_DISABLE_ENV_VAR)
+	if disable == "" {
+		return true
+	}
+
+	// anything that isn't clearly 'don't disable' is taken as disable
+	disabled, err := strconv.ParseBool(disable)
+
+	return err == nil && !disabled
+}
+
+// ReportRun posts a TelemetryEvent for a tool run to the telemetry url.  It gives up after TELEMETRY_TIMEOUT, and is never sent with credentials.
+func (dbt *DBT) ReportRun(toolName string, version string) (err error) {
+	event := TelemetryEvent{
+		Tool:       toolName,
+		Version:    version,
+		Os:         runtime.GOOS,
+		Arch:       runtime.GOARCH,
+		DbtVersion: VERSION,
+		Timestamp:  time.Now().UTC().Format(time.RFC3339),
+	}
+
+	body, err := json.Marshal(event)
+	if err != nil {
+		err = errors.Wrapf(err, "failed to marshal telemetry event")
+		return err
+	}
+
+	// not the request context, which a run that used up the --timeout has already spent
+	ctx, cancel := context.WithTimeout(context.Background(), TELEMETRY_TIMEOUT)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, dbt.Config.TelemetryUrl, bytes.NewReader(body))
+	if err != nil {
+		err = errors.Wrapf(err, "failed to create request for url: %s", dbt.Config.TelemetryUrl)
+		return err
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := dbt.HttpClient().Do(req)
+	if err != nil {
+		err = errors.Wrapf(err, "failed to report run to %s", dbt.Config.TelemetryUrl)
+		return err
+	}
+
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		err = fmt.Errorf("reporting run to %s returned %s", dbt.Config.TelemetryUrl, resp.Status)
+		return err
+	}
+
+	return err
+}
+
+// reportRun reports a successful run, if telemetry's on.  Telemetry's best effort, so failures are only mentioned in verbose output.
+func (dbt *DBT) reportRun(localPath string, args []string) {
+	err := dbt.ReportRun(args[0], filepath.Base(filepath.Dir(localPath)))
+	if err != nil {
+		dbt.VerboseOutput("Telemetry: %s", err)
+	}
+}
@@ -0,0 +1,150 @@
+package dbt
+
+import (
+	"encoding/json"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"runtime"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestTelemetry(t *testing.T) {
+	_, config, _ := newTestSignedRepo(t, "foo", "1.0.0")
+
+	homedir := t.TempDir()
+
+	err := makeStagingDbtDir(homedir, false)
+	if err != nil {
+		t.Fatalf("Failed creating dbt dir: %s", err)
+	}
+
+	var events []TelemetryEvent
+	var mutex sync.Mutex
+
+	collector := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, http.MethodPost, r.Method, "Events are POSTed.")
+		assert.Empty(t, r.Header.Get("Authorization"), "Events carry no credentials.")
+
+		var event TelemetryEvent
+
+		err := json.NewDecoder(r.Body).Decode(&event)
+		assert.NoError(t, err, "Event is JSON.")
+
+		mutex.Lock()
+		events = append(events, event)
+		mutex.Unlock()
+	}))
+
+	defer collector.Close()
+
+	broken := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+
+	defer broken.Close()
+
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(TELEMETRY_TIMEOUT)
+	}))
+
+	defer slow.Close()
+
+	testExec = true
+	defer func() { testExec = false }()
+
+	_ = os.Setenv(HELPER_PROCESS_ENV_VAR, "1")
+	defer os.Unsetenv(HELPER_PROCESS_ENV_VAR)
+
+	inputs := []struct {
+		name     string
+		url      string
+		disable  string
+		code     string
+		offline  bool
+		reported bool
+	}{
+		{"reported", collector.URL, "", "0", false, true},
+		{"not configured", "", "", "0", false, false},
+		{"disabled", collector.URL, "1", "0", false, false},
+		{"disabled with true", collector.URL, "true", "0", false, false},
+		{"disabled with junk", collector.URL, "yes please", "0", false, false},
+		{"not disabled", collector.URL, "false", "0", false, true},
+		{"failed run", collector.URL, "", "3", false, false},
+		{"offline", collector.URL, "", "0", true, false},
+		{"collector fails", broken.URL, "", "0", false, false},
+		{"collector unreachable", "http://127.0.0.1:1/events", "", "0", false, false},
+		{"collector slow", slow.URL, "", "0", false, false},
+	}
+
+	for _, tc := range inputs {
+		t.Run(tc.name, func(t *testing.T) {
+			mutex.Lock()
+			events = nil
+			mutex.Unlock()
+
+			if tc.disable != "" {
+				_ = os.Setenv(DBT_TELEMETRY_DISABLE_ENV_VAR, tc.disable)
+				defer os.Unsetenv(DBT_TELEMETRY_DISABLE_ENV_VAR)
+			}
+
+			tcConfig := config
+			tcConfig.TelemetryUrl = tc.url
+			tcConfig.Username = "someone"
+			tcConfig.Password = "secret"
+
+			obj := &DBT{
+				Config: tcConfig,
+				Logger: log.New(ioutil.Discard, "", 0),
+			}
+
+			err := obj.FetchTrustStore(homedir)
+			if err != nil {
+				t.Fatalf("Failed fetching truststore: %s", err)
+			}
+
+			start := time.Now()
+
+			err = obj.RunTool("1.0.0", []string{"foo", "exit", tc.code}, homedir, tc.offline)
+			if tc.code == "0" {
+				assert.NoError(t, err, "Telemetry never fails a run.")
+			}
+
+			assert.True(t, time.Since(start) < TELEMETRY_TIMEOUT/2, "Telemetry doesn't hold up the run.")
+
+			// the run doesn't wait for the report, so it may still be on its way
+			if tc.reported {
+				assert.Eventually(t, func() bool {
+					mutex.Lock()
+					defer mutex.Unlock()
+					return len(events) > 0
+				}, TELEMETRY_TIMEOUT, 10*time.Millisecond, "Run is reported.")
+			}
+
+			mutex.Lock()
+			defer mutex.Unlock()
+
+			if !tc.reported {
+				assert.Empty(t, events, "Nothing is reported.")
+				return
+			}
+
+			if assert.Len(t, events, 1, "Run is reported once.") {
+				event := events[0]
+				assert.Equal(t, "foo", event.Tool, "Tool is reported.")
+				assert.Equal(t, "1.0.0", event.Version, "Version is reported.")
+				assert.Equal(t, runtime.GOOS, event.Os, "OS is reported.")
+				assert.Equal(t, runtime.GOARCH, event.Arch, "Arch is reported.")
+				assert.Equal(t, VERSION, event.DbtVersion, "Dbt version is reported.")
+
+				_, err := time.Parse(time.RFC3339, event.Timestamp)
+				assert.NoError(t, err, "Timestamp is RFC3339.")
+			}
+		})
+	}
+}
@@ -0,0 +1,71 @@
+// Copyright © 2019 Nik Ogura <nik.ogura@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package dbt
+
+import (
+	"fmt"
+	"github.com/pkg/errors"
+	"io/ioutil"
+	"os"
+	"time"
+)
+
+// DEFAULT_UPGRADE_CHECK_INTERVAL_SECONDS how long after checking for a new version of dbt to wait before checking again, unless the config says otherwise.
+const DEFAULT_UPGRADE_CHECK_INTERVAL_SECONDS = 3600
+
+// UPGRADE_CHECK_MARKER the file whose modification time records when dbt last checked for a new version of itself.
+const UPGRADE_CHECK_MARKER = ".last_upgrade_check"
+
+// UpgradeCheckMarkerPath returns the path of the file recording when dbt last checked for a new version of itself.  Usually ~/.dbt/.last_upgrade_check
+func UpgradeCheckMarkerPath(homedir string) string {
+	return fmt.Sprintf("%s/%s", dbtBaseDir(homedir, XDG_CACHE_HOME_ENV_VAR), UPGRADE_CHECK_MARKER)
+}
+
+// UpgradeCheckInterval returns how long to wait between checks for a new version of dbt.  Zero in the config means DEFAULT_UPGRADE_CHECK_INTERVAL_SECONDS, and anything negative means checking every time.
+func (dbt *DBT) UpgradeCheckInterval() (interval time.Duration) {
+	seconds := dbt.Config.Dbt.UpgradeCheckIntervalSeconds
+	if seconds == 0 {
+		seconds = DEFAULT_UPGRADE_CHECK_INTERVAL_SECONDS
+	}
+
+	if seconds < 0 {
+		return 0
+	}
+
+	return time.Duration(seconds) * time.Second
+}
+
+// UpgradeCheckDue returns true if it's been longer than the upgrade check interval since dbt last checked for a new version of itself, or if it never has.
+func (dbt *DBT) UpgradeCheckDue(homedir string) (due bool) {
+	info, err := os.Stat(UpgradeCheckMarkerPath(homedir))
+	if err != nil {
+		return true
+	}
+
+	return time.Since(info.ModTime()) >= dbt.UpgradeCheckInterval()
+}
+
+// MarkUpgradeChecked records that dbt has just checked for a new version of itself.
+func MarkUpgradeChecked(homedir string) (err error) {
+	markerPath := UpgradeCheckMarkerPath(homedir)
+
+	err = ioutil.WriteFile(markerPath, []byte(time.Now().UTC().Format(time.RFC3339)), 0644)
+	if err != nil {
+		err = errors.Wrapf(err, "failed to write %s", markerPath)
+		return err
+	}
+
+	return err
+}
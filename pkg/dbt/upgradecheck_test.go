@@ -0,0 +1,104 @@
+// Copyright © 2019 Nik Ogura <nik.ogura@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package dbt
+
+import (
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestUpgradeCheckDue(t *testing.T) {
+	inputs := []struct {
+		name     string
+		interval int
+		age      time.Duration
+		marked   bool
+		due      bool
+	}{
+		{
+			"never checked",
+			0,
+			0,
+			false,
+			true,
+		},
+		{
+			"checked just now",
+			0,
+			0,
+			true,
+			false,
+		},
+		{
+			"checked before the default interval",
+			0,
+			2 * time.Hour,
+			true,
+			true,
+		},
+		{
+			"checked within a longer interval",
+			7200,
+			time.Hour,
+			true,
+			false,
+		},
+		{
+			"check every time",
+			-1,
+			0,
+			true,
+			true,
+		},
+	}
+
+	for _, tc := range inputs {
+		t.Run(tc.name, func(t *testing.T) {
+			homedir, err := ioutil.TempDir("", "dbt-upgrade-check")
+			if err != nil {
+				t.Fatalf("Failed creating homedir: %s", err)
+			}
+
+			defer os.RemoveAll(homedir)
+
+			err = os.MkdirAll(fmt.Sprintf("%s/%s", homedir, DbtDir), 0755)
+			if err != nil {
+				t.Fatalf("Failed creating dbt dir: %s", err)
+			}
+
+			if tc.marked {
+				err = MarkUpgradeChecked(homedir)
+				if err != nil {
+					t.Fatalf("Failed marking upgrade check: %s", err)
+				}
+
+				then := time.Now().Add(-tc.age)
+
+				err = os.Chtimes(UpgradeCheckMarkerPath(homedir), then, then)
+				if err != nil {
+					t.Fatalf("Failed aging marker: %s", err)
+				}
+			}
+
+			dbtObj := &DBT{Config: Config{Dbt: DbtConfig{UpgradeCheckIntervalSeconds: tc.interval}}}
+
+			assert.Equal(t, tc.due, dbtObj.UpgradeCheckDue(homedir), "Upgrade check is due only once the interval is up.")
+		})
+	}
+}
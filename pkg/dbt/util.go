@@ -15,21 +15,30 @@
 package dbt
 
 import (
+	"context"
 	"crypto/sha1"
 	"crypto/sha256"
 	"encoding/base64"
 	"encoding/hex"
 	"fmt"
+	"github.com/Masterminds/semver/v3"
 	"github.com/orion-labs/jwt-ssh-agent-go/pkg/agentjwt"
 	"github.com/pkg/errors"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+	"hash"
 	"io"
 	"io/ioutil"
+	"net"
 	"net/http"
+	"net/url"
 	"os"
 	"os/exec"
 	"path"
+	"regexp"
 	"strconv"
 	"strings"
+	"time"
 )
 
 // StringInSlice returns true if the given string is in the given slice
@@ -75,6 +84,43 @@ func LatestVersion(versions []string) (latest string) {
 	return latest
 }
 
+// exactVersion matches a single version, as opposed to a range
+var exactVersion = regexp.MustCompile(`^\d+\.\d+\.\d+$`)
+
+// versionRangeStart matches the start of a version range, like '^1.2', '~1.2.3', '>=1.0 <2' or '1.x'
+var versionRangeStart = regexp.MustCompile(`^[\^~<>=!\d]`)
+
+// IsVersionRange returns true if a version is a semver range, such as '^1.2' or '~1.2.3', rather than one exact version.  Whether it's a valid range is up to LatestVersionSatisfying.
+func IsVersionRange(version string) bool {
+	return !exactVersion.MatchString(version) && versionRangeStart.MatchString(version)
+}
+
+// LatestVersionSatisfying returns the highest of versions that's within the semver range constraint.  Empty if there's none.  Versions that aren't semantic versions are ignored.
+func LatestVersionSatisfying(versions []string, constraint string) (latest string, err error) {
+	c, err := semver.NewConstraint(constraint)
+	if err != nil {
+		err = errors.Wrapf(err, "invalid version range %q", constraint)
+		return latest, err
+	}
+
+	matching := make([]string, 0)
+
+	for _, version := range versions {
+		v, parseErr := semver.NewVersion(version)
+		if parseErr != nil || !exactVersion.MatchString(version) {
+			continue
+		}
+
+		if c.Check(v) {
+			matching = append(matching, version)
+		}
+	}
+
+	latest = LatestVersion(matching)
+
+	return latest, err
+}
+
 // VersionAIsNewerThanB returns true if Semantic Version string v1 is newer (higher numbers) than Semantic Version string v2
 func VersionAIsNewerThanB(a string, b string) (result bool) {
 	aParts, err := SemverParse(a)
@@ -131,16 +177,26 @@ func Spaceship(a int, b int) int {
 
 // FileSha256 returns the hex encoded Sha256 checksum for the given file
 func FileSha256(fileName string) (checksum string, err error) {
-	hasher := sha256.New()
-	checksumBytes, err := ioutil.ReadFile(fileName)
+	return fileChecksum(fileName, sha256.New())
+}
 
+// FileSha1 returns the hex encoded Sha1 checksum for the given file
+func FileSha1(fileName string) (checksum string, err error) {
+	return fileChecksum(fileName, sha1.New())
+}
+
+// fileChecksum returns the hex encoded checksum for the given file.  The file is streamed through the hasher rather than read whole, so a big file doesn't take as much memory as it does disk.
+func fileChecksum(fileName string, hasher hash.Hash) (checksum string, err error) {
+	f, err := os.Open(fileName)
 	if err != nil {
 		return checksum, err
 	}
 
-	_, err = hasher.Write(checksumBytes)
+	defer f.Close()
 
+	_, err = io.Copy(hasher, f)
 	if err != nil {
+		err = errors.Wrapf(err, "failed reading %s", fileName)
 		return checksum, err
 	}
 
@@ -149,24 +205,38 @@ func FileSha256(fileName string) (checksum string, err error) {
 	return checksum, err
 }
 
-// FileSha1 returns the hex encoded Sha1 checksum for the given file
-func FileSha1(fileName string) (checksum string, err error) {
-	hasher := sha1.New()
-	checksumBytes, err := ioutil.ReadFile(fileName)
+// SshAgentPubkey returns the first RSA key held by the ssh-agent at SSH_AUTH_SOCK, in authorized_keys format.  Only RSA keys will do, since the tokens are signed RS256.
+func SshAgentPubkey() (pubkey string, err error) {
+	socket := os.Getenv("SSH_AUTH_SOCK")
+	if socket == "" {
+		err = errors.New("no SSH_AUTH_SOCK in env.  Is ssh-agent running?")
+		return pubkey, err
+	}
 
+	conn, err := net.Dial("unix", socket)
 	if err != nil {
-		return checksum, err
+		err = errors.Wrapf(err, "failed to connect to ssh-agent at %s", socket)
+		return pubkey, err
 	}
 
-	_, err = hasher.Write(checksumBytes)
+	defer conn.Close()
 
+	keys, err := agent.NewClient(conn).List()
 	if err != nil {
-		return checksum, err
+		err = errors.Wrapf(err, "failed to list ssh-agent keys")
+		return pubkey, err
 	}
 
-	checksum = hex.EncodeToString(hasher.Sum(nil))
+	for _, key := range keys {
+		if key.Type() == ssh.KeyAlgoRSA {
+			pubkey = strings.TrimSpace(string(ssh.MarshalAuthorizedKey(key)))
+			return pubkey, err
+		}
+	}
 
-	return checksum, err
+	err = errors.New("ssh-agent has no RSA keys.  Add one with ssh-add")
+
+	return pubkey, err
 }
 
 // FileCopy copies a single file from src to dst
@@ -229,11 +299,26 @@ func DirCopy(src string, dst string) error {
 	return nil
 }
 
+// DEFAULT_FUNC_TIMEOUT how long a getter function gets to run, unless the config says otherwise
+const DEFAULT_FUNC_TIMEOUT = 10 * time.Second
+
 // GetFunc runs a shell command that is a getter function.  This could certainly be dangerous, so be careful how you use it.
 func GetFunc(shellCommand string) (result string, err error) {
-	cmd := exec.Command("sh", "-c", shellCommand)
+	return GetFuncTimeout(shellCommand, DEFAULT_FUNC_TIMEOUT)
+}
+
+// GetFuncTimeout is GetFunc, giving the command timeout to finish.  If it doesn't, it's killed, and the error is ErrFuncTimeout.  Whitespace is trimmed from around the output, so a helper that echoes its answer with a newline, or a CRLF, doesn't end up with it in an Authorization header.
+func GetFuncTimeout(shellCommand string, timeout time.Duration) (result string, err error) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	cmd := exec.CommandContext(ctx, "sh", "-c", shellCommand)
 
 	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		err = errors.Wrapf(err, "failed to connect to stdout of %q", shellCommand)
+		return result, err
+	}
 
 	cmd.Stdin = os.Stdin
 	cmd.Stderr = os.Stderr
@@ -246,81 +331,154 @@ func GetFunc(shellCommand string) (result string, err error) {
 		return result, err
 	}
 
-	stdoutBytes, err := ioutil.ReadAll(stdout)
-	if err != nil {
-		err = errors.Wrapf(err, "error reading stdout from func")
-		return result, err
+	// Read in the background, so something the command started that's still holding its stdout can't keep us past the deadline.
+	output := make(chan []byte, 1)
+
+	go func() {
+		stdoutBytes, _ := ioutil.ReadAll(stdout)
+		output <- stdoutBytes
+	}()
+
+	var stdoutBytes []byte
+
+	select {
+	case stdoutBytes = <-output:
+	case <-ctx.Done():
 	}
 
+	// the context kills the command once the deadline passes
 	err = cmd.Wait()
+
+	if ctx.Err() == context.DeadlineExceeded {
+		err = errors.Wrapf(ErrFuncTimeout, "%q didn't finish within %s", shellCommand, timeout)
+		return result, err
+	}
+
 	if err != nil {
 		err = errors.Wrapf(err, "error waiting for %q to exit", shellCommand)
 		return result, err
 	}
 
-	result = strings.TrimSuffix(string(stdoutBytes), "\n")
+	result = strings.TrimSpace(string(stdoutBytes))
 
 	return result, err
 }
 
 // GetFuncUsername runs a shell command that is a getter function for the username.  This could certainly be dangerous, so be careful how you use it.
 func GetFuncUsername(shellCommand string, username string) (result string, err error) {
+	return GetFuncUsernameTimeout(shellCommand, username, DEFAULT_FUNC_TIMEOUT)
+}
+
+// GetFuncUsernameTimeout is GetFuncUsername, giving the command timeout to finish, as GetFuncTimeout does.
+func GetFuncUsernameTimeout(shellCommand string, username string, timeout time.Duration) (result string, err error) {
 	// add the username as the first arg of the shell command
 	shellCommand = fmt.Sprintf("%s %s", shellCommand, username)
 
-	cmd := exec.Command("sh", "-c", shellCommand)
-
-	stdout, err := cmd.StdoutPipe()
-
-	cmd.Stdin = os.Stdin
-	cmd.Stderr = os.Stderr
+	return GetFuncTimeout(shellCommand, timeout)
+}
 
-	cmd.Env = os.Environ()
+// ParseFuncTimeout parses the config's getter function timeout, such as '30s'.  Empty means DEFAULT_FUNC_TIMEOUT.
+func ParseFuncTimeout(timeout string) (parsed time.Duration, err error) {
+	if timeout == "" {
+		parsed = DEFAULT_FUNC_TIMEOUT
+		return parsed, err
+	}
 
-	err = cmd.Start()
+	parsed, err = time.ParseDuration(timeout)
 	if err != nil {
-		err = errors.Wrapf(err, "failed to run %q", shellCommand)
-		return result, err
+		err = errors.Wrapf(err, "functimeout %q isn't a duration, such as '30s'", timeout)
+		return parsed, err
 	}
 
-	stdoutBytes, err := ioutil.ReadAll(stdout)
-	if err != nil {
-		err = errors.Wrapf(err, "error reading stdout from func")
-		return result, err
+	if parsed <= 0 {
+		err = errors.New(fmt.Sprintf("functimeout %q must be more than 0", timeout))
+		return parsed, err
 	}
 
-	err = cmd.Wait()
+	return parsed, err
+}
+
+// getFunc runs a getter function from the config, giving it as long as the config allows.
+func (dbt *DBT) getFunc(shellCommand string) (result string, err error) {
+	timeout, err := ParseFuncTimeout(dbt.Config.FuncTimeout)
 	if err != nil {
-		err = errors.Wrapf(err, "error waiting for %q to exit", shellCommand)
 		return result, err
 	}
 
-	result = strings.TrimSuffix(string(stdoutBytes), "\n")
+	return GetFuncTimeout(shellCommand, timeout)
+}
 
-	return result, err
+// DefaultCredentials returns the credentials at the top level of the config, which are used for any host without credentials of its own.
+func (c Config) DefaultCredentials() (creds CredentialSet) {
+	creds = CredentialSet{
+		Username:     c.Username,
+		Password:     c.Password,
+		UsernameFunc: c.UsernameFunc,
+		PasswordFunc: c.PasswordFunc,
+		Pubkey:       c.Pubkey,
+		PubkeyPath:   c.PubkeyPath,
+		PubkeyFunc:   c.PubkeyFunc,
+		Token:        c.Token,
+		SshAgent:     c.SshAgent,
+	}
+
+	return creds
 }
 
-// AuthHeaders Convenience function to add auth headers - basic or token for non-s3 requests.  Depending on how client is configured, could result in both Basic Auth and Token headers.  Reposerver will, however only pay attention to one or the other.
+// CredentialsFor returns the credentials for requests to the given url.  Credentials for its hostname:port win over credentials for its hostname, and failing both, it's the config's default credentials.
+func (dbt *DBT) CredentialsFor(u *url.URL) (creds CredentialSet) {
+	if u != nil {
+		for _, host := range []string{u.Host, u.Hostname()} {
+			for name, set := range dbt.Config.Credentials {
+				if strings.EqualFold(name, host) {
+					creds = set
+					return creds
+				}
+			}
+		}
+	}
+
+	creds = dbt.Config.DefaultCredentials()
+
+	return creds
+}
+
+// AuthHeaders Convenience function to add auth headers - basic or token for non-s3 requests.  Depending on how client is configured, could result in both Basic Auth and Token headers.  Reposerver will, however only pay attention to one or the other.  With OIDC configured, only the login's bearer token is sent, and only to the hosts OIDCHost says.  The credentials are the ones for the request's host, see CredentialsFor.
 func (dbt *DBT) AuthHeaders(r *http.Request) (err error) {
+	// OIDC gated repos take the login token, and nothing else.  Other hosts get their own credentials.
+	if dbt.OIDCHost(r.URL) {
+		token, err := dbt.OIDCBearer()
+		if err != nil {
+			return err
+		}
+
+		r.Header.Set("Authorization", "Bearer "+token)
+
+		return err
+	}
+
+	// the request's host might have credentials of its own
+	creds := dbt.CredentialsFor(r.URL)
+
 	// Basic Auth
 	// start with values hardcoded in the config file
-	username := dbt.Config.Username
-	password := dbt.Config.Password
+	username := creds.Username
+	password := creds.Password
 
 	// Username func takes precedence over hardcoded username
-	if dbt.Config.UsernameFunc != "" {
-		username, err = GetFunc(dbt.Config.UsernameFunc)
+	if creds.UsernameFunc != "" {
+		username, err = dbt.getFunc(creds.UsernameFunc)
 		if err != nil {
-			err = errors.Wrapf(err, "failed to get username from shell function %q", dbt.Config.UsernameFunc)
+			err = errors.Wrapf(err, "failed to get username from shell function %q", creds.UsernameFunc)
 			return err
 		}
 	}
 
 	// PasswordFunc takes precedence over hardcoded password
-	if dbt.Config.PasswordFunc != "" {
-		password, err = GetFunc(dbt.Config.PasswordFunc)
+	if creds.PasswordFunc != "" {
+		password, err = dbt.getFunc(creds.PasswordFunc)
 		if err != nil {
-			err = errors.Wrapf(err, "failed to get password from shell function %q", dbt.Config.PasswordFunc)
+			err = errors.Wrapf(err, "failed to get password from shell function %q", creds.PasswordFunc)
 			return err
 		}
 	}
@@ -331,13 +489,13 @@ func (dbt *DBT) AuthHeaders(r *http.Request) (err error) {
 
 	// Pubkey JWT Auth
 	// Start with username and pubkey hardcoded in the config
-	pubkey := dbt.Config.Pubkey
+	pubkey := creds.Pubkey
 
 	// read pubkey from file
-	if dbt.Config.PubkeyPath != "" {
-		b, err := ioutil.ReadFile(dbt.Config.PubkeyPath)
+	if creds.PubkeyPath != "" {
+		b, err := ioutil.ReadFile(creds.PubkeyPath)
 		if err != nil {
-			err = errors.Wrapf(err, "failed to read public key from file %s", dbt.Config.PubkeyPath)
+			err = errors.Wrapf(err, "failed to read public key from file %s", creds.PubkeyPath)
 			return err
 		}
 
@@ -346,10 +504,19 @@ func (dbt *DBT) AuthHeaders(r *http.Request) (err error) {
 	}
 
 	// PubkeyFunc takes precedence over files and hardcoding
-	if dbt.Config.PubkeyFunc != "" {
-		pubkey, err = GetFunc(dbt.Config.PubkeyFunc)
+	if creds.PubkeyFunc != "" {
+		pubkey, err = dbt.getFunc(creds.PubkeyFunc)
 		if err != nil {
-			err = errors.Wrapf(err, "failed to get public key from shell function %q", dbt.Config.PubkeyFunc)
+			err = errors.Wrapf(err, "failed to get public key from shell function %q", creds.PubkeyFunc)
+			return err
+		}
+	}
+
+	// Failing all that, ask the agent which key to use
+	if pubkey == "" && creds.SshAgent {
+		pubkey, err = SshAgentPubkey()
+		if err != nil {
+			err = errors.Wrapf(err, "failed to get public key from ssh-agent")
 			return err
 		}
 	}
@@ -365,6 +532,13 @@ func (dbt *DBT) AuthHeaders(r *http.Request) (err error) {
 		if token != "" {
 			r.Header.Add("Token", token)
 		}
+
+		return err
+	}
+
+	// Otherwise use a pre-issued token if we have one.
+	if creds.Token != "" {
+		r.Header.Add("Token", creds.Token)
 	}
 
 	return err
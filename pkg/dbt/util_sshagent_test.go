@@ -0,0 +1,189 @@
+package dbt
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+	"net"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// startTestSshAgent serves an in memory ssh-agent holding the given keys on SSH_AUTH_SOCK for the duration of the test.
+func startTestSshAgent(t *testing.T, keys ...interface{}) {
+	keyring := agent.NewKeyring()
+
+	for _, key := range keys {
+		err := keyring.Add(agent.AddedKey{PrivateKey: key})
+		if err != nil {
+			t.Fatalf("Failed adding key to agent: %s", err)
+		}
+	}
+
+	// unix socket paths are short, so stay out of the test's own temp dir
+	dir, err := os.MkdirTemp("", "agent")
+	if err != nil {
+		t.Fatalf("Failed creating agent dir: %s", err)
+	}
+
+	t.Cleanup(func() { os.RemoveAll(dir) })
+
+	socket := filepath.Join(dir, "sock")
+
+	listener, err := net.Listen("unix", socket)
+	if err != nil {
+		t.Fatalf("Failed listening on %s: %s", socket, err)
+	}
+
+	t.Cleanup(func() { listener.Close() })
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+
+			go func() {
+				_ = agent.ServeAgent(keyring, conn)
+				conn.Close()
+			}()
+		}
+	}()
+
+	t.Setenv("SSH_AUTH_SOCK", socket)
+}
+
+// testRSAKey returns a new RSA key, and its public half in authorized_keys format.
+func testRSAKey(t *testing.T) (key *rsa.PrivateKey, pubkey string) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("Failed generating key: %s", err)
+	}
+
+	sshPubkey, err := ssh.NewPublicKey(&key.PublicKey)
+	if err != nil {
+		t.Fatalf("Failed converting key: %s", err)
+	}
+
+	pubkey = strings.TrimSpace(string(ssh.MarshalAuthorizedKey(sshPubkey)))
+
+	return key, pubkey
+}
+
+func TestSshAgentPubkey(t *testing.T) {
+	rsaKey, rsaPubkey := testRSAKey(t)
+
+	_, edKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("Failed generating key: %s", err)
+	}
+
+	inputs := []struct {
+		name   string
+		keys   []interface{}
+		pubkey string
+		err    bool
+	}{
+		{"rsa", []interface{}{rsaKey}, rsaPubkey, false},
+		{"rsa after ed25519", []interface{}{edKey, rsaKey}, rsaPubkey, false},
+		{"no rsa", []interface{}{edKey}, "", true},
+		{"empty", []interface{}{}, "", true},
+	}
+
+	for _, tc := range inputs {
+		t.Run(tc.name, func(t *testing.T) {
+			startTestSshAgent(t, tc.keys...)
+
+			pubkey, err := SshAgentPubkey()
+
+			if tc.err {
+				assert.NotNil(t, err, "No usable key is an error.")
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("Failed getting pubkey: %s", err)
+			}
+
+			assert.Equal(t, tc.pubkey, pubkey, "Agent's RSA key is used.")
+		})
+	}
+
+	t.Run("no agent", func(t *testing.T) {
+		t.Setenv("SSH_AUTH_SOCK", "")
+
+		_, err := SshAgentPubkey()
+		assert.NotNil(t, err, "No agent is an error.")
+	})
+}
+
+func TestSshAgentClientAuth(t *testing.T) {
+	key, pubkey := testRSAKey(t)
+	_, otherPubkey := testRSAKey(t)
+
+	startTestSshAgent(t, key)
+
+	serverRoot := t.TempDir()
+
+	err := os.MkdirAll(fmt.Sprintf("%s/dbt-tools/foo/1.0.0", serverRoot), 0755)
+	if err != nil {
+		t.Fatalf("Failed creating tool dir: %s", err)
+	}
+
+	idpFile := filepath.Join(t.TempDir(), "identity")
+	err = os.WriteFile(idpFile, []byte(fmt.Sprintf(`{"getUsers": [{"username": "tester", "publickey": %q}], "putUsers": []}`, pubkey)), 0644)
+	if err != nil {
+		t.Fatalf("Failed writing idp file: %s", err)
+	}
+
+	repoServer := &DBTRepoServer{
+		ServerRoot:  serverRoot,
+		AuthTypeGet: AUTH_SSH_AGENT_FILE,
+		AuthGets:    true,
+		AuthOptsGet: AuthOpts{IdpFile: idpFile},
+	}
+
+	r, err := repoServer.Router()
+	if err != nil {
+		t.Fatalf("Failed creating router: %s", err)
+	}
+
+	server := httptest.NewServer(r)
+	defer server.Close()
+
+	inputs := []struct {
+		name   string
+		config Config
+		ok     bool
+	}{
+		{"agent key", Config{Username: "tester", SshAgent: true}, true},
+		{"configured key", Config{Username: "tester", Pubkey: pubkey}, true},
+		{"configured key wins", Config{Username: "tester", Pubkey: otherPubkey, SshAgent: true}, false},
+		{"wrong user", Config{Username: "someone", SshAgent: true}, false},
+		{"no auth", Config{Username: "tester"}, false},
+	}
+
+	for _, tc := range inputs {
+		t.Run(tc.name, func(t *testing.T) {
+			tc.config.Tools.Repo = fmt.Sprintf("%s/dbt-tools", server.URL)
+			obj := &DBT{Config: tc.config}
+
+			versions, _ := obj.FetchToolVersions("foo")
+
+			if tc.ok {
+				assert.Equal(t, []string{"1.0.0"}, versions, "Authenticated client lists versions.")
+				return
+			}
+
+			assert.Empty(t, versions, "Unauthenticated client lists nothing.")
+		})
+	}
+}
@@ -15,10 +15,19 @@
 package dbt
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
+	"github.com/pkg/errors"
 	"github.com/stretchr/testify/assert"
 	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"runtime"
 	"testing"
+	"time"
 )
 
 func TestSemverParse(t *testing.T) {
@@ -69,6 +78,52 @@ func TestFileSha256(t *testing.T) {
 
 }
 
+func TestFileSha256Large(t *testing.T) {
+	fileName := filepath.Join(t.TempDir(), "large")
+
+	// 64MB, written a chunk at a time so the test itself doesn't hold it all
+	chunk := make([]byte, 1024*1024)
+	for i := range chunk {
+		chunk[i] = byte(i % 251)
+	}
+
+	f, err := os.Create(fileName)
+	if err != nil {
+		t.Fatalf("Error creating test file: %s", err)
+	}
+
+	expectedHasher := sha256.New()
+
+	for i := 0; i < 64; i++ {
+		chunk[0] = byte(i)
+
+		_, err = f.Write(chunk)
+		if err != nil {
+			t.Fatalf("Error writing test file: %s", err)
+		}
+
+		expectedHasher.Write(chunk)
+	}
+
+	_ = f.Close()
+
+	var before runtime.MemStats
+	var after runtime.MemStats
+
+	runtime.GC()
+	runtime.ReadMemStats(&before)
+
+	checksum, err := FileSha256(fileName)
+	if err != nil {
+		t.Fatalf("Couldn't get checksum for file %q: %s", fileName, err)
+	}
+
+	runtime.ReadMemStats(&after)
+
+	assert.Equal(t, hex.EncodeToString(expectedHasher.Sum(nil)), checksum, "Checksum of large file matches expectations.")
+	assert.Less(t, after.TotalAlloc-before.TotalAlloc, uint64(len(chunk)), "Checksumming allocates less than a chunk of the file, let alone all of it.")
+}
+
 func TestFileSha1(t *testing.T) {
 	fileName := fmt.Sprintf("%s/%s", tmpDir, "foo")
 
@@ -146,3 +201,210 @@ func testStringTrue() string {
 func testStringFalse() string {
 	return "fargle"
 }
+
+func TestGetFunc(t *testing.T) {
+	t.Setenv("DBT_TEST_CREDENTIAL", "s3cr3t")
+
+	inputs := []struct {
+		name    string
+		command string
+	}{
+		{"echo", "echo $DBT_TEST_CREDENTIAL"},
+		{"crlf", "printf '%s\\r\\n' $DBT_TEST_CREDENTIAL"},
+		{"padded", "printf '  %s  \\n\\n' $DBT_TEST_CREDENTIAL"},
+	}
+
+	for _, tc := range inputs {
+		t.Run(tc.name, func(t *testing.T) {
+			result, err := GetFunc(tc.command)
+			if err != nil {
+				t.Fatalf("Getter function failed: %s", err)
+			}
+
+			assert.Equal(t, "s3cr3t", result, "Credential has no surrounding whitespace.")
+		})
+	}
+}
+
+func TestPubkeysFromFuncTimeout(t *testing.T) {
+	inputs := []struct {
+		name    string
+		opts    AuthOpts
+		pubkey  string
+		timeout bool
+	}{
+		{"within the default", AuthOpts{IdpFunc: "echo key-for"}, "key-for foo", false},
+		{"within the timeout", AuthOpts{IdpFunc: "echo key-for", FuncTimeout: "1s"}, "key-for foo", false},
+		{"past the timeout", AuthOpts{IdpFunc: "sleep 3; echo", FuncTimeout: "200ms"}, "", true},
+	}
+
+	for _, tc := range inputs {
+		t.Run(tc.name, func(t *testing.T) {
+			d := &DBTRepoServer{AuthOptsGet: tc.opts, AuthOptsPut: tc.opts}
+
+			for _, lookup := range []func(string) (string, error){d.PubkeysFromFuncGet, d.PubkeysFromFuncPut} {
+				start := time.Now()
+
+				pubkey, err := lookup("foo")
+
+				assert.Less(t, int64(time.Since(start)), int64(2*time.Second), "Lookup returns promptly.")
+
+				if tc.timeout {
+					assert.Equal(t, ErrFuncTimeout, errors.Cause(err), "Lookup times out.")
+					continue
+				}
+
+				if err != nil {
+					t.Fatalf("Lookup failed: %s", err)
+				}
+
+				assert.Equal(t, tc.pubkey, pubkey, "Lookup gets the key.")
+			}
+		})
+	}
+}
+
+func TestGetFuncTimeout(t *testing.T) {
+	inputs := []struct {
+		name     string
+		command  string
+		timeout  time.Duration
+		expected string
+		cause    error
+		err      bool
+	}{
+		{"output", "echo foo", time.Second, "foo", nil, false},
+		{"trailing whitespace", "printf 'foo \\t\\r\\n\\n'", time.Second, "foo", nil, false},
+		{"surrounding whitespace", "printf '\\n  foo\\r\\n'", time.Second, "foo", nil, false},
+		{"inner whitespace kept", "printf ' foo bar\\n'", time.Second, "foo bar", nil, false},
+		{"fails", "exit 3", time.Second, "", nil, true},
+		{"hangs", "sleep 3", 200 * time.Millisecond, "", ErrFuncTimeout, true},
+		{"child holds stdout", "sleep 3 & echo foo; wait", 200 * time.Millisecond, "", ErrFuncTimeout, true},
+	}
+
+	for _, tc := range inputs {
+		t.Run(tc.name, func(t *testing.T) {
+			start := time.Now()
+
+			result, err := GetFuncTimeout(tc.command, tc.timeout)
+
+			assert.Less(t, int64(time.Since(start)), int64(2*time.Second), "Getter function returns promptly.")
+
+			if tc.err {
+				if assert.NotNil(t, err, "Getter function fails.") && tc.cause != nil {
+					assert.Equal(t, tc.cause, errors.Cause(err), "Failure is a timeout.")
+				}
+
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("Getter function failed: %s", err)
+			}
+
+			assert.Equal(t, tc.expected, result, "Getter function output is as expected.")
+		})
+	}
+}
+
+func TestAuthHeadersFuncTimeout(t *testing.T) {
+	inputs := []struct {
+		name    string
+		timeout string
+		cause   error
+		err     bool
+	}{
+		{"default", "", nil, false},
+		{"configured", "2s", nil, false},
+		{"too short", "100ms", ErrFuncTimeout, true},
+		{"bad", "soon", nil, true},
+	}
+
+	for _, tc := range inputs {
+		t.Run(tc.name, func(t *testing.T) {
+			obj := &DBT{
+				Config: Config{
+					UsernameFunc: "echo foo",
+					PasswordFunc: "sleep 0.5; printf ' bar\\r\\n'",
+					FuncTimeout:  tc.timeout,
+				},
+				Logger: log.New(ioutil.Discard, "", 0),
+			}
+
+			req, err := http.NewRequest("GET", "http://127.0.0.1/dbt", nil)
+			if err != nil {
+				t.Fatalf("Failed creating request: %s", err)
+			}
+
+			err = obj.AuthHeaders(req)
+			if tc.err {
+				if assert.NotNil(t, err, "Auth headers fail.") && tc.cause != nil {
+					assert.Equal(t, tc.cause, errors.Cause(err), "Failure is a timeout.")
+				}
+
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("Failed adding auth headers: %s", err)
+			}
+
+			username, password, ok := req.BasicAuth()
+			assert.True(t, ok, "Basic auth is set.")
+			assert.Equal(t, "foo", username, "Username has no surrounding whitespace.")
+			assert.Equal(t, "bar", password, "Password has no surrounding whitespace.")
+		})
+	}
+}
+
+func TestCredentialsFor(t *testing.T) {
+	config := Config{
+		Username: "default",
+		Password: "defaultpass",
+		Credentials: map[string]CredentialSet{
+			"tools.example.com":      {Token: "toolstoken"},
+			"repo.example.com":       {Username: "repo", Password: "repopass"},
+			"repo.example.com:8443":  {Username: "repo8443", PasswordFunc: "echo repo8443pass"},
+			"TrustStore.Example.COM": {Username: "trust", Password: "trustpass"},
+		},
+	}
+
+	inputs := []struct {
+		name     string
+		url      string
+		username string
+		password string
+		token    string
+	}{
+		{"default", "https://other.example.com/dbt", "default", "defaultpass", ""},
+		{"hostname", "https://tools.example.com/dbt-tools/foo", "", "", "toolstoken"},
+		{"hostname any port", "https://repo.example.com:9999/dbt", "repo", "repopass", ""},
+		{"hostname and port", "https://repo.example.com:8443/dbt", "repo8443", "repo8443pass", ""},
+		{"case insensitive", "https://truststore.example.com/truststore", "trust", "trustpass", ""},
+	}
+
+	for _, tc := range inputs {
+		t.Run(tc.name, func(t *testing.T) {
+			obj := &DBT{
+				Config: config,
+				Logger: log.New(ioutil.Discard, "", 0),
+			}
+
+			req, err := http.NewRequest("GET", tc.url, nil)
+			if err != nil {
+				t.Fatalf("Failed creating request: %s", err)
+			}
+
+			err = obj.AuthHeaders(req)
+			if err != nil {
+				t.Fatalf("Failed adding auth headers: %s", err)
+			}
+
+			username, password, ok := req.BasicAuth()
+			assert.Equal(t, tc.username != "", ok, "Basic auth is set if the host has a username.")
+			assert.Equal(t, tc.username, username, "Username is the host's.")
+			assert.Equal(t, tc.password, password, "Password is the host's.")
+			assert.Equal(t, tc.token, req.Header.Get("Token"), "Token is the host's.")
+		})
+	}
+}